@@ -0,0 +1,171 @@
+package conntrack
+
+import (
+	"sync"
+	"time"
+)
+
+// A Rate holds the packet and byte rates of a Flow in one direction, per second.
+type Rate struct {
+	Packets, Bytes float64
+}
+
+// An AccountingDelta describes the traffic a Flow has seen in between two observations.
+// Its Counters hold the amount of packets and bytes sent in each direction.
+type AccountingDelta struct {
+	Orig, Reply Counter
+
+	// Elapsed is the time in between both observations. Zero if unknown.
+	Elapsed time.Duration
+
+	// Reset is true when the Flow's counters could not be compared to the previous
+	// observation because the connection was recreated with the same tuple, or because
+	// its counters were zeroed. The delta then holds all traffic seen since the
+	// (re)creation or reset, over an unknown interval.
+	Reset bool
+}
+
+// RateOrig returns the Flow's rate in the original direction. Returns a zero Rate when
+// the time in between observations is unknown.
+func (d AccountingDelta) RateOrig() Rate {
+	return d.rate(d.Orig)
+}
+
+// RateReply returns the Flow's rate in the reply direction. Returns a zero Rate when
+// the time in between observations is unknown.
+func (d AccountingDelta) RateReply() Rate {
+	return d.rate(d.Reply)
+}
+
+func (d AccountingDelta) rate(ctr Counter) Rate {
+
+	if d.Elapsed <= 0 || d.Reset {
+		return Rate{}
+	}
+
+	s := d.Elapsed.Seconds()
+
+	return Rate{Packets: float64(ctr.Packets) / s, Bytes: float64(ctr.Bytes) / s}
+}
+
+// FlowDelta computes the traffic seen by a Flow in between two observations prev and cur,
+// taken elapsed time apart. The Flows are assumed to describe the same tuple.
+//
+// When both Flows carry a non-zero ID or start Timestamp and these differ, the connection
+// was recreated in between observations and the delta is reset to cur's counters. The same
+// happens when one of cur's counters is lower than prev's, which occurs when counters are
+// zeroed by the user.
+func FlowDelta(prev, cur Flow, elapsed time.Duration) AccountingDelta {
+
+	d := AccountingDelta{Elapsed: elapsed}
+
+	recreated := prev.ID != 0 && cur.ID != 0 && prev.ID != cur.ID ||
+		!prev.Timestamp.Start.IsZero() && !cur.Timestamp.Start.IsZero() && !prev.Timestamp.Start.Equal(cur.Timestamp.Start)
+
+	orig, okOrig := counterDelta(prev.CountersOrig, cur.CountersOrig)
+	reply, okReply := counterDelta(prev.CountersReply, cur.CountersReply)
+
+	if recreated || !okOrig || !okReply {
+		d.Orig, d.Reply = cur.CountersOrig, cur.CountersReply
+		d.Reset = true
+		return d
+	}
+
+	d.Orig, d.Reply = orig, reply
+
+	return d
+}
+
+// counterDelta subtracts prev from cur. Returns false if any of cur's values
+// is lower than prev's.
+func counterDelta(prev, cur Counter) (Counter, bool) {
+
+	if cur.Packets < prev.Packets || cur.Bytes < prev.Bytes {
+		return Counter{}, false
+	}
+
+	return Counter{
+		Direction: cur.Direction,
+		Packets:   cur.Packets - prev.Packets,
+		Bytes:     cur.Bytes - prev.Bytes,
+	}, true
+}
+
+// An AccountingTracker remembers the last observation of each Flow it is given and
+// computes the traffic seen in between successive observations of the same Flow.
+// Flows are identified by their Key, which includes their zone. It is safe for concurrent use.
+//
+// An AccountingTracker can be fed with the results of periodic dumps or with Events.
+// Call Forget when a Flow is destroyed, or Expire periodically, to bound its memory use.
+type AccountingTracker struct {
+	mu    sync.Mutex
+	flows map[FlowKey]observation
+}
+
+// observation is the state of a Flow as seen by an AccountingTracker at a point in time.
+type observation struct {
+	flow Flow
+	at   time.Time
+}
+
+// NewAccountingTracker returns an empty AccountingTracker.
+func NewAccountingTracker() *AccountingTracker {
+	return &AccountingTracker{flows: make(map[FlowKey]observation)}
+}
+
+// Observe records f as observed at time t and returns the traffic the Flow saw since
+// its previous observation. The first observation of a Flow returns a delta holding all
+// of its counters and a zero Elapsed, unless the Flow carries a start Timestamp, in which
+// case Elapsed is the time since the start of the Flow.
+func (tr *AccountingTracker) Observe(f Flow, t time.Time) AccountingDelta {
+
+	k := f.Key()
+
+	tr.mu.Lock()
+	prev, ok := tr.flows[k]
+	tr.flows[k] = observation{flow: f, at: t}
+	tr.mu.Unlock()
+
+	if !ok {
+		d := AccountingDelta{Orig: f.CountersOrig, Reply: f.CountersReply}
+		if !f.Timestamp.Start.IsZero() {
+			d.Elapsed = t.Sub(f.Timestamp.Start)
+		}
+		return d
+	}
+
+	return FlowDelta(prev.flow, f, t.Sub(prev.at))
+}
+
+// Forget removes a Flow from the AccountingTracker.
+func (tr *AccountingTracker) Forget(f Flow) {
+	tr.mu.Lock()
+	delete(tr.flows, f.Key())
+	tr.mu.Unlock()
+}
+
+// Expire removes all Flows last observed before t from the AccountingTracker.
+// Returns the amount of Flows removed.
+func (tr *AccountingTracker) Expire(t time.Time) int {
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	var n int
+	for k, o := range tr.flows {
+		if o.at.Before(t) {
+			delete(tr.flows, k)
+			n++
+		}
+	}
+
+	return n
+}
+
+// Len returns the amount of Flows tracked by the AccountingTracker.
+func (tr *AccountingTracker) Len() int {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+
+	return len(tr.flows)
+}
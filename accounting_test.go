@@ -0,0 +1,141 @@
+package conntrack
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func acctFlow(id uint32, op, ob, rp, rb uint64) Flow {
+	f := NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0)
+	f.ID = id
+	f.CountersOrig = Counter{Packets: op, Bytes: ob}
+	f.CountersReply = Counter{Direction: true, Packets: rp, Bytes: rb}
+	return f
+}
+
+func TestFlowDelta(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		prev, cur Flow
+		elapsed   time.Duration
+		delta     AccountingDelta
+		orig      Rate
+	}{
+		{
+			name:    "regular increase",
+			prev:    acctFlow(1, 10, 1000, 5, 500),
+			cur:     acctFlow(1, 30, 3000, 7, 900),
+			elapsed: 2 * time.Second,
+			delta: AccountingDelta{
+				Orig:    Counter{Packets: 20, Bytes: 2000},
+				Reply:   Counter{Direction: true, Packets: 2, Bytes: 400},
+				Elapsed: 2 * time.Second,
+			},
+			orig: Rate{Packets: 10, Bytes: 1000},
+		},
+		{
+			name:    "counters zeroed",
+			prev:    acctFlow(1, 10, 1000, 5, 500),
+			cur:     acctFlow(1, 1, 100, 5, 500),
+			elapsed: time.Second,
+			delta: AccountingDelta{
+				Orig:    Counter{Packets: 1, Bytes: 100},
+				Reply:   Counter{Direction: true, Packets: 5, Bytes: 500},
+				Elapsed: time.Second,
+				Reset:   true,
+			},
+		},
+		{
+			name:    "flow recreated with higher counters",
+			prev:    acctFlow(1, 10, 1000, 5, 500),
+			cur:     acctFlow(2, 20, 2000, 5, 500),
+			elapsed: time.Second,
+			delta: AccountingDelta{
+				Orig:    Counter{Packets: 20, Bytes: 2000},
+				Reply:   Counter{Direction: true, Packets: 5, Bytes: 500},
+				Elapsed: time.Second,
+				Reset:   true,
+			},
+		},
+		{
+			name: "unknown interval",
+			prev: acctFlow(0, 1, 10, 0, 0),
+			cur:  acctFlow(0, 2, 20, 0, 0),
+			delta: AccountingDelta{
+				Orig:  Counter{Packets: 1, Bytes: 10},
+				Reply: Counter{Direction: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := FlowDelta(tt.prev, tt.cur, tt.elapsed)
+			assert.Equal(t, tt.delta, d)
+			assert.Equal(t, tt.orig, d.RateOrig())
+		})
+	}
+}
+
+func TestFlowDeltaTimestamp(t *testing.T) {
+
+	start := time.Unix(1000, 0)
+
+	prev := acctFlow(0, 10, 1000, 0, 0)
+	prev.Timestamp.Start = start
+
+	cur := acctFlow(0, 20, 2000, 0, 0)
+	cur.Timestamp.Start = start
+
+	assert.False(t, FlowDelta(prev, cur, time.Second).Reset)
+
+	cur.Timestamp.Start = start.Add(time.Second)
+	assert.True(t, FlowDelta(prev, cur, time.Second).Reset)
+}
+
+func TestAccountingTracker(t *testing.T) {
+
+	tr := NewAccountingTracker()
+	now := time.Unix(2000, 0)
+
+	f := acctFlow(1, 10, 1000, 10, 2000)
+	f.Timestamp.Start = now.Add(-10 * time.Second)
+
+	d := tr.Observe(f, now)
+	assert.Equal(t, AccountingDelta{Orig: f.CountersOrig, Reply: f.CountersReply, Elapsed: 10 * time.Second}, d)
+	assert.Equal(t, Rate{Packets: 1, Bytes: 200}, d.RateReply())
+
+	f2 := acctFlow(1, 15, 1500, 10, 2000)
+	f2.Timestamp.Start = f.Timestamp.Start
+
+	d = tr.Observe(f2, now.Add(5*time.Second))
+	assert.Equal(t, Rate{Packets: 1, Bytes: 100}, d.RateOrig())
+	assert.Equal(t, Rate{}, d.RateReply())
+	assert.Equal(t, 1, tr.Len())
+
+	// Another Flow with a different tuple.
+	g := acctFlow(2, 1, 1, 1, 1)
+	g.TupleOrig.Proto.SourcePort = 4321
+	g.TupleReply.Proto.DestinationPort = 4321
+	tr.Observe(g, now.Add(10*time.Second))
+	assert.Equal(t, 2, tr.Len())
+
+	// A Flow with f's tuples in another conntrack zone.
+	z := acctFlow(3, 1, 100, 0, 0)
+	z.Zone = 1
+	d = tr.Observe(z, now.Add(10*time.Second))
+	assert.Equal(t, Counter{Packets: 1, Bytes: 100}, d.Orig)
+	assert.False(t, d.Reset)
+	assert.Equal(t, 3, tr.Len())
+
+	assert.Equal(t, 1, tr.Expire(now.Add(6*time.Second)))
+	assert.Equal(t, 2, tr.Len())
+
+	tr.Forget(g)
+	tr.Forget(z)
+	assert.Equal(t, 0, tr.Len())
+}
@@ -2,11 +2,12 @@ package conntrack
 
 import (
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/mdlayher/netlink"
 	"github.com/pkg/errors"
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 const (
@@ -60,7 +61,7 @@ func (hlp Helper) marshal() netfilter.Attribute {
 
 	nfa := netfilter.Attribute{Type: uint16(ctaHelp), Nested: true, Children: make([]netfilter.Attribute, 1, 2)}
 
-	nfa.Children[0] = netfilter.Attribute{Type: uint16(ctaHelpName), Data: []byte(hlp.Name)}
+	nfa.Children[0] = netfilter.Attribute{Type: uint16(ctaHelpName), Data: nulString(hlp.Name)}
 
 	if len(hlp.Info) > 0 {
 		nfa.Children = append(nfa.Children, netfilter.Attribute{Type: uint16(ctaHelpInfo), Data: hlp.Info})
@@ -69,6 +70,12 @@ func (hlp Helper) marshal() netfilter.Attribute {
 	return nfa
 }
 
+// nulString returns s as a NUL-terminated string. The kernel rejects string
+// attributes without terminator, like helper names, with EINVAL.
+func nulString(s string) []byte {
+	return append([]byte(s), 0)
+}
+
 // The ProtoInfo structure holds a pointer to
 // one of ProtoInfoTCP, ProtoInfoDCCP or ProtoInfoSCTP.
 type ProtoInfo struct {
@@ -275,9 +282,26 @@ func (spi ProtoInfoSCTP) marshal() netfilter.Attribute {
 	return nfa
 }
 
+// Direction is the direction of a connection an attribute applies to,
+// either DirOrig or DirReply.
+type Direction bool
+
+// Directions of a connection.
+const (
+	DirOrig  Direction = false
+	DirReply Direction = true
+)
+
+func (d Direction) String() string {
+	if d == DirReply {
+		return "reply"
+	}
+	return "orig"
+}
+
 // A Counter holds a pair of counters that represent packets and bytes sent over
 // a Conntrack connection. Direction is true when it's a reply counter.
-// This attribute cannot be changed on a connection and thus cannot be marshaled.
+// This attribute cannot be changed on a connection and is only marshaled by Flow.MarshalBinary.
 type Counter struct {
 
 	// true means it's a reply counter,
@@ -289,12 +313,17 @@ type Counter struct {
 }
 
 func (ctr Counter) String() string {
-	dir := "orig"
-	if ctr.Direction {
-		dir = "reply"
-	}
+	return fmt.Sprintf("[%s: %d pkts/%d B]", ctr.Dir(), ctr.Packets, ctr.Bytes)
+}
+
+// Dir returns the Direction of the Counter, DirReply if it's a reply counter.
+func (ctr Counter) Dir() Direction {
+	return Direction(ctr.Direction)
+}
 
-	return fmt.Sprintf("[%s: %d pkts/%d B]", dir, ctr.Packets, ctr.Bytes)
+// SetDir sets the Direction of the Counter.
+func (ctr *Counter) SetDir(d Direction) {
+	ctr.Direction = bool(d)
 }
 
 // Filled returns true if the counter's values are non-zero.
@@ -328,9 +357,33 @@ func (ctr *Counter) unmarshal(ad *netlink.AttributeDecoder) error {
 	return ad.Err()
 }
 
+// marshal marshals a Counter into a netfilter.Attribute.
+func (ctr Counter) marshal() netfilter.Attribute {
+
+	at := ctaCountersOrig
+	if ctr.Direction {
+		at = ctaCountersReply
+	}
+
+	return netfilter.Attribute{
+		Type:   uint16(at),
+		Nested: true,
+		Children: []netfilter.Attribute{
+			{Type: uint16(ctaCountersPackets), Data: netfilter.Uint64Bytes(ctr.Packets)},
+			{Type: uint16(ctaCountersBytes), Data: netfilter.Uint64Bytes(ctr.Bytes)},
+		},
+	}
+}
+
 // A Timestamp represents the start and end time of a flow.
-// The timer resolution in the kernel is in nanosecond-epoch.
-// This attribute cannot be changed on a connection and thus cannot be marshaled.
+//
+// The kernel records both using ktime_get_real_ns, in nanoseconds since the Unix epoch
+// according to CLOCK_REALTIME, not relative to boot. They are decoded as-is and need no
+// conversion using the system's uptime or CLOCK_BOOTTIME, and are not skewed by
+// suspend and resume. Like any wall clock time, they jump along with the system clock
+// when it is set, so Flow.Age and Flow.Duration can be off around clock changes.
+//
+// This attribute cannot be changed on a connection and is only marshaled by Flow.MarshalBinary.
 type Timestamp struct {
 	Start time.Time
 	Stop  time.Time
@@ -358,9 +411,28 @@ func (ts *Timestamp) unmarshal(ad *netlink.AttributeDecoder) error {
 	return ad.Err()
 }
 
+// marshal marshals a Timestamp into a netfilter.Attribute. Zero times are omitted.
+func (ts Timestamp) marshal() netfilter.Attribute {
+
+	nfa := netfilter.Attribute{Type: uint16(ctaTimestamp), Nested: true}
+
+	if !ts.Start.IsZero() {
+		nfa.Children = append(nfa.Children, netfilter.Attribute{
+			Type: uint16(ctaTimestampStart), Data: netfilter.Uint64Bytes(uint64(ts.Start.UnixNano())),
+		})
+	}
+	if !ts.Stop.IsZero() {
+		nfa.Children = append(nfa.Children, netfilter.Attribute{
+			Type: uint16(ctaTimestampStop), Data: netfilter.Uint64Bytes(uint64(ts.Stop.UnixNano())),
+		})
+	}
+
+	return nfa
+}
+
 // A Security structure holds the security info belonging to a connection.
 // Kernel uses this to store and match SELinux context name.
-// This attribute cannot be changed on a connection and thus cannot be marshaled.
+// This attribute cannot be changed on a connection and is only marshaled by Flow.MarshalBinary.
 type Security string
 
 // unmarshal unmarshals a nested security attribute into a conntrack.Security structure.
@@ -383,6 +455,15 @@ func (sec *Security) unmarshal(ad *netlink.AttributeDecoder) error {
 	return ad.Err()
 }
 
+// marshal marshals a Security into a netfilter.Attribute.
+func (sec Security) marshal() netfilter.Attribute {
+	return netfilter.Attribute{
+		Type:     uint16(ctaSecCtx),
+		Nested:   true,
+		Children: []netfilter.Attribute{{Type: uint16(ctaSecCtxName), Data: []byte(sec)}},
+	}
+}
+
 // SequenceAdjust represents a TCP sequence number adjustment event.
 // Direction is true when it's a reply adjustment.
 type SequenceAdjust struct {
@@ -501,6 +582,69 @@ func (sp SynProxy) marshal() netfilter.Attribute {
 	return nfa
 }
 
+// A NATRange is a range of addresses and ports the source or destination of a new
+// connection is translated to, like iptables' SNAT and DNAT targets and the
+// --src-nat, --dst-nat and --nat-port-range options of conntrack -I.
+//
+// MinIP and MinPort alone translate to a single address or port. Leave MinIP
+// empty to only translate ports, or the ports zero to only translate addresses.
+// The kernel picks a free address and port from the range and applies it to
+// the connection's reply tuple, which is where the translation can be observed
+// afterwards. This attribute can only be set when creating a connection.
+type NATRange struct {
+	MinIP, MaxIP     net.IP
+	MinPort, MaxPort uint16
+}
+
+// filled returns true if the NATRange has an address or a port set.
+func (nr NATRange) filled() bool {
+	return len(nr.MinIP) != 0 || nr.MinPort != 0
+}
+
+// marshal marshals a NATRange into a netfilter.Attribute of type at.
+func (nr NATRange) marshal(at uint16) (netfilter.Attribute, error) {
+
+	nfa := netfilter.Attribute{Type: at, Nested: true, Children: make([]netfilter.Attribute, 0, 3)}
+
+	if len(nr.MinIP) != 0 {
+		minIP, maxIP := nr.MinIP, nr.MaxIP
+		if len(maxIP) == 0 {
+			maxIP = minIP
+		}
+
+		if min4, max4 := minIP.To4(), maxIP.To4(); min4 != nil && max4 != nil {
+			nfa.Children = append(nfa.Children,
+				netfilter.Attribute{Type: uint16(ctaNATv4MinIP), Data: min4},
+				netfilter.Attribute{Type: uint16(ctaNATv4MaxIP), Data: max4},
+			)
+		} else if min4 == nil && max4 == nil && minIP.To16() != nil && maxIP.To16() != nil {
+			nfa.Children = append(nfa.Children,
+				netfilter.Attribute{Type: uint16(ctaNATv6MinIP), Data: minIP.To16()},
+				netfilter.Attribute{Type: uint16(ctaNATv6MaxIP), Data: maxIP.To16()},
+			)
+		} else {
+			return netfilter.Attribute{}, errBadNATRange
+		}
+	}
+
+	if nr.MinPort != 0 {
+		maxPort := nr.MaxPort
+		if maxPort == 0 {
+			maxPort = nr.MinPort
+		}
+
+		nfa.Children = append(nfa.Children, netfilter.Attribute{
+			Type: uint16(ctaNATProto), Nested: true,
+			Children: []netfilter.Attribute{
+				{Type: uint16(ctaProtoNATPortMin), Data: netfilter.Uint16Bytes(nr.MinPort)},
+				{Type: uint16(ctaProtoNATPortMax), Data: netfilter.Uint16Bytes(maxPort)},
+			},
+		})
+	}
+
+	return nfa, nil
+}
+
 // TODO: ctaStats
 // TODO: ctaStatsGlobal
 // TODO: ctaStatsExp
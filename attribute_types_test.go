@@ -2,13 +2,15 @@ package conntrack
 
 import (
 	"fmt"
+	"net"
 	"testing"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/mdlayher/netlink"
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 var (
@@ -59,7 +61,7 @@ func TestAttributeHelper(t *testing.T) {
 		Children: []netfilter.Attribute{
 			{
 				Type: uint16(ctaHelpName),
-				Data: []byte("foo"),
+				Data: []byte("foo\x00"),
 			},
 			{
 				Type: uint16(ctaHelpInfo),
@@ -490,3 +492,87 @@ func TestAttributeSynProxy(t *testing.T) {
 
 	assert.EqualValues(t, nfaSynProxy, sp.marshal())
 }
+
+func TestAttributeNATRange(t *testing.T) {
+
+	assert.False(t, NATRange{}.filled())
+	assert.False(t, NATRange{MaxPort: 1}.filled())
+	assert.True(t, NATRange{MinIP: net.IPv4(1, 2, 3, 4)}.filled())
+	assert.True(t, NATRange{MinPort: 1}.filled())
+
+	nr := NATRange{
+		MinIP:   net.ParseIP("192.0.2.1"),
+		MaxIP:   net.ParseIP("192.0.2.10"),
+		MinPort: 1024,
+		MaxPort: 2047,
+	}
+	nfa, err := nr.marshal(uint16(ctaNatSrc))
+	require.NoError(t, err)
+	assert.Equal(t, netfilter.Attribute{
+		Type:   uint16(ctaNatSrc),
+		Nested: true,
+		Children: []netfilter.Attribute{
+			{Type: uint16(ctaNATv4MinIP), Data: []byte{192, 0, 2, 1}},
+			{Type: uint16(ctaNATv4MaxIP), Data: []byte{192, 0, 2, 10}},
+			{Type: uint16(ctaNATProto), Nested: true, Children: []netfilter.Attribute{
+				{Type: uint16(ctaProtoNATPortMin), Data: []byte{0x04, 0x00}},
+				{Type: uint16(ctaProtoNATPortMax), Data: []byte{0x07, 0xff}},
+			}},
+		},
+	}, nfa)
+
+	// Single address and port, no maximum given.
+	nfa, err = NATRange{MinIP: net.ParseIP("2001:db8::1"), MinPort: 80}.marshal(uint16(ctaNatDst))
+	require.NoError(t, err)
+	assert.Equal(t, netfilter.Attribute{
+		Type:   uint16(ctaNatDst),
+		Nested: true,
+		Children: []netfilter.Attribute{
+			{Type: uint16(ctaNATv6MinIP), Data: net.ParseIP("2001:db8::1")},
+			{Type: uint16(ctaNATv6MaxIP), Data: net.ParseIP("2001:db8::1")},
+			{Type: uint16(ctaNATProto), Nested: true, Children: []netfilter.Attribute{
+				{Type: uint16(ctaProtoNATPortMin), Data: []byte{0, 80}},
+				{Type: uint16(ctaProtoNATPortMax), Data: []byte{0, 80}},
+			}},
+		},
+	}, nfa)
+
+	_, err = NATRange{MinIP: net.ParseIP("192.0.2.1"), MaxIP: net.ParseIP("2001:db8::1")}.marshal(uint16(ctaNatSrc))
+	assert.Equal(t, errBadNATRange, err)
+
+	_, err = NATRange{MinIP: net.IP{1, 2}}.marshal(uint16(ctaNatSrc))
+	assert.Equal(t, errBadNATRange, err)
+}
+
+// TestAttributeByteOrder checks the wire format of numeric attributes. The kernel
+// sends and expects them in network byte order on all architectures.
+func TestAttributeByteOrder(t *testing.T) {
+
+	ctr := Counter{Packets: 0x0102030405060708, Bytes: 0x1112131415161718}.marshal()
+	assert.Equal(t, []byte{1, 2, 3, 4, 5, 6, 7, 8}, ctr.Children[0].Data)
+
+	seq := SequenceAdjust{Position: 0x01020304}.marshal()
+	assert.Equal(t, []byte{1, 2, 3, 4}, seq.Children[0].Data)
+
+	tpl, err := Tuple{
+		IP:    IPTuple{SourceAddress: net.IPv4(192, 0, 2, 1), DestinationAddress: net.IPv4(192, 0, 2, 2)},
+		Proto: ProtoTuple{Protocol: 6, SourcePort: 0x0102, DestinationPort: 80},
+		Zone:  0x0304,
+	}.marshal(uint16(ctaTupleOrig))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, tpl.Children[1].Children[1].Data)
+	assert.Equal(t, []byte{3, 4}, tpl.Children[2].Data)
+
+	b, err := netfilter.MarshalAttributes([]netfilter.Attribute{ctr})
+	require.NoError(t, err)
+
+	ad, err := netfilter.NewAttributeDecoder(b)
+	require.NoError(t, err)
+	require.True(t, ad.Next())
+
+	var c Counter
+	ad.Nested(c.unmarshal)
+	require.NoError(t, ad.Err())
+	assert.Equal(t, uint64(0x0102030405060708), c.Packets)
+	assert.Equal(t, uint64(0x1112131415161718), c.Bytes)
+}
@@ -0,0 +1,148 @@
+package conntrack
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/mdlayher/netlink"
+)
+
+// deleteBatchSize is the amount of delete requests deleteBatch sends before reading
+// the kernel's acknowledgements, so they fit in the socket's receive buffer.
+const deleteBatchSize = 64
+
+// A pendingDelete is a delete request sent by deleteBatch awaiting its reply.
+type pendingDelete struct {
+	req   netlink.Message
+	flow  Flow
+	start time.Time
+}
+
+// deleteBatch deletes flows by ID over the Conn's Netlink socket, matching the
+// kernel's acknowledgements to their Flow like a Pipeline instead of waiting for
+// each of them. Deleted Flows are counted in res, Flows that failed to be deleted
+// are added to its Errors. Flows that disappeared from the table are neither. The
+// Conn's timeouts apply to the whole batch.
+//
+// Each request is passed through the Conn's SendHooks, and its reply through its
+// ReceiveHooks, like a query. Returns an error if the socket failed, in which case
+// the Flows left are not deleted.
+func (c *Conn) deleteBatch(ctx context.Context, flows []Flow, res *DeleteResult) error {
+
+	c.queryMu.Lock()
+	defer c.queryMu.Unlock()
+
+	done, err := c.deadlines(ctx)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	rc, err := c.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, pipelineBufferSize)
+	for len(flows) > 0 {
+		n := min(len(flows), deleteBatchSize)
+		if err := c.deleteWindow(ctx, rc, b, flows[:n], res); err != nil {
+			return contextError(ctx, err)
+		}
+		flows = flows[n:]
+	}
+
+	return nil
+}
+
+// deleteWindow sends the delete requests of flows, followed by a barrier request,
+// and reads replies from rc into b until the barrier is answered. Requests left
+// without reply at that point were lost in a receive buffer overrun.
+func (c *Conn) deleteWindow(ctx context.Context, rc syscall.RawConn, b []byte, flows []Flow, res *DeleteResult) error {
+
+	pending := make(map[uint32]pendingDelete, len(flows))
+	for _, f := range flows {
+		req, err := deleteRequest(f, true, netlink.Acknowledge)
+		if err == nil {
+			req, err = c.beforeSend(ctx, req)
+		}
+		if err != nil {
+			res.Errors = append(res.Errors, FlowError{Flow: f, Err: err})
+			continue
+		}
+
+		start := time.Now()
+		if req, err = c.send(req); err != nil {
+			return err
+		}
+		pending[req.Header.Sequence] = pendingDelete{req: req, flow: f, start: start}
+	}
+
+	barrier, err := barrierRequest(0)
+	if err != nil {
+		return err
+	}
+	if barrier, err = c.send(barrier); err != nil {
+		return err
+	}
+
+	for {
+		n, err := readDatagram(rc, b)
+		if err == syscall.ENOBUFS {
+			// Replies were dropped by the kernel, the barrier tells which.
+			continue
+		}
+		if err != nil {
+			return &netlink.OpError{Op: "receive", Err: err}
+		}
+
+		msgs, err := splitMessages(bytes.Clone(b[:n]))
+		if err != nil {
+			return err
+		}
+		c.receive(msgs)
+
+		for _, m := range msgs {
+			if m.Header.Sequence == barrier.Header.Sequence {
+				for _, p := range pending {
+					res.Errors = append(res.Errors, FlowError{Flow: p.flow, Err: errPipelineNoReply})
+				}
+				return nil
+			}
+
+			p, ok := pending[m.Header.Sequence]
+			if !ok || m.Header.Type != netlink.Error {
+				continue
+			}
+			delete(pending, m.Header.Sequence)
+
+			c.deleted(ctx, p, m, res)
+		}
+	}
+}
+
+// deleted accounts for the reply m to the delete request p in res, after passing
+// it through the Conn's ReceiveHooks.
+func (c *Conn) deleted(ctx context.Context, p pendingDelete, m netlink.Message, res *DeleteResult) {
+
+	var msgs []netlink.Message
+	err := ackError(m)
+	if err == nil {
+		msgs = []netlink.Message{m}
+	}
+
+	if c.receiveHooks != nil {
+		_, err = c.afterReceive(ctx, Receipt{Request: &p.req, Messages: msgs, Err: err, Start: p.start, Duration: time.Since(p.start)})
+	}
+
+	switch {
+	case err == nil:
+		res.Deleted++
+	case errors.Is(err, syscall.ENOENT):
+	default:
+		res.Errors = append(res.Errors, FlowError{Flow: p.flow, Err: err})
+	}
+}
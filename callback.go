@@ -0,0 +1,130 @@
+package conntrack
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// callbackGroups are the multicast groups joined by Serve for each Event type
+// that can have a callback.
+var callbackGroups = []struct {
+	t     eventType
+	group NetlinkGroup
+}{
+	{EventNew, GroupCTNew},
+	{EventUpdate, GroupCTUpdate},
+	{EventDestroy, GroupCTDestroy},
+}
+
+// OnNew registers fn to be called by Serve for every EventNew, with the Flow of
+// the new connection. Registering another function replaces the previous one, nil
+// removes it. Callbacks must be registered before calling Serve.
+func (c *Conn) OnNew(fn func(Flow)) {
+	c.on(EventNew, fn)
+}
+
+// OnUpdate registers fn to be called by Serve for every EventUpdate, like OnNew.
+func (c *Conn) OnUpdate(fn func(Flow)) {
+	c.on(EventUpdate, fn)
+}
+
+// OnDestroy registers fn to be called by Serve for every EventDestroy, like OnNew.
+func (c *Conn) OnDestroy(fn func(Flow)) {
+	c.on(EventDestroy, fn)
+}
+
+func (c *Conn) on(t eventType, fn func(Flow)) {
+
+	if fn == nil {
+		delete(c.callbacks, t)
+		return
+	}
+
+	if c.callbacks == nil {
+		c.callbacks = make(map[eventType]func(Flow))
+	}
+	c.callbacks[t] = fn
+}
+
+// Serve is an alternative to Listen that calls the callbacks registered using OnNew,
+// OnUpdate and OnDestroy instead of sending Events to a channel. It joins the multicast
+// groups of the Event types that have a callback and starts numWorkers workers, each
+// decoding Events and calling their callback in turn. Callbacks may be called by
+// multiple workers concurrently.
+//
+// A panicking callback is recovered from and logged to the Conn's logger, and counted
+// in ConnStats.CallbackPanics. The worker then continues with the next Event.
+//
+// Serve blocks until ctx is done or a worker fails, for example because the socket's
+// receive buffer overran, and waits for all callbacks to return. It returns ctx.Err()
+// or the error of the failing worker. Like after Listen, the Conn remains subscribed
+// to the multicast groups and should be closed after Serve returns.
+func (c *Conn) Serve(ctx context.Context, numWorkers uint8) error {
+
+	if numWorkers == 0 {
+		return errors.Errorf(errWorkerCount, numWorkers)
+	}
+
+	var groups []NetlinkGroup
+	for _, cg := range callbackGroups {
+		if c.callbacks[cg.t] != nil {
+			groups = append(groups, cg.group)
+		}
+	}
+	if len(groups) == 0 {
+		return errNoCallbacks
+	}
+
+	if c.isMulticast() {
+		return errConnHasListeners
+	}
+
+	if err := c.joinGroups(groups); err != nil {
+		return err
+	}
+
+	errChan := make(chan error, numWorkers)
+	for id := uint8(0); id < numWorkers; id++ {
+		go c.eventWorker(id, c.dispatch, errChan)
+	}
+
+	running := int(numWorkers)
+
+	var err error
+	select {
+	case <-ctx.Done():
+		err = ctx.Err()
+	case err = <-errChan:
+		running--
+	}
+
+	// Interrupt the workers waiting for events and wait for them to stop.
+	c.interrupt()
+	for ; running > 0; running-- {
+		<-errChan
+	}
+	c.clearDeadlines()
+
+	return err
+}
+
+// dispatch calls the callback registered for the type of ev, recovering from panics.
+func (c *Conn) dispatch(ev Event) {
+
+	fn := c.callbacks[ev.Type]
+	if fn == nil || ev.Flow == nil {
+		return
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&c.stats.callbackPanics, 1)
+			c.logger.Error("recovered panic in event callback", "event", ev.Type.String(), "panic", fmt.Sprint(r))
+		}
+	}()
+
+	fn(*ev.Flow)
+}
@@ -0,0 +1,64 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnServe(t *testing.T) {
+
+	sc, nsid, err := makeNSConn()
+	require.NoError(t, err)
+	defer sc.Close()
+
+	lc, err := Dial(&netlink.Config{NetNS: nsid})
+	require.NoError(t, err)
+	defer lc.Close()
+
+	var created, destroyed uint32
+	lc.OnNew(func(f Flow) {
+		atomic.AddUint32(&created, 1)
+		if f.TupleOrig.Proto.SourcePort == 1 {
+			panic("callback failed")
+		}
+	})
+	lc.OnDestroy(func(f Flow) { atomic.AddUint32(&destroyed, 1) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errChan := make(chan error)
+	go func() { errChan <- lc.Serve(ctx, 2) }()
+
+	// Wait for Serve to join the multicast groups.
+	require.Eventually(t, lc.isMulticast, time.Second, time.Millisecond)
+
+	numFlows := 10
+	for i := 1; i <= numFlows; i++ {
+		f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), uint16(i), 53, 120, 0)
+		require.NoError(t, sc.Create(f))
+		require.NoError(t, sc.Delete(f))
+	}
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadUint32(&created) == uint32(numFlows) && atomic.LoadUint32(&destroyed) == uint32(numFlows)
+	}, time.Second, time.Millisecond)
+	assert.Equal(t, uint64(1), lc.ConnStats().CallbackPanics)
+
+	cancel()
+	select {
+	case err := <-errChan:
+		assert.Equal(t, context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for Serve to return")
+	}
+
+	assert.Equal(t, errConnHasListeners, lc.Serve(context.Background(), 1))
+}
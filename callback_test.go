@@ -0,0 +1,43 @@
+package conntrack
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnCallbacks(t *testing.T) {
+
+	var c Conn
+
+	var got []uint32
+	c.OnNew(func(f Flow) { got = append(got, f.ID) })
+	c.OnDestroy(func(f Flow) { panic("boom") })
+	c.OnUpdate(func(f Flow) {})
+	c.OnUpdate(nil)
+
+	assert.Len(t, c.callbacks, 2)
+
+	var buf bytes.Buffer
+	c.logger = slog.New(slog.NewTextHandler(&buf, nil))
+
+	c.dispatch(Event{Type: EventNew, Flow: &Flow{ID: 1}})
+	c.dispatch(Event{Type: EventUpdate, Flow: &Flow{ID: 2}})
+	c.dispatch(Event{Type: EventNew})
+	assert.Equal(t, []uint32{1}, got)
+
+	assert.NotPanics(t, func() { c.dispatch(Event{Type: EventDestroy, Flow: &Flow{}}) })
+	assert.Equal(t, uint64(1), c.ConnStats().CallbackPanics)
+	assert.Contains(t, buf.String(), `level=ERROR msg="recovered panic in event callback" event=EventDestroy panic=boom`)
+}
+
+func TestConnServeErrors(t *testing.T) {
+
+	var c Conn
+
+	assert.EqualError(t, c.Serve(context.Background(), 0), "invalid worker count 0")
+	assert.Equal(t, errNoCallbacks, c.Serve(context.Background(), 1))
+}
@@ -0,0 +1,152 @@
+package conntrack
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// sysctlDir is the directory holding the kernel's Conntrack tunables
+// of the calling process' network namespace.
+var sysctlDir = "/proc/sys/net/netfilter"
+
+// Capabilities describes which optional Conntrack features are active in the
+// kernel, so tools can tell an absent attribute apart from a disabled feature.
+type Capabilities struct {
+	// Accounting is true if the kernel counts packets and bytes per connection,
+	// filling Flow.CountersOrig and Flow.CountersReply. Enabled using
+	// `sysctl net.netfilter.nf_conntrack_acct=1`.
+	Accounting bool
+
+	// Timestamps is true if the kernel records the start and stop time of
+	// connections in Flow.Timestamp. Enabled using
+	// `sysctl net.netfilter.nf_conntrack_timestamp=1`.
+	Timestamps bool
+
+	// Events is true if the kernel delivers Conntrack events to listeners.
+	// Controlled by `sysctl net.netfilter.nf_conntrack_events`.
+	Events bool
+
+	// Zones is true if the kernel was built with Conntrack zone support.
+	Zones bool
+
+	// LabelsSize is the size in bytes of the connection labels found in the
+	// table, or 0 if no Flow carries labels.
+	LabelsSize int
+
+	// Sysctls is true if the values of Accounting, Timestamps and Events were
+	// read from the kernel's tunables. When false, Accounting and Timestamps are
+	// only derived from the Flows in the table, and Events is always false.
+	Sysctls bool
+}
+
+// Capabilities probes which optional Conntrack features are active. It dumps the
+// table to look for counters, timestamps, labels and zones on existing Flows and
+// checks whether the kernel accepts a zone in a query.
+//
+// Sysctls are read from /proc/sys/net/netfilter. Since this reflects the network
+// namespace of the calling process, they are not consulted for Conns dialed into
+// another namespace using netlink.Config.NetNS. See Capabilities.Sysctls.
+func (c *Conn) Capabilities() (Capabilities, error) {
+
+	var caps Capabilities
+
+	flows, err := c.Dump()
+	if err != nil {
+		return caps, err
+	}
+	caps.inspect(flows)
+
+	if !caps.Zones {
+		caps.Zones, err = c.probeZones()
+		if err != nil {
+			return caps, err
+		}
+	}
+
+	if !c.netNS {
+		if err := caps.readSysctls(sysctlDir); err != nil {
+			return caps, err
+		}
+	}
+
+	return caps, nil
+}
+
+// inspect sets the Capabilities evidenced by the attributes of the given Flows.
+func (caps *Capabilities) inspect(flows []Flow) {
+
+	for _, f := range flows {
+		if f.CountersOrig.Packets != 0 || f.CountersOrig.Bytes != 0 ||
+			f.CountersReply.Packets != 0 || f.CountersReply.Bytes != 0 {
+			caps.Accounting = true
+		}
+
+		if !f.Timestamp.Start.IsZero() {
+			caps.Timestamps = true
+		}
+
+		if f.Zone != 0 || f.TupleOrig.Zone != 0 || f.TupleReply.Zone != 0 {
+			caps.Zones = true
+		}
+
+		caps.LabelsSize = max(caps.LabelsSize, len(f.Labels))
+	}
+}
+
+// probeZones queries the kernel for a connection in a non-default zone. Kernels
+// without zone support reject the query with EOPNOTSUPP instead of ENOENT.
+func (c *Conn) probeZones() (bool, error) {
+
+	f := NewFlow(6, 0, net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2), 1, 1, 0, 0)
+	f.Zone = 1
+
+	_, err := c.Get(f)
+	switch {
+	case err == nil, errors.Is(err, syscall.ENOENT):
+		return true, nil
+	case errors.Is(err, syscall.EOPNOTSUPP):
+		return false, nil
+	}
+
+	return false, err
+}
+
+// readSysctls reads the Accounting, Timestamps and Events tunables from dir.
+// Tunables missing from dir leave their Capabilities untouched.
+func (caps *Capabilities) readSysctls(dir string) error {
+
+	sysctls := []struct {
+		name string
+		cap  *bool
+	}{
+		{"nf_conntrack_acct", &caps.Accounting},
+		{"nf_conntrack_timestamp", &caps.Timestamps},
+		{"nf_conntrack_events", &caps.Events},
+	}
+
+	for _, s := range sysctls {
+		b, err := os.ReadFile(filepath.Join(dir, s.name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+		if err != nil {
+			return err
+		}
+
+		// nf_conntrack_events can be 2, enabling events only while there are listeners.
+		*s.cap = v != 0
+		caps.Sysctls = true
+	}
+
+	return nil
+}
@@ -0,0 +1,43 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnCapabilities(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	caps, err := c.Capabilities()
+	require.NoError(t, err)
+
+	// Sysctls of the test process' namespace don't apply to the Conn.
+	assert.False(t, caps.Sysctls)
+	assert.True(t, caps.Zones)
+	assert.Equal(t, 0, caps.LabelsSize)
+
+	// A Flow in a non-default zone is found in the dump.
+	f := NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0)
+	f.Zone = 1
+	require.NoError(t, c.Create(f))
+
+	caps, err = c.Capabilities()
+	require.NoError(t, err)
+	assert.True(t, caps.Zones)
+
+	hc, err := Dial(nil)
+	require.NoError(t, err)
+	defer hc.Close()
+
+	caps, err = hc.Capabilities()
+	require.NoError(t, err)
+	assert.True(t, caps.Sysctls)
+}
@@ -0,0 +1,49 @@
+package conntrack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCapabilitiesInspect(t *testing.T) {
+
+	var caps Capabilities
+	caps.inspect([]Flow{{}})
+	assert.Equal(t, Capabilities{}, caps)
+
+	caps.inspect([]Flow{
+		{CountersReply: Counter{Packets: 1, Bytes: 60}},
+		{Timestamp: Timestamp{Start: time.Unix(1, 0)}},
+		{TupleReply: Tuple{Zone: 2}, Labels: make([]byte, 16)},
+	})
+	assert.Equal(t, Capabilities{Accounting: true, Timestamps: true, Zones: true, LabelsSize: 16}, caps)
+}
+
+func TestCapabilitiesReadSysctls(t *testing.T) {
+
+	dir := t.TempDir()
+
+	// Missing tunables leave Capabilities untouched.
+	caps := Capabilities{Accounting: true}
+	require.NoError(t, caps.readSysctls(dir))
+	assert.Equal(t, Capabilities{Accounting: true}, caps)
+
+	write := func(name, val string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(val), 0644))
+	}
+
+	write("nf_conntrack_acct", "0\n")
+	write("nf_conntrack_timestamp", "1\n")
+	write("nf_conntrack_events", "2\n")
+
+	require.NoError(t, caps.readSysctls(dir))
+	assert.Equal(t, Capabilities{Timestamps: true, Events: true, Sysctls: true}, caps)
+
+	write("nf_conntrack_acct", "yes\n")
+	assert.Error(t, caps.readSysctls(dir))
+}
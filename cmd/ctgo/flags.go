@@ -0,0 +1,100 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ti-mo/conntrack"
+)
+
+var errNeedAddrs = errors.New("need a source and destination address, -s and -d")
+
+// protocols maps the protocol names accepted by -p to their numbers.
+var protocols = map[string]uint8{
+	"icmp":    1,
+	"tcp":     6,
+	"udp":     17,
+	"dccp":    33,
+	"gre":     47,
+	"icmpv6":  58,
+	"sctp":    132,
+	"udplite": 136,
+}
+
+// flowFlags registers the flags describing a Flow on fs, named after those of
+// conntrack(8). The returned function builds the Flow after fs was parsed.
+func flowFlags(fs *flag.FlagSet) func() (conntrack.Flow, error) {
+
+	proto := fs.String("p", "tcp", "layer 4 `protocol` name or number")
+	src := fs.String("s", "", "source `address` of the original direction")
+	dst := fs.String("d", "", "destination `address` of the original direction")
+	sport := fs.Uint("sport", 0, "source `port` of the original direction")
+	dport := fs.Uint("dport", 0, "destination `port` of the original direction")
+	timeout := fs.Uint("t", 120, "`timeout` of the Flow in seconds")
+	mark := fs.Uint("m", 0, "connection `mark`")
+	zone := fs.Uint("z", 0, "conntrack `zone`")
+
+	return func() (conntrack.Flow, error) {
+
+		p, ok := protocols[*proto]
+		if !ok {
+			n, err := parseUint(*proto, 8)
+			if err != nil {
+				return conntrack.Flow{}, fmt.Errorf("unknown protocol '%s'", *proto)
+			}
+			p = uint8(n)
+		}
+
+		s, d := net.ParseIP(*src), net.ParseIP(*dst)
+		if s == nil || d == nil {
+			return conntrack.Flow{}, errNeedAddrs
+		}
+
+		if *sport > 0xffff || *dport > 0xffff || *zone > 0xffff {
+			return conntrack.Flow{}, errors.New("ports and zone need to fit in 16 bits")
+		}
+
+		f := conntrack.NewFlow(p, 0, s, d, uint16(*sport), uint16(*dport), uint32(*timeout), uint32(*mark))
+		f.Zone = uint16(*zone)
+
+		return f, nil
+	}
+}
+
+// parseGroups converts a comma-separated list of event types into the multicast
+// groups to join, either for Flow or for expectation events.
+func parseGroups(s string, exp bool) ([]conntrack.NetlinkGroup, error) {
+
+	names := map[string]conntrack.NetlinkGroup{
+		"new":     conntrack.GroupCTNew,
+		"update":  conntrack.GroupCTUpdate,
+		"destroy": conntrack.GroupCTDestroy,
+	}
+	if exp {
+		names = map[string]conntrack.NetlinkGroup{
+			"new":     conntrack.GroupCTExpNew,
+			"update":  conntrack.GroupCTExpUpdate,
+			"destroy": conntrack.GroupCTExpDestroy,
+		}
+	}
+
+	var groups []conntrack.NetlinkGroup
+	for _, n := range strings.Split(s, ",") {
+		g, ok := names[strings.TrimSpace(n)]
+		if !ok {
+			return nil, fmt.Errorf("unknown event type '%s'", n)
+		}
+		groups = append(groups, g)
+	}
+
+	return groups, nil
+}
+
+// parseUint parses a decimal or 0x-prefixed hexadecimal number of the given size.
+func parseUint(s string, bits int) (uint64, error) {
+	return strconv.ParseUint(s, 0, bits)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntrack"
+)
+
+func TestFlowFlags(t *testing.T) {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flow := flowFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-p", "udp", "-s", "10.0.0.1", "-d", "10.0.0.2", "-sport", "53", "-dport", "5353", "-m", "255", "-z", "3"}))
+
+	f, err := flow()
+	require.NoError(t, err)
+
+	want := conntrack.NewFlow(17, 0, net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), 53, 5353, 120, 255)
+	want.Zone = 3
+	assert.Equal(t, want, f)
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	flow = flowFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-p", "132", "-s", "::1", "-d", "::2"}))
+	f, err = flow()
+	require.NoError(t, err)
+	assert.Equal(t, uint8(132), f.TupleOrig.Proto.Protocol)
+
+	for _, args := range [][]string{
+		{"-s", "10.0.0.1"},
+		{"-p", "foo", "-s", "10.0.0.1", "-d", "10.0.0.2"},
+		{"-s", "10.0.0.1", "-d", "10.0.0.2", "-sport", "65536"},
+	} {
+		fs = flag.NewFlagSet("test", flag.ContinueOnError)
+		flow = flowFlags(fs)
+		require.NoError(t, fs.Parse(args))
+		_, err = flow()
+		assert.Error(t, err, args)
+	}
+}
+
+func TestParseGroups(t *testing.T) {
+
+	g, err := parseGroups("new, destroy", false)
+	require.NoError(t, err)
+	assert.Equal(t, []conntrack.NetlinkGroup{conntrack.GroupCTNew, conntrack.GroupCTDestroy}, g)
+
+	g, err = parseGroups("update", true)
+	require.NoError(t, err)
+	assert.Equal(t, []conntrack.NetlinkGroup{conntrack.GroupCTExpUpdate}, g)
+
+	_, err = parseGroups("new,bogus", false)
+	assert.EqualError(t, err, "unknown event type 'bogus'")
+}
+
+func TestFilterFlags(t *testing.T) {
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := filterFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-mark", "0x10", "-mask", "255"}))
+	assert.Equal(t, conntrack.Filter{Mark: 0x10, Mask: 0xff}, *f)
+
+	// -mark without -mask matches the whole mark, in any order.
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	f = filterFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-mark", "0"}))
+	assert.Equal(t, conntrack.Filter{Mark: 0, Mask: 0xffffffff}, *f)
+
+	fs = flag.NewFlagSet("test", flag.ContinueOnError)
+	f = filterFlags(fs)
+	require.NoError(t, fs.Parse([]string{"-mask", "0xf0", "-mark", "0x10"}))
+	assert.Equal(t, conntrack.Filter{Mark: 0x10, Mask: 0xf0}, *f)
+
+	assert.Error(t, fs.Parse([]string{"-mark", "0x100000000"}))
+}
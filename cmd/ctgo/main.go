@@ -0,0 +1,372 @@
+// Command ctgo manipulates and observes the Conntrack table, implementing a
+// subset of conntrack(8) using the conntrack package.
+//
+//	ctgo list [-f ipv4|ipv6] [-mark m [-mask m]] [-o text|json|csv|tsv]
+//	ctgo watch [-e new,update,destroy] [-x] [-o text|json]
+//	ctgo create -p tcp -s 10.0.0.1 -d 10.0.0.2 -sport 1234 -dport 80 -t 120 [-m mark] [-z zone]
+//	ctgo delete -p tcp -s 10.0.0.1 -d 10.0.0.2 -sport 1234 -dport 80 [-z zone]
+//	ctgo flush [-mark m [-mask m]] [-x]
+//	ctgo stats [-x]
+//	ctgo expect [-o text|json]
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/ti-mo/conntrack"
+)
+
+const usage = `usage: ctgo <command> [flags]
+
+Commands:
+  list, dump  list the Conntrack table
+  watch       print Conntrack events as they occur
+  create      create a Flow
+  delete      delete a Flow
+  flush       empty the Conntrack table or the expectation table
+  stats       print Conntrack statistics
+  expect      list the expectation table
+
+Run 'ctgo <command> -h' for the flags of a command.
+`
+
+var commands = map[string]func(args []string, out io.Writer) error{
+	"list":   list,
+	"dump":   list,
+	"watch":  watch,
+	"create": create,
+	"delete": deleteFlow,
+	"flush":  flush,
+	"stats":  stats,
+	"expect": expect,
+}
+
+func main() {
+
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "ctgo: unknown command '%s'\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err := cmd(os.Args[2:], os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "ctgo %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
+
+func list(args []string, out io.Writer) error {
+
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	family := fs.String("f", "", "only list Flows of `family` ipv4 or ipv6")
+	filter := filterFlags(fs)
+	format := fs.String("o", "text", "output `format`: text, json, csv or tsv")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pf, err := parseFamily(*family)
+	if err != nil {
+		return err
+	}
+
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	var flows []conntrack.Flow
+	if filter.Mask != 0 {
+		flows, err = c.DumpFilter(*filter, conntrack.DumpFamily(pf))
+	} else {
+		flows, err = c.Dump(conntrack.DumpFamily(pf))
+	}
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		for _, f := range flows {
+			fmt.Fprintln(out, f)
+		}
+	case "json":
+		enc := json.NewEncoder(out)
+		for _, f := range flows {
+			if err := enc.Encode(f); err != nil {
+				return err
+			}
+		}
+	case "csv", "tsv":
+		enc := conntrack.NewCSVEncoder(out)
+		if *format == "tsv" {
+			enc.Comma = '\t'
+		}
+		return enc.Encode(flows)
+	default:
+		return fmt.Errorf("unknown output format '%s'", *format)
+	}
+
+	return nil
+}
+
+func watch(args []string, out io.Writer) error {
+
+	fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+	events := fs.String("e", "new,update,destroy", "comma-separated list of event `types` to watch")
+	exp := fs.Bool("x", false, "watch expectation events instead of Flow events")
+	format := fs.String("o", "text", "output `format`: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	groups, err := parseGroups(*events, *exp)
+	if err != nil {
+		return err
+	}
+
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown output format '%s'", *format)
+	}
+
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	evChan := make(chan conntrack.Event, 1024)
+	errChan, err := c.Listen(evChan, 1, groups)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	enc := json.NewEncoder(out)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-errChan:
+			return err
+		case ev := <-evChan:
+			if *format == "json" {
+				if err := enc.Encode(ev); err != nil {
+					return err
+				}
+				continue
+			}
+			fmt.Fprintln(out, ev)
+		}
+	}
+}
+
+func create(args []string, _ io.Writer) error {
+
+	fs := flag.NewFlagSet("create", flag.ContinueOnError)
+	flow := flowFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := flow()
+	if err != nil {
+		return err
+	}
+
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.Create(f)
+}
+
+func deleteFlow(args []string, _ io.Writer) error {
+
+	fs := flag.NewFlagSet("delete", flag.ContinueOnError)
+	flow := flowFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	f, err := flow()
+	if err != nil {
+		return err
+	}
+
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	return c.Delete(f)
+}
+
+func flush(args []string, _ io.Writer) error {
+
+	fs := flag.NewFlagSet("flush", flag.ContinueOnError)
+	filter := filterFlags(fs)
+	exp := fs.Bool("x", false, "flush the expectation table instead of the Conntrack table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	switch {
+	case *exp:
+		return c.FlushExpect()
+	case filter.Mask != 0:
+		return c.FlushFilter(*filter)
+	}
+
+	return c.Flush()
+}
+
+func stats(args []string, out io.Writer) error {
+
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	exp := fs.Bool("x", false, "print expectation statistics instead of Conntrack statistics")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if *exp {
+		se, err := c.StatsExpect()
+		if err != nil {
+			return err
+		}
+		for _, s := range se {
+			fmt.Fprintf(out, "cpu=%d new=%d create=%d delete=%d\n", s.CPUID, s.New, s.Create, s.Delete)
+		}
+		return nil
+	}
+
+	sg, err := c.StatsGlobal()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "entries=%d max=%d\n", sg.Entries, sg.MaxEntries)
+
+	cs, err := c.Stats()
+	if err != nil {
+		return err
+	}
+	for _, s := range cs {
+		fmt.Fprintln(out, s)
+	}
+
+	return nil
+}
+
+func expect(args []string, out io.Writer) error {
+
+	fs := flag.NewFlagSet("expect", flag.ContinueOnError)
+	format := fs.String("o", "text", "output `format`: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	exps, err := c.DumpExpect()
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "text":
+		for _, ex := range exps {
+			fmt.Fprintf(out, "%d proto=%d master=%s tuple=%s mask=%s helper=%s\n",
+				ex.Timeout, ex.Tuple.Proto.Protocol, ex.TupleMaster, ex.Tuple, ex.Mask, ex.HelpName)
+		}
+	case "json":
+		enc := json.NewEncoder(out)
+		for _, ex := range exps {
+			if err := enc.Encode(ex); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unknown output format '%s'", *format)
+	}
+
+	return nil
+}
+
+// filterFlags registers the flags of a mark Filter on fs. Unless -mask is given,
+// -mark matches the whole connection mark.
+func filterFlags(fs *flag.FlagSet) *conntrack.Filter {
+
+	var f conntrack.Filter
+	var masked bool
+
+	mark, mask := uintFlag(&f.Mark), uintFlag(&f.Mask)
+	fs.Func("mark", "only consider Flows with this connection `mark`, after applying -mask", func(s string) error {
+		if !masked {
+			f.Mask = 0xffffffff
+		}
+		return mark(s)
+	})
+	fs.Func("mask", "`mask` applied to connection marks before comparing them to -mark (default 0xffffffff)", func(s string) error {
+		masked = true
+		return mask(s)
+	})
+
+	return &f
+}
+
+func uintFlag(v *uint32) func(string) error {
+	return func(s string) error {
+		u, err := parseUint(s, 32)
+		*v = uint32(u)
+		return err
+	}
+}
+
+func parseFamily(s string) (conntrack.ProtoFamily, error) {
+	switch s {
+	case "":
+		return conntrack.ProtoUnspec, nil
+	case "ipv4":
+		return conntrack.ProtoIPv4, nil
+	case "ipv6":
+		return conntrack.ProtoIPv6, nil
+	}
+	return 0, fmt.Errorf("unknown family '%s'", s)
+}
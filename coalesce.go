@@ -0,0 +1,190 @@
+package conntrack
+
+import (
+	"context"
+	"time"
+)
+
+// A CoalescedEvent is an Event produced by a Coalescer. For a burst of EventUpdate
+// Events of the same connection, it holds the last Event of the burst.
+type CoalescedEvent struct {
+	Event
+
+	// Merged is the amount of Events the CoalescedEvent stands for,
+	// 1 for Events that were passed through as-is.
+	Merged int
+
+	// Fields holds the properties of the Flow that changed between the first
+	// and the last Event of the burst.
+	Fields FlowField
+
+	// Delta holds the traffic the Flow saw between the first and the last Event
+	// of the burst, and the time between receiving them. Its counters are only
+	// filled when the kernel has accounting enabled.
+	Delta AccountingDelta
+}
+
+// A Coalescer merges bursts of EventUpdate Events of the same connection into a
+// single CoalescedEvent. The first EventUpdate of a connection opens a window
+// during which further EventUpdates of that connection are merged into it. When
+// the window closes, a single CoalescedEvent carrying the latest state of the Flow
+// is emitted. A TCP connection going through its handshake and teardown can emit
+// ten or more EventUpdates that collapse into a few.
+//
+// All other Events are passed through as soon as they are received. An EventDestroy
+// first flushes any pending EventUpdate of the same connection, so the order of
+// Events of a connection is preserved. Connections are identified by their Flow's
+// Key, which includes their zone.
+type Coalescer struct {
+	window time.Duration
+
+	pending map[FlowKey]*coalescing
+	// queue holds the keys of pending Events in the order their windows close.
+	queue []FlowKey
+}
+
+// coalescing is an EventUpdate being merged with others.
+type coalescing struct {
+	ev       CoalescedEvent
+	first    Flow
+	firstAt  time.Time
+	deadline time.Time
+}
+
+// NewCoalescer returns a Coalescer merging EventUpdates within window.
+// A window of 0 passes all Events through as-is.
+func NewCoalescer(window time.Duration) *Coalescer {
+	return &Coalescer{
+		window:  window,
+		pending: make(map[FlowKey]*coalescing),
+	}
+}
+
+// Run reads Events from in and sends CoalescedEvents to out until ctx is done
+// or in is closed. When in is closed, pending Events are flushed to out before
+// returning nil. Returns ctx.Err() when ctx is done, discarding pending Events.
+func (c *Coalescer) Run(ctx context.Context, in <-chan Event, out chan<- CoalescedEvent) error {
+
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	// send sends ce to out, unless ctx is done.
+	send := func(ce CoalescedEvent) error {
+		select {
+		case out <- ce:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case ev, ok := <-in:
+			if !ok {
+				for _, ce := range c.flush(time.Time{}) {
+					if err := send(ce); err != nil {
+						return err
+					}
+				}
+				return nil
+			}
+
+			wasIdle := len(c.queue) == 0
+			for _, ce := range c.add(ev, time.Now()) {
+				if err := send(ce); err != nil {
+					return err
+				}
+			}
+			if wasIdle && len(c.queue) != 0 {
+				timer.Reset(time.Until(c.pending[c.queue[0]].deadline))
+			}
+
+		case <-timer.C:
+			for _, ce := range c.flush(time.Now()) {
+				if err := send(ce); err != nil {
+					return err
+				}
+			}
+			if len(c.queue) != 0 {
+				timer.Reset(time.Until(c.pending[c.queue[0]].deadline))
+			}
+		}
+	}
+}
+
+// add processes ev received at now and returns the CoalescedEvents ready to be sent.
+func (c *Coalescer) add(ev Event, now time.Time) []CoalescedEvent {
+
+	if ev.Flow == nil || c.window <= 0 || (ev.Type != EventUpdate && ev.Type != EventDestroy) {
+		return []CoalescedEvent{{Event: ev, Merged: 1}}
+	}
+
+	k := ev.Flow.Key()
+	p, ok := c.pending[k]
+
+	if ev.Type == EventDestroy {
+		out := []CoalescedEvent{{Event: ev, Merged: 1}}
+		if ok {
+			out = append([]CoalescedEvent{p.ev}, out...)
+			c.remove(k)
+		}
+		return out
+	}
+
+	if !ok {
+		c.pending[k] = &coalescing{
+			ev:       CoalescedEvent{Event: ev, Merged: 1},
+			first:    *ev.Flow,
+			firstAt:  now,
+			deadline: now.Add(c.window),
+		}
+		c.queue = append(c.queue, k)
+		return nil
+	}
+
+	p.ev.Fields |= changedFields(*p.ev.Flow, *ev.Flow)
+	p.ev.Event = ev
+	p.ev.Merged++
+	p.ev.Delta = FlowDelta(p.first, *ev.Flow, now.Sub(p.firstAt))
+
+	return nil
+}
+
+// flush returns the pending Events whose window closed at now, in order.
+// A zero now flushes all pending Events.
+func (c *Coalescer) flush(now time.Time) []CoalescedEvent {
+
+	var out []CoalescedEvent
+
+	for len(c.queue) != 0 {
+		k := c.queue[0]
+		p := c.pending[k]
+		if !now.IsZero() && p.deadline.After(now) {
+			break
+		}
+
+		out = append(out, p.ev)
+		delete(c.pending, k)
+		c.queue = c.queue[1:]
+	}
+
+	return out
+}
+
+// remove removes the pending Event with key k before its window closes.
+func (c *Coalescer) remove(k FlowKey) {
+
+	delete(c.pending, k)
+
+	for i, qk := range c.queue {
+		if qk == k {
+			c.queue = append(c.queue[:i], c.queue[i+1:]...)
+			return
+		}
+	}
+}
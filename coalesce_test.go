@@ -0,0 +1,145 @@
+package conntrack
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// updateEvent returns an Event of type et for a UDP Flow with the given source port,
+// mark and original counters.
+func updateEvent(et eventType, sport uint16, mark uint32, packets uint64) Event {
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), sport, 53, 120, mark)
+	f.CountersOrig = Counter{Packets: packets, Bytes: packets * 100}
+	return Event{Type: et, Flow: &f}
+}
+
+func TestCoalescerMerge(t *testing.T) {
+
+	c := NewCoalescer(time.Second)
+	t0 := time.Unix(0, 0)
+
+	assert.Empty(t, c.add(updateEvent(EventUpdate, 1, 0, 1), t0))
+	assert.Empty(t, c.add(updateEvent(EventUpdate, 2, 0, 1), t0.Add(100*time.Millisecond)))
+	assert.Empty(t, c.add(updateEvent(EventUpdate, 1, 1, 3), t0.Add(200*time.Millisecond)))
+	assert.Empty(t, c.add(updateEvent(EventUpdate, 1, 1, 6), t0.Add(500*time.Millisecond)))
+
+	// Other Events are passed through.
+	out := c.add(updateEvent(EventNew, 3, 0, 0), t0)
+	require.Len(t, out, 1)
+	assert.Equal(t, EventNew, out[0].Type)
+	assert.Equal(t, 1, out[0].Merged)
+
+	assert.Empty(t, c.flush(t0.Add(999*time.Millisecond)))
+
+	out = c.flush(t0.Add(time.Second))
+	require.Len(t, out, 1)
+	ce := out[0]
+	assert.Equal(t, 3, ce.Merged)
+	assert.Equal(t, uint32(1), ce.Flow.Mark, "latest state")
+	assert.Equal(t, FieldMark|FieldCountersOrig, ce.Fields)
+	assert.Equal(t, Counter{Packets: 5, Bytes: 500}, ce.Delta.Orig)
+	assert.Equal(t, 500*time.Millisecond, ce.Delta.Elapsed)
+
+	out = c.flush(time.Time{})
+	require.Len(t, out, 1)
+	assert.Equal(t, uint16(2), out[0].Flow.TupleOrig.Proto.SourcePort)
+	assert.Equal(t, 1, out[0].Merged)
+
+	assert.Empty(t, c.pending)
+	assert.Empty(t, c.queue)
+}
+
+func TestCoalescerDestroy(t *testing.T) {
+
+	c := NewCoalescer(time.Second)
+	t0 := time.Unix(0, 0)
+
+	assert.Empty(t, c.add(updateEvent(EventUpdate, 1, 0, 1), t0))
+	assert.Empty(t, c.add(updateEvent(EventUpdate, 2, 0, 1), t0))
+
+	out := c.add(updateEvent(EventDestroy, 1, 0, 2), t0)
+	require.Len(t, out, 2)
+	assert.Equal(t, EventUpdate, out[0].Type)
+	assert.Equal(t, EventDestroy, out[1].Type)
+
+	// Destroy without pending update.
+	out = c.add(updateEvent(EventDestroy, 3, 0, 2), t0)
+	require.Len(t, out, 1)
+	assert.Equal(t, EventDestroy, out[0].Type)
+
+	out = c.flush(time.Time{})
+	require.Len(t, out, 1)
+	assert.Equal(t, uint16(2), out[0].Flow.TupleOrig.Proto.SourcePort)
+}
+
+func TestCoalescerZones(t *testing.T) {
+
+	c := NewCoalescer(time.Second)
+	t0 := time.Unix(0, 0)
+
+	// The same tuple in another conntrack zone is another connection.
+	z := updateEvent(EventUpdate, 1, 0, 1)
+	z.Flow.Zone = 1
+
+	assert.Empty(t, c.add(updateEvent(EventUpdate, 1, 0, 1), t0))
+	assert.Empty(t, c.add(z, t0))
+	assert.Len(t, c.pending, 2)
+
+	// Destroying one of them leaves the other pending.
+	out := c.add(updateEvent(EventDestroy, 1, 0, 2), t0)
+	require.Len(t, out, 2)
+	assert.Equal(t, uint16(0), out[0].Flow.Zone)
+
+	out = c.flush(time.Time{})
+	require.Len(t, out, 1)
+	assert.Equal(t, uint16(1), out[0].Flow.Zone)
+}
+
+func TestCoalescerNoWindow(t *testing.T) {
+
+	c := NewCoalescer(0)
+
+	out := c.add(updateEvent(EventUpdate, 1, 0, 1), time.Now())
+	require.Len(t, out, 1)
+	assert.Equal(t, 1, out[0].Merged)
+}
+
+func TestCoalescerRun(t *testing.T) {
+
+	c := NewCoalescer(20 * time.Millisecond)
+
+	in := make(chan Event)
+	out := make(chan CoalescedEvent, 10)
+	errChan := make(chan error)
+
+	go func() { errChan <- c.Run(context.Background(), in, out) }()
+
+	for i := uint64(1); i <= 5; i++ {
+		in <- updateEvent(EventUpdate, 1, 0, i)
+	}
+
+	// The window closes without further Events.
+	select {
+	case ce := <-out:
+		assert.Equal(t, 5, ce.Merged)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for coalesced event")
+	}
+
+	// Closing in flushes pending Events.
+	in <- updateEvent(EventUpdate, 2, 0, 1)
+	close(in)
+	require.NoError(t, <-errChan)
+
+	ce := <-out
+	assert.Equal(t, uint16(2), ce.Flow.TupleOrig.Proto.SourcePort)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, c.Run(ctx, make(chan Event), out))
+}
@@ -1,28 +1,95 @@
 package conntrack
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/mdlayher/netlink"
 	"github.com/pkg/errors"
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 // Conn represents a Netlink connection to the Netfilter
 // subsystem and implements all Conntrack actions.
 type Conn struct {
-	conn *netfilter.Conn
+	// stats and the timeouts are accessed atomically and must stay 64-bit aligned.
+	stats connStats
+
+	readTimeout, writeTimeout int64
+
+	conn *netlink.Conn
+	// queryMu serializes queries along with the socket deadlines they set.
+	queryMu sync.Mutex
+
+	// multicast is set once the Conn joined multicast groups. It can no longer
+	// be used for queries for its remaining lifetime.
+	multicast bool
+	groupsMu  sync.RWMutex
+
+	// netNS is true if the Conn was dialed into another network namespace.
+	netNS bool
+
+	logger    *slog.Logger
+	lenient   bool
+	canonical bool
+	strict    bool
+	recorder  *Recorder
+
+	overflow    OverflowPolicy
+	eventBuffer int
+	samplers    map[eventType]Sampler
+
+	callbacks map[eventType]func(Flow)
+
+	decodeErrors decodeErrorStats
+
+	operationHooks []OperationHook
+	sendHooks      []SendHook
+	receiveHooks   []ReceiveHook
 }
 
 // Dial opens a new Netfilter Netlink connection and returns it
 // wrapped in a Conn structure that implements the Conntrack API.
-func Dial(config *netlink.Config) (*Conn, error) {
-	c, err := netfilter.Dial(config)
+// Any Options given are applied to the Conn before it is returned.
+func Dial(config *netlink.Config, opts ...Option) (*Conn, error) {
+	nlc, err := dialNetlink(config)
 	if err != nil {
 		return nil, err
 	}
 
-	return &Conn{c}, nil
+	c := &Conn{
+		conn:   nlc,
+		netNS:  config != nil && config.NetNS != 0,
+		logger: slog.New(discardHandler{}),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.strict {
+		if err := c.setStrictCheck(); err != nil {
+			nlc.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// setStrictCheck enables strict checking of the requests sent over the Conn's socket.
+func (c *Conn) setStrictCheck() error {
+
+	rc, err := c.conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	return setStrictCheck(rc)
 }
 
 // Close closes a Conn.
@@ -30,6 +97,71 @@ func (c *Conn) Close() error {
 	return c.conn.Close()
 }
 
+// execute sends req over the Conn's Netlink socket and returns the validated
+// replies. Fails if the Conn joined multicast groups.
+func (c *Conn) execute(req netlink.Message) ([]netlink.Message, error) {
+
+	c.groupsMu.RLock()
+	defer c.groupsMu.RUnlock()
+
+	if c.multicast {
+		return nil, errConnIsMulticast
+	}
+
+	nlm, err := c.conn.Execute(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "netfilter query")
+	}
+
+	return nlm, nil
+}
+
+// send sends req over the Conn's Netlink socket without waiting for the replies.
+// Returns req as sent, with its sequence number and PID filled in. Fails if the
+// Conn joined multicast groups.
+func (c *Conn) send(req netlink.Message) (netlink.Message, error) {
+
+	c.groupsMu.RLock()
+	defer c.groupsMu.RUnlock()
+
+	if c.multicast {
+		return netlink.Message{}, errConnIsMulticast
+	}
+
+	return c.conn.Send(req)
+}
+
+// joinGroups joins the Conn's Netlink socket to the multicast groups. Marks the
+// Conn as multicast, meaning it can no longer be used for queries.
+func (c *Conn) joinGroups(groups []NetlinkGroup) error {
+
+	if len(groups) == 0 {
+		return errNoMulticastGroups
+	}
+
+	c.groupsMu.Lock()
+	defer c.groupsMu.Unlock()
+
+	for _, group := range groups {
+		if err := c.conn.JoinGroup(uint32(group)); err != nil {
+			return err
+		}
+	}
+
+	c.multicast = true
+
+	return nil
+}
+
+// isMulticast returns true if the Conn joined multicast groups.
+func (c *Conn) isMulticast() bool {
+
+	c.groupsMu.RLock()
+	defer c.groupsMu.RUnlock()
+
+	return c.multicast
+}
+
 // SetOption enables or disables a netlink socket option for the Conn.
 func (c *Conn) SetOption(option netlink.ConnOption, enable bool) error {
 	return c.conn.SetOption(option, enable)
@@ -57,84 +189,365 @@ func (c *Conn) SetWriteBuffer(bytes int) error {
 // Listen joins the Netfilter connection to a multicast group and starts a given
 // amount of Flow decoders from the Conn to the Flow channel. Returns an error channel
 // the workers will return any errors on. Any error during Flow decoding is fatal and
-// will halt the worker it occurs on. When numWorkers amount of errors have been received on
-// the error channel, no more events will be produced on evChan.
+// will halt the worker it occurs on, unless the Conn is in lenient mode. When numWorkers
+// amount of errors have been received on the error channel, no more events will be
+// produced on evChan.
 //
 // The Conn will be marked as having listeners active, which will prevent Listen from being
 // called again. For listening on other groups, open another socket.
 //
-// evChan consumers need to be able to keep up with the Event producers. When the channel is full,
-// messages will pile up in the Netlink socket's buffer, putting the socket at risk of being closed
-// by the kernel when it eventually fills up.
-func (c *Conn) Listen(evChan chan<- Event, numWorkers uint8, groups []netfilter.NetlinkGroup) (chan error, error) {
+// evChan consumers need to be able to keep up with the Event producers. By default, when the channel
+// is full, messages will pile up in the Netlink socket's buffer, putting the socket at risk of being
+// closed by the kernel when it eventually fills up. Use WithEventOverflow to buffer or drop Events
+// instead.
+func (c *Conn) Listen(evChan chan<- Event, numWorkers uint8, groups []NetlinkGroup) (chan error, error) {
 
 	if numWorkers == 0 {
 		return nil, errors.Errorf(errWorkerCount, numWorkers)
 	}
 
 	// Prevent Listen() from being called twice on the same Conn.
-	// This is checked again in JoinGroups(), but an early failure is preferred.
-	if c.conn.IsMulticast() {
+	// This is checked again in joinGroups(), but an early failure is preferred.
+	if c.isMulticast() {
 		return nil, errConnHasListeners
 	}
 
-	err := c.conn.JoinGroups(groups)
+	err := c.joinGroups(groups)
 	if err != nil {
 		return nil, err
 	}
 
 	errChan := make(chan error)
+	deliver, q := c.eventSink(evChan)
 
 	// Start numWorkers amount of worker goroutines
+	var wg sync.WaitGroup
+	wg.Add(int(numWorkers))
 	for id := uint8(0); id < numWorkers; id++ {
-		go c.eventWorker(id, evChan, errChan)
+		go func(id uint8) {
+			defer wg.Done()
+			c.eventWorker(id, deliver, errChan)
+		}(id)
+	}
+
+	// Flush the event buffer into evChan once all workers have stopped.
+	if q != nil {
+		go func() {
+			wg.Wait()
+			q.close()
+		}()
 	}
 
 	return errChan, nil
 }
 
-// eventWorker is a worker function that decodes Netlink messages into Events.
-func (c *Conn) eventWorker(workerID uint8, evChan chan<- Event, errChan chan<- error) {
-
-	var err error
-	var recv []netlink.Message
-	var ev Event
+// eventWorker is a worker function that decodes Netlink messages into Events
+// and hands them to deliver.
+func (c *Conn) eventWorker(workerID uint8, deliver func(Event), errChan chan<- error) {
 
 	for {
-		// Receive data from the Netlink socket
-		recv, err = c.conn.Receive()
+		ev, ok, err := c.readEvent(workerID)
 		if err != nil {
-			errChan <- errors.Wrap(err, fmt.Sprintf(errWorkerReceive, workerID))
+			errChan <- err
 			return
 		}
+		if !ok {
+			continue
+		}
 
-		// Receive() always returns a list of Netlink Messages, but multicast messages should never be multi-part
-		if len(recv) > 1 {
-			errChan <- errMultipartEvent
-			return
+		deliver(ev)
+	}
+}
+
+// readEvent reads a message from the Netlink socket and decodes it into an Event,
+// as a single operation of the Conn's OperationHooks. Returns false if there was no
+// Event to deliver, like when the message was rejected by a Sampler.
+func (c *Conn) readEvent(workerID uint8) (ev Event, ok bool, err error) {
+
+	ctx, end := c.startOperation(context.Background(), "Listen")
+	defer func() {
+		var n int
+		if ok {
+			n = 1
+		}
+		end(n, err)
+	}()
+
+	// Receive data from the Netlink socket
+	start := time.Now()
+	recv, err := c.conn.Receive()
+	if err == nil {
+		c.receive(recv)
+	}
+	if c.receiveHooks != nil {
+		recv, err = c.afterReceive(ctx, Receipt{Messages: recv, Err: err, Start: start, Duration: time.Since(start)})
+	}
+	if err != nil {
+		if isNoBufs(err) {
+			atomic.AddUint64(&c.stats.overruns, 1)
+			c.logger.Warn("netlink receive buffer overrun, kernel dropped events", "worker", workerID)
+		}
+		return ev, false, errors.Wrap(err, fmt.Sprintf(errWorkerReceive, workerID))
+	}
+	if len(recv) == 0 {
+		return ev, false, nil
+	}
+
+	// Receive() always returns a list of Netlink Messages, but multicast messages should never be multi-part
+	if len(recv) > 1 {
+		return ev, false, errMultipartEvent
+	}
+
+	// Skip events rejected by the Conn's Samplers before decoding them
+	if !c.sample(recv[0]) {
+		return ev, false, nil
+	}
+
+	// Decode event
+	return c.decodeEvent(workerID, recv[0])
+}
+
+// decodeEvent decodes a Netlink message received by a Listen worker into an Event.
+// Returns false if the message could not be decoded and was skipped in lenient mode.
+func (c *Conn) decodeEvent(workerID uint8, nlm netlink.Message) (Event, bool, error) {
+
+	var ev Event
+
+	if err := ev.unmarshal(nlm); err != nil {
+		atomic.AddUint64(&c.stats.decodeErrors, 1)
+		c.decodeErrors.add(nlm, err)
+		if c.lenient {
+			c.logger.Warn("skipping undecodable event", "worker", workerID, "error", err.Error())
+			return ev, false, nil
+		}
+		return ev, false, err
+	}
+
+	atomic.AddUint64(&c.stats.eventsDecoded, 1)
+
+	if c.canonical {
+		if ev.Flow != nil {
+			*ev.Flow = ev.Flow.Canonical()
+		}
+		if ev.Expect != nil {
+			*ev.Expect = ev.Expect.Canonical()
+		}
+	}
+
+	return ev, true, nil
+}
+
+// receive accounts for messages read from the Conn's socket in its ConnStats
+// and passes them to the Conn's Recorder, if any.
+func (c *Conn) receive(msgs []netlink.Message) {
+
+	c.stats.receive(msgs)
+
+	if c.recorder != nil {
+		c.recorder.Record(msgs)
+	}
+}
+
+// query sends a request over the Conn's Netlink socket and returns the kernel's replies.
+// All replies are accounted for in the Conn's ConnStats and recorded by its Recorder.
+func (c *Conn) query(req netlink.Message) ([]netlink.Message, error) {
+	return c.queryContext(context.Background(), req)
+}
+
+// queryContext is query, interrupted when ctx is done. Returns ctx.Err() if the
+// query was interrupted. The Conn's timeouts apply to the query.
+func (c *Conn) queryContext(ctx context.Context, req netlink.Message) ([]netlink.Message, error) {
+
+	req, err := c.beforeSend(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	// Deadlines apply to the whole socket, so a query must not start before
+	// the deadlines of the previous one are cleared.
+	c.queryMu.Lock()
+	done, err := c.deadlines(ctx)
+	if err != nil {
+		c.queryMu.Unlock()
+		return nil, err
+	}
+
+	start := time.Now()
+	nlm, err := c.execute(req)
+	done()
+	c.queryMu.Unlock()
+	if err != nil {
+		err = contextError(ctx, err)
+	}
+	if err == nil {
+		c.receive(nlm)
+	}
+
+	if c.receiveHooks != nil {
+		nlm, err = c.afterReceive(ctx, Receipt{Request: &req, Messages: nlm, Err: err, Start: start, Duration: time.Since(start)})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return nlm, nil
+}
+
+// dumpContext sends a dump request over the Conn's Netlink socket and returns the
+// kernel's replies. Interrupted dumps are retried up to retries times. The dump is
+// interrupted when ctx is done.
+func (c *Conn) dumpContext(ctx context.Context, req netlink.Message, retries int) ([]netlink.Message, error) {
+	return c.retryDump(func() ([]netlink.Message, error) { return c.queryContext(ctx, req) }, retries)
+}
+
+// retryDump calls query until it returns a consistent dump, at most retries+1 times.
+// The kernel flags the messages of a dump with NLM_F_DUMP_INTR when the table changed
+// while it was being dumped, meaning entries may have been missed or repeated.
+// Returns ErrDumpInterrupted when the last attempt was still interrupted.
+func (c *Conn) retryDump(query func() ([]netlink.Message, error), retries int) ([]netlink.Message, error) {
+
+	for i := 0; ; i++ {
+		nlm, err := query()
+		if err != nil {
+			return nil, err
 		}
 
-		// Decode event and send on channel
-		ev = *new(Event)
-		err := ev.unmarshal(recv[0])
+		if !dumpInterrupted(nlm) {
+			return nlm, nil
+		}
+
+		if !c.retryInterrupted(i, retries) {
+			return nil, ErrDumpInterrupted
+		}
+	}
+}
+
+// retryInterrupted counts an interrupted dump in the Conn's ConnStats and returns
+// true if the interrupted attempt, counted from zero, may be retried according to
+// retries. Every retry is logged along with the number of the interrupted attempt.
+func (c *Conn) retryInterrupted(attempt, retries int) bool {
+
+	atomic.AddUint64(&c.stats.dumpsInterrupted, 1)
+
+	if attempt >= retries {
+		return false
+	}
+
+	c.logger.Warn("retrying dump interrupted by changes to the table", "attempt", attempt+1, "retries", retries)
+
+	return true
+}
+
+// dumpInterrupted returns true if any of the messages of a dump carries the
+// NLM_F_DUMP_INTR flag.
+func dumpInterrupted(nlm []netlink.Message) bool {
+	for _, m := range nlm {
+		if m.Header.Flags&netlink.DumpInterrupted != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// unmarshalFlows unmarshals the Flows in the kernel's response to a query.
+// In lenient mode, messages that fail to decode are logged and skipped.
+func (c *Conn) unmarshalFlows(nlm []netlink.Message) ([]Flow, error) {
+
+	if !c.lenient {
+		fs, err := unmarshalFlows(nlm)
 		if err != nil {
-			errChan <- err
-			return
+			return nil, err
+		}
+		for i := range fs {
+			fs[i] = c.canonicalFlow(fs[i])
+		}
+		return fs, nil
+	}
+
+	out := make([]Flow, 0, len(nlm))
+
+	for _, m := range nlm {
+		f, ok, err := c.unmarshalFlow(m)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		out = append(out, f)
+	}
+
+	return out, nil
+}
+
+// unmarshalFlow unmarshals a single Flow in the kernel's response to a query.
+// Returns false if the message could not be decoded and was skipped in lenient mode.
+func (c *Conn) unmarshalFlow(nlm netlink.Message) (Flow, bool, error) {
+
+	f, err := unmarshalFlow(nlm)
+	if err != nil {
+		if !c.lenient {
+			return f, false, err
 		}
+		atomic.AddUint64(&c.stats.decodeErrors, 1)
+		c.decodeErrors.add(nlm, err)
+		c.logger.Warn("skipping undecodable flow", "error", err.Error())
+		return f, false, nil
+	}
+
+	return c.canonicalFlow(f), true, nil
+}
+
+// canonicalFlow returns f with its addresses in canonical form if the Conn
+// was dialed WithCanonicalAddresses.
+func (c *Conn) canonicalFlow(f Flow) Flow {
+	if c.canonical {
+		return f.Canonical()
+	}
+	return f
+}
+
+// unmarshalExpects unmarshals the Expects in the kernel's response to a query,
+// with their addresses in canonical form if the Conn was dialed WithCanonicalAddresses.
+func (c *Conn) unmarshalExpects(nlm []netlink.Message) ([]Expect, error) {
+
+	exps, err := unmarshalExpects(nlm)
+	if err != nil || !c.canonical {
+		return exps, err
+	}
 
-		evChan <- ev
+	for i := range exps {
+		exps[i] = exps[i].Canonical()
 	}
+
+	return exps, nil
 }
 
 // Dump gets all Conntrack connections from the kernel in the form of a list
-// of Flow objects.
-func (c *Conn) Dump() ([]Flow, error) {
+// of Flow objects. DumpOptions like DumpFamily restrict the Flows dumped.
+//
+// When the table changes while it is being dumped, the kernel may report the dump as
+// interrupted. Interrupted dumps are retried according to DumpRetries, and fail with
+// ErrDumpInterrupted when no consistent dump could be made. This applies to all dumps
+// but DumpPages, which can't retry dumps it already passed to its caller.
+func (c *Conn) Dump(opts ...DumpOption) ([]Flow, error) {
+	return c.DumpContext(context.Background(), opts...)
+}
+
+// DumpContext is Dump, interrupted when ctx is done. ctx is passed to the Conn's hooks.
+func (c *Conn) DumpContext(ctx context.Context, opts ...DumpOption) (flows []Flow, err error) {
+
+	ctx, end := c.startOperation(ctx, "Dump")
+	defer func() { end(len(flows), err) }()
+
+	dc := NewDumpConfig(opts...)
 
 	req, err := netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlink,
 			MessageType: netfilter.MessageType(ctGet),
-			Family:      netfilter.ProtoUnspec, // ProtoUnspec dumps both IPv4 and IPv6
+			Family:      dc.Family, // ProtoUnspec dumps both IPv4 and IPv6
 			Flags:       netlink.Request | netlink.Dump,
 		},
 		nil)
@@ -143,23 +556,82 @@ func (c *Conn) Dump() ([]Flow, error) {
 		return nil, err
 	}
 
-	nlm, err := c.conn.Query(req)
+	nlm, err := c.dumpContext(ctx, req, dc.Retries)
 	if err != nil {
 		return nil, err
 	}
 
-	return unmarshalFlows(nlm)
+	return c.unmarshalFlows(nlm)
+}
+
+// AppendDump dumps the Conntrack table like Dump and appends the Flows to dst,
+// returning the extended slice. To avoid allocating a new slice on every dump,
+// pass the result of a previous dump truncated to zero length, like flows[:0].
+// The dump is decoded as it is read from the socket, so the Flows are the only
+// copy of the table held in memory. On error, dst is returned unmodified.
+func (c *Conn) AppendDump(dst []Flow, opts ...DumpOption) (out []Flow, err error) {
+
+	ctx, end := c.startOperation(context.Background(), "AppendDump")
+	defer func() { end(len(out)-len(dst), err) }()
+
+	dc := NewDumpConfig(opts...)
+
+	req, err := netfilter.MarshalNetlink(
+		netfilter.Header{
+			SubsystemID: netfilter.NFSubsysCTNetlink,
+			MessageType: netfilter.MessageType(ctGet),
+			Family:      dc.Family, // ProtoUnspec dumps both IPv4 and IPv6
+			Flags:       netlink.Request | netlink.Dump,
+		},
+		nil)
+
+	if err != nil {
+		return dst, err
+	}
+
+	n := len(dst)
+	out = dst
+
+	for i := 0; ; i++ {
+		// Decode into the Flows of the previous attempt, if any.
+		out = out[:n]
+
+		interrupted, err := c.streamDump(ctx, req, func(m netlink.Message) error {
+			f, ok, err := c.unmarshalFlow(m)
+			if ok {
+				out = append(out, f)
+			}
+			return err
+		})
+		if err != nil {
+			return dst[:n], err
+		}
+
+		if !interrupted {
+			return out, nil
+		}
+
+		if !c.retryInterrupted(i, dc.Retries) {
+			return dst[:n], ErrDumpInterrupted
+		}
+	}
 }
 
 // DumpFilter gets all Conntrack connections from the kernel in the form of a list
 // of Flow objects, but only returns Flows matching the connmark specified in the Filter parameter.
-func (c *Conn) DumpFilter(f Filter) ([]Flow, error) {
+// DumpOptions like DumpFamily further restrict the Flows dumped.
+func (c *Conn) DumpFilter(f Filter, opts ...DumpOption) (flows []Flow, err error) {
+
+	ctx, end := c.startOperation(context.Background(), "DumpFilter")
+	defer func() { end(len(flows), err) }()
+
+	dc := NewDumpConfig(opts...)
 
 	req, err := netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlink,
 			MessageType: netfilter.MessageType(ctGet),
-			Family:      netfilter.ProtoUnspec, // ProtoUnspec dumps both IPv4 and IPv6
+			Family:      dc.Family, // ProtoUnspec dumps both IPv4 and IPv6
 			Flags:       netlink.Request | netlink.Dump,
 		},
 		f.marshal())
@@ -168,23 +640,111 @@ func (c *Conn) DumpFilter(f Filter) ([]Flow, error) {
 		return nil, err
 	}
 
-	nlm, err := c.conn.Query(req)
+	nlm, err := c.dumpContext(ctx, req, dc.Retries)
 	if err != nil {
 		return nil, err
 	}
 
-	return unmarshalFlows(nlm)
+	return c.unmarshalFlows(nlm)
+}
+
+// DumpPages dumps the Conntrack table like Dump, but decodes the Flows in pages of at
+// most pageSize Flows and passes each page to fn, in order. The dump is decoded as it
+// is read from the socket, so only a single page of Flows and the Netlink messages
+// of a single datagram are held in memory at once. Page boundaries allow the caller
+// to checkpoint its progress.
+//
+// The slice passed to fn is reused for the next page, so fn must copy any Flows it
+// wants to retain. Dumping stops at the first error returned by fn, which is returned
+// by DumpPages, or when ctx is done, in which case ctx.Err() is returned. The Conn is
+// busy receiving the dump until DumpPages returns, so fn must not use the Conn.
+//
+// Since its pages were already passed to fn, an interrupted dump is not retried.
+// DumpPages returns ErrDumpInterrupted after passing the last page to fn, leaving
+// it to the caller to discard its results or dump again.
+func (c *Conn) DumpPages(ctx context.Context, pageSize int, fn func([]Flow) error, opts ...DumpOption) (err error) {
+
+	var flows int
+	ctx, end := c.startOperation(ctx, "DumpPages")
+	defer func() { end(flows, err) }()
+
+	if pageSize <= 0 {
+		return errors.Errorf(errPageSize, pageSize)
+	}
+
+	dc := NewDumpConfig(opts...)
+
+	req, err := netfilter.MarshalNetlink(
+		netfilter.Header{
+			SubsystemID: netfilter.NFSubsysCTNetlink,
+			MessageType: netfilter.MessageType(ctGet),
+			Family:      dc.Family, // ProtoUnspec dumps both IPv4 and IPv6
+			Flags:       netlink.Request | netlink.Dump,
+		},
+		nil)
+
+	if err != nil {
+		return err
+	}
+
+	var page []Flow
+
+	flush := func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(page); err != nil {
+			return err
+		}
+		flows += len(page)
+		page = page[:0]
+		return nil
+	}
+
+	interrupted, err := c.streamDump(ctx, req, func(m netlink.Message) error {
+		f, ok, err := c.unmarshalFlow(m)
+		if err != nil || !ok {
+			return err
+		}
+
+		page = append(page, f)
+		if len(page) < pageSize {
+			return nil
+		}
+
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	// Hand over the last partial page.
+	if len(page) > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+
+	if interrupted {
+		atomic.AddUint64(&c.stats.dumpsInterrupted, 1)
+		return ErrDumpInterrupted
+	}
+
+	return nil
 }
 
 // DumpExpect gets all expected Conntrack expectations from the kernel in the form
 // of a list of Expect objects.
-func (c *Conn) DumpExpect() ([]Expect, error) {
+func (c *Conn) DumpExpect() (exps []Expect, err error) {
+
+	ctx, end := c.startOperation(context.Background(), "DumpExpect")
+	defer func() { end(len(exps), err) }()
 
 	req, err := netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlinkExp,
 			MessageType: netfilter.MessageType(ctGet),
-			Family:      netfilter.ProtoUnspec, // ProtoUnspec dumps both IPv4 and IPv6
+			Family:      ProtoUnspec, // ProtoUnspec dumps both IPv4 and IPv6
 			Flags:       netlink.Request | netlink.Dump | netlink.Acknowledge,
 		},
 		nil)
@@ -193,22 +753,85 @@ func (c *Conn) DumpExpect() ([]Expect, error) {
 		return nil, err
 	}
 
-	nlm, err := c.conn.Query(req)
+	nlm, err := c.dumpContext(ctx, req, defaultDumpRetries)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.unmarshalExpects(nlm)
+}
+
+// DumpExpectFor gets all expectations created by helpers for the connection described by f,
+// which is looked up by its TupleOrig or TupleReply, in that order, and Zone. One of TupleOrig
+// or TupleReply is required. Returns an error wrapping unix.ENOENT if the connection does not
+// exist.
+func (c *Conn) DumpExpectFor(f Flow) (exps []Expect, err error) {
+
+	ctx, end := c.startOperation(context.Background(), "DumpExpectFor")
+	defer func() { end(len(exps), err) }()
+
+	t := f.TupleOrig
+	if !t.filled() {
+		t = f.TupleReply
+	}
+	if !t.filled() {
+		return nil, errNeedTuples
+	}
+
+	tm, err := t.marshal(uint16(ctaExpectMaster))
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := []netfilter.Attribute{tm}
+	if f.Zone != 0 {
+		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaExpectZone), Data: netfilter.Uint16Bytes(f.Zone)})
+	}
+
+	// The kernel parses the master tuple according to the message's family.
+	pf := ProtoIPv4
+	if t.IP.IsIPv6() {
+		pf = ProtoIPv6
+	}
+
+	req, err := netfilter.MarshalNetlink(
+		netfilter.Header{
+			SubsystemID: netfilter.NFSubsysCTNetlinkExp,
+			MessageType: netfilter.MessageType(ctExpGet),
+			Family:      pf,
+			Flags:       netlink.Request | netlink.Dump | netlink.Acknowledge,
+		},
+		attrs)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nlm, err := c.dumpContext(ctx, req, defaultDumpRetries)
 	if err != nil {
 		return nil, err
 	}
 
-	return unmarshalExpects(nlm)
+	// When the connection has no helper attached, the kernel does not start a dump
+	// and only acknowledges the request.
+	if len(nlm) == 1 && nlm[0].Header.Type == netlink.Error {
+		return []Expect{}, nil
+	}
+
+	return c.unmarshalExpects(nlm)
 }
 
 // Flush empties the Conntrack table. Deletes all IPv4 and IPv6 entries.
-func (c *Conn) Flush() error {
+func (c *Conn) Flush() (err error) {
+
+	ctx, end := c.startOperation(context.Background(), "Flush")
+	defer func() { end(0, err) }()
 
 	req, err := netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlink,
 			MessageType: netfilter.MessageType(ctDelete),
-			Family:      netfilter.ProtoUnspec, // Family is ignored for flush
+			Family:      ProtoUnspec, // Family is ignored for flush
 			Flags:       netlink.Request | netlink.Acknowledge,
 		},
 		nil)
@@ -217,7 +840,7 @@ func (c *Conn) Flush() error {
 		return err
 	}
 
-	_, err = c.conn.Query(req)
+	_, err = c.queryContext(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -227,13 +850,16 @@ func (c *Conn) Flush() error {
 
 // FlushFilter deletes all entries from the Conntrack table matching a given Filter.
 // Both IPv4 and IPv6 entries are considered for deletion.
-func (c *Conn) FlushFilter(f Filter) error {
+func (c *Conn) FlushFilter(f Filter) (err error) {
+
+	ctx, end := c.startOperation(context.Background(), "FlushFilter")
+	defer func() { end(0, err) }()
 
 	req, err := netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlink,
 			MessageType: netfilter.MessageType(ctDelete),
-			Family:      netfilter.ProtoUnspec, // Family is ignored for flush
+			Family:      ProtoUnspec, // Family is ignored for flush
 			Flags:       netlink.Request | netlink.Acknowledge,
 		},
 		f.marshal())
@@ -242,7 +868,7 @@ func (c *Conn) FlushFilter(f Filter) error {
 		return err
 	}
 
-	_, err = c.conn.Query(req)
+	_, err = c.queryContext(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -250,38 +876,69 @@ func (c *Conn) FlushFilter(f Filter) error {
 	return nil
 }
 
-// Create creates a new Conntrack entry.
-func (c *Conn) Create(f Flow) error {
+// FlushExpect empties the Conntrack expectation table. Deletes all IPv4 and IPv6 entries.
+//
+// The kernel does not consider the protocol family when flushing expectations, so
+// there are no per-family variants of this method. Use FlushExpectHelper to only
+// delete the expectations created by a specific helper.
+func (c *Conn) FlushExpect() error {
+	return c.flushExpect("FlushExpect", nil)
+}
 
-	// Conntrack create requires timeout to be set.
-	if f.Timeout == 0 {
-		return errNeedTimeout
-	}
+// FlushExpectHelper deletes all expectations created by the Conntrack helper
+// with the given name, like "ftp" or "sip", from the expectation table.
+func (c *Conn) FlushExpectHelper(name string) error {
+
+	return c.flushExpect("FlushExpectHelper", []netfilter.Attribute{
+		{Type: uint16(ctaExpectHelpName), Data: nulString(name)},
+	})
+}
+
+// flushExpect sends an expectation delete request without a tuple, deleting all
+// expectations matching attrs. name is the name of the operation.
+func (c *Conn) flushExpect(name string, attrs []netfilter.Attribute) (err error) {
+
+	ctx, end := c.startOperation(context.Background(), name)
+	defer func() { end(0, err) }()
+
+	req, err := netfilter.MarshalNetlink(
+		netfilter.Header{
+			SubsystemID: netfilter.NFSubsysCTNetlinkExp,
+			MessageType: netfilter.MessageType(ctExpDelete),
+			Family:      ProtoUnspec, // Family is ignored for flush
+			Flags:       netlink.Request | netlink.Acknowledge,
+		},
+		attrs)
 
-	attrs, err := f.marshal()
 	if err != nil {
 		return err
 	}
 
-	pf := netfilter.ProtoIPv4
-	if f.TupleOrig.IP.IsIPv6() && f.TupleReply.IP.IsIPv6() {
-		pf = netfilter.ProtoIPv6
+	_, err = c.queryContext(ctx, req)
+	if err != nil {
+		return err
 	}
 
-	req, err := netfilter.MarshalNetlink(
-		netfilter.Header{
-			SubsystemID: netfilter.NFSubsysCTNetlink,
-			MessageType: netfilter.MessageType(ctNew),
-			Family:      pf,
-			Flags: netlink.Request | netlink.Acknowledge |
-				netlink.Excl | netlink.Create,
-		}, attrs)
+	return nil
+}
 
+// Create creates a new Conntrack entry.
+func (c *Conn) Create(f Flow) error {
+	return c.CreateContext(context.Background(), f)
+}
+
+// CreateContext is Create, interrupted when ctx is done. ctx is passed to the Conn's hooks.
+func (c *Conn) CreateContext(ctx context.Context, f Flow) (err error) {
+
+	ctx, end := c.startOperation(ctx, "Create")
+	defer func() { end(0, err) }()
+
+	req, err := createRequest(f, netlink.Acknowledge)
 	if err != nil {
 		return err
 	}
 
-	_, err = c.conn.Query(req)
+	_, err = c.queryContext(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -289,18 +946,44 @@ func (c *Conn) Create(f Flow) error {
 	return nil
 }
 
-// CreateExpect creates a new Conntrack Expect entry. Warning: Experimental, haven't
-// got this to create an Expect correctly. Best-effort implementation based on kernel source.
-func (c *Conn) CreateExpect(ex Expect) error {
+// createRequest returns the message creating f, with flags set in its header
+// on top of the ones needed for the request.
+func createRequest(f Flow, flags netlink.HeaderFlags) (netlink.Message, error) {
+
+	// Conntrack create requires timeout to be set.
+	if f.Timeout == 0 {
+		return netlink.Message{}, errNeedTimeout
+	}
+
+	attrs, err := f.marshal()
+	if err != nil {
+		return netlink.Message{}, err
+	}
+
+	return netfilter.MarshalNetlink(
+		netfilter.Header{
+			SubsystemID: netfilter.NFSubsysCTNetlink,
+			MessageType: netfilter.MessageType(ctNew),
+			Family:      f.family(),
+			Flags:       netlink.Request | netlink.Excl | netlink.Create | flags,
+		}, attrs)
+}
+
+// CreateExpect creates a new Conntrack Expect entry. The kernel is strict about the
+// Expects it accepts, use an ExpectBuilder to build one related to an existing Flow.
+func (c *Conn) CreateExpect(ex Expect) (err error) {
+
+	ctx, end := c.startOperation(context.Background(), "CreateExpect")
+	defer func() { end(0, err) }()
 
 	attrs, err := ex.marshal()
 	if err != nil {
 		return err
 	}
 
-	pf := netfilter.ProtoIPv4
+	pf := ProtoIPv4
 	if ex.Tuple.IP.IsIPv6() && ex.Mask.IP.IsIPv6() && ex.TupleMaster.IP.IsIPv6() {
-		pf = netfilter.ProtoIPv6
+		pf = ProtoIPv6
 	}
 
 	req, err := netfilter.MarshalNetlink(
@@ -316,7 +999,7 @@ func (c *Conn) CreateExpect(ex Expect) error {
 		return err
 	}
 
-	_, err = c.conn.Query(req)
+	_, err = c.queryContext(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -328,17 +1011,23 @@ func (c *Conn) CreateExpect(ex Expect) error {
 // The following attributes are considered in the query: TupleOrig or TupleReply, in that order,
 // and Zone. One of TupleOrig or TupleReply is required for a successful query.
 func (c *Conn) Get(f Flow) (Flow, error) {
+	return c.GetContext(context.Background(), f)
+}
+
+// GetContext is Get, interrupted when ctx is done. ctx is passed to the Conn's hooks.
+func (c *Conn) GetContext(ctx context.Context, f Flow) (qf Flow, err error) {
 
-	var qf Flow
+	ctx, end := c.startOperation(ctx, "Get")
+	defer func() { end(1, err) }()
 
 	attrs, err := f.marshal()
 	if err != nil {
 		return qf, err
 	}
 
-	pf := netfilter.ProtoIPv4
+	pf := ProtoIPv4
 	if f.TupleOrig.IP.IsIPv6() && f.TupleReply.IP.IsIPv6() {
-		pf = netfilter.ProtoIPv6
+		pf = ProtoIPv6
 	}
 
 	req, err := netfilter.MarshalNetlink(
@@ -353,7 +1042,7 @@ func (c *Conn) Get(f Flow) (Flow, error) {
 		return qf, err
 	}
 
-	nlm, err := c.conn.Query(req)
+	nlm, err := c.queryContext(ctx, req)
 	if err != nil {
 		return qf, err
 	}
@@ -361,12 +1050,16 @@ func (c *Conn) Get(f Flow) (Flow, error) {
 	// Since this is not a dump (and ACK flag is set), the kernel sends a message containing
 	// the flow, followed by a Netlink (non-)error message. The error is already parsed by
 	// the netlink library, so we only read the first message containing the Flow.
+	if len(nlm) == 0 {
+		return qf, errNoReply
+	}
+
 	qf, err = unmarshalFlow(nlm[0])
 	if err != nil {
 		return qf, err
 	}
 
-	return qf, nil
+	return c.canonicalFlow(qf), nil
 }
 
 // Update updates a Conntrack entry. Only the following attributes are considered
@@ -374,35 +1067,82 @@ func (c *Conn) Get(f Flow) (Flow, error) {
 // SynProxy, Labels. All other attributes are immutable past the point of creation.
 // See the ctnetlink_change_conntrack() kernel function for exact behaviour.
 func (c *Conn) Update(f Flow) error {
+	return c.UpdateContext(context.Background(), f)
+}
 
-	// Kernel rejects updates with a master tuple set
-	if f.TupleMaster.filled() {
-		return errUpdateMaster
+// UpdateContext is Update, interrupted when ctx is done. ctx is passed to the Conn's hooks.
+func (c *Conn) UpdateContext(ctx context.Context, f Flow) (err error) {
+
+	ctx, end := c.startOperation(ctx, "Update")
+	defer func() { end(0, err) }()
+
+	req, err := updateRequest(f, netlink.Acknowledge)
+	if err != nil {
+		return err
 	}
 
-	attrs, err := f.marshal()
+	_, err = c.queryContext(ctx, req)
 	if err != nil {
 		return err
 	}
 
-	pf := netfilter.ProtoIPv4
-	if f.TupleOrig.IP.IsIPv6() && f.TupleReply.IP.IsIPv6() {
-		pf = netfilter.ProtoIPv6
+	return nil
+}
+
+// updateRequest returns the message updating f, with flags set in its header
+// on top of the ones needed for the request.
+func updateRequest(f Flow, flags netlink.HeaderFlags) (netlink.Message, error) {
+
+	// Kernel rejects updates with a master tuple set
+	if f.TupleMaster.filled() {
+		return netlink.Message{}, errUpdateMaster
 	}
 
-	req, err := netfilter.MarshalNetlink(
+	// NAT can only be set up when creating a connection
+	if f.NATSrc.filled() || f.NATDst.filled() {
+		return netlink.Message{}, errUpdateNAT
+	}
+
+	attrs, err := f.marshal()
+	if err != nil {
+		return netlink.Message{}, err
+	}
+
+	return netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlink,
 			MessageType: netfilter.MessageType(ctNew),
-			Family:      pf,
-			Flags:       netlink.Request | netlink.Acknowledge,
+			Family:      f.family(),
+			Flags:       netlink.Request | flags,
 		}, attrs)
+}
 
+// Delete removes a Conntrack entry given a Flow. Flows are looked up in the conntrack table
+// based on the original and reply tuple. When the Flow's ID field is filled, it must match the
+// ID on the connection returned from the tuple lookup, or the delete will fail.
+func (c *Conn) Delete(f Flow) error {
+	return c.DeleteContext(context.Background(), f)
+}
+
+// DeleteContext is Delete, interrupted when ctx is done. ctx is passed to the Conn's hooks.
+func (c *Conn) DeleteContext(ctx context.Context, f Flow) (err error) {
+
+	ctx, end := c.startOperation(ctx, "Delete")
+	defer func() { end(0, err) }()
+
+	return c.delete(ctx, f, false)
+}
+
+// delete deletes a Flow from the Conntrack table. If matchID is set, the Flow's ID
+// is sent along, so the kernel only deletes the connection if its ID matches.
+func (c *Conn) delete(ctx context.Context, f Flow, matchID bool) error {
+
+	req, err := deleteRequest(f, matchID, netlink.Acknowledge)
 	if err != nil {
 		return err
 	}
 
-	_, err = c.conn.Query(req)
+	_, err = c.queryContext(ctx, req)
 	if err != nil {
 		return err
 	}
@@ -410,52 +1150,118 @@ func (c *Conn) Update(f Flow) error {
 	return nil
 }
 
-// Delete removes a Conntrack entry given a Flow. Flows are looked up in the conntrack table
-// based on the original and reply tuple. When the Flow's ID field is filled, it must match the
-// ID on the connection returned from the tuple lookup, or the delete will fail.
-func (c *Conn) Delete(f Flow) error {
+// deleteRequest returns the message deleting f, with flags set in its header
+// on top of the ones needed for the request. See delete for matchID.
+func deleteRequest(f Flow, matchID bool, flags netlink.HeaderFlags) (netlink.Message, error) {
 
 	attrs, err := f.marshal()
 	if err != nil {
-		return err
+		return netlink.Message{}, err
 	}
 
-	// Default to IPv4, set netlink protocol family to IPv6 if orig/reply is IPv6.
-	pf := netfilter.ProtoIPv4
-	if f.TupleOrig.IP.IsIPv6() && f.TupleReply.IP.IsIPv6() {
-		pf = netfilter.ProtoIPv6
+	if matchID && f.ID != 0 {
+		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaID), Data: netfilter.Uint32Bytes(f.ID)})
 	}
 
-	req, err := netfilter.MarshalNetlink(
+	return netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlink,
 			MessageType: netfilter.MessageType(ctDelete),
-			Family:      pf,
-			Flags:       netlink.Request | netlink.Acknowledge,
+			Family:      f.family(),
+			Flags:       netlink.Request | flags,
 		}, attrs)
+}
 
-	if err != nil {
-		return err
+// A DeleteResult is the outcome of Conn.DeleteWhere.
+type DeleteResult struct {
+	// Matched is the amount of Flows the match function returned true for.
+	Matched int
+
+	// Deleted is the amount of matched Flows that were deleted.
+	Deleted int
+
+	// Errors holds the matched Flows that could not be deleted.
+	Errors []FlowError
+}
+
+// A FlowError is an error that occurred operating on a specific Flow.
+type FlowError struct {
+	Flow Flow
+	Err  error
+}
+
+func (fe FlowError) Error() string {
+	return fmt.Sprintf("flow %s: %s", fe.Flow.TupleOrig, fe.Err)
+}
+
+// Unwrap returns the underlying error.
+func (fe FlowError) Unwrap() error {
+	return fe.Err
+}
+
+// deleteWherePageSize is the amount of Flows DeleteWhere decodes at once to
+// match them.
+const deleteWherePageSize = 256
+
+// DeleteWhere dumps the Conntrack table and deletes all Flows match returns true for.
+// The dump is processed in pages like DumpPages, and only the matching Flows are kept
+// to be deleted once the dump is complete. DumpOptions like DumpFamily restrict the
+// Flows considered. Deletes are sent in batches, without waiting for the kernel to
+// acknowledge each of them.
+//
+// Flows are deleted by ID, so connections created after the dump that reuse the
+// tuple of a matched Flow are left alone. Flows that disappear from the table before
+// they can be deleted are counted in DeleteResult.Matched only. Other failures to
+// delete a Flow are collected in DeleteResult.Errors and don't stop DeleteWhere.
+// The returned error is set when the dump itself fails, in which case no Flows are
+// deleted, or when the socket fails while deleting. An interrupted dump is not
+// retried, its matching Flows are deleted and ErrDumpInterrupted is returned.
+func (c *Conn) DeleteWhere(match func(Flow) bool, opts ...DumpOption) (DeleteResult, error) {
+	return c.DeleteWhereContext(context.Background(), match, opts...)
+}
+
+// DeleteWhereContext is DeleteWhere, interrupted when ctx is done. ctx is passed to the Conn's hooks.
+func (c *Conn) DeleteWhereContext(ctx context.Context, match func(Flow) bool, opts ...DumpOption) (res DeleteResult, err error) {
+
+	ctx, end := c.startOperation(ctx, "DeleteWhere")
+	defer func() { end(res.Deleted, err) }()
+
+	var matched []Flow
+
+	err = c.DumpPages(ctx, deleteWherePageSize, func(page []Flow) error {
+		for _, f := range page {
+			if match(f) {
+				matched = append(matched, f)
+			}
+		}
+		return nil
+	}, opts...)
+	if err != nil && !errors.Is(err, ErrDumpInterrupted) {
+		return res, err
 	}
 
-	_, err = c.conn.Query(req)
-	if err != nil {
-		return err
+	res.Matched = len(matched)
+
+	if derr := c.deleteBatch(ctx, matched, &res); derr != nil {
+		return res, derr
 	}
 
-	return nil
+	return res, err
 }
 
 // Stats returns a list of Stats structures, one per CPU present in the machine.
 // Each Stats structure contains performance counters of all Conntrack actions
 // performed on that specific CPU.
-func (c *Conn) Stats() ([]Stats, error) {
+func (c *Conn) Stats() (stats []Stats, err error) {
+
+	ctx, end := c.startOperation(context.Background(), "Stats")
+	defer func() { end(len(stats), err) }()
 
 	req, err := netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlink,
 			MessageType: netfilter.MessageType(ctGetStatsCPU),
-			Family:      netfilter.ProtoUnspec,
+			Family:      ProtoUnspec,
 			Flags:       netlink.Request | netlink.Dump,
 		}, nil)
 
@@ -463,7 +1269,7 @@ func (c *Conn) Stats() ([]Stats, error) {
 		return nil, err
 	}
 
-	msgs, err := c.conn.Query(req)
+	msgs, err := c.queryContext(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -474,13 +1280,16 @@ func (c *Conn) Stats() ([]Stats, error) {
 // StatsExpect returns a list of StatsExpect structures, one per CPU present in the machine.
 // Each StatsExpect structure indicates how many Expect entries were initialized,
 // created or deleted on each CPU.
-func (c *Conn) StatsExpect() ([]StatsExpect, error) {
+func (c *Conn) StatsExpect() (stats []StatsExpect, err error) {
+
+	ctx, end := c.startOperation(context.Background(), "StatsExpect")
+	defer func() { end(len(stats), err) }()
 
 	req, err := netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlinkExp,
 			MessageType: netfilter.MessageType(ctExpGetStatsCPU),
-			Family:      netfilter.ProtoUnspec,
+			Family:      ProtoUnspec,
 			Flags:       netlink.Request | netlink.Dump,
 		}, nil)
 
@@ -488,7 +1297,7 @@ func (c *Conn) StatsExpect() ([]StatsExpect, error) {
 		return nil, err
 	}
 
-	msgs, err := c.conn.Query(req)
+	msgs, err := c.queryContext(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -503,26 +1312,31 @@ func (c *Conn) StatsExpect() ([]StatsExpect, error) {
 //
 // Starting from kernels 4.18 and higher, MaxEntries is returned, describing the maximum size
 // of the Conntrack table.
-func (c *Conn) StatsGlobal() (StatsGlobal, error) {
+func (c *Conn) StatsGlobal() (sg StatsGlobal, err error) {
+
+	ctx, end := c.startOperation(context.Background(), "StatsGlobal")
+	defer func() { end(1, err) }()
 
 	req, err := netfilter.MarshalNetlink(
 		netfilter.Header{
 			SubsystemID: netfilter.NFSubsysCTNetlink,
 			MessageType: netfilter.MessageType(ctGetStats),
-			Family:      netfilter.ProtoUnspec,
+			Family:      ProtoUnspec,
 			Flags:       netlink.Request | netlink.Dump | netlink.Acknowledge,
 		}, nil)
 
-	var sg StatsGlobal
-
 	if err != nil {
 		return sg, err
 	}
 
-	msgs, err := c.conn.Query(req)
+	msgs, err := c.queryContext(ctx, req)
 	if err != nil {
 		return sg, err
 	}
 
+	if len(msgs) == 0 {
+		return sg, errNoReply
+	}
+
 	return unmarshalStatsGlobal(msgs[0])
 }
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
 	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/mdlayher/netlink"
@@ -48,6 +50,49 @@ func TestConnDialSetOption(t *testing.T) {
 	require.NoError(t, err, "closing Conn")
 }
 
+// The kernel rejects malformed dump and get requests over a socket with strict
+// checking enabled.
+func TestConnStrictCheck(t *testing.T) {
+
+	ns, err := netns.New()
+	require.NoError(t, err)
+	defer ns.Close()
+
+	c, err := Dial(&netlink.Config{NetNS: int(ns)}, WithStrictCheck())
+	require.NoError(t, err)
+	defer c.Close()
+
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0xff)
+	require.NoError(t, c.Create(f))
+
+	_, err = c.Get(f)
+	require.NoError(t, err, "get")
+
+	flows, err := c.Dump()
+	require.NoError(t, err, "dump")
+	assert.Len(t, flows, 1)
+
+	flows, err = c.Dump(DumpFamily(ProtoIPv4))
+	require.NoError(t, err, "dump family")
+	assert.Len(t, flows, 1)
+
+	flows, err = c.DumpFilter(Filter{Mark: 0xff, Mask: 0xff})
+	require.NoError(t, err, "dump filter")
+	assert.Len(t, flows, 1)
+
+	_, err = c.DumpExpect()
+	require.NoError(t, err, "dump expect")
+
+	_, err = c.Stats()
+	require.NoError(t, err, "stats")
+
+	_, err = c.StatsExpect()
+	require.NoError(t, err, "stats expect")
+
+	_, err = c.StatsGlobal()
+	require.NoError(t, err, "stats global")
+}
+
 // checkKmod checks if the kernel modules required for this test suite are loaded into the kernel.
 // Since around 4.19, conntrack is a single module, so only warn about _ipv4/6 when that one
 // is not loaded.
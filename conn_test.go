@@ -11,7 +11,6 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/ti-mo/conntrack"
-	"github.com/ti-mo/netfilter"
 )
 
 func TestConnDialError(t *testing.T) {
@@ -181,6 +180,31 @@ func ExampleConn_delete() {
 	}
 }
 
+func ExampleConn_deleteWhere() {
+	// Open a Conntrack connection.
+	c, err := conntrack.Dial(nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	backend := net.IPv4(10, 0, 0, 42)
+
+	// Evict all connections to a backend that went away.
+	res, err := c.DeleteWhere(func(f conntrack.Flow) bool {
+		return f.TupleOrig.IP.DestinationAddress.Equal(backend)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Flows that failed to be deleted are reported individually.
+	for _, err := range res.Errors {
+		log.Print(err)
+	}
+
+	log.Printf("deleted %d out of %d flows", res.Deleted, res.Matched)
+}
+
 func ExampleConn_listen() {
 	// Open a Conntrack connection.
 	c, err := conntrack.Dial(nil)
@@ -193,7 +217,7 @@ func ExampleConn_listen() {
 
 	// Listen for all Conntrack and Conntrack-Expect events with 4 decoder goroutines.
 	// All errors caught in the decoders are passed on channel errCh.
-	errCh, err := c.Listen(evCh, 4, append(netfilter.GroupsCT, netfilter.GroupsCTExp...))
+	errCh, err := c.Listen(evCh, 4, append(conntrack.GroupsCT, conntrack.GroupsCTExp...))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,114 @@
+package conntrack
+
+import (
+	"expvar"
+	"os"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/mdlayher/netlink"
+	"github.com/pkg/errors"
+)
+
+// ConnStats is a snapshot of the internal counters of a Conn. It describes
+// the amount of data read from the Conn's Netlink socket and the health of its
+// event pipeline. All counters are cumulative over the lifetime of the Conn.
+type ConnStats struct {
+	// Netlink messages and bytes received from the kernel,
+	// both in response to queries and as multicast events.
+	MessagesReceived uint64
+	BytesReceived    uint64
+
+	// Events successfully decoded by Listen workers.
+	EventsDecoded uint64
+	// Messages that failed to decode into an Event or Flow. In lenient mode,
+	// these messages were skipped. See Conn.DecodeErrorStats for a breakdown.
+	DecodeErrors uint64
+	// Amount of times the socket's receive buffer overran (ENOBUFS), meaning
+	// the kernel dropped one or more events because the Conn didn't keep up.
+	Overruns uint64
+	// Events skipped without decoding them by the Conn's event Samplers.
+	// See WithEventSampler.
+	EventsSkipped uint64
+	// Events dropped by the Conn's OverflowPolicy because the consumer
+	// of Listen's evChan didn't keep up. See WithEventOverflow.
+	EventsDropped uint64
+	// Dumps the kernel reported as interrupted by changes to the table,
+	// including those that were retried successfully.
+	DumpsInterrupted uint64
+	// Panics recovered from callbacks called by Serve.
+	CallbackPanics uint64
+}
+
+// connStats holds the live counters of a Conn. All of its fields
+// are accessed atomically.
+type connStats struct {
+	messagesReceived uint64
+	bytesReceived    uint64
+	eventsDecoded    uint64
+	decodeErrors     uint64
+	overruns         uint64
+	eventsSkipped    uint64
+	eventsDropped    uint64
+	dumpsInterrupted uint64
+	callbackPanics   uint64
+}
+
+// receive accounts for a batch of messages read from the socket.
+func (cs *connStats) receive(msgs []netlink.Message) {
+
+	var b uint64
+	for _, m := range msgs {
+		b += uint64(m.Header.Length)
+	}
+
+	atomic.AddUint64(&cs.messagesReceived, uint64(len(msgs)))
+	atomic.AddUint64(&cs.bytesReceived, b)
+}
+
+// snapshot returns a consistent-enough copy of the counters as a ConnStats.
+func (cs *connStats) snapshot() ConnStats {
+	return ConnStats{
+		MessagesReceived: atomic.LoadUint64(&cs.messagesReceived),
+		BytesReceived:    atomic.LoadUint64(&cs.bytesReceived),
+		EventsDecoded:    atomic.LoadUint64(&cs.eventsDecoded),
+		DecodeErrors:     atomic.LoadUint64(&cs.decodeErrors),
+		Overruns:         atomic.LoadUint64(&cs.overruns),
+		EventsSkipped:    atomic.LoadUint64(&cs.eventsSkipped),
+		EventsDropped:    atomic.LoadUint64(&cs.eventsDropped),
+		DumpsInterrupted: atomic.LoadUint64(&cs.dumpsInterrupted),
+		CallbackPanics:   atomic.LoadUint64(&cs.callbackPanics),
+	}
+}
+
+// ConnStats returns a snapshot of the Conn's internal counters.
+// Not to be confused with Stats, which queries the kernel's counters.
+func (c *Conn) ConnStats() ConnStats {
+	return c.stats.snapshot()
+}
+
+// Expvar returns an expvar.Var that renders the Conn's ConnStats as JSON.
+// Publish it under a name of choice to expose it on /debug/vars:
+//
+//	expvar.Publish("conntrack", c.Expvar())
+func (c *Conn) Expvar() expvar.Var {
+	return expvar.Func(func() interface{} {
+		return c.ConnStats()
+	})
+}
+
+// isNoBufs returns true if err was caused by the socket's receive buffer
+// overrunning, indicating the kernel had to drop messages.
+func isNoBufs(err error) bool {
+
+	opErr, ok := errors.Cause(err).(*netlink.OpError)
+	if !ok {
+		return false
+	}
+
+	if se, ok := opErr.Err.(*os.SyscallError); ok {
+		return se.Err == syscall.ENOBUFS
+	}
+
+	return opErr.Err == syscall.ENOBUFS
+}
@@ -0,0 +1,85 @@
+package conntrack
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"github.com/mdlayher/netlink"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnStatsReceive(t *testing.T) {
+
+	var cs connStats
+
+	cs.receive([]netlink.Message{
+		{Header: netlink.Header{Length: 20}},
+		{Header: netlink.Header{Length: 124}},
+	})
+	cs.receive(nil)
+
+	assert.Equal(t, ConnStats{MessagesReceived: 2, BytesReceived: 144}, cs.snapshot())
+}
+
+func TestConnStatsExpvar(t *testing.T) {
+
+	var c Conn
+	c.stats.eventsDecoded = 3
+	c.stats.overruns = 1
+
+	var cs ConnStats
+	require.NoError(t, json.Unmarshal([]byte(c.Expvar().String()), &cs))
+
+	assert.Equal(t, ConnStats{EventsDecoded: 3, Overruns: 1}, cs)
+}
+
+func TestIsNoBufs(t *testing.T) {
+
+	tests := []struct {
+		name string
+		err  error
+		ok   bool
+	}{
+		{
+			name: "syscall error",
+			err:  &netlink.OpError{Op: "receive", Err: os.NewSyscallError("recvmsg", syscall.ENOBUFS)},
+			ok:   true,
+		},
+		{
+			name: "wrapped errno",
+			err:  pkgerrors.Wrap(&netlink.OpError{Op: "receive", Err: syscall.ENOBUFS}, "listen"),
+			ok:   true,
+		},
+		{
+			name: "other errno",
+			err:  &netlink.OpError{Op: "receive", Err: os.NewSyscallError("recvmsg", syscall.EBADF)},
+		},
+		{
+			name: "not an OpError",
+			err:  errors.New("ENOBUFS"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.ok, isNoBufs(tt.err))
+		})
+	}
+}
+
+// TestAtomicAlignment checks that 64-bit fields accessed atomically are 64-bit aligned,
+// which is required on 32-bit architectures like 386, arm and mips.
+func TestAtomicAlignment(t *testing.T) {
+
+	var c Conn
+
+	assert.Zero(t, unsafe.Offsetof(c.stats)%8)
+	assert.Zero(t, unsafe.Offsetof(c.readTimeout)%8)
+	assert.Zero(t, unsafe.Offsetof(c.writeTimeout)%8)
+}
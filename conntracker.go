@@ -0,0 +1,16 @@
+package conntrack
+
+// A Conntracker manipulates and observes a Conntrack table. It is implemented by
+// *Conn, and by the in-memory fake in the conntracktest package for testing code
+// that manages Flows without a Linux kernel or elevated privileges.
+type Conntracker interface {
+	Dump(opts ...DumpOption) ([]Flow, error)
+	Get(f Flow) (Flow, error)
+	Create(f Flow) error
+	Update(f Flow) error
+	Delete(f Flow) error
+	Flush() error
+	Listen(evChan chan<- Event, numWorkers uint8, groups []NetlinkGroup) (chan error, error)
+}
+
+var _ Conntracker = (*Conn)(nil)
@@ -0,0 +1,415 @@
+// Package conntrackpb encodes Conntrack Events and Flows as Protocol Buffers messages.
+//
+// The messages are described by conntrack.proto in this package's directory, which
+// consumers in other languages can generate code from. The encoder and decoder in
+// this package are written against the same schema and do not depend on a Protocol
+// Buffers runtime, so importing it does not add any dependencies to a program.
+//
+// Decoding ignores unknown fields, allowing the schema to be extended.
+package conntrackpb
+
+import (
+	"net"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// eventTypes maps Event types to their values in the Event.Type enum.
+var eventTypes = []struct {
+	t conntrack.Event
+	v uint64
+}{
+	{conntrack.Event{Type: conntrack.EventUnknown}, 0},
+	{conntrack.Event{Type: conntrack.EventNew}, 1},
+	{conntrack.Event{Type: conntrack.EventUpdate}, 2},
+	{conntrack.Event{Type: conntrack.EventDestroy}, 3},
+	{conntrack.Event{Type: conntrack.EventExpNew}, 4},
+	{conntrack.Event{Type: conntrack.EventExpDestroy}, 5},
+}
+
+// MarshalEvent encodes ev as an Event message.
+func MarshalEvent(ev conntrack.Event) []byte {
+
+	var e encoder
+
+	for _, et := range eventTypes {
+		if et.t.Type == ev.Type {
+			e.uint(1, et.v)
+		}
+	}
+
+	if ev.Flow != nil {
+		e.message(2, func(e *encoder) { encodeFlow(e, *ev.Flow) })
+	}
+	if ev.Expect != nil {
+		e.message(3, func(e *encoder) { encodeExpect(e, *ev.Expect) })
+	}
+
+	return e.b
+}
+
+// UnmarshalEvent decodes an Event message.
+func UnmarshalEvent(b []byte) (conntrack.Event, error) {
+
+	var ev conntrack.Event
+
+	d := decoder{b: b}
+	for d.next() {
+		switch d.num {
+		case 1:
+			v := d.uint()
+			for _, et := range eventTypes {
+				if et.v == v {
+					ev.Type = et.t.Type
+				}
+			}
+		case 2:
+			ev.Flow = &conntrack.Flow{}
+			d.message(func(d *decoder) { decodeFlow(d, ev.Flow) })
+			finishFlow(ev.Flow)
+		case 3:
+			ev.Expect = &conntrack.Expect{}
+			d.message(func(d *decoder) { decodeExpect(d, ev.Expect) })
+		}
+	}
+
+	return ev, d.err
+}
+
+// MarshalFlow encodes f as a Flow message.
+func MarshalFlow(f conntrack.Flow) []byte {
+
+	var e encoder
+	encodeFlow(&e, f)
+
+	return e.b
+}
+
+// UnmarshalFlow decodes a Flow message.
+func UnmarshalFlow(b []byte) (conntrack.Flow, error) {
+
+	var f conntrack.Flow
+
+	d := decoder{b: b}
+	for d.next() {
+		decodeFlow(&d, &f)
+	}
+	finishFlow(&f)
+
+	return f, d.err
+}
+
+func encodeFlow(e *encoder, f conntrack.Flow) {
+
+	e.uint(1, uint64(f.ID))
+	e.uint(2, uint64(f.Timeout))
+	e.message(3, func(e *encoder) {
+		e.int(1, unixNano(f.Timestamp.Start))
+		e.int(2, unixNano(f.Timestamp.Stop))
+	})
+	e.uint(4, uint64(f.Status.Value))
+
+	// ProtoInfo messages are emitted even when empty, to retain their presence.
+	if tcp := f.ProtoInfo.TCP; tcp != nil {
+		e.present(5, func(e *encoder) {
+			e.uint(1, uint64(tcp.State))
+			e.uint(2, uint64(tcp.OriginalWindowScale))
+			e.uint(3, uint64(tcp.ReplyWindowScale))
+			e.uint(4, uint64(tcp.OriginalFlags))
+			e.uint(5, uint64(tcp.ReplyFlags))
+		})
+	}
+	if dccp := f.ProtoInfo.DCCP; dccp != nil {
+		e.present(6, func(e *encoder) {
+			e.uint(1, uint64(dccp.State))
+			e.uint(2, uint64(dccp.Role))
+			e.uint(3, dccp.HandshakeSeq)
+		})
+	}
+	if sctp := f.ProtoInfo.SCTP; sctp != nil {
+		e.present(7, func(e *encoder) {
+			e.uint(1, uint64(sctp.State))
+			e.uint(2, uint64(sctp.VTagOriginal))
+			e.uint(3, uint64(sctp.VTagReply))
+		})
+	}
+
+	e.message(8, func(e *encoder) {
+		e.string(1, f.Helper.Name)
+		e.bytes(2, f.Helper.Info)
+	})
+	e.uint(9, uint64(f.Zone))
+	e.message(10, func(e *encoder) { encodeCounter(e, f.CountersOrig) })
+	e.message(11, func(e *encoder) { encodeCounter(e, f.CountersReply) })
+	e.string(12, string(f.SecurityContext))
+	e.message(13, func(e *encoder) { encodeTuple(e, f.TupleOrig) })
+	e.message(14, func(e *encoder) { encodeTuple(e, f.TupleReply) })
+	e.message(15, func(e *encoder) { encodeTuple(e, f.TupleMaster) })
+	e.message(16, func(e *encoder) { encodeSeqAdj(e, f.SeqAdjOrig) })
+	e.message(17, func(e *encoder) { encodeSeqAdj(e, f.SeqAdjReply) })
+	e.bytes(18, f.Labels)
+	e.bytes(19, f.LabelsMask)
+	e.uint(20, uint64(f.Mark))
+	e.uint(21, uint64(f.Use))
+	e.message(22, func(e *encoder) {
+		e.uint(1, uint64(f.SynProxy.ISN))
+		e.uint(2, uint64(f.SynProxy.ITS))
+		e.uint(3, uint64(f.SynProxy.TSOff))
+	})
+}
+
+func decodeFlow(d *decoder, f *conntrack.Flow) {
+
+	switch d.num {
+	case 1:
+		f.ID = uint32(d.uint())
+	case 2:
+		f.Timeout = uint32(d.uint())
+	case 3:
+		d.message(func(d *decoder) {
+			switch d.num {
+			case 1:
+				f.Timestamp.Start = time.Unix(0, int64(d.uint()))
+			case 2:
+				f.Timestamp.Stop = time.Unix(0, int64(d.uint()))
+			}
+		})
+	case 4:
+		f.Status.Value = conntrack.StatusFlag(d.uint())
+	case 5:
+		tcp := &conntrack.ProtoInfoTCP{}
+		f.ProtoInfo.TCP = tcp
+		d.message(func(d *decoder) {
+			switch d.num {
+			case 1:
+				tcp.State = uint8(d.uint())
+			case 2:
+				tcp.OriginalWindowScale = uint8(d.uint())
+			case 3:
+				tcp.ReplyWindowScale = uint8(d.uint())
+			case 4:
+				tcp.OriginalFlags = uint16(d.uint())
+			case 5:
+				tcp.ReplyFlags = uint16(d.uint())
+			}
+		})
+	case 6:
+		dccp := &conntrack.ProtoInfoDCCP{}
+		f.ProtoInfo.DCCP = dccp
+		d.message(func(d *decoder) {
+			switch d.num {
+			case 1:
+				dccp.State = uint8(d.uint())
+			case 2:
+				dccp.Role = uint8(d.uint())
+			case 3:
+				dccp.HandshakeSeq = d.uint()
+			}
+		})
+	case 7:
+		sctp := &conntrack.ProtoInfoSCTP{}
+		f.ProtoInfo.SCTP = sctp
+		d.message(func(d *decoder) {
+			switch d.num {
+			case 1:
+				sctp.State = uint8(d.uint())
+			case 2:
+				sctp.VTagOriginal = uint32(d.uint())
+			case 3:
+				sctp.VTagReply = uint32(d.uint())
+			}
+		})
+	case 8:
+		d.message(func(d *decoder) {
+			switch d.num {
+			case 1:
+				f.Helper.Name = string(d.bytes())
+			case 2:
+				f.Helper.Info = d.bytes()
+			}
+		})
+	case 9:
+		f.Zone = uint16(d.uint())
+	case 10:
+		d.message(func(d *decoder) { decodeCounter(d, &f.CountersOrig) })
+	case 11:
+		d.message(func(d *decoder) { decodeCounter(d, &f.CountersReply) })
+	case 12:
+		f.SecurityContext = conntrack.Security(d.bytes())
+	case 13:
+		d.message(func(d *decoder) { decodeTuple(d, &f.TupleOrig) })
+	case 14:
+		d.message(func(d *decoder) { decodeTuple(d, &f.TupleReply) })
+	case 15:
+		d.message(func(d *decoder) { decodeTuple(d, &f.TupleMaster) })
+	case 16:
+		d.message(func(d *decoder) { decodeSeqAdj(d, &f.SeqAdjOrig) })
+	case 17:
+		d.message(func(d *decoder) { decodeSeqAdj(d, &f.SeqAdjReply) })
+	case 18:
+		f.Labels = d.bytes()
+	case 19:
+		f.LabelsMask = d.bytes()
+	case 20:
+		f.Mark = uint32(d.uint())
+	case 21:
+		f.Use = uint32(d.uint())
+	case 22:
+		d.message(func(d *decoder) {
+			switch d.num {
+			case 1:
+				f.SynProxy.ISN = uint32(d.uint())
+			case 2:
+				f.SynProxy.ITS = uint32(d.uint())
+			case 3:
+				f.SynProxy.TSOff = uint32(d.uint())
+			}
+		})
+	}
+}
+
+// finishFlow sets the members of a decoded Flow that are implied by the schema.
+// Reply direction members are identified by their field, not by their contents.
+func finishFlow(f *conntrack.Flow) {
+	f.CountersReply.Direction = true
+	f.SeqAdjReply.Direction = true
+}
+
+func encodeExpect(e *encoder, ex conntrack.Expect) {
+
+	e.uint(1, uint64(ex.ID))
+	e.uint(2, uint64(ex.Timeout))
+	e.message(3, func(e *encoder) { encodeTuple(e, ex.TupleMaster) })
+	e.message(4, func(e *encoder) { encodeTuple(e, ex.Tuple) })
+	e.message(5, func(e *encoder) { encodeTuple(e, ex.Mask) })
+	e.uint(6, uint64(ex.Zone))
+	e.string(7, ex.HelpName)
+	e.string(8, ex.Function)
+	e.uint(9, uint64(ex.Flags))
+	e.uint(10, uint64(ex.Class))
+	e.bool(11, ex.NAT.Direction)
+	e.message(12, func(e *encoder) { encodeTuple(e, ex.NAT.Tuple) })
+}
+
+func decodeExpect(d *decoder, ex *conntrack.Expect) {
+
+	switch d.num {
+	case 1:
+		ex.ID = uint32(d.uint())
+	case 2:
+		ex.Timeout = uint32(d.uint())
+	case 3:
+		d.message(func(d *decoder) { decodeTuple(d, &ex.TupleMaster) })
+	case 4:
+		d.message(func(d *decoder) { decodeTuple(d, &ex.Tuple) })
+	case 5:
+		d.message(func(d *decoder) { decodeTuple(d, &ex.Mask) })
+	case 6:
+		ex.Zone = uint16(d.uint())
+	case 7:
+		ex.HelpName = string(d.bytes())
+	case 8:
+		ex.Function = string(d.bytes())
+	case 9:
+		ex.Flags = uint32(d.uint())
+	case 10:
+		ex.Class = uint32(d.uint())
+	case 11:
+		ex.NAT.Direction = d.uint() != 0
+	case 12:
+		d.message(func(d *decoder) { decodeTuple(d, &ex.NAT.Tuple) })
+	}
+}
+
+func encodeTuple(e *encoder, t conntrack.Tuple) {
+
+	e.bytes(1, addrBytes(t.IP.SourceAddress))
+	e.bytes(2, addrBytes(t.IP.DestinationAddress))
+	e.uint(3, uint64(t.Proto.Protocol))
+	e.uint(4, uint64(t.Proto.SourcePort))
+	e.uint(5, uint64(t.Proto.DestinationPort))
+	e.uint(6, uint64(t.Proto.ICMPID))
+	e.uint(7, uint64(t.Proto.ICMPType))
+	e.uint(8, uint64(t.Proto.ICMPCode))
+	e.uint(9, uint64(t.Zone))
+}
+
+func decodeTuple(d *decoder, t *conntrack.Tuple) {
+
+	switch d.num {
+	case 1:
+		t.IP.SourceAddress = net.IP(d.bytes())
+	case 2:
+		t.IP.DestinationAddress = net.IP(d.bytes())
+	case 3:
+		t.Proto.Protocol = uint8(d.uint())
+		t.Proto.ICMPv4 = t.Proto.Protocol == protoICMP
+		t.Proto.ICMPv6 = t.Proto.Protocol == protoICMPv6
+	case 4:
+		t.Proto.SourcePort = uint16(d.uint())
+	case 5:
+		t.Proto.DestinationPort = uint16(d.uint())
+	case 6:
+		t.Proto.ICMPID = uint16(d.uint())
+	case 7:
+		t.Proto.ICMPType = uint8(d.uint())
+	case 8:
+		t.Proto.ICMPCode = uint8(d.uint())
+	case 9:
+		t.Zone = uint16(d.uint())
+	}
+}
+
+// Protocol numbers implying the ICMPv4 and ICMPv6 flags of a ProtoTuple.
+const (
+	protoICMP   = 1
+	protoICMPv6 = 58
+)
+
+// addrBytes returns ip in its 4-byte form if it is an IPv4 address.
+func addrBytes(ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+func encodeCounter(e *encoder, ctr conntrack.Counter) {
+	e.uint(1, ctr.Packets)
+	e.uint(2, ctr.Bytes)
+}
+
+func decodeCounter(d *decoder, ctr *conntrack.Counter) {
+	switch d.num {
+	case 1:
+		ctr.Packets = d.uint()
+	case 2:
+		ctr.Bytes = d.uint()
+	}
+}
+
+func encodeSeqAdj(e *encoder, seq conntrack.SequenceAdjust) {
+	e.uint(1, uint64(seq.Position))
+	e.uint(2, uint64(seq.OffsetBefore))
+	e.uint(3, uint64(seq.OffsetAfter))
+}
+
+func decodeSeqAdj(d *decoder, seq *conntrack.SequenceAdjust) {
+	switch d.num {
+	case 1:
+		seq.Position = uint32(d.uint())
+	case 2:
+		seq.OffsetBefore = uint32(d.uint())
+	case 3:
+		seq.OffsetAfter = uint32(d.uint())
+	}
+}
+
+// unixNano returns t as nanoseconds since the Unix epoch, or 0 if t is zero.
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
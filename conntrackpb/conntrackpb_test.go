@@ -0,0 +1,121 @@
+package conntrackpb
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntrack"
+)
+
+func TestFlowRoundTrip(t *testing.T) {
+
+	f := conntrack.NewFlow(6, conntrack.StatusAssured|conntrack.StatusSeenReply,
+		net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4(), 51234, 22, 120, 0xff)
+	f.ID = 42
+	f.Zone = 3
+	f.Timestamp = conntrack.Timestamp{Start: time.Unix(0, 1000), Stop: time.Unix(0, 2000)}
+	f.ProtoInfo = conntrack.ProtoInfo{
+		TCP:  &conntrack.ProtoInfoTCP{State: 3, OriginalWindowScale: 7, ReplyFlags: 0x23},
+		DCCP: &conntrack.ProtoInfoDCCP{},
+		SCTP: &conntrack.ProtoInfoSCTP{State: 1, VTagOriginal: 2, VTagReply: 3},
+	}
+	f.Helper = conntrack.Helper{Name: "ftp", Info: []byte{1}}
+	f.CountersOrig = conntrack.Counter{Packets: 1, Bytes: 2}
+	f.CountersReply = conntrack.Counter{Direction: true, Packets: 3, Bytes: 4}
+	f.SecurityContext = "unconfined"
+	f.TupleMaster = conntrack.Tuple{
+		IP:    conntrack.IPTuple{SourceAddress: net.ParseIP("2001:db8::1"), DestinationAddress: net.ParseIP("2001:db8::2")},
+		Proto: conntrack.ProtoTuple{Protocol: 58, ICMPv6: true, ICMPID: 1, ICMPType: 128},
+		Zone:  1,
+	}
+	f.SeqAdjOrig = conntrack.SequenceAdjust{Position: 1, OffsetBefore: 2, OffsetAfter: 3}
+	f.SeqAdjReply = conntrack.SequenceAdjust{Direction: true, Position: 4}
+	f.Labels = []byte{0xde, 0xad}
+	f.LabelsMask = []byte{0xff, 0xff}
+	f.Use = 1
+	f.SynProxy = conntrack.SynProxy{ISN: 1, ITS: 2, TSOff: 3}
+
+	got, err := UnmarshalFlow(MarshalFlow(f))
+	require.NoError(t, err)
+
+	if diff := cmp.Diff(f, got); diff != "" {
+		t.Fatalf("unexpected round-trip result (-want +got):\n%s", diff)
+	}
+}
+
+func TestEventRoundTrip(t *testing.T) {
+
+	f := conntrack.NewFlow(17, 0, net.IPv4(1, 2, 3, 4).To4(), net.IPv4(5, 6, 7, 8).To4(), 53, 53, 30, 0)
+	f.CountersReply.Direction = true
+	f.SeqAdjReply.Direction = true
+
+	ex := conntrack.Expect{
+		ID: 1, Timeout: 2,
+		TupleMaster: f.TupleOrig, Tuple: f.TupleReply,
+		Mask:     conntrack.Tuple{Proto: conntrack.ProtoTuple{DestinationPort: 0xffff}},
+		Zone:     4,
+		HelpName: "ftp", Function: "fn", Flags: conntrack.ExpectFlagPermanent, Class: 2,
+		NAT: conntrack.ExpectNAT{Direction: true, Tuple: f.TupleOrig},
+	}
+
+	for _, ev := range []conntrack.Event{
+		{},
+		{Type: conntrack.EventNew, Flow: &f},
+		{Type: conntrack.EventExpDestroy, Expect: &ex},
+	} {
+		got, err := UnmarshalEvent(MarshalEvent(ev))
+		require.NoError(t, err)
+
+		if diff := cmp.Diff(ev, got); diff != "" {
+			t.Fatalf("unexpected round-trip result (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestMarshalFlowWire(t *testing.T) {
+
+	b := MarshalFlow(conntrack.Flow{ID: 1, Timeout: 150, Mark: 300, CountersReply: conntrack.Counter{Direction: true}})
+
+	// Zero-valued fields and empty messages are omitted.
+	assert.Equal(t, []byte{0x08, 0x01, 0x10, 0x96, 0x01, 0xa0, 0x01, 0xac, 0x02}, b)
+}
+
+func TestUnmarshalUnknownFields(t *testing.T) {
+
+	b := []byte{
+		0x08, 0x01, // id: 1
+		0xf9, 0x01, 1, 2, 3, 4, 5, 6, 7, 8, // field 31, fixed64
+		0xfd, 0x01, 1, 2, 3, 4, // field 31, fixed32
+		0xfa, 0x01, 0x01, 0xff, // field 31, bytes
+		0xf8, 0x01, 0x05, // field 31, varint
+		0x10, 0x02, // timeout: 2
+	}
+
+	f, err := UnmarshalFlow(b)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), f.ID)
+	assert.Equal(t, uint32(2), f.Timeout)
+}
+
+func TestUnmarshalError(t *testing.T) {
+
+	for _, b := range [][]byte{
+		{0x08},                   // truncated varint
+		{0x6a, 0x05, 0x0a},       // truncated nested message
+		{0x6a, 0x02, 0x0a, 0x05}, // nested field exceeds its message
+		{0x0a, 0x00},             // id as bytes
+		{0x0b},                   // group wire type
+		{0x08, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}, // overflow
+	} {
+		_, err := UnmarshalFlow(b)
+		assert.Error(t, err, "%x", b)
+	}
+
+	_, err := UnmarshalEvent([]byte{0x12, 0x01, 0x08})
+	assert.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package conntrackpb
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Wire types of the Protocol Buffers encoding.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var (
+	errTruncated = errors.New("conntrackpb: truncated message")
+	errOverflow  = errors.New("conntrackpb: varint overflows 64 bits")
+)
+
+const errWireType = "conntrackpb: unsupported wire type %d for field %d"
+
+// An encoder appends fields to a buffer. Zero values are omitted, following proto3.
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) tag(num int, typ int) {
+	e.b = binary.AppendUvarint(e.b, uint64(num)<<3|uint64(typ))
+}
+
+func (e *encoder) uint(num int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(num, wireVarint)
+	e.b = binary.AppendUvarint(e.b, v)
+}
+
+func (e *encoder) int(num int, v int64) {
+	e.uint(num, uint64(v))
+}
+
+func (e *encoder) bool(num int, v bool) {
+	if v {
+		e.uint(num, 1)
+	}
+}
+
+func (e *encoder) bytes(num int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	e.tag(num, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *encoder) string(num int, v string) {
+	e.bytes(num, []byte(v))
+}
+
+// message appends the nested message encoded by fn. Empty messages are omitted.
+func (e *encoder) message(num int, fn func(*encoder)) {
+	var sub encoder
+	fn(&sub)
+	e.bytes(num, sub.b)
+}
+
+// present appends the nested message encoded by fn, even if it is empty.
+func (e *encoder) present(num int, fn func(*encoder)) {
+	var sub encoder
+	fn(&sub)
+	e.tag(num, wireBytes)
+	e.b = binary.AppendUvarint(e.b, uint64(len(sub.b)))
+	e.b = append(e.b, sub.b...)
+}
+
+// A decoder iterates over the fields of an encoded message.
+type decoder struct {
+	b   []byte
+	err error
+
+	num int
+	typ int
+	val uint64
+	buf []byte
+}
+
+// next advances to the next field, returning false at the end of the message
+// or when an error occurred.
+func (d *decoder) next() bool {
+
+	if d.err != nil || len(d.b) == 0 {
+		return false
+	}
+
+	tag := d.uvarint()
+	d.num, d.typ = int(tag>>3), int(tag&7)
+
+	switch d.typ {
+	case wireVarint:
+		d.val = d.uvarint()
+	case wireBytes:
+		n := d.uvarint()
+		if d.err == nil && n > uint64(len(d.b)) {
+			d.err = errTruncated
+		}
+		if d.err == nil {
+			d.buf, d.b = d.b[:n], d.b[n:]
+		}
+	case wireFixed64:
+		d.skip(8)
+	case wireFixed32:
+		d.skip(4)
+	default:
+		d.err = fmt.Errorf(errWireType, d.typ, d.num)
+	}
+
+	return d.err == nil
+}
+
+func (d *decoder) uvarint() uint64 {
+
+	if d.err != nil {
+		return 0
+	}
+
+	v, n := binary.Uvarint(d.b)
+	switch {
+	case n == 0:
+		d.err = errTruncated
+	case n < 0:
+		d.err = errOverflow
+	default:
+		d.b = d.b[n:]
+	}
+
+	return v
+}
+
+func (d *decoder) skip(n int) {
+	if len(d.b) < n {
+		d.err = errTruncated
+		return
+	}
+	d.b = d.b[n:]
+}
+
+// uint returns the value of the current field, or 0 if it is not a varint.
+func (d *decoder) uint() uint64 {
+	if d.typ != wireVarint {
+		d.err = fmt.Errorf(errWireType, d.typ, d.num)
+		return 0
+	}
+	return d.val
+}
+
+// bytes returns a copy of the current field's contents.
+func (d *decoder) bytes() []byte {
+	if d.typ != wireBytes {
+		d.err = fmt.Errorf(errWireType, d.typ, d.num)
+		return nil
+	}
+	return append([]byte(nil), d.buf...)
+}
+
+// message decodes the current field as a nested message using fn.
+func (d *decoder) message(fn func(*decoder)) {
+
+	if d.typ != wireBytes {
+		d.err = fmt.Errorf(errWireType, d.typ, d.num)
+		return
+	}
+
+	sub := decoder{b: d.buf}
+	for sub.next() {
+		fn(&sub)
+	}
+
+	if sub.err != nil {
+		d.err = sub.err
+	}
+}
@@ -0,0 +1,469 @@
+// Package conntracktest provides an in-memory implementation of conntrack.Conntracker
+// for testing code that manages Conntrack entries, without needing a Linux kernel or
+// elevated privileges.
+//
+// A Table mimics the behaviour of the kernel's Conntrack table as observed over
+// Netlink: Flows are unique by their original and reply tuples within a zone, expire
+// when their timeout runs out, and mutations produce Events for listeners. Errors are
+// returned in the same form as a conntrack.Conn would return them, so callers can
+// inspect them the same way, e.g. using errors.Is(err, unix.ENOENT).
+//
+// Time in a Table is virtual and only moves forward when calling Advance, which makes
+// expiry deterministic in tests.
+package conntracktest
+
+import (
+	"errors"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/mdlayher/netlink"
+
+	"github.com/ti-mo/conntrack"
+)
+
+var (
+	errNeedTimeout  = errors.New("Flow needs Timeout field set for this operation")
+	errNeedTuples   = errors.New("Flow needs Original and Reply Tuple set for this operation")
+	errUpdateMaster = errors.New("cannot send TupleMaster in Flow update")
+	errWorkerCount  = errors.New("invalid worker count 0")
+)
+
+var _ conntrack.Conntracker = (*Table)(nil)
+
+// A Table is an in-memory Conntrack table implementing conntrack.Conntracker.
+// The zero value is not usable, create a Table using NewTable. It is safe for
+// concurrent use.
+type Table struct {
+	mu      sync.Mutex
+	now     time.Time
+	lastID  uint32
+	entries map[uint32]*entry
+	index   map[key]*entry
+
+	// deliver serializes the delivery of Events to listeners, so they observe
+	// mutations in the order they were applied.
+	deliver   sync.Mutex
+	listeners []listener
+}
+
+// An entry is a Flow in the Table and the virtual time it expires at.
+type entry struct {
+	flow    conntrack.Flow
+	expires time.Time
+}
+
+type listener struct {
+	evChan chan<- conntrack.Event
+
+	new, update, destroy bool
+}
+
+// wants returns true if the listener joined the group of ev.
+func (l listener) wants(ev conntrack.Event) bool {
+	switch ev.Type {
+	case conntrack.EventNew:
+		return l.new
+	case conntrack.EventUpdate:
+		return l.update
+	case conntrack.EventDestroy:
+		return l.destroy
+	}
+	return false
+}
+
+// key uniquely identifies a Tuple within a zone.
+type key struct {
+	src, dst [16]byte
+
+	proto        uint8
+	sport, dport uint16
+
+	icmpID             uint16
+	icmpType, icmpCode uint8
+
+	zone uint16
+}
+
+// NewTable returns an empty Table.
+func NewTable() *Table {
+	return &Table{
+		now:     time.Unix(0, 0),
+		entries: make(map[uint32]*entry),
+		index:   make(map[key]*entry),
+	}
+}
+
+// Len returns the amount of Flows in the Table.
+func (t *Table) Len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.entries)
+}
+
+// Advance moves the Table's virtual clock forward by d. Flows whose timeout runs
+// out are removed from the Table, producing an EventDestroy each.
+func (t *Table) Advance(d time.Duration) {
+
+	t.mu.Lock()
+	t.now = t.now.Add(d)
+
+	var evs []conntrack.Event
+	for _, e := range t.sorted() {
+		if !e.expires.After(t.now) {
+			t.remove(e)
+			evs = append(evs, conntrack.Event{Type: conntrack.EventDestroy, Flow: t.snapshotPtr(e)})
+		}
+	}
+
+	t.send(evs)
+}
+
+// Dump returns all Flows in the Table, ordered by their ID. Like the kernel,
+// it honours conntrack.DumpFamily.
+func (t *Table) Dump(opts ...conntrack.DumpOption) ([]conntrack.Flow, error) {
+
+	dc := conntrack.NewDumpConfig(opts...)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	out := make([]conntrack.Flow, 0, len(t.entries))
+	for _, e := range t.sorted() {
+		if dc.Family != conntrack.ProtoUnspec && dc.Family != family(e.flow.TupleOrig) {
+			continue
+		}
+		out = append(out, t.snapshot(e))
+	}
+
+	return out, nil
+}
+
+// Get looks up a Flow in the Table by f's TupleOrig or TupleReply, in that order,
+// and Zone. Returns an error wrapping ENOENT when the Flow does not exist.
+func (t *Table) Get(f conntrack.Flow) (conntrack.Flow, error) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, err := t.lookup(f)
+	if err != nil {
+		return conntrack.Flow{}, err
+	}
+
+	return t.snapshot(e), nil
+}
+
+// Create adds f to the Table and produces an EventNew. Like the kernel, the Flow's
+// TupleReply is derived from its TupleOrig when it is not set, and a new ID is
+// assigned. Returns an error wrapping EEXIST when either of its tuples is already
+// in use in the Flow's Zone.
+func (t *Table) Create(f conntrack.Flow) error {
+
+	if f.Timeout == 0 {
+		return errNeedTimeout
+	}
+
+	if !filled(f.TupleOrig) {
+		return errNeedTuples
+	}
+
+	if !filled(f.TupleReply) {
+		f.TupleReply = invert(f.TupleOrig)
+	}
+
+	t.mu.Lock()
+
+	ko, kr := tupleKey(f.TupleOrig, f.Zone), tupleKey(f.TupleReply, f.Zone)
+	if t.index[ko] != nil || t.index[kr] != nil {
+		t.mu.Unlock()
+		return opError(syscall.EEXIST)
+	}
+
+	t.lastID++
+	f.ID = t.lastID
+	f.Use = 1
+	f.Status.Value |= conntrack.StatusConfirmed
+	f.TupleMaster = conntrack.Tuple{}
+	f.CountersOrig = conntrack.Counter{}
+	f.CountersReply = conntrack.Counter{Direction: true}
+	f.Timestamp = conntrack.Timestamp{}
+	if f.MarkMask != 0 {
+		f.Mark &= f.MarkMask
+	}
+	f.MarkMask = 0
+
+	e := &entry{flow: f, expires: t.now.Add(seconds(f.Timeout))}
+	t.entries[f.ID] = e
+	t.index[ko], t.index[kr] = e, e
+
+	t.send([]conntrack.Event{{Type: conntrack.EventNew, Flow: t.snapshotPtr(e)}})
+
+	return nil
+}
+
+// Update changes the mutable attributes of a Flow in the Table and produces an
+// EventUpdate. The Flow is looked up like in Get. Only non-zero Timeout, Status,
+// Mark, Labels, ProtoInfo, Helper, SeqAdjOrig, SeqAdjReply and SynProxy fields are
+// applied. Status bits can only be set, not cleared, a new Timeout restarts the
+// Flow's timer and a MarkMask limits the bits of the mark being changed.
+func (t *Table) Update(f conntrack.Flow) error {
+
+	if filled(f.TupleMaster) {
+		return errUpdateMaster
+	}
+
+	t.mu.Lock()
+
+	e, err := t.lookup(f)
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+
+	if f.Timeout != 0 {
+		e.flow.Timeout = f.Timeout
+		e.expires = t.now.Add(seconds(f.Timeout))
+	}
+
+	e.flow.Status.Value |= f.Status.Value
+
+	if f.MarkMask != 0 {
+		e.flow.Mark = e.flow.Mark&^f.MarkMask | f.Mark&f.MarkMask
+	} else if f.Mark != 0 {
+		e.flow.Mark = f.Mark
+	}
+	if len(f.Labels) != 0 {
+		e.flow.Labels = append([]byte(nil), f.Labels...)
+	}
+	if f.ProtoInfo.TCP != nil || f.ProtoInfo.DCCP != nil || f.ProtoInfo.SCTP != nil {
+		e.flow.ProtoInfo = f.ProtoInfo
+	}
+	if f.Helper.Name != "" {
+		e.flow.Helper = f.Helper
+	}
+	if f.SeqAdjOrig != (conntrack.SequenceAdjust{}) {
+		e.flow.SeqAdjOrig = f.SeqAdjOrig
+	}
+	if f.SeqAdjReply != (conntrack.SequenceAdjust{}) {
+		e.flow.SeqAdjReply = f.SeqAdjReply
+	}
+	if f.SynProxy != (conntrack.SynProxy{}) {
+		e.flow.SynProxy = f.SynProxy
+	}
+
+	t.send([]conntrack.Event{{Type: conntrack.EventUpdate, Flow: t.snapshotPtr(e)}})
+
+	return nil
+}
+
+// Delete removes a Flow from the Table and produces an EventDestroy. The Flow is
+// looked up like in Get. When f's ID is non-zero, it must match the ID of the Flow
+// found, or an error wrapping ENOENT is returned.
+func (t *Table) Delete(f conntrack.Flow) error {
+
+	t.mu.Lock()
+
+	e, err := t.lookup(f)
+	if err == nil && f.ID != 0 && f.ID != e.flow.ID {
+		err = opError(syscall.ENOENT)
+	}
+	if err != nil {
+		t.mu.Unlock()
+		return err
+	}
+
+	t.remove(e)
+	t.send([]conntrack.Event{{Type: conntrack.EventDestroy, Flow: t.snapshotPtr(e)}})
+
+	return nil
+}
+
+// Flush removes all Flows from the Table, producing an EventDestroy for each.
+func (t *Table) Flush() error {
+
+	t.mu.Lock()
+
+	var evs []conntrack.Event
+	for _, e := range t.sorted() {
+		t.remove(e)
+		evs = append(evs, conntrack.Event{Type: conntrack.EventDestroy, Flow: t.snapshotPtr(e)})
+	}
+
+	t.send(evs)
+
+	return nil
+}
+
+// Listen sends Events about mutations of the Table on evChan. Only Events matching
+// one of the Flow groups in conntrack.GroupsCT are sent; other groups are ignored.
+// numWorkers must be non-zero but has no further effect, Events are sent one at a
+// time in the order the mutations were applied.
+//
+// Like with a conntrack.Conn, evChan consumers need to keep up with the Table: the
+// method causing an Event blocks until the Event is received. The returned error
+// channel never produces any errors. Listen can be called multiple times.
+func (t *Table) Listen(evChan chan<- conntrack.Event, numWorkers uint8, groups []conntrack.NetlinkGroup) (chan error, error) {
+
+	if numWorkers == 0 {
+		return nil, errWorkerCount
+	}
+
+	l := listener{evChan: evChan}
+	for _, g := range groups {
+		switch g {
+		case conntrack.GroupCTNew:
+			l.new = true
+		case conntrack.GroupCTUpdate:
+			l.update = true
+		case conntrack.GroupCTDestroy:
+			l.destroy = true
+		}
+	}
+
+	t.deliver.Lock()
+	t.listeners = append(t.listeners, l)
+	t.deliver.Unlock()
+
+	return make(chan error), nil
+}
+
+// send delivers evs to all listeners. It must be called with t.mu held, and
+// releases it before blocking on any listener.
+func (t *Table) send(evs []conntrack.Event) {
+
+	t.deliver.Lock()
+	t.mu.Unlock()
+	defer t.deliver.Unlock()
+
+	for _, ev := range evs {
+		for _, l := range t.listeners {
+			if l.wants(ev) {
+				l.evChan <- ev
+			}
+		}
+	}
+}
+
+// lookup finds the entry matching f's TupleOrig or TupleReply, and Zone.
+func (t *Table) lookup(f conntrack.Flow) (*entry, error) {
+
+	var e *entry
+	switch {
+	case filled(f.TupleOrig):
+		e = t.index[tupleKey(f.TupleOrig, f.Zone)]
+	case filled(f.TupleReply):
+		e = t.index[tupleKey(f.TupleReply, f.Zone)]
+	default:
+		return nil, errNeedTuples
+	}
+
+	if e == nil {
+		return nil, opError(syscall.ENOENT)
+	}
+
+	return e, nil
+}
+
+func (t *Table) remove(e *entry) {
+	delete(t.entries, e.flow.ID)
+	delete(t.index, tupleKey(e.flow.TupleOrig, e.flow.Zone))
+	delete(t.index, tupleKey(e.flow.TupleReply, e.flow.Zone))
+}
+
+// sorted returns all entries ordered by their Flow's ID.
+func (t *Table) sorted() []*entry {
+
+	out := make([]*entry, 0, len(t.entries))
+	for _, e := range t.entries {
+		out = append(out, e)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].flow.ID < out[j].flow.ID })
+
+	return out
+}
+
+// snapshot returns a copy of e's Flow with its Timeout set to the remaining
+// amount of seconds until the Flow expires, rounded up.
+func (t *Table) snapshot(e *entry) conntrack.Flow {
+
+	f := e.flow
+	f.Labels = append([]byte(nil), f.Labels...)
+	if len(f.Labels) == 0 {
+		f.Labels = nil
+	}
+
+	f.Timeout = 0
+	if rem := e.expires.Sub(t.now); rem > 0 {
+		f.Timeout = uint32((rem + time.Second - 1) / time.Second)
+	}
+
+	return f
+}
+
+func (t *Table) snapshotPtr(e *entry) *conntrack.Flow {
+	f := t.snapshot(e)
+	return &f
+}
+
+// family returns the protocol family of t's addresses.
+func family(t conntrack.Tuple) conntrack.ProtoFamily {
+	if t.IP.IsIPv6() {
+		return conntrack.ProtoIPv6
+	}
+	return conntrack.ProtoIPv4
+}
+
+func filled(t conntrack.Tuple) bool {
+	return len(t.IP.SourceAddress) != 0 && len(t.IP.DestinationAddress) != 0 && t.Proto.Protocol != 0
+}
+
+// invert returns the reply Tuple of a connection with original Tuple t, without NAT.
+func invert(t conntrack.Tuple) conntrack.Tuple {
+
+	r := t
+	r.IP.SourceAddress, r.IP.DestinationAddress = t.IP.DestinationAddress, t.IP.SourceAddress
+	r.Proto.SourcePort, r.Proto.DestinationPort = t.Proto.DestinationPort, t.Proto.SourcePort
+
+	// ICMP echo requests are answered by echo replies.
+	switch {
+	case t.Proto.Protocol == 1 && t.Proto.ICMPType == 8:
+		r.Proto.ICMPType = 0
+	case t.Proto.Protocol == 58 && t.Proto.ICMPType == 128:
+		r.Proto.ICMPType = 129
+	}
+
+	return r
+}
+
+func tupleKey(t conntrack.Tuple, zone uint16) key {
+
+	k := key{
+		proto:    t.Proto.Protocol,
+		sport:    t.Proto.SourcePort,
+		dport:    t.Proto.DestinationPort,
+		icmpID:   t.Proto.ICMPID,
+		icmpType: t.Proto.ICMPType,
+		icmpCode: t.Proto.ICMPCode,
+		zone:     zone,
+	}
+
+	copy(k.src[:], net.IP(t.IP.SourceAddress).To16())
+	copy(k.dst[:], net.IP(t.IP.DestinationAddress).To16())
+
+	return k
+}
+
+// opError returns errno in the form a conntrack.Conn returns errors from the kernel.
+func opError(errno syscall.Errno) error {
+	return &netlink.OpError{Op: "receive", Err: errno}
+}
+
+func seconds(s uint32) time.Duration {
+	return time.Duration(s) * time.Second
+}
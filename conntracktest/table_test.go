@@ -0,0 +1,190 @@
+package conntracktest
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntrack"
+)
+
+func testFlow(sport uint16) conntrack.Flow {
+	return conntrack.NewFlow(6, 0, net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4(), sport, 80, 10, 0)
+}
+
+func TestTableCRUD(t *testing.T) {
+
+	tbl := NewTable()
+
+	evChan := make(chan conntrack.Event, 16)
+	_, err := tbl.Listen(evChan, 1, conntrack.GroupsCT)
+	require.NoError(t, err)
+
+	f := testFlow(1234)
+	require.NoError(t, tbl.Create(f))
+	assert.Equal(t, 1, tbl.Len())
+
+	// Duplicate tuples are rejected, in both directions.
+	err = tbl.Create(f)
+	assert.True(t, errors.Is(err, syscall.EEXIST), "unexpected error: %v", err)
+
+	rf := f
+	rf.TupleOrig, rf.TupleReply = f.TupleReply, f.TupleOrig
+	err = tbl.Create(rf)
+	assert.True(t, errors.Is(err, syscall.EEXIST), "unexpected error: %v", err)
+
+	// The same tuple in another zone is a different connection.
+	zf := f
+	zf.Zone = 1
+	require.NoError(t, tbl.Create(zf))
+
+	// Lookup by the reply tuple only.
+	q := conntrack.Flow{TupleReply: f.TupleReply}
+	got, err := tbl.Get(q)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(1), got.ID)
+	assert.Equal(t, uint32(10), got.Timeout)
+	assert.True(t, got.Status.Confirmed())
+
+	tbl.Advance(4 * time.Second)
+
+	require.NoError(t, tbl.Update(conntrack.Flow{TupleOrig: f.TupleOrig, Mark: 0xff, Status: conntrack.Status{Value: conntrack.StatusAssured}}))
+	got, err = tbl.Get(f)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0xff), got.Mark)
+	assert.Equal(t, uint32(6), got.Timeout)
+	assert.True(t, got.Status.Assured())
+	assert.True(t, got.Status.Confirmed())
+
+	assert.Equal(t, errUpdateMaster, tbl.Update(conntrack.Flow{TupleOrig: f.TupleOrig, TupleMaster: f.TupleOrig}))
+
+	// A mismatching ID prevents a delete.
+	err = tbl.Delete(conntrack.Flow{TupleOrig: f.TupleOrig, ID: 42})
+	assert.True(t, errors.Is(err, syscall.ENOENT), "unexpected error: %v", err)
+
+	require.NoError(t, tbl.Delete(got))
+
+	_, err = tbl.Get(f)
+	assert.True(t, errors.Is(err, syscall.ENOENT), "unexpected error: %v", err)
+
+	err = tbl.Update(f)
+	assert.True(t, errors.Is(err, syscall.ENOENT), "unexpected error: %v", err)
+
+	require.NoError(t, tbl.Flush())
+	assert.Equal(t, 0, tbl.Len())
+
+	var types []string
+	for len(evChan) > 0 {
+		ev := <-evChan
+		types = append(types, ev.Type.String())
+	}
+	assert.Equal(t, []string{"EventNew", "EventNew", "EventUpdate", "EventDestroy", "EventDestroy"}, types)
+}
+
+func TestTableCreateReply(t *testing.T) {
+
+	tbl := NewTable()
+
+	f := testFlow(1234)
+	f.TupleReply = conntrack.Tuple{}
+	require.NoError(t, tbl.Create(f))
+
+	got, err := tbl.Get(conntrack.Flow{TupleReply: testFlow(1234).TupleReply})
+	require.NoError(t, err)
+	assert.Equal(t, uint16(1234), got.TupleReply.Proto.DestinationPort)
+
+	assert.Equal(t, errNeedTimeout, tbl.Create(conntrack.Flow{TupleOrig: f.TupleOrig}))
+	assert.Equal(t, errNeedTuples, tbl.Create(conntrack.Flow{Timeout: 1}))
+
+	_, err = tbl.Get(conntrack.Flow{})
+	assert.Equal(t, errNeedTuples, err)
+}
+
+func TestTableExpiry(t *testing.T) {
+
+	tbl := NewTable()
+
+	evChan := make(chan conntrack.Event, 4)
+	_, err := tbl.Listen(evChan, 1, []conntrack.NetlinkGroup{conntrack.GroupCTDestroy})
+	require.NoError(t, err)
+
+	short, long := testFlow(1), testFlow(2)
+	long.Timeout = 30
+	require.NoError(t, tbl.Create(short))
+	require.NoError(t, tbl.Create(long))
+
+	tbl.Advance(9*time.Second + time.Millisecond)
+
+	flows, err := tbl.Dump()
+	require.NoError(t, err)
+	require.Len(t, flows, 2)
+	assert.Equal(t, uint32(1), flows[0].Timeout)
+	assert.Equal(t, uint32(21), flows[1].Timeout)
+
+	tbl.Advance(time.Second)
+
+	flows, err = tbl.Dump()
+	require.NoError(t, err)
+	require.Len(t, flows, 1)
+	assert.Equal(t, uint32(2), flows[0].ID)
+
+	// Only the destroy group was joined.
+	require.Len(t, evChan, 1)
+	ev := <-evChan
+	assert.Equal(t, conntrack.EventDestroy, ev.Type)
+	assert.Equal(t, uint32(1), ev.Flow.ID)
+}
+
+func TestTableListenError(t *testing.T) {
+	_, err := NewTable().Listen(make(chan conntrack.Event), 0, conntrack.GroupsCT)
+	assert.Equal(t, errWorkerCount, err)
+}
+
+func TestTableDumpFamily(t *testing.T) {
+
+	tbl := NewTable()
+
+	v6 := conntrack.NewFlow(17, 0, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 53, 53, 10, 0)
+	require.NoError(t, tbl.Create(testFlow(1)))
+	require.NoError(t, tbl.Create(v6))
+
+	flows, err := tbl.Dump(conntrack.DumpFamily(conntrack.ProtoIPv6))
+	require.NoError(t, err)
+	require.Len(t, flows, 1)
+	assert.Equal(t, uint32(2), flows[0].ID)
+
+	flows, err = tbl.Dump(conntrack.DumpFamily(conntrack.ProtoIPv4))
+	require.NoError(t, err)
+	require.Len(t, flows, 1)
+	assert.Equal(t, uint32(1), flows[0].ID)
+
+	flows, err = tbl.Dump()
+	require.NoError(t, err)
+	assert.Len(t, flows, 2)
+}
+
+func TestTableUpdateMarkMask(t *testing.T) {
+
+	tbl := NewTable()
+
+	f := testFlow(1)
+	f.Mark = 0x12345678
+	require.NoError(t, tbl.Create(f))
+
+	require.NoError(t, tbl.Update(conntrack.Flow{TupleOrig: f.TupleOrig, Mark: 0xab00, MarkMask: 0xff00}))
+	got, err := tbl.Get(f)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x1234ab78), got.Mark)
+	assert.Equal(t, uint32(0), got.MarkMask)
+
+	// Bits of Mark outside the MarkMask are ignored.
+	require.NoError(t, tbl.Update(conntrack.Flow{TupleOrig: f.TupleOrig, Mark: 0xffff00cd, MarkMask: 0xff}))
+	got, err = tbl.Get(f)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x1234abcd), got.Mark)
+}
@@ -0,0 +1,196 @@
+package conntrack
+
+import (
+	"encoding/csv"
+	"io"
+	"net"
+	"strconv"
+)
+
+// A Column is a field of a Flow written by a CSVEncoder.
+type Column uint8
+
+// Columns that can be written by a CSVEncoder. Addresses and ports are taken from
+// the Flow's original and reply tuples. Counters are only filled when accounting
+// is enabled in the kernel.
+const (
+	ColumnID Column = iota
+	ColumnProto
+	ColumnOrigSrc
+	ColumnOrigDst
+	ColumnOrigSrcPort
+	ColumnOrigDstPort
+	ColumnReplySrc
+	ColumnReplyDst
+	ColumnReplySrcPort
+	ColumnReplyDstPort
+	ColumnOrigPackets
+	ColumnOrigBytes
+	ColumnReplyPackets
+	ColumnReplyBytes
+	ColumnTimeout
+	ColumnMark
+	ColumnZone
+	ColumnStatus
+)
+
+var columnNames = [...]string{
+	ColumnID:           "id",
+	ColumnProto:        "proto",
+	ColumnOrigSrc:      "orig_src",
+	ColumnOrigDst:      "orig_dst",
+	ColumnOrigSrcPort:  "orig_sport",
+	ColumnOrigDstPort:  "orig_dport",
+	ColumnReplySrc:     "reply_src",
+	ColumnReplyDst:     "reply_dst",
+	ColumnReplySrcPort: "reply_sport",
+	ColumnReplyDstPort: "reply_dport",
+	ColumnOrigPackets:  "orig_packets",
+	ColumnOrigBytes:    "orig_bytes",
+	ColumnReplyPackets: "reply_packets",
+	ColumnReplyBytes:   "reply_bytes",
+	ColumnTimeout:      "timeout",
+	ColumnMark:         "mark",
+	ColumnZone:         "zone",
+	ColumnStatus:       "status",
+}
+
+// String returns the Column's name, as written in the header row.
+func (c Column) String() string {
+	if int(c) < len(columnNames) {
+		return columnNames[c]
+	}
+	return "column" + strconv.Itoa(int(c))
+}
+
+// Sets of Columns to pass to NewCSVEncoder, which can be combined using append.
+var (
+	ColumnsTuples = []Column{
+		ColumnProto,
+		ColumnOrigSrc, ColumnOrigDst, ColumnOrigSrcPort, ColumnOrigDstPort,
+		ColumnReplySrc, ColumnReplyDst, ColumnReplySrcPort, ColumnReplyDstPort,
+	}
+	ColumnsCounters = []Column{ColumnOrigPackets, ColumnOrigBytes, ColumnReplyPackets, ColumnReplyBytes}
+	ColumnsState    = []Column{ColumnTimeout, ColumnMark, ColumnZone, ColumnStatus}
+)
+
+// A CSVEncoder writes Flows to an io.Writer as comma-separated values, one Flow per
+// row, preceded by a header row naming the Columns. Set Comma to '\t' before the first
+// call to Encode to write tab-separated values instead.
+//
+// Encode has the signature of the callback of Conn.DumpPages, so the Conntrack table
+// can be exported without holding all of its Flows in memory:
+//
+//	err := c.DumpPages(ctx, 1024, enc.Encode)
+type CSVEncoder struct {
+	// Comma is the field delimiter, ',' by default.
+	Comma rune
+
+	w      *csv.Writer
+	cols   []Column
+	header bool
+	row    []string
+}
+
+// NewCSVEncoder returns a CSVEncoder writing the given Columns to w. When no
+// Columns are given, the Flow's ID, tuples, counters and state are written.
+func NewCSVEncoder(w io.Writer, cols ...Column) *CSVEncoder {
+
+	if len(cols) == 0 {
+		cols = append([]Column{ColumnID}, ColumnsTuples...)
+		cols = append(cols, ColumnsCounters...)
+		cols = append(cols, ColumnsState...)
+	}
+
+	return &CSVEncoder{
+		Comma: ',',
+		w:     csv.NewWriter(w),
+		cols:  cols,
+		row:   make([]string, len(cols)),
+	}
+}
+
+// Encode writes a row for each of the given Flows and flushes them to the
+// underlying io.Writer. The header row is written by the first call.
+func (e *CSVEncoder) Encode(flows []Flow) error {
+
+	e.w.Comma = e.Comma
+
+	if !e.header {
+		for i, c := range e.cols {
+			e.row[i] = c.String()
+		}
+		if err := e.w.Write(e.row); err != nil {
+			return err
+		}
+		e.header = true
+	}
+
+	for _, f := range flows {
+		for i, c := range e.cols {
+			e.row[i] = csvField(f, c)
+		}
+		if err := e.w.Write(e.row); err != nil {
+			return err
+		}
+	}
+
+	e.w.Flush()
+
+	return e.w.Error()
+}
+
+// csvField formats the value of the Column c of f.
+func csvField(f Flow, c Column) string {
+
+	u := func(v uint64) string { return strconv.FormatUint(v, 10) }
+
+	switch c {
+	case ColumnID:
+		return u(uint64(f.ID))
+	case ColumnProto:
+		return protoLookup(f.TupleOrig.Proto.Protocol)
+	case ColumnOrigSrc:
+		return csvIP(f.TupleOrig.IP.SourceAddress)
+	case ColumnOrigDst:
+		return csvIP(f.TupleOrig.IP.DestinationAddress)
+	case ColumnOrigSrcPort:
+		return u(uint64(f.TupleOrig.Proto.SourcePort))
+	case ColumnOrigDstPort:
+		return u(uint64(f.TupleOrig.Proto.DestinationPort))
+	case ColumnReplySrc:
+		return csvIP(f.TupleReply.IP.SourceAddress)
+	case ColumnReplyDst:
+		return csvIP(f.TupleReply.IP.DestinationAddress)
+	case ColumnReplySrcPort:
+		return u(uint64(f.TupleReply.Proto.SourcePort))
+	case ColumnReplyDstPort:
+		return u(uint64(f.TupleReply.Proto.DestinationPort))
+	case ColumnOrigPackets:
+		return u(f.CountersOrig.Packets)
+	case ColumnOrigBytes:
+		return u(f.CountersOrig.Bytes)
+	case ColumnReplyPackets:
+		return u(f.CountersReply.Packets)
+	case ColumnReplyBytes:
+		return u(f.CountersReply.Bytes)
+	case ColumnTimeout:
+		return u(uint64(f.Timeout))
+	case ColumnMark:
+		return u(uint64(f.Mark))
+	case ColumnZone:
+		return u(uint64(f.Zone))
+	case ColumnStatus:
+		return f.Status.String()
+	}
+
+	return ""
+}
+
+// csvIP formats ip, leaving the field empty when there is no address.
+func csvIP(ip net.IP) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	return ip.String()
+}
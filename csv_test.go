@@ -0,0 +1,50 @@
+package conntrack
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVEncoder(t *testing.T) {
+
+	f := NewFlow(6, StatusAssured, net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2), 1234, 80, 120, 0xff)
+	f.ID = 42
+	f.CountersOrig = Counter{Packets: 1, Bytes: 60}
+	f.CountersReply = Counter{Direction: true, Packets: 2, Bytes: 120}
+
+	var b bytes.Buffer
+	enc := NewCSVEncoder(&b)
+	require.NoError(t, enc.Encode([]Flow{f}))
+	require.NoError(t, enc.Encode(nil))
+
+	assert.Equal(t,
+		"id,proto,orig_src,orig_dst,orig_sport,orig_dport,reply_src,reply_dst,reply_sport,reply_dport,"+
+			"orig_packets,orig_bytes,reply_packets,reply_bytes,timeout,mark,zone,status\n"+
+			"42,tcp,192.0.2.1,192.0.2.2,1234,80,192.0.2.2,192.0.2.1,80,1234,1,60,2,120,120,255,0,ASSURED\n",
+		b.String())
+
+	// Tab-separated, selected columns, a Flow without a reply tuple.
+	b.Reset()
+	enc = NewCSVEncoder(&b, append([]Column{ColumnOrigSrc, ColumnReplySrc}, ColumnsCounters[:1]...)...)
+	enc.Comma = '\t'
+	f.TupleReply = Tuple{}
+	require.NoError(t, enc.Encode([]Flow{f, f}))
+
+	assert.Equal(t, "orig_src\treply_src\torig_packets\n192.0.2.1\t\t1\n192.0.2.1\t\t1\n", b.String())
+
+	assert.Equal(t, "column200", Column(200).String())
+	assert.Equal(t, "", csvField(f, Column(200)))
+}
+
+type failWriter struct{}
+
+func (failWriter) Write([]byte) (int, error) { return 0, errors.New("write failure") }
+
+func TestCSVEncoderError(t *testing.T) {
+	assert.EqualError(t, NewCSVEncoder(failWriter{}).Encode([]Flow{{}}), "write failure")
+}
@@ -0,0 +1,307 @@
+package ctsync
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/conntrack/conntrackpb"
+)
+
+// An Applier applies the state received by a Receiver to a Conntrack table.
+// It is implemented by conntrack.Conn, conntrack.Pool and conntrack.Pipeline.
+type Applier interface {
+	Create(conntrack.Flow) error
+	Update(conntrack.Flow) error
+	Delete(conntrack.Flow) error
+}
+
+// A batcher is an Applier reporting errors asynchronously, like conntrack.Pipeline.
+type batcher interface {
+	Wait() ([]conntrack.FlowError, error)
+}
+
+type op uint8
+
+const (
+	opCreate op = iota
+	opUpdate
+	opDelete
+)
+
+// An action is an operation on a Flow applied because of a received Event.
+type action struct {
+	ev conntrack.Event
+	op op
+	// retried is set when the action fell back to another operation,
+	// which is only done once.
+	retried bool
+}
+
+// A Receiver applies the Events sent by a Sender to a Conntrack table. EventNew
+// creates the Flow, EventUpdate updates it and EventDestroy deletes it. Since the
+// tables of both peers drift apart, for example when the Receiver started after the
+// Sender, a Flow that already exists is updated instead of created, and a Flow that
+// doesn't exist is created instead of updated. Deleting a Flow that doesn't exist is
+// not an error.
+//
+// The Flows of received Events are stripped of the attributes that can't be applied,
+// like counters and the Flow's ID. Flows with source or destination NAT are created
+// with the NAT derived from their reply tuple.
+//
+// A Receiver must not be used by multiple goroutines concurrently.
+type Receiver struct {
+	a       Applier
+	b       batcher
+	onError func(conntrack.Event, error)
+
+	pending map[conntrack.FlowKey]*action
+}
+
+// NewReceiver returns a Receiver applying Events using a. Errors applying an Event
+// are passed to onError along with the Event, and don't stop the Receiver. Errors
+// not related to a single Event are passed with a zero Event. onError may be nil.
+//
+// If a is a conntrack.Pipeline, requests are sent without waiting for the kernel to
+// process them. The Receiver waits for replies each time it would block reading more
+// Events, and when it receives an Event about a Flow with a request in flight.
+func NewReceiver(a Applier, onError func(conntrack.Event, error)) *Receiver {
+
+	r := &Receiver{a: a, onError: onError}
+
+	if b, ok := a.(batcher); ok {
+		r.b = b
+		r.pending = make(map[conntrack.FlowKey]*action)
+	}
+
+	return r
+}
+
+// Serve applies the Events read from a stream, for example a TCP connection accepted
+// from the Sender. Returns nil when r reaches EOF at the boundary of a frame, or an
+// error when reading from r fails or the stream is corrupted.
+func (r *Receiver) Serve(rd io.Reader) error {
+
+	br := bufio.NewReader(rd)
+	defer r.flush()
+
+	var h [frameHeaderLen]byte
+	var msg []byte
+	for {
+		// Apply the batch before waiting for the Sender.
+		if br.Buffered() == 0 {
+			r.flush()
+		}
+
+		if _, err := io.ReadFull(br, h[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+
+		n, err := frameLength(h[:])
+		if err != nil {
+			return err
+		}
+
+		if cap(msg) < n {
+			msg = make([]byte, n)
+		}
+		msg = msg[:n]
+		if _, err := io.ReadFull(br, msg); err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+
+		ev, err := conntrackpb.UnmarshalEvent(msg)
+		if err != nil {
+			return err
+		}
+
+		r.handle(ev)
+	}
+}
+
+// ServePacket applies the Events read from datagrams, for example from a UDP socket
+// joined to the multicast group the Sender writes to. Invalid datagrams are passed to
+// the Receiver's error callback and skipped. Returns the error reading from pc, which
+// is net.ErrClosed after pc was closed.
+func (r *Receiver) ServePacket(pc net.PacketConn) error {
+
+	buf := make([]byte, 64*1024)
+	for {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		evs, err := splitFrames(buf[:n])
+		if err != nil {
+			r.fail(conntrack.Event{}, err)
+			continue
+		}
+
+		for _, ev := range evs {
+			r.handle(ev)
+		}
+		r.flush()
+	}
+}
+
+// handle applies a received Event.
+func (r *Receiver) handle(ev conntrack.Event) {
+
+	if ev.Flow == nil {
+		return
+	}
+
+	act := &action{ev: ev}
+	switch ev.Type {
+	case conntrack.EventNew:
+		act.op = opCreate
+	case conntrack.EventUpdate:
+		act.op = opUpdate
+	case conntrack.EventDestroy:
+		act.op = opDelete
+	default:
+		return
+	}
+
+	// Requests about the same Flow must be applied in order, and their
+	// errors told apart.
+	if r.pending[key(*ev.Flow)] != nil {
+		r.flush()
+	}
+
+	r.apply(act)
+}
+
+// apply performs act's operation. When applying synchronously, errors are
+// handled right away.
+func (r *Receiver) apply(act *action) {
+
+	var err error
+	switch f := *act.ev.Flow; act.op {
+	case opCreate:
+		err = r.a.Create(createFlow(f))
+	case opUpdate:
+		err = r.a.Update(updateFlow(f))
+	case opDelete:
+		err = r.a.Delete(deleteFlow(f))
+	}
+
+	if err != nil {
+		r.failed(act, err)
+		return
+	}
+
+	if r.b != nil {
+		r.pending[key(*act.ev.Flow)] = act
+	}
+}
+
+// failed falls back to another operation if act failed because the table of the
+// Receiver doesn't match the Sender's, and reports the error otherwise.
+func (r *Receiver) failed(act *action, err error) {
+
+	if !act.retried {
+		switch {
+		case act.op == opCreate && errors.Is(err, syscall.EEXIST):
+			act.op, act.retried = opUpdate, true
+			r.apply(act)
+			return
+		case act.op == opUpdate && errors.Is(err, syscall.ENOENT):
+			act.op, act.retried = opCreate, true
+			r.apply(act)
+			return
+		case act.op == opDelete && errors.Is(err, syscall.ENOENT):
+			return
+		}
+	}
+
+	r.fail(act.ev, err)
+}
+
+// flush waits for the batcher to process all pending actions, including
+// the fallbacks of failed ones.
+func (r *Receiver) flush() {
+
+	for len(r.pending) != 0 {
+		errs, err := r.b.Wait()
+
+		pending := r.pending
+		r.pending = make(map[conntrack.FlowKey]*action)
+
+		if err != nil {
+			r.fail(conntrack.Event{}, err)
+		}
+
+		for _, fe := range errs {
+			if act := pending[key(fe.Flow)]; act != nil {
+				r.failed(act, fe.Err)
+			}
+		}
+	}
+}
+
+func (r *Receiver) fail(ev conntrack.Event, err error) {
+	if r.onError != nil {
+		r.onError(ev, err)
+	}
+}
+
+// key identifies the connection of f. Only the original tuple is considered,
+// since it is the only tuple sent when deleting a Flow.
+func key(f conntrack.Flow) conntrack.FlowKey {
+	return conntrack.Flow{TupleOrig: f.TupleOrig, Zone: f.Zone}.Key()
+}
+
+// createFlow returns the attributes of f that can be set when creating a Flow.
+func createFlow(f conntrack.Flow) conntrack.Flow {
+
+	cf := updateFlow(f)
+	cf.TupleReply = f.TupleReply
+	cf.Helper = conntrack.Helper{Name: f.Helper.Name}
+
+	// The kernel derives the tuples of a NATed connection from its NAT ranges.
+	if f.Status.SrcNAT() {
+		cf.NATSrc = conntrack.NATRange{
+			MinIP:   f.TupleReply.IP.DestinationAddress,
+			MinPort: f.TupleReply.Proto.DestinationPort,
+		}
+	}
+	if f.Status.DstNAT() {
+		cf.NATDst = conntrack.NATRange{
+			MinIP:   f.TupleReply.IP.SourceAddress,
+			MinPort: f.TupleReply.Proto.SourcePort,
+		}
+	}
+
+	return cf
+}
+
+// updateFlow returns the attributes of f that can be changed on an existing Flow.
+func updateFlow(f conntrack.Flow) conntrack.Flow {
+	return conntrack.Flow{
+		TupleOrig:   f.TupleOrig,
+		Zone:        f.Zone,
+		Timeout:     f.Timeout,
+		Status:      conntrack.Status{Value: f.Status.Value & (conntrack.StatusSeenReply | conntrack.StatusAssured)},
+		ProtoInfo:   f.ProtoInfo,
+		Mark:        f.Mark,
+		Labels:      f.Labels,
+		SeqAdjOrig:  f.SeqAdjOrig,
+		SeqAdjReply: f.SeqAdjReply,
+	}
+}
+
+// deleteFlow returns the attributes of f identifying the Flow to delete.
+func deleteFlow(f conntrack.Flow) conntrack.Flow {
+	return conntrack.Flow{TupleOrig: f.TupleOrig, Zone: f.Zone}
+}
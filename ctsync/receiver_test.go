@@ -0,0 +1,264 @@
+package ctsync
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/conntrack/conntracktest"
+)
+
+// frames returns a stream of frames holding evs.
+func frames(t *testing.T, evs ...conntrack.Event) *bytes.Buffer {
+
+	var buf bytes.Buffer
+	s := NewSender(&buf, 0)
+	for _, ev := range evs {
+		require.NoError(t, s.Send(ev))
+	}
+
+	return &buf
+}
+
+func event(typ uint8, f conntrack.Flow) conntrack.Event {
+
+	ev := conntrack.Event{Flow: &f}
+	switch typ {
+	case 'n':
+		ev.Type = conntrack.EventNew
+	case 'u':
+		ev.Type = conntrack.EventUpdate
+	case 'd':
+		ev.Type = conntrack.EventDestroy
+	}
+
+	return ev
+}
+
+// errs collects the errors passed to a Receiver's error callback.
+type errs []error
+
+func (e *errs) add(_ conntrack.Event, err error) {
+	*e = append(*e, err)
+}
+
+func TestReceiverServe(t *testing.T) {
+
+	tbl := conntracktest.NewTable()
+	var errs errs
+	r := NewReceiver(tbl, errs.add)
+
+	f1, f2 := testFlow(1), testFlow(2)
+	f1u := f1
+	f1u.Mark = 5
+
+	require.NoError(t, r.Serve(frames(t,
+		event('n', f1),
+		event('n', f2),
+		event('u', f1u),
+		event('d', f2),
+	)))
+	assert.Empty(t, errs)
+
+	flows, err := tbl.Dump()
+	require.NoError(t, err)
+	require.Len(t, flows, 1)
+	assert.Equal(t, f1.TupleOrig, flows[0].TupleOrig)
+	assert.Equal(t, uint32(5), flows[0].Mark)
+}
+
+func TestReceiverFallback(t *testing.T) {
+
+	tbl := conntracktest.NewTable()
+	require.NoError(t, tbl.Create(testFlow(1)))
+
+	var errs errs
+	r := NewReceiver(tbl, errs.add)
+
+	f1 := testFlow(1)
+	f1.Mark = 7
+
+	// f1 already exists, f3 and f4 don't.
+	require.NoError(t, r.Serve(frames(t,
+		event('n', f1),
+		event('u', testFlow(3)),
+		event('d', testFlow(4)),
+	)))
+	assert.Empty(t, errs)
+
+	got, err := tbl.Get(f1)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), got.Mark)
+
+	_, err = tbl.Get(testFlow(3))
+	assert.NoError(t, err)
+
+	// Falling back fails too.
+	f5 := testFlow(5)
+	f5.Timeout = 0
+	require.NoError(t, r.Serve(frames(t, event('u', f5))))
+	assert.Len(t, errs, 1)
+}
+
+func TestReceiverServeCorrupt(t *testing.T) {
+
+	r := NewReceiver(conntracktest.NewTable(), nil)
+
+	b := frames(t, event('n', testFlow(1))).Bytes()
+
+	assert.Equal(t, io.ErrUnexpectedEOF, r.Serve(bytes.NewReader(b[:len(b)-1])))
+	assert.Equal(t, io.ErrUnexpectedEOF, r.Serve(bytes.NewReader(b[:4])))
+
+	b[0] = 'x'
+	assert.Equal(t, errFrameHeader, r.Serve(bytes.NewReader(b)))
+}
+
+func TestReceiverServePacket(t *testing.T) {
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	tbl := conntracktest.NewTable()
+	var errs errs
+	r := NewReceiver(tbl, errs.add)
+
+	done := make(chan error)
+	go func() {
+		done <- r.ServePacket(pc)
+	}()
+
+	c, err := net.Dial("udp", pc.LocalAddr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	_, err = c.Write([]byte("garbage"))
+	require.NoError(t, err)
+
+	require.NoError(t, NewSender(c, DatagramSize).Resync([]conntrack.Flow{testFlow(1), testFlow(2)}))
+
+	require.Eventually(t, func() bool {
+		return tbl.Len() == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, pc.Close())
+	assert.True(t, errors.Is(<-done, net.ErrClosed))
+
+	require.Len(t, errs, 1)
+	assert.Equal(t, errFrameShort, errs[0])
+}
+
+// batchTable is a conntracktest.Table applying requests when Wait is called,
+// like a conntrack.Pipeline.
+type batchTable struct {
+	*conntracktest.Table
+
+	queue []func() error
+	flows []conntrack.Flow
+	waits int
+}
+
+func (b *batchTable) Create(f conntrack.Flow) error {
+	return b.enqueue(f, b.Table.Create)
+}
+
+func (b *batchTable) Update(f conntrack.Flow) error {
+	return b.enqueue(f, b.Table.Update)
+}
+
+func (b *batchTable) Delete(f conntrack.Flow) error {
+	return b.enqueue(f, b.Table.Delete)
+}
+
+func (b *batchTable) enqueue(f conntrack.Flow, fn func(conntrack.Flow) error) error {
+
+	b.queue = append(b.queue, func() error { return fn(f) })
+	b.flows = append(b.flows, f)
+
+	return nil
+}
+
+func (b *batchTable) Wait() ([]conntrack.FlowError, error) {
+
+	b.waits++
+
+	var fes []conntrack.FlowError
+	for i, fn := range b.queue {
+		if err := fn(); err != nil {
+			fes = append(fes, conntrack.FlowError{Flow: b.flows[i], Err: err})
+		}
+	}
+	b.queue, b.flows = nil, nil
+
+	return fes, nil
+}
+
+func TestReceiverBatch(t *testing.T) {
+
+	bt := &batchTable{Table: conntracktest.NewTable()}
+	require.NoError(t, bt.Table.Create(testFlow(1)))
+
+	var errs errs
+	r := NewReceiver(bt, errs.add)
+
+	f1 := testFlow(1)
+	f1.Mark = 7
+
+	require.NoError(t, r.Serve(frames(t,
+		event('n', f1),
+		event('u', testFlow(2)),
+		event('d', testFlow(3)),
+	)))
+	assert.Empty(t, errs)
+
+	// One batch for the Events, one for the fallbacks.
+	assert.Equal(t, 2, bt.waits)
+
+	got, err := bt.Get(f1)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(7), got.Mark)
+	assert.Equal(t, 2, bt.Len())
+
+	// Events about a Flow with a request in flight start a new batch.
+	bt.waits = 0
+	require.NoError(t, r.Serve(frames(t,
+		event('n', testFlow(4)),
+		event('d', testFlow(4)),
+	)))
+	assert.Empty(t, errs)
+	assert.Equal(t, 2, bt.waits)
+	assert.Equal(t, 2, bt.Len())
+}
+
+func TestReceiverSanitize(t *testing.T) {
+
+	f := testFlow(1)
+	f.ID = 42
+	f.Status.Value |= conntrack.StatusConfirmed | conntrack.StatusSrcNAT | conntrack.StatusSrcNATDone
+	f.TupleReply.IP.DestinationAddress = net.IPv4(192, 0, 2, 1).To4()
+	f.TupleReply.Proto.DestinationPort = 4000
+	f.CountersOrig = conntrack.Counter{Packets: 1, Bytes: 60}
+	f.Helper = conntrack.Helper{Name: "ftp", Info: []byte{1}}
+
+	cf := createFlow(f)
+	assert.Zero(t, cf.ID)
+	assert.Equal(t, conntrack.StatusAssured, cf.Status.Value)
+	assert.Zero(t, cf.CountersOrig)
+	assert.Equal(t, conntrack.Helper{Name: "ftp"}, cf.Helper)
+	assert.Equal(t, f.TupleReply, cf.TupleReply)
+	assert.Equal(t, conntrack.NATRange{MinIP: net.IPv4(192, 0, 2, 1).To4(), MinPort: 4000}, cf.NATSrc)
+	assert.Zero(t, cf.NATDst)
+
+	uf := updateFlow(f)
+	assert.Zero(t, uf.TupleReply)
+	assert.Zero(t, uf.Helper)
+	assert.Zero(t, uf.NATSrc)
+
+	assert.Equal(t, conntrack.Flow{TupleOrig: f.TupleOrig}, deleteFlow(f))
+}
@@ -0,0 +1,218 @@
+// Package ctsync synchronizes the Conntrack tables of two machines, like conntrackd
+// does for active-passive firewall pairs. The active machine sends the Events of
+// its table to the passive one using a Sender, which applies them to its own table
+// using a Receiver. After a failover, the connections established through the
+// previously active machine are known to the new one and are not dropped.
+//
+// Events are sent over any transport: TCP, UDP or multicast UDP. Over datagram
+// transports, lost Events are not retransmitted. Call Sender.Resync periodically,
+// or when the peer (re)connects, to send the whole table and fix up any missed Events.
+//
+// # Wire format
+//
+// The wire format is native to this package and is not compatible with conntrackd.
+// A stream or datagram carries a sequence of frames, each consisting of an 8-byte
+// header and an Event encoded as a Protocol Buffers message by the conntrackpb package:
+//
+//	0      2         3       4                8
+//	+------+---------+-------+----------------+--------------------+
+//	| "ct" | version | flags | length         | conntrackpb Event  |
+//	+------+---------+-------+----------------+--------------------+
+//
+// The version is 1 and flags are 0. The length is the length of the Event message
+// in bytes, as a big-endian uint32. Frames are never split across datagrams.
+package ctsync
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/conntrack/conntrackpb"
+)
+
+const (
+	frameVersion   = 1
+	frameHeaderLen = 8
+
+	// maxFrameLen bounds the length of a frame's Event. Events are at most a few
+	// hundred bytes long, longer frames are the result of a corrupted stream.
+	maxFrameLen = 64 * 1024
+
+	// DatagramSize is a size for Senders on datagram transports, which keeps
+	// datagrams within the payload of a single packet on a link with a regular MTU.
+	DatagramSize = 1400
+)
+
+var frameMagic = [2]byte{'c', 't'}
+
+var (
+	errFrameHeader = errors.New("ctsync: invalid frame header, not a ctsync stream or unsupported version")
+	errFrameLength = errors.New("ctsync: frame length exceeds maximum")
+	errFrameShort  = errors.New("ctsync: datagram ends in the middle of a frame")
+)
+
+// appendFrame appends ev to b as a frame.
+func appendFrame(b []byte, ev conntrack.Event) []byte {
+
+	msg := conntrackpb.MarshalEvent(ev)
+
+	b = append(b, frameMagic[0], frameMagic[1], frameVersion, 0)
+	b = binary.BigEndian.AppendUint32(b, uint32(len(msg)))
+
+	return append(b, msg...)
+}
+
+// frameLength validates the frame header h and returns the length of its Event.
+func frameLength(h []byte) (int, error) {
+
+	if h[0] != frameMagic[0] || h[1] != frameMagic[1] || h[2] != frameVersion {
+		return 0, errFrameHeader
+	}
+
+	n := binary.BigEndian.Uint32(h[4:8])
+	if n > maxFrameLen {
+		return 0, errFrameLength
+	}
+
+	return int(n), nil
+}
+
+// splitFrames decodes all frames in a datagram.
+func splitFrames(b []byte) ([]conntrack.Event, error) {
+
+	var evs []conntrack.Event
+
+	for len(b) != 0 {
+		if len(b) < frameHeaderLen {
+			return nil, errFrameShort
+		}
+
+		n, err := frameLength(b)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < frameHeaderLen+n {
+			return nil, errFrameShort
+		}
+
+		ev, err := conntrackpb.UnmarshalEvent(b[frameHeaderLen : frameHeaderLen+n])
+		if err != nil {
+			return nil, err
+		}
+		evs = append(evs, ev)
+
+		b = b[frameHeaderLen+n:]
+	}
+
+	return evs, nil
+}
+
+// A Sender sends Events about Flows to a Receiver. It is safe for concurrent use.
+type Sender struct {
+	w    io.Writer
+	size int
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+// NewSender returns a Sender writing frames to w. Frames are buffered and passed to w
+// in a single Write call of at most size bytes, unless a single frame is longer. Over
+// datagram transports, use DatagramSize or the payload size of the path to the peer.
+// A size of 0 writes every frame as soon as it is sent.
+func NewSender(w io.Writer, size int) *Sender {
+	return &Sender{w: w, size: size}
+}
+
+// Send sends ev. Events without Flow, like those about expectations, are ignored.
+// The frame is buffered until the buffer is full or Flush is called.
+func (s *Sender) Send(ev conntrack.Event) error {
+
+	if ev.Flow == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.buf)
+	s.buf = appendFrame(s.buf, ev)
+
+	// Write out the frames buffered before ev if ev doesn't fit with them.
+	if n != 0 && len(s.buf) > s.size {
+		if err := s.write(s.buf[:n]); err != nil {
+			s.buf = s.buf[:0]
+			return err
+		}
+		s.buf = append(s.buf[:0], s.buf[n:]...)
+	}
+
+	if len(s.buf) >= s.size {
+		return s.flush()
+	}
+
+	return nil
+}
+
+// Flush writes all buffered frames to the Sender's io.Writer.
+func (s *Sender) Flush() error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.flush()
+}
+
+func (s *Sender) flush() error {
+
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	err := s.write(s.buf)
+	s.buf = s.buf[:0]
+
+	return err
+}
+
+func (s *Sender) write(b []byte) error {
+	_, err := s.w.Write(b)
+	return err
+}
+
+// Run sends the Events received from in, flushing the buffer whenever no more
+// Events are waiting in in. Returns nil when in is closed, or the first error
+// writing to the Sender's io.Writer.
+func (s *Sender) Run(in <-chan conntrack.Event) error {
+
+	for ev := range in {
+		if err := s.Send(ev); err != nil {
+			return err
+		}
+
+		if len(in) == 0 {
+			if err := s.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return s.Flush()
+}
+
+// Resync sends an EventUpdate for all flows, for example the result of Conn.Dump,
+// and flushes the buffer. The Receiver creates the Flows it doesn't know about yet.
+// Flows the peer knows about but are missing from flows are not removed.
+func (s *Sender) Resync(flows []conntrack.Flow) error {
+
+	for i := range flows {
+		if err := s.Send(conntrack.Event{Type: conntrack.EventUpdate, Flow: &flows[i]}); err != nil {
+			return err
+		}
+	}
+
+	return s.Flush()
+}
@@ -0,0 +1,145 @@
+package ctsync
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntrack"
+)
+
+func testFlow(sport uint16) conntrack.Flow {
+	return conntrack.NewFlow(6, conntrack.StatusAssured, net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4(), sport, 80, 120, 0)
+}
+
+// writes records the buffers passed to Write.
+type writes [][]byte
+
+func (w *writes) Write(b []byte) (int, error) {
+	*w = append(*w, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+
+	f1, f2 := testFlow(1), testFlow(2)
+	evs := []conntrack.Event{
+		{Type: conntrack.EventNew, Flow: &f1},
+		{Type: conntrack.EventDestroy, Flow: &f2},
+	}
+
+	var b []byte
+	for _, ev := range evs {
+		b = appendFrame(b, ev)
+	}
+	assert.Equal(t, []byte{'c', 't', frameVersion, 0}, b[:4])
+
+	got, err := splitFrames(b)
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, conntrack.EventNew, got[0].Type)
+	assert.Equal(t, f1.TupleOrig.Proto, got[0].Flow.TupleOrig.Proto)
+	assert.Equal(t, conntrack.EventDestroy, got[1].Type)
+	assert.Equal(t, f2.TupleOrig.Proto, got[1].Flow.TupleOrig.Proto)
+
+	_, err = splitFrames(b[:len(b)-1])
+	assert.Equal(t, errFrameShort, err)
+
+	_, err = splitFrames(b[:3])
+	assert.Equal(t, errFrameShort, err)
+
+	bad := append([]byte(nil), b...)
+	bad[2] = frameVersion + 1
+	_, err = splitFrames(bad)
+	assert.Equal(t, errFrameHeader, err)
+
+	long := appendFrame(nil, evs[0])
+	long[4] = 0xff
+	_, err = splitFrames(long)
+	assert.Equal(t, errFrameLength, err)
+}
+
+func TestSenderBuffer(t *testing.T) {
+
+	f := testFlow(1)
+	ev := conntrack.Event{Type: conntrack.EventNew, Flow: &f}
+	frame := appendFrame(nil, ev)
+
+	// Two frames fit in a Write, a third one doesn't.
+	var w writes
+	s := NewSender(&w, 2*len(frame)+len(frame)/2)
+
+	require.NoError(t, s.Send(ev))
+	require.NoError(t, s.Send(ev))
+	assert.Empty(t, w)
+
+	require.NoError(t, s.Send(ev))
+	require.Len(t, w, 1)
+	assert.Len(t, w[0], 2*len(frame))
+
+	// Events without Flow are ignored.
+	require.NoError(t, s.Send(conntrack.Event{Type: conntrack.EventExpNew, Expect: &conntrack.Expect{}}))
+
+	require.NoError(t, s.Flush())
+	require.Len(t, w, 2)
+	assert.Equal(t, frame, w[1])
+
+	require.NoError(t, s.Flush())
+	assert.Len(t, w, 2)
+
+	// Frames longer than the size are written on their own.
+	w = nil
+	s = NewSender(&w, 0)
+	require.NoError(t, s.Send(ev))
+	require.NoError(t, s.Send(ev))
+	assert.Equal(t, writes{frame, frame}, w)
+}
+
+type failWriter struct{}
+
+var errWrite = errors.New("write failed")
+
+func (failWriter) Write([]byte) (int, error) {
+	return 0, errWrite
+}
+
+func TestSenderRun(t *testing.T) {
+
+	f := testFlow(1)
+	in := make(chan conntrack.Event, 4)
+	for i := 0; i < 3; i++ {
+		in <- conntrack.Event{Type: conntrack.EventUpdate, Flow: &f}
+	}
+	close(in)
+
+	var w writes
+	require.NoError(t, NewSender(&w, DatagramSize).Run(in))
+
+	// All frames are written at once since the channel was full.
+	require.Len(t, w, 1)
+	evs, err := splitFrames(w[0])
+	require.NoError(t, err)
+	assert.Len(t, evs, 3)
+
+	in = make(chan conntrack.Event, 1)
+	in <- conntrack.Event{Type: conntrack.EventUpdate, Flow: &f}
+	assert.Equal(t, errWrite, NewSender(failWriter{}, DatagramSize).Run(in))
+}
+
+func TestSenderResync(t *testing.T) {
+
+	var buf bytes.Buffer
+	require.NoError(t, NewSender(&buf, DatagramSize).Resync([]conntrack.Flow{testFlow(1), testFlow(2)}))
+
+	evs, err := splitFrames(buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, evs, 2)
+	for i, ev := range evs {
+		assert.Equal(t, conntrack.EventUpdate, ev.Type)
+		assert.Equal(t, uint16(i+1), ev.Flow.TupleOrig.Proto.SourcePort)
+	}
+}
@@ -0,0 +1,120 @@
+package conntrack
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// aLongTimeAgo is a deadline in the past, set on the socket to interrupt
+// a pending read or write.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// SetReadTimeout limits the time a query waits for the kernel's reply, like a
+// Dump waiting for the Conntrack table or a Create waiting for its acknowledgement.
+// The timeout starts counting when the query is made. Zero disables the timeout.
+//
+// A query that timed out returns an error wrapping os.ErrDeadlineExceeded. The
+// kernel may still reply to it later on, so the Conn can no longer be used for
+// queries; close it and dial a new one. The timeout does not apply to Listen.
+//
+// Queries made concurrently over the same Conn are sent one after the other, each
+// with its own deadlines, so a query may wait for the timeouts of others.
+func (c *Conn) SetReadTimeout(d time.Duration) {
+	atomic.StoreInt64(&c.readTimeout, int64(d))
+}
+
+// SetWriteTimeout limits the time a query waits for its request to be sent,
+// like SetReadTimeout. Zero disables the timeout.
+func (c *Conn) SetWriteTimeout(d time.Duration) {
+	atomic.StoreInt64(&c.writeTimeout, int64(d))
+}
+
+// deadlines sets the read and write deadlines of the Conn's socket for a single
+// query, based on its timeouts and the deadline of ctx. When ctx is done before
+// the query finishes, the deadlines are moved into the past to interrupt it.
+// The returned function clears the deadlines and must be called after the query.
+// Must be called with c.queryMu held.
+func (c *Conn) deadlines(ctx context.Context) (func(), error) {
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	rt := time.Duration(atomic.LoadInt64(&c.readTimeout))
+	wt := time.Duration(atomic.LoadInt64(&c.writeTimeout))
+	cd, hasDeadline := ctx.Deadline()
+
+	if rt == 0 && wt == 0 && ctx.Done() == nil {
+		return func() {}, nil
+	}
+
+	now := time.Now()
+	deadline := func(timeout time.Duration) time.Time {
+		var t time.Time
+		if timeout > 0 {
+			t = now.Add(timeout)
+		}
+		if hasDeadline && (t.IsZero() || cd.Before(t)) {
+			t = cd
+		}
+		return t
+	}
+
+	if err := c.conn.SetReadDeadline(deadline(rt)); err != nil {
+		return nil, err
+	}
+	if err := c.conn.SetWriteDeadline(deadline(wt)); err != nil {
+		return nil, err
+	}
+
+	if ctx.Done() == nil {
+		return c.clearDeadlines, nil
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			_ = c.conn.SetDeadline(aLongTimeAgo)
+		case <-stop:
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+		c.clearDeadlines()
+	}, nil
+}
+
+// contextError returns ctx's error if err was caused by ctx being done. The socket
+// deadline derived from ctx's deadline may expire before ctx reports it.
+func contextError(ctx context.Context, err error) error {
+
+	if cerr := ctx.Err(); cerr != nil {
+		return cerr
+	}
+
+	if cd, ok := ctx.Deadline(); ok && errors.Is(err, os.ErrDeadlineExceeded) && !time.Now().Before(cd) {
+		return context.DeadlineExceeded
+	}
+
+	return err
+}
+
+// clearDeadlines removes the deadlines set on the Conn's socket by deadlines.
+func (c *Conn) clearDeadlines() {
+	_ = c.conn.SetDeadline(time.Time{})
+}
+
+// interrupt makes all pending and future reads from the Conn's socket fail, stopping
+// the workers of Listen and Serve. Closing the socket doesn't interrupt pending reads.
+func (c *Conn) interrupt() {
+	_ = c.conn.SetReadDeadline(aLongTimeAgo)
+}
@@ -0,0 +1,140 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+// noReply returns a message the kernel doesn't reply to, since it is not
+// flagged as a request and doesn't ask for an acknowledgement.
+func noReply(t *testing.T) netlink.Message {
+	req, err := netfilter.MarshalNetlink(
+		netfilter.Header{
+			SubsystemID: netfilter.NFSubsysCTNetlink,
+			MessageType: netfilter.MessageType(ctGetStats),
+			Family:      ProtoUnspec,
+		}, nil)
+	require.NoError(t, err)
+
+	return req
+}
+
+func TestConnReadTimeout(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.SetReadTimeout(time.Second)
+	_, err = c.Dump()
+	require.NoError(t, err, "dump within timeout")
+
+	c.SetReadTimeout(10 * time.Millisecond)
+	_, err = c.query(noReply(t))
+	assert.True(t, errors.Is(err, os.ErrDeadlineExceeded), "unexpected error: %v", err)
+}
+
+func TestConnQueryContext(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	_, err = c.queryContext(ctx, noReply(t))
+	assert.Equal(t, context.Canceled, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, err = c.queryContext(ctx, noReply(t))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestConnQueryConcurrentDeadlines(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0)
+	require.NoError(t, c.Create(f))
+
+	// A query waiting for a reply until its deadline.
+	timedOut := make(chan error)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		_, err := c.queryContext(ctx, noReply(t))
+		timedOut <- err
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+
+	// A concurrent query without deadline must not clear the deadline of the
+	// pending query, which would then wait forever and block this one.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	got := make(chan error)
+	go func() {
+		_, err := c.GetContext(ctx, f)
+		got <- err
+	}()
+
+	select {
+	case err := <-timedOut:
+		assert.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("pending query's deadline was cleared by a concurrent query")
+	}
+
+	assert.NoError(t, <-got)
+}
+
+func TestConnContextMethods(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, c.CreateContext(ctx, f))
+	assert.Equal(t, context.Canceled, c.UpdateContext(ctx, f))
+	assert.Equal(t, context.Canceled, c.DeleteContext(ctx, f))
+	_, err = c.GetContext(ctx, f)
+	assert.Equal(t, context.Canceled, err)
+	_, err = c.DumpContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	ctx = context.Background()
+
+	require.NoError(t, c.CreateContext(ctx, f))
+	require.NoError(t, c.UpdateContext(ctx, f))
+	_, err = c.GetContext(ctx, f)
+	require.NoError(t, err)
+	flows, err := c.DumpContext(ctx)
+	require.NoError(t, err)
+	assert.Len(t, flows, 1)
+	require.NoError(t, c.DeleteContext(ctx, f))
+}
@@ -0,0 +1,74 @@
+package conntrack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnDeadlinesContextDone(t *testing.T) {
+
+	c, err := Dial(nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, c.DumpPages(ctx, 1, func([]Flow) error { return nil }))
+
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+
+	assert.Equal(t, context.DeadlineExceeded, c.DumpPages(ctx, 1, func([]Flow) error { return nil }))
+}
+
+func TestConnSetTimeouts(t *testing.T) {
+
+	c, err := Dial(nil)
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.SetReadTimeout(time.Second)
+	c.SetWriteTimeout(2 * time.Second)
+	assert.Equal(t, int64(time.Second), c.readTimeout)
+	assert.Equal(t, int64(2*time.Second), c.writeTimeout)
+}
+
+// pendingDeadline is a context whose deadline passed without it being done yet.
+type pendingDeadline struct {
+	context.Context
+	d time.Time
+}
+
+func (ctx pendingDeadline) Deadline() (time.Time, bool) {
+	return ctx.d, true
+}
+
+func TestContextError(t *testing.T) {
+
+	errTimeout := fmt.Errorf("recvmsg: %w", os.ErrDeadlineExceeded)
+	errOther := errors.New("no such file or directory")
+
+	assert.Equal(t, errTimeout, contextError(context.Background(), errTimeout))
+
+	// The socket deadline expired before ctx's timer fired.
+	past := pendingDeadline{context.Background(), time.Now().Add(-time.Millisecond)}
+	assert.Equal(t, context.DeadlineExceeded, contextError(past, errTimeout))
+
+	// A deadline in the future did not cause the timeout.
+	future, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	assert.Equal(t, errTimeout, contextError(future, errTimeout))
+	assert.Equal(t, errOther, contextError(future, errOther))
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, contextError(canceled, errOther))
+}
@@ -0,0 +1,221 @@
+package conntrack
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+// A DecodeErrorKey classifies Netlink messages that failed to decode, by the type
+// of the message and the top-level attribute that could not be decoded.
+type DecodeErrorKey struct {
+	// Message is the kernel's name of the message type, like IPCTNL_MSG_CT_NEW for
+	// new and updated Flows and replies to dumps, or IPCTNL_MSG_EXP_DELETE for
+	// destroyed Expects. Empty if the message's Netfilter header is invalid.
+	Message string
+
+	// Attribute is the kernel's name of the top-level attribute that failed to
+	// decode, like CTA_PROTOINFO. Empty if the failure can't be attributed to
+	// a single attribute.
+	Attribute string
+}
+
+// String returns a string representation of the DecodeErrorKey.
+func (k DecodeErrorKey) String() string {
+
+	if k.Attribute == "" {
+		return k.Message
+	}
+
+	return k.Message + "/" + k.Attribute
+}
+
+// Kernel names of the message types and top-level attributes of the Conntrack
+// and Conntrack expectation subsystems, indexed by their values.
+var (
+	messageNames = []string{
+		ctNew:    "IPCTNL_MSG_CT_NEW",
+		ctGet:    "IPCTNL_MSG_CT_GET",
+		ctDelete: "IPCTNL_MSG_CT_DELETE",
+	}
+	expMessageNames = []string{
+		ctExpNew:    "IPCTNL_MSG_EXP_NEW",
+		ctExpGet:    "IPCTNL_MSG_EXP_GET",
+		ctExpDelete: "IPCTNL_MSG_EXP_DELETE",
+	}
+	attributeNames = []string{
+		ctaUnspec:        "CTA_UNSPEC",
+		ctaTupleOrig:     "CTA_TUPLE_ORIG",
+		ctaTupleReply:    "CTA_TUPLE_REPLY",
+		ctaStatus:        "CTA_STATUS",
+		ctaProtoInfo:     "CTA_PROTOINFO",
+		ctaHelp:          "CTA_HELP",
+		ctaNatSrc:        "CTA_NAT_SRC",
+		ctaTimeout:       "CTA_TIMEOUT",
+		ctaMark:          "CTA_MARK",
+		ctaCountersOrig:  "CTA_COUNTERS_ORIG",
+		ctaCountersReply: "CTA_COUNTERS_REPLY",
+		ctaUse:           "CTA_USE",
+		ctaID:            "CTA_ID",
+		ctaNatDst:        "CTA_NAT_DST",
+		ctaTupleMaster:   "CTA_TUPLE_MASTER",
+		ctaSeqAdjOrig:    "CTA_SEQ_ADJ_ORIG",
+		ctaSeqAdjReply:   "CTA_SEQ_ADJ_REPLY",
+		ctaSecMark:       "CTA_SECMARK",
+		ctaZone:          "CTA_ZONE",
+		ctaSecCtx:        "CTA_SECCTX",
+		ctaTimestamp:     "CTA_TIMESTAMP",
+		ctaMarkMask:      "CTA_MARK_MASK",
+		ctaLabels:        "CTA_LABELS",
+		ctaLabelsMask:    "CTA_LABELS_MASK",
+		ctaSynProxy:      "CTA_SYNPROXY",
+	}
+	expectNames = []string{
+		ctaExpectUnspec:   "CTA_EXPECT_UNSPEC",
+		ctaExpectMaster:   "CTA_EXPECT_MASTER",
+		ctaExpectTuple:    "CTA_EXPECT_TUPLE",
+		ctaExpectMask:     "CTA_EXPECT_MASK",
+		ctaExpectTimeout:  "CTA_EXPECT_TIMEOUT",
+		ctaExpectID:       "CTA_EXPECT_ID",
+		ctaExpectHelpName: "CTA_EXPECT_HELP_NAME",
+		ctaExpectZone:     "CTA_EXPECT_ZONE",
+		ctaExpectFlags:    "CTA_EXPECT_FLAGS",
+		ctaExpectClass:    "CTA_EXPECT_CLASS",
+		ctaExpectNAT:      "CTA_EXPECT_NAT",
+		ctaExpectFN:       "CTA_EXPECT_FN",
+	}
+)
+
+// lookupName returns names[i], or a numeric name for values unknown to this package.
+func lookupName(names []string, i int, kind string) string {
+
+	if i < len(names) {
+		return names[i]
+	}
+
+	return fmt.Sprintf("unknown %s %d", kind, i)
+}
+
+// nfHeaderLen is the length of the Netfilter header preceding the attributes
+// of a message.
+const nfHeaderLen = 4
+
+// classifyDecodeError returns the DecodeErrorKey of a message that failed to decode.
+// The failing attribute is found by decoding each top-level attribute on its own.
+func classifyDecodeError(nlm netlink.Message) DecodeErrorKey {
+
+	h, _, err := netfilter.DecodeNetlink(nlm)
+	if err != nil {
+		return DecodeErrorKey{}
+	}
+
+	var k DecodeErrorKey
+	var decode func(ad *netlink.AttributeDecoder) error
+	var attrs []string
+
+	switch h.SubsystemID {
+	case netfilter.NFSubsysCTNetlink:
+		k.Message = lookupName(messageNames, int(h.MessageType), "message type")
+		decode = func(ad *netlink.AttributeDecoder) error { return new(Flow).unmarshal(ad) }
+		attrs = attributeNames
+	case netfilter.NFSubsysCTNetlinkExp:
+		k.Message = lookupName(expMessageNames, int(h.MessageType), "message type")
+		decode = func(ad *netlink.AttributeDecoder) error { return new(Expect).unmarshal(ad) }
+		attrs = expectNames
+	default:
+		k.Message = fmt.Sprintf("unknown subsystem %d message type %d", h.SubsystemID, h.MessageType)
+		return k
+	}
+
+	b := nlm.Data[nfHeaderLen:]
+	for len(b) >= 4 {
+		l := int(nlenc.Uint16(b[0:2]))
+		if l < 4 || l > len(b) {
+			return k
+		}
+
+		ad, err := netfilter.NewAttributeDecoder(b[:l])
+		if err != nil || decode(ad) != nil {
+			// Strip the nested and byte order flags from the type.
+			k.Attribute = lookupName(attrs, int(nlenc.Uint16(b[2:4])&0x3fff), "attribute")
+			return k
+		}
+
+		b = b[min(nlmsgAlign(l), len(b)):]
+	}
+
+	return k
+}
+
+// nlmsgAlign rounds n up to a multiple of 4, the alignment of Netlink attributes.
+func nlmsgAlign(n int) int {
+	return (n + 3) &^ 3
+}
+
+// decodeErrorStats aggregates the messages a Conn failed to decode.
+type decodeErrorStats struct {
+	mu     sync.Mutex
+	counts map[DecodeErrorKey]uint64
+
+	// capture enables keeping a copy of the last failing message.
+	capture bool
+	last    []byte
+	lastErr error
+}
+
+// add accounts for nlm failing to decode with err.
+func (s *decodeErrorStats) add(nlm netlink.Message, err error) {
+
+	k := classifyDecodeError(nlm)
+
+	var b []byte
+	if s.capture {
+		// Fix up the length of messages that weren't received from a socket.
+		nlm.Header.Length = uint32(nlmsgHeaderLen + len(nlm.Data))
+		b, _ = nlm.MarshalBinary()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.counts == nil {
+		s.counts = make(map[DecodeErrorKey]uint64)
+	}
+	s.counts[k]++
+
+	if s.capture {
+		s.last, s.lastErr = b, err
+	}
+}
+
+// DecodeErrorStats returns the amount of messages that failed to decode since
+// the Conn was dialed, by type of message and failing attribute. Their sum is
+// ConnStats.DecodeErrors. Use WithLenientDecoding to keep decoding Events and
+// dumps in spite of these failures.
+func (c *Conn) DecodeErrorStats() map[DecodeErrorKey]uint64 {
+
+	c.decodeErrors.mu.Lock()
+	defer c.decodeErrors.mu.Unlock()
+
+	out := make(map[DecodeErrorKey]uint64, len(c.decodeErrors.counts))
+	for k, n := range c.decodeErrors.counts {
+		out[k] = n
+	}
+
+	return out
+}
+
+// LastDecodeError returns the last message that failed to decode in Netlink wire
+// format, including its Netlink header, along with the error decoding it. Returns
+// nil if no message failed to decode, or if the Conn was not dialed
+// WithDecodeErrorCapture.
+func (c *Conn) LastDecodeError() ([]byte, error) {
+
+	c.decodeErrors.mu.Lock()
+	defer c.decodeErrors.mu.Unlock()
+
+	return c.decodeErrors.last, c.decodeErrors.lastErr
+}
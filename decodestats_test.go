@@ -0,0 +1,140 @@
+package conntrack
+
+import (
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+func TestClassifyDecodeError(t *testing.T) {
+
+	f := NewFlow(6, 0, net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2), 1234, 80, 120, 0)
+	attrs, err := f.marshal()
+	require.NoError(t, err)
+
+	// A valid Flow followed by a non-nested CTA_PROTOINFO.
+	attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaProtoInfo), Data: []byte{1, 2, 3, 4}})
+	nlm, err := netfilter.MarshalNetlink(netfilter.Header{
+		SubsystemID: netfilter.NFSubsysCTNetlink,
+		MessageType: netfilter.MessageType(ctDelete),
+	}, attrs)
+	require.NoError(t, err)
+
+	var ev Event
+	require.Error(t, ev.unmarshal(nlm))
+
+	exp, err := netfilter.MarshalNetlink(netfilter.Header{
+		SubsystemID: netfilter.NFSubsysCTNetlinkExp,
+		MessageType: netfilter.MessageType(ctExpNew),
+	}, []netfilter.Attribute{{Type: uint16(ctaExpectTuple), Data: []byte{1, 2, 3, 4}}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		nlm  netlink.Message
+		key  DecodeErrorKey
+		str  string
+	}{
+		{
+			name: "tuple",
+			nlm:  badFlowMessage,
+			key:  DecodeErrorKey{Message: "IPCTNL_MSG_CT_NEW", Attribute: "CTA_TUPLE_ORIG"},
+			str:  "IPCTNL_MSG_CT_NEW/CTA_TUPLE_ORIG",
+		},
+		{
+			name: "protoinfo after valid attributes",
+			nlm:  nlm,
+			key:  DecodeErrorKey{Message: "IPCTNL_MSG_CT_DELETE", Attribute: "CTA_PROTOINFO"},
+		},
+		{
+			name: "expect",
+			nlm:  exp,
+			key:  DecodeErrorKey{Message: "IPCTNL_MSG_EXP_NEW", Attribute: "CTA_EXPECT_TUPLE"},
+		},
+		{
+			name: "unknown message type, nested attribute",
+			nlm: netlink.Message{
+				Header: netlink.Header{Type: netlink.HeaderType(netfilter.NFSubsysCTNetlink)<<8 | 9},
+				Data:   []byte{1, 2, 3, 4, 8, 0, 1, 0x80, 1, 2, 3, 4},
+			},
+			key: DecodeErrorKey{Message: "unknown message type 9", Attribute: "CTA_TUPLE_ORIG"},
+		},
+		{
+			name: "unknown subsystem",
+			nlm: netlink.Message{
+				Header: netlink.Header{Type: netlink.HeaderType(netfilter.NFSubsysQueue) << 8},
+				Data:   []byte{1, 2, 3, 4},
+			},
+			key: DecodeErrorKey{Message: "unknown subsystem 3 message type 0"},
+			str: "unknown subsystem 3 message type 0",
+		},
+		{
+			name: "truncated attribute",
+			nlm: netlink.Message{
+				Header: netlink.Header{Type: netlink.HeaderType(netfilter.NFSubsysCTNetlink) << 8},
+				Data:   []byte{1, 2, 3, 4, 8, 0, 1, 0},
+			},
+			key: DecodeErrorKey{Message: "IPCTNL_MSG_CT_NEW"},
+		},
+		{
+			name: "invalid header",
+			nlm:  netlink.Message{Data: []byte{1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k := classifyDecodeError(tt.nlm)
+			assert.Equal(t, tt.key, k)
+			if tt.str != "" {
+				assert.Equal(t, tt.str, k.String())
+			}
+		})
+	}
+
+	assert.Equal(t, "CTA_SYNPROXY", lookupName(attributeNames, int(ctaSynProxy), "attribute"))
+	assert.Equal(t, "unknown attribute 99", lookupName(attributeNames, 99, "attribute"))
+}
+
+func TestConnDecodeErrorStats(t *testing.T) {
+
+	c := Conn{logger: slog.New(discardHandler{}), lenient: true}
+
+	_, ok, err := c.decodeEvent(0, badFlowMessage)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = c.unmarshalFlows([]netlink.Message{badFlowMessage})
+	require.NoError(t, err)
+
+	key := DecodeErrorKey{Message: "IPCTNL_MSG_CT_NEW", Attribute: "CTA_TUPLE_ORIG"}
+	stats := c.DecodeErrorStats()
+	assert.Equal(t, map[DecodeErrorKey]uint64{key: 2}, stats)
+	assert.EqualValues(t, 2, c.ConnStats().DecodeErrors)
+
+	// The returned map is a copy.
+	stats[key] = 0
+	assert.EqualValues(t, 2, c.DecodeErrorStats()[key])
+
+	b, err := c.LastDecodeError()
+	assert.Nil(t, b)
+	assert.NoError(t, err)
+
+	WithDecodeErrorCapture()(&c)
+
+	_, _, err = c.decodeEvent(0, badFlowMessage)
+	require.NoError(t, err)
+
+	b, err = c.LastDecodeError()
+	assert.EqualError(t, err, "Tuple unmarshal: need a Nested attribute to decode this structure")
+
+	var m netlink.Message
+	require.NoError(t, m.UnmarshalBinary(b))
+	assert.Equal(t, badFlowMessage.Data, m.Data)
+	assert.Equal(t, badFlowMessage.Header.Type, m.Header.Type)
+}
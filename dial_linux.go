@@ -0,0 +1,40 @@
+package conntrack
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/mdlayher/netlink"
+)
+
+// Netlink socket options missing from package syscall. NETLINK_GET_STRICT_CHK
+// is available since Linux 4.20.
+const (
+	solNetlink          = 270
+	netlinkGetStrictChk = 12
+)
+
+// dialNetlink opens the Netfilter Netlink socket underlying a Conn or Pipeline.
+func dialNetlink(config *netlink.Config) (*netlink.Conn, error) {
+	return netlink.Dial(syscall.NETLINK_NETFILTER, config)
+}
+
+// recvfrom reads a single datagram from the socket fd into b.
+func recvfrom(fd uintptr, b []byte) (int, error) {
+	n, _, err := syscall.Recvfrom(int(fd), b, 0)
+	return n, err
+}
+
+// setStrictCheck enables strict checking of requests sent over the socket rc.
+func setStrictCheck(rc syscall.RawConn) error {
+
+	var serr error
+	err := rc.Control(func(fd uintptr) {
+		serr = syscall.SetsockoptInt(int(fd), solNetlink, netlinkGetStrictChk, 1)
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.NewSyscallError("setsockopt", serr)
+}
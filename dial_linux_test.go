@@ -0,0 +1,27 @@
+package conntrack
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialStrictCheck(t *testing.T) {
+
+	c, err := Dial(nil, WithStrictCheck())
+	require.NoError(t, err)
+	defer c.Close()
+
+	rc, err := c.conn.SyscallConn()
+	require.NoError(t, err)
+
+	var v int
+	var serr error
+	require.NoError(t, rc.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), solNetlink, netlinkGetStrictChk)
+	}))
+	require.NoError(t, serr)
+	assert.Equal(t, 1, v)
+}
@@ -0,0 +1,24 @@
+//go:build !linux
+
+package conntrack
+
+import (
+	"syscall"
+
+	"github.com/mdlayher/netlink"
+)
+
+// dialNetlink always fails on platforms other than Linux, since Conntrack is a
+// Linux kernel subsystem. Packages importing conntrack can still be built and
+// unit tested on other platforms.
+func dialNetlink(*netlink.Config) (*netlink.Conn, error) {
+	return nil, ErrNotImplemented
+}
+
+func recvfrom(uintptr, []byte) (int, error) {
+	return 0, ErrNotImplemented
+}
+
+func setStrictCheck(syscall.RawConn) error {
+	return ErrNotImplemented
+}
@@ -0,0 +1,168 @@
+package conntrack
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+)
+
+// FlowField is a bitfield identifying mutable properties of a Flow.
+type FlowField uint32
+
+// Properties of a Flow that can change over its lifetime.
+const (
+	FieldTimeout FlowField = 1 << iota
+	FieldStatus
+	FieldProtoInfo
+	FieldHelper
+	FieldMark
+	FieldLabels
+	FieldCountersOrig
+	FieldCountersReply
+	FieldSeqAdj
+	FieldSynProxy
+
+	FieldCounters = FieldCountersOrig | FieldCountersReply
+
+	// FieldsEvent are the fields that cause the kernel to emit an update event when changed.
+	FieldsEvent = FieldStatus | FieldProtoInfo | FieldHelper | FieldMark | FieldLabels | FieldSeqAdj | FieldSynProxy
+)
+
+var flowFieldNames = []string{
+	"TIMEOUT",
+	"STATUS",
+	"PROTOINFO",
+	"HELPER",
+	"MARK",
+	"LABELS",
+	"COUNTERS_ORIG",
+	"COUNTERS_REPLY",
+	"SEQADJ",
+	"SYNPROXY",
+}
+
+func (ff FlowField) String() string {
+
+	var names []string
+
+	for i, name := range flowFieldNames {
+		if ff&(1<<uint32(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "NONE"
+	}
+
+	return strings.Join(names, "|")
+}
+
+// A FlowChange describes a Flow that is present in two snapshots of the
+// Conntrack table, but has different properties in each of them.
+type FlowChange struct {
+	Old, New Flow
+
+	// Fields holds the properties that differ between Old and New.
+	Fields FlowField
+}
+
+// A FlowDiff holds the differences between two snapshots of the Conntrack table.
+type FlowDiff struct {
+	Created, Destroyed []Flow
+	Changed            []FlowChange
+}
+
+// Diff compares two snapshots of the Conntrack table, usually the results of
+// consecutive dumps, and returns the Flows that were created, destroyed or changed
+// in between them.
+//
+// Flows are matched on their Key, which includes their tuples and zone. This also
+// matches snapshots in which the same connection was observed from opposite
+// directions. Matched Flows that both carry a non-zero ID must have equal IDs, or
+// the old Flow is considered destroyed and the new one created in its place,
+// reusing the same tuple.
+//
+// Created and Changed are ordered like new, Destroyed is ordered like old.
+func Diff(old, new []Flow) FlowDiff {
+
+	byKey := make(map[FlowKey]int, len(old))
+	for i := range old {
+		byKey[old[i].Key()] = i
+	}
+
+	matched := make([]bool, len(old))
+
+	var d FlowDiff
+
+	for _, f := range new {
+
+		i, ok := byKey[f.Key()]
+		if !ok || matched[i] {
+			d.Created = append(d.Created, f)
+			continue
+		}
+
+		o := old[i]
+
+		if o.ID != 0 && f.ID != 0 && o.ID != f.ID {
+			// The tuple was reused by a new connection, the old Flow
+			// is reported when collecting the unmatched Flows below.
+			d.Created = append(d.Created, f)
+			continue
+		}
+
+		matched[i] = true
+
+		if ff := changedFields(o, f); ff != 0 {
+			d.Changed = append(d.Changed, FlowChange{Old: o, New: f, Fields: ff})
+		}
+	}
+
+	for i, o := range old {
+		if !matched[i] {
+			d.Destroyed = append(d.Destroyed, o)
+		}
+	}
+
+	return d
+}
+
+// changedFields returns the mutable properties that differ between two Flows.
+func changedFields(a, b Flow) FlowField {
+
+	var ff FlowField
+
+	if a.Timeout != b.Timeout {
+		ff |= FieldTimeout
+	}
+	if a.Status != b.Status {
+		ff |= FieldStatus
+	}
+	if !reflect.DeepEqual(a.ProtoInfo, b.ProtoInfo) {
+		ff |= FieldProtoInfo
+	}
+	if a.Helper.Name != b.Helper.Name || !bytes.Equal(a.Helper.Info, b.Helper.Info) {
+		ff |= FieldHelper
+	}
+	if a.Mark != b.Mark {
+		ff |= FieldMark
+	}
+	if !bytes.Equal(a.Labels, b.Labels) {
+		ff |= FieldLabels
+	}
+	if a.CountersOrig != b.CountersOrig {
+		ff |= FieldCountersOrig
+	}
+	if a.CountersReply != b.CountersReply {
+		ff |= FieldCountersReply
+	}
+	if a.SeqAdjOrig != b.SeqAdjOrig || a.SeqAdjReply != b.SeqAdjReply {
+		ff |= FieldSeqAdj
+	}
+	if a.SynProxy != b.SynProxy {
+		ff |= FieldSynProxy
+	}
+
+	return ff
+}
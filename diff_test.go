@@ -0,0 +1,98 @@
+package conntrack
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiff(t *testing.T) {
+
+	a := NewFlow(6, StatusConfirmed, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0)
+	a.ID = 1
+
+	b := NewFlow(17, 0, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 53, 53, 30, 0)
+
+	c := NewFlow(6, 0, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), 4321, 443, 120, 0)
+	c.ID = 3
+
+	// a with updated state and counters.
+	a2 := a
+	a2.Status.Value |= StatusAssured | StatusSeenReply
+	a2.Timeout = 100
+	a2.CountersOrig = Counter{Packets: 2, Bytes: 120}
+	a2.ProtoInfo = ProtoInfo{TCP: &ProtoInfoTCP{State: 3}}
+
+	// b observed from the reply direction, unchanged.
+	b2 := b
+	b2.TupleOrig, b2.TupleReply = b.TupleReply, b.TupleOrig
+
+	// c's tuple reused by another connection.
+	c2 := c
+	c2.ID = 4
+
+	// A Flow in a different zone is distinct from a.
+	z := a
+	z.TupleOrig.Zone = 1
+	z.TupleReply.Zone = 1
+
+	// A Flow with the same tuples as a in a different conntrack zone.
+	fz := a
+	fz.Zone = 2
+
+	d := Diff([]Flow{a, b, c}, []Flow{c2, z, fz, b2, a2})
+
+	want := FlowDiff{
+		Created:   []Flow{c2, z, fz},
+		Destroyed: []Flow{c},
+		Changed: []FlowChange{
+			{
+				Old: a, New: a2,
+				Fields: FieldTimeout | FieldStatus | FieldProtoInfo | FieldCountersOrig,
+			},
+		},
+	}
+
+	if diff := cmp.Diff(want, d); diff != "" {
+		t.Fatalf("unexpected Diff (-want +got):\n%s", diff)
+	}
+
+	assert.Equal(t, FlowDiff{Created: []Flow{a}}, Diff(nil, []Flow{a}))
+	assert.Equal(t, FlowDiff{Destroyed: []Flow{a}}, Diff([]Flow{a}, nil))
+	assert.Equal(t, FlowDiff{}, Diff([]Flow{a, b}, []Flow{b, a}))
+
+	// Same-tuple Flows in different zones are matched to their own zone's Flow.
+	assert.Equal(t, FlowDiff{}, Diff([]Flow{a, fz}, []Flow{fz, a}))
+	assert.Equal(t, FlowDiff{Destroyed: []Flow{fz}}, Diff([]Flow{a, fz}, []Flow{a}))
+}
+
+func TestChangedFields(t *testing.T) {
+
+	var a Flow
+
+	b := Flow{
+		Timeout:       1,
+		Status:        Status{Value: StatusAssured},
+		ProtoInfo:     ProtoInfo{DCCP: &ProtoInfoDCCP{}},
+		Helper:        Helper{Name: "ftp"},
+		Mark:          1,
+		Labels:        []byte{1},
+		CountersOrig:  Counter{Packets: 1},
+		CountersReply: Counter{Direction: true},
+		SeqAdjReply:   SequenceAdjust{Position: 1},
+		SynProxy:      SynProxy{ISN: 1},
+	}
+
+	ff := changedFields(a, b)
+	assert.Equal(t, FlowField(1<<len(flowFieldNames)-1), ff)
+	assert.Equal(t, FieldsEvent|FieldTimeout|FieldCounters, ff)
+	assert.Equal(t, FlowField(0), changedFields(b, b))
+}
+
+func TestFlowFieldString(t *testing.T) {
+	assert.Equal(t, "NONE", FlowField(0).String())
+	assert.Equal(t, "STATUS|MARK", (FieldStatus | FieldMark).String())
+	assert.Equal(t, "COUNTERS_ORIG|COUNTERS_REPLY", FieldCounters.String())
+}
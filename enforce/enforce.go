@@ -0,0 +1,198 @@
+// Package enforce is an extension point for taking enforcement actions on
+// connections identified through Conntrack, like an IPS dropping the packets of
+// connections flagged by another subsystem using a connmark or connlabel.
+//
+// A Hook matches Flows against an ordered list of Rules and calls a user-supplied
+// Handler for the first Rule a Flow matches. The Handler takes whatever action is
+// appropriate, like deleting the connection or logging it, and returns a Verdict.
+// Flows are fed to a Hook from Conntrack events using Watch, or one at a time
+// using Evaluate.
+//
+// # NFQUEUE
+//
+// Packets queued to userspace by the NFQUEUE target can carry the Conntrack entry
+// they belong to when the queue is configured with NFQA_CFG_F_CONNTRACK. The
+// NFQA_CT attribute holds the Flow's attributes as sent by the kernel in events,
+// which Packet decodes using Flow.UnmarshalBinary. Without that attribute, the
+// Flow is looked up using conntrack.LookupPacket. The resulting Verdict converts
+// to a netfilter verdict for the queued packet using NF:
+//
+//	// Using github.com/florianl/go-nfqueue, configured with nfqueue.NfQaCfgFlagConntrack.
+//	fn := func(a nfqueue.Attribute) int {
+//		var ct []byte
+//		if a.Ct != nil {
+//			ct = *a.Ct
+//		}
+//		v, _ := hook.Packet(conn, *a.Payload, ct)
+//		nf.SetVerdict(*a.PacketID, v.NF())
+//		return 0
+//	}
+package enforce
+
+import (
+	"context"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// A Verdict is the outcome of evaluating a Flow.
+type Verdict uint8
+
+// Verdicts returned by Handlers and Hooks. VerdictNone means no Rule matched the
+// Flow, or that the Handler doesn't want to influence the Flow's packets.
+const (
+	VerdictNone Verdict = iota
+	VerdictAccept
+	VerdictDrop
+)
+
+// Netfilter verdicts, from include/uapi/linux/netfilter.h.
+const (
+	nfDrop   = 0
+	nfAccept = 1
+)
+
+// NF returns the netfilter verdict for a packet of a Flow with Verdict v, to be
+// passed to NFQUEUE. Only VerdictDrop drops the packet.
+func (v Verdict) NF() int {
+	if v == VerdictDrop {
+		return nfDrop
+	}
+	return nfAccept
+}
+
+func (v Verdict) String() string {
+	switch v {
+	case VerdictAccept:
+		return "accept"
+	case VerdictDrop:
+		return "drop"
+	}
+	return "none"
+}
+
+// A Predicate reports whether a Flow is of interest to a Rule.
+type Predicate func(conntrack.Flow) bool
+
+// Mark matches Flows whose connmark equals mark after applying mask.
+func Mark(mark, mask uint32) Predicate {
+	return func(f conntrack.Flow) bool {
+		return f.Mark&mask == mark
+	}
+}
+
+// Label matches Flows that have the given connlabel bit set.
+// Labels are numbered like in iptables' connlabel match.
+func Label(bit uint) Predicate {
+	return func(f conntrack.Flow) bool {
+		return f.HasLabel(bit)
+	}
+}
+
+// All matches Flows matching all of ps.
+func All(ps ...Predicate) Predicate {
+	return func(f conntrack.Flow) bool {
+		for _, p := range ps {
+			if !p(f) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Any matches Flows matching any of ps.
+func Any(ps ...Predicate) Predicate {
+	return func(f conntrack.Flow) bool {
+		for _, p := range ps {
+			if p(f) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// A Rule selects the Flows a Handler acts upon.
+type Rule struct {
+	// Name identifies the Rule to the Handler.
+	Name string
+
+	Match Predicate
+}
+
+// A Handler takes an enforcement action on a Flow matching rule and returns
+// the Verdict for the Flow.
+type Handler func(rule Rule, f conntrack.Flow) Verdict
+
+// A Hook calls a Handler for Flows matching its Rules.
+type Hook struct {
+	rules   []Rule
+	handler Handler
+}
+
+// NewHook returns a Hook calling h for Flows matching any of rules. Rules are
+// evaluated in order, h is called for the first Rule a Flow matches.
+func NewHook(h Handler, rules ...Rule) *Hook {
+	return &Hook{rules: rules, handler: h}
+}
+
+// Evaluate matches f against the Hook's Rules and returns the Handler's Verdict
+// for the first matching Rule, or VerdictNone if none match.
+func (h *Hook) Evaluate(f conntrack.Flow) Verdict {
+
+	for _, r := range h.rules {
+		if r.Match(f) {
+			return h.handler(r, f)
+		}
+	}
+
+	return VerdictNone
+}
+
+// Watch evaluates the Flows of EventNew and EventUpdate Events received on evChan
+// until ctx is done or evChan is closed. Other Events, like those of expectations
+// and destroyed connections, are ignored. Returns ctx.Err() when ctx is done.
+//
+// Connlabels are only sent in events when they change, so Flows matched by a Label
+// Predicate are evaluated when the label is set on the connection.
+func (h *Hook) Watch(ctx context.Context, evChan <-chan conntrack.Event) error {
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev, ok := <-evChan:
+			if !ok {
+				return nil
+			}
+			if ev.Flow == nil || (ev.Type != conntrack.EventNew && ev.Type != conntrack.EventUpdate) {
+				continue
+			}
+			h.Evaluate(*ev.Flow)
+		}
+	}
+}
+
+// Packet evaluates the Flow a packet queued by NFQUEUE belongs to. ctAttrs holds the
+// packet's NFQA_CT attribute, if any, which is decoded into the Flow. Otherwise the
+// Flow is looked up using ct and the packet's payload, starting at its IP header.
+// Returns VerdictNone and an error if the Flow could not be determined.
+func (h *Hook) Packet(ct conntrack.Conntracker, payload, ctAttrs []byte) (Verdict, error) {
+
+	var f conntrack.Flow
+
+	if len(ctAttrs) != 0 {
+		if err := f.UnmarshalBinary(ctAttrs); err != nil {
+			return VerdictNone, err
+		}
+		return h.Evaluate(f), nil
+	}
+
+	f, _, err := conntrack.LookupPacket(ct, payload)
+	if err != nil {
+		return VerdictNone, err
+	}
+
+	return h.Evaluate(f), nil
+}
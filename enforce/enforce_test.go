@@ -0,0 +1,140 @@
+package enforce
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/conntrack/conntracktest"
+)
+
+func testFlow(sport uint16, mark uint32) conntrack.Flow {
+	return conntrack.NewFlow(6, 0, net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), sport, 80, 120, mark)
+}
+
+// tcpPacket returns an IPv4 TCP packet with the original tuple of testFlow.
+func tcpPacket(sport uint16) []byte {
+
+	b := make([]byte, 40)
+	b[0] = 0x45
+	b[9] = 6
+	copy(b[12:16], net.ParseIP("192.0.2.1").To4())
+	copy(b[16:20], net.ParseIP("192.0.2.2").To4())
+	binary.BigEndian.PutUint16(b[20:22], sport)
+	binary.BigEndian.PutUint16(b[22:24], 80)
+
+	return b
+}
+
+// recorder returns a Handler recording the Rules it is called for.
+func recorder(v Verdict) (Handler, *[]string) {
+	var names []string
+	return func(r Rule, _ conntrack.Flow) Verdict {
+		names = append(names, r.Name)
+		return v
+	}, &names
+}
+
+func TestVerdict(t *testing.T) {
+	assert.Equal(t, 0, VerdictDrop.NF())
+	assert.Equal(t, 1, VerdictAccept.NF())
+	assert.Equal(t, 1, VerdictNone.NF())
+
+	assert.Equal(t, "none", VerdictNone.String())
+	assert.Equal(t, "accept", VerdictAccept.String())
+	assert.Equal(t, "drop", VerdictDrop.String())
+}
+
+func TestPredicates(t *testing.T) {
+	f := testFlow(1234, 0xff01)
+	f.SetLabel(10)
+
+	assert.True(t, Mark(0x01, 0xff)(f))
+	assert.False(t, Mark(0x02, 0xff)(f))
+	assert.True(t, Mark(0xff00, 0xff00)(f))
+
+	assert.True(t, Label(10)(f))
+	assert.False(t, Label(9)(f))
+	assert.False(t, Label(127)(f), "label beyond the Flow's labels")
+
+	assert.True(t, All(Mark(0x01, 0xff), Label(10))(f))
+	assert.False(t, All(Mark(0x01, 0xff), Label(9))(f))
+	assert.True(t, All()(f))
+
+	assert.True(t, Any(Mark(0x02, 0xff), Label(10))(f))
+	assert.False(t, Any(Mark(0x02, 0xff), Label(9))(f))
+	assert.False(t, Any()(f))
+}
+
+func TestHookEvaluate(t *testing.T) {
+	h, names := recorder(VerdictDrop)
+	hook := NewHook(h,
+		Rule{Name: "blocked", Match: Mark(1, 0xf)},
+		Rule{Name: "all", Match: Mark(0, 0)},
+	)
+
+	assert.Equal(t, VerdictDrop, hook.Evaluate(testFlow(1, 1)))
+	assert.Equal(t, VerdictDrop, hook.Evaluate(testFlow(2, 2)))
+	assert.Equal(t, []string{"blocked", "all"}, *names)
+
+	assert.Equal(t, VerdictNone, NewHook(h).Evaluate(testFlow(1, 1)))
+}
+
+func TestHookWatch(t *testing.T) {
+	h, names := recorder(VerdictDrop)
+	hook := NewHook(h, Rule{Name: "blocked", Match: Mark(1, 1)})
+
+	f1, f2, f3 := testFlow(1, 1), testFlow(2, 0), testFlow(3, 1)
+
+	evChan := make(chan conntrack.Event, 4)
+	evChan <- conntrack.Event{Type: conntrack.EventNew, Flow: &f1}
+	evChan <- conntrack.Event{Type: conntrack.EventUpdate, Flow: &f2}
+	evChan <- conntrack.Event{Type: conntrack.EventDestroy, Flow: &f3}
+	evChan <- conntrack.Event{Type: conntrack.EventExpNew, Expect: &conntrack.Expect{}}
+	close(evChan)
+
+	require.NoError(t, hook.Watch(context.Background(), evChan))
+	assert.Equal(t, []string{"blocked"}, *names)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, hook.Watch(ctx, make(chan conntrack.Event)))
+}
+
+func TestHookPacket(t *testing.T) {
+	hook := NewHook(func(Rule, conntrack.Flow) Verdict { return VerdictDrop },
+		Rule{Name: "blocked", Match: Mark(1, 1)})
+
+	// Decode the Flow from the NFQA_CT attribute.
+	attrs, err := testFlow(1234, 1).MarshalBinary()
+	require.NoError(t, err)
+
+	v, err := hook.Packet(nil, nil, attrs)
+	require.NoError(t, err)
+	assert.Equal(t, VerdictDrop, v)
+
+	_, err = hook.Packet(nil, nil, []byte{1})
+	assert.Error(t, err)
+
+	// Look up the Flow using the packet.
+	tbl := conntracktest.NewTable()
+	require.NoError(t, tbl.Create(testFlow(1234, 1)))
+	require.NoError(t, tbl.Create(testFlow(1235, 0)))
+
+	v, err = hook.Packet(tbl, tcpPacket(1234), nil)
+	require.NoError(t, err)
+	assert.Equal(t, VerdictDrop, v)
+
+	v, err = hook.Packet(tbl, tcpPacket(1235), nil)
+	require.NoError(t, err)
+	assert.Equal(t, VerdictNone, v)
+
+	v, err = hook.Packet(tbl, tcpPacket(1236), nil)
+	assert.Error(t, err)
+	assert.Equal(t, VerdictNone, v)
+}
@@ -1,6 +1,6 @@
 package conntrack
 
-import "github.com/ti-mo/netfilter"
+import "github.com/ti-mo/conntrack/internal/netfilter"
 
 // All enums in this file are translated from the Linux kernel source at
 // include/uapi/linux/netfilter/nfnetlink_conntrack.h
@@ -50,14 +50,14 @@ const (
 	ctaStatus                             // CTA_STATUS
 	ctaProtoInfo                          // CTA_PROTOINFO
 	ctaHelp                               // CTA_HELP
-	ctaNatSrc                             // CTA_NAT_SRC, Deprecated
+	ctaNatSrc                             // CTA_NAT_SRC
 	ctaTimeout                            // CTA_TIMEOUT
 	ctaMark                               // CTA_MARK
 	ctaCountersOrig                       // CTA_COUNTERS_ORIG
 	ctaCountersReply                      // CTA_COUNTERS_REPLY
 	ctaUse                                // CTA_USE
 	ctaID                                 // CTA_ID
-	ctaNatDst                             // CTA_NAT_DST, Deprecated
+	ctaNatDst                             // CTA_NAT_DST
 	ctaTupleMaster                        // CTA_TUPLE_MASTER
 	ctaSeqAdjOrig                         // CTA_SEQ_ADJ_ORIG
 	ctaSeqAdjReply                        // CTA_SEQ_ADJ_REPLY
@@ -111,6 +111,29 @@ const (
 	ctaIPv6Dst                     // CTA_IP_V6_DST
 )
 
+// natType describes the type of NAT range attribute in this container.
+type natType uint8
+
+// enum ctattr_nat
+const (
+	ctaNATUnspec  natType = iota // CTA_NAT_UNSPEC
+	ctaNATv4MinIP                // CTA_NAT_V4_MINIP
+	ctaNATv4MaxIP                // CTA_NAT_V4_MAXIP
+	ctaNATProto                  // CTA_NAT_PROTO
+	ctaNATv6MinIP                // CTA_NAT_V6_MINIP
+	ctaNATv6MaxIP                // CTA_NAT_V6_MAXIP
+)
+
+// protoNATType describes the type of NAT port range attribute in this container.
+type protoNATType uint8
+
+// enum ctattr_protonat
+const (
+	ctaProtoNATUnspec  protoNATType = iota // CTA_PROTONAT_UNSPEC
+	ctaProtoNATPortMin                     // CTA_PROTONAT_PORT_MIN
+	ctaProtoNATPortMax                     // CTA_PROTONAT_PORT_MAX
+)
+
 // helperType describes the kind of helper in this container.
 type helperType uint8
 
@@ -13,14 +13,14 @@ func TestUnusedEnums(t *testing.T) {
 		ctGetDying,       // Narrow time window for query
 		ctGetUnconfirmed, // Narrow time window for query
 		ctExpGet,         // Haven't figured out how to create expects, so there's nothing to Get()
-		ctaNatSrc,        // Deprecated
-		ctaNatDst,        // Deprecated
 		ctaSecMark,       // Deprecated
 
 		// All the below is unused
 		ctaTupleUnspec,
 		ctaProtoUnspec,
 		ctaIPUnspec,
+		ctaNATUnspec,
+		ctaProtoNATUnspec,
 		ctaTimestampPad,
 		ctaProtoInfoDCCPPad,
 		ctaExpectUnspec,
@@ -1,12 +1,36 @@
 package conntrack
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+var (
+	// ErrNotImplemented is returned on platforms other than Linux by Dial, and by
+	// everything encoding or decoding Netlink messages, like Flow.MarshalBinary.
+	ErrNotImplemented = netfilter.ErrNotImplemented
+
+	// ErrDumpInterrupted is returned by dumps that were interrupted by changes to the
+	// table more often than they were allowed to be retried. See DumpRetries.
+	ErrDumpInterrupted = errors.New("dump was interrupted by changes to the table, result is inconsistent")
+
+	// ErrTimestampUnavailable is returned by Flow.Age and Flow.Duration for Flows
+	// without a start timestamp. The kernel only records timestamps when enabled
+	// using `sysctl net.netfilter.nf_conntrack_timestamp=1`, and only for
+	// connections created after it was enabled.
+	ErrTimestampUnavailable = errors.New("flow has no timestamp, is nf_conntrack_timestamp enabled?")
+)
 
 var (
 	errNotConntrack     = errors.New("trying to decode a non-conntrack or conntrack-exp message")
 	errConnHasListeners = errors.New("Conn has existing listeners, open another to listen on more groups")
+	errNoCallbacks      = errors.New("Conn has no event callbacks registered, register them before calling Serve")
 	errMultipartEvent   = errors.New("received multicast event with more than one Netlink message")
 
+	errConnIsMulticast   = errors.New("Conn is attached to one or more multicast groups and can no longer be used for bidirectional traffic")
+	errNoMulticastGroups = errors.New("need one or more multicast groups to join")
+
 	errNotNested       = errors.New("need a Nested attribute to decode this structure")
 	errNeedSingleChild = errors.New("need (at least) 1 child attribute")
 	errNeedChildren    = errors.New("need (at least) 2 child attributes")
@@ -21,13 +45,60 @@ var (
 	errNeedTuples  = errors.New("Flow needs Original and Reply Tuple set for this operation")
 
 	errUpdateMaster = errors.New("cannot send TupleMaster in Flow update")
+	errUpdateNAT    = errors.New("cannot send NATSrc or NATDst in Flow update")
+	errBadNATRange  = errors.New("NATRange addresses must be valid and belong to the same address family")
+
+	errZones = errors.New("Flow can only have one of Zone, TupleOrig.Zone and TupleReply.Zone set")
 
 	errExpectNeedTuples = errors.New("Expect needs Tuple, Mask and TupleMaster Tuples set for this operation")
+
+	errExpectMaster       = errors.New("ExpectBuilder needs a master Flow with TupleOrig set")
+	errExpectMasterHelper = errors.New("master Flow has no helper, the kernel only accepts expectations of connections with a helper")
+	errExpectTuple        = errors.New("expected Tuple needs a destination address and port")
+	errExpectFamily       = errors.New("expected Tuple addresses must belong to the address family of the master Flow")
+
+	errPollInterval  = errors.New("Poller needs a positive polling interval")
+	errPollerStarted = errors.New("Poller was already started, create another to poll again")
+
+	errParseFlowShort = errors.New("flow line needs at least a protocol name and number")
+	errParseIP        = errors.New("invalid IP address")
+
+	errRecordingHeader = errors.New("not a conntrack recording, or a recording in an unsupported format")
+
+	errPacketShort    = errors.New("packet is too short to contain its headers")
+	errPacketFragment = errors.New("packet is a non-initial fragment without layer 4 header")
+
+	errPipelineOverrun = errors.New("Pipeline receive buffer overran, replies to failed requests may have been lost")
+	errPipelineNoReply = errors.New("kernel reply to request was lost, receive buffer overran")
+
+	errMessageLength = errors.New("invalid Netlink message length in kernel reply")
+	errMessageError  = errors.New("Netlink error message too short to hold an error code")
+
+	errMultiWatcherClosed = errors.New("MultiWatcher is closed")
+
+	errNoReply = errors.New("no reply message left to decode, was it dropped by a ReceiveHook?")
 )
 
 const (
 	errUnknownEventType = "unknown event type %d"
 	errWorkerCount      = "invalid worker count %d"
+	errPageSize         = "invalid page size %d"
+	errPoolSize         = "invalid pool size %d"
 	errWorkerReceive    = "netlink.Receive error in listenWorker %d, exiting"
 	errAttributeChild   = "unknown attribute child Type '%d'"
+
+	errUnknownStatusFlag    = "unknown status flag '%s'"
+	errUnknownEventTypeName = "unknown event type '%s'"
+
+	errParseToken = "unexpected token '%s' in flow line"
+	errParseValue = "invalid value for key '%s': '%s'"
+	errParseState = "unknown connection state '%s' for protocol %s"
+
+	errPacketVersion = "unknown IP version %d"
+
+	errExpectProtocol = "protocol %d has no ports, expectations need a TCP, UDP, UDP-Lite, DCCP or SCTP Tuple"
+
+	errNamespaceWatched = "network namespace '%s' is already watched"
+	errNamespaceUnknown = "network namespace '%s' is not watched"
+	errNamespaceDump    = "dumping network namespace '%s'"
 )
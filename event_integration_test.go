@@ -12,7 +12,6 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/mdlayher/netlink"
-	"github.com/ti-mo/netfilter"
 )
 
 func TestConnListen(t *testing.T) {
@@ -29,7 +28,7 @@ func TestConnListen(t *testing.T) {
 	// currently cannot be terminated gracefully when stuck in Receive(), so we have to inject an event
 	// ourselves, while making sure the worker exits before re-entering Receive().
 	ev := make(chan Event)
-	errChan, err := lc.Listen(ev, 1, []netfilter.NetlinkGroup{netfilter.GroupCTNew, netfilter.GroupCTUpdate})
+	errChan, err := lc.Listen(ev, 1, []NetlinkGroup{GroupCTNew, GroupCTUpdate})
 	require.NoError(t, err)
 
 	// Watch for listen channel errors in the background
@@ -91,6 +90,11 @@ func TestConnListen(t *testing.T) {
 		assert.GreaterOrEqual(t, re.Flow.Timeout, f.Timeout-2, "timeout")
 	}
 
+	// Every event read from the channel must have been accounted for.
+	assert.EqualValues(t, numFlows*2, lc.ConnStats().EventsDecoded)
+	assert.EqualValues(t, numFlows*2, lc.ConnStats().MessagesReceived)
+	assert.Zero(t, lc.ConnStats().DecodeErrors)
+
 	// Generate an event to unblock the listen worker goroutine
 	go func() {
 		f.Timeout = 1
@@ -114,10 +118,10 @@ func TestConnListenError(t *testing.T) {
 	require.EqualError(t, err, "need one or more multicast groups to join")
 
 	// Successfully join a multicast group
-	_, err = c.Listen(make(chan Event), 1, netfilter.GroupsCT)
+	_, err = c.Listen(make(chan Event), 1, GroupsCT)
 	require.NoError(t, err)
 
 	// Fail when joining another multicast group
-	_, err = c.Listen(make(chan Event), 1, netfilter.GroupsCT)
+	_, err = c.Listen(make(chan Event), 1, GroupsCT)
 	require.EqualError(t, err, "Conn has existing listeners, open another to listen on more groups")
 }
@@ -7,7 +7,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 var eventTypeTests = []struct {
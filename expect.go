@@ -5,7 +5,7 @@ import (
 
 	"github.com/mdlayher/netlink"
 	"github.com/pkg/errors"
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 const (
@@ -23,11 +23,31 @@ type Expect struct {
 
 	HelpName, Function string
 
+	// Flags is an ORed combination of the ExpectFlag constants.
 	Flags, Class uint32
 
 	NAT ExpectNAT
 }
 
+// Flags of an expected connection, set in Expect.Flags.
+const (
+	ExpectFlagPermanent uint32 = 1 << 0 // NF_CT_EXPECT_PERMANENT, not removed when the expected connection arrives
+	ExpectFlagInactive  uint32 = 1 << 1 // NF_CT_EXPECT_INACTIVE, does not match any connections until activated
+	ExpectFlagUserspace uint32 = 1 << 2 // NF_CT_EXPECT_USERSPACE, created from userspace instead of by a helper
+)
+
+// Canonical returns a copy of the Expect with the addresses of all its tuples
+// in canonical form, like Flow.Canonical.
+func (ex Expect) Canonical() Expect {
+
+	ex.TupleMaster = ex.TupleMaster.canonical()
+	ex.Tuple = ex.Tuple.canonical()
+	ex.Mask = ex.Mask.canonical()
+	ex.NAT.Tuple = ex.NAT.Tuple.canonical()
+
+	return ex
+}
+
 // ExpectNAT holds NAT information about an expected connection.
 type ExpectNAT struct {
 	Direction bool
@@ -150,7 +170,7 @@ func (ex Expect) marshal() ([]netfilter.Attribute, error) {
 	attrs[3] = netfilter.Attribute{Type: uint16(ctaExpectTimeout), Data: netfilter.Uint32Bytes(ex.Timeout)}
 
 	if ex.HelpName != "" {
-		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaExpectHelpName), Data: []byte(ex.HelpName)})
+		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaExpectHelpName), Data: nulString(ex.HelpName)})
 	}
 
 	if ex.Zone != 0 {
@@ -166,7 +186,7 @@ func (ex Expect) marshal() ([]netfilter.Attribute, error) {
 	}
 
 	if ex.Function != "" {
-		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaExpectFN), Data: []byte(ex.Function)})
+		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaExpectFN), Data: nulString(ex.Function)})
 	}
 
 	if ex.NAT.Tuple.filled() {
@@ -0,0 +1,162 @@
+package conntrack
+
+import (
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultExpectTimeout is the Timeout in seconds of Expects built by an ExpectBuilder
+// without Timeout. It matches the timeout of the expectations of the kernel's FTP helper.
+const DefaultExpectTimeout = 300
+
+// An ExpectBuilder builds an Expect for a connection related to a master Flow, like
+// the data channel negotiated over an FTP control connection, as a userspace ALG
+// (application layer gateway) would. It derives the Expect's mask, zone and flags
+// from the expected Tuple and master Flow, and validates them against the master
+// before the kernel rejects them with an unhelpful EINVAL.
+//
+// The kernel only accepts expectations of connections that have a helper assigned,
+// either by the kernel itself or by setting Flow.Helper when creating the master.
+type ExpectBuilder struct {
+	// Master is the connection the expected connection is related to, as returned
+	// by Get, Dump or an Event. Its TupleOrig, Zone and Helper are used.
+	Master Flow
+
+	// Tuple is the original tuple of the expected connection. A nil SourceAddress
+	// or zero SourcePort match any source address or port. DestinationAddress and
+	// DestinationPort are required. Protocol defaults to the master's protocol.
+	Tuple Tuple
+
+	// Timeout is the time in seconds the expectation waits for the expected connection.
+	// Defaults to DefaultExpectTimeout.
+	Timeout uint32
+
+	// Helper is the name of a helper to assign to the expected connection, like for
+	// the media channels of some VoIP protocols. Usually empty.
+	Helper string
+
+	// Class is the expectation class within the policy of the master's helper, limiting
+	// the amount of expectations per class. Zero for helpers with a single class, like ftp.
+	Class uint32
+
+	// Permanent keeps the expectation after the expected connection arrived, matching
+	// any amount of connections until it times out.
+	Permanent bool
+}
+
+// Build returns the Expect described by the ExpectBuilder, or an error if the
+// ExpectBuilder is incomplete or inconsistent with its master Flow.
+func (b ExpectBuilder) Build() (Expect, error) {
+
+	master := b.Master.TupleOrig
+	if !master.filled() {
+		return Expect{}, errExpectMaster
+	}
+	if b.Master.Helper.Name == "" {
+		return Expect{}, errExpectMasterHelper
+	}
+
+	t := b.Tuple
+	if t.Proto.Protocol == 0 {
+		t.Proto.Protocol = master.Proto.Protocol
+	}
+	switch t.Proto.Protocol {
+	case protoTCP, protoUDP, protoUDPLite, protoDCCP, protoSCTP:
+	default:
+		return Expect{}, errors.Errorf(errExpectProtocol, t.Proto.Protocol)
+	}
+
+	if t.IP.DestinationAddress == nil || t.Proto.DestinationPort == 0 {
+		return Expect{}, errExpectTuple
+	}
+
+	// The expected connection's addresses must be of the master's family,
+	// and have the same length as the mask.
+	n := net.IPv6len
+	if master.IP.SourceAddress.To4() != nil {
+		n = net.IPv4len
+	}
+	family := func(ip net.IP) net.IP {
+		if n == net.IPv4len {
+			return ip.To4()
+		}
+		if ip.To4() != nil {
+			return nil
+		}
+		return ip.To16()
+	}
+
+	srcMask := net.IP(allOnes(n))
+	if t.IP.SourceAddress == nil {
+		t.IP.SourceAddress, srcMask = make(net.IP, n), make(net.IP, n)
+	}
+
+	t.IP.SourceAddress = family(t.IP.SourceAddress)
+	t.IP.DestinationAddress = family(t.IP.DestinationAddress)
+	if t.IP.SourceAddress == nil || t.IP.DestinationAddress == nil {
+		return Expect{}, errExpectFamily
+	}
+
+	// The kernel matches the destination of expected connections exactly,
+	// only the source can be masked.
+	mask := Tuple{
+		IP: IPTuple{
+			SourceAddress:      srcMask,
+			DestinationAddress: net.IP(allOnes(n)),
+		},
+		Proto: ProtoTuple{
+			Protocol:        t.Proto.Protocol,
+			DestinationPort: 0xffff,
+		},
+	}
+	if t.Proto.SourcePort != 0 {
+		mask.Proto.SourcePort = 0xffff
+	}
+
+	zone := b.Master.Zone
+	if zone == 0 {
+		zone = master.Zone
+	}
+	master.Zone, t.Zone = 0, 0
+
+	ex := Expect{
+		Timeout:     b.Timeout,
+		TupleMaster: master,
+		Tuple:       t,
+		Mask:        mask,
+		Zone:        zone,
+		HelpName:    b.Helper,
+		Class:       b.Class,
+	}
+	if ex.Timeout == 0 {
+		ex.Timeout = DefaultExpectTimeout
+	}
+	if b.Permanent {
+		ex.Flags |= ExpectFlagPermanent
+	}
+
+	return ex, nil
+}
+
+// Create builds the Expect and creates it using c.
+func (b ExpectBuilder) Create(c *Conn) error {
+
+	ex, err := b.Build()
+	if err != nil {
+		return err
+	}
+
+	return c.CreateExpect(ex)
+}
+
+// allOnes returns a mask of n bytes with all bits set.
+func allOnes(n int) []byte {
+
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = 0xff
+	}
+
+	return b
+}
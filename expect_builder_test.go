@@ -0,0 +1,112 @@
+package conntrack
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpectBuilderBuild(t *testing.T) {
+
+	master := NewFlow(6, 0, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), 42000, 21, 120, 0)
+	master.Helper = Helper{Name: "ftp"}
+	master.Zone = 3
+
+	ex, err := ExpectBuilder{
+		Master: master,
+		Tuple: Tuple{
+			IP:    IPTuple{DestinationAddress: net.IPv4(10, 0, 0, 2)},
+			Proto: ProtoTuple{DestinationPort: 30000},
+		},
+		Permanent: true,
+	}.Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, Expect{
+		Timeout:     DefaultExpectTimeout,
+		TupleMaster: master.TupleOrig,
+		Tuple: Tuple{
+			IP:    IPTuple{SourceAddress: net.IP{0, 0, 0, 0}, DestinationAddress: net.IP{10, 0, 0, 2}},
+			Proto: ProtoTuple{Protocol: 6, DestinationPort: 30000},
+		},
+		Mask: Tuple{
+			IP:    IPTuple{SourceAddress: net.IP{0, 0, 0, 0}, DestinationAddress: net.IP{255, 255, 255, 255}},
+			Proto: ProtoTuple{Protocol: 6, DestinationPort: 0xffff},
+		},
+		Zone:  3,
+		Flags: ExpectFlagPermanent,
+	}, ex)
+
+	_, err = ex.marshal()
+	assert.NoError(t, err)
+
+	// A fully specified IPv6 UDP tuple, zone from the master's tuple.
+	master6 := NewFlow(17, 0, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 5060, 5060, 120, 0)
+	master6.Helper = Helper{Name: "sip"}
+	master6.TupleOrig.Zone = 4
+
+	ex, err = ExpectBuilder{
+		Master: master6,
+		Tuple: Tuple{
+			IP:    IPTuple{SourceAddress: net.ParseIP("2001:db8::2"), DestinationAddress: net.ParseIP("2001:db8::1")},
+			Proto: ProtoTuple{SourcePort: 10000, DestinationPort: 20000},
+		},
+		Timeout: 60,
+		Helper:  "sip",
+		Class:   1,
+	}.Build()
+	require.NoError(t, err)
+
+	assert.Equal(t, uint32(60), ex.Timeout)
+	assert.Equal(t, uint16(4), ex.Zone)
+	assert.Zero(t, ex.TupleMaster.Zone)
+	assert.Equal(t, "sip", ex.HelpName)
+	assert.Equal(t, uint32(1), ex.Class)
+	assert.Zero(t, ex.Flags)
+	assert.Equal(t, net.IP(allOnes(16)), ex.Mask.IP.SourceAddress)
+	assert.Equal(t, ProtoTuple{Protocol: 17, SourcePort: 0xffff, DestinationPort: 0xffff}, ex.Mask.Proto)
+	assert.True(t, ex.Tuple.IP.IsIPv6() && ex.Mask.IP.IsIPv6())
+}
+
+func TestExpectBuilderBuildError(t *testing.T) {
+
+	master := NewFlow(6, 0, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), 42000, 21, 120, 0)
+	master.Helper = Helper{Name: "ftp"}
+
+	tuple := Tuple{
+		IP:    IPTuple{DestinationAddress: net.IPv4(10, 0, 0, 2)},
+		Proto: ProtoTuple{DestinationPort: 30000},
+	}
+
+	_, err := ExpectBuilder{Tuple: tuple}.Build()
+	assert.Equal(t, errExpectMaster, err)
+
+	noHelper := master
+	noHelper.Helper = Helper{}
+	_, err = ExpectBuilder{Master: noHelper, Tuple: tuple}.Build()
+	assert.Equal(t, errExpectMasterHelper, err)
+
+	icmp := tuple
+	icmp.Proto.Protocol = 1
+	_, err = ExpectBuilder{Master: master, Tuple: icmp}.Build()
+	assert.EqualError(t, err, "protocol 1 has no ports, expectations need a TCP, UDP, UDP-Lite, DCCP or SCTP Tuple")
+
+	noPort := tuple
+	noPort.Proto.DestinationPort = 0
+	_, err = ExpectBuilder{Master: master, Tuple: noPort}.Build()
+	assert.Equal(t, errExpectTuple, err)
+
+	v6 := tuple
+	v6.IP.DestinationAddress = net.ParseIP("2001:db8::2")
+	_, err = ExpectBuilder{Master: master, Tuple: v6}.Build()
+	assert.Equal(t, errExpectFamily, err)
+
+	bad := tuple
+	bad.IP.SourceAddress = net.IP{1, 2, 3}
+	_, err = ExpectBuilder{Master: master, Tuple: bad}.Build()
+	assert.Equal(t, errExpectFamily, err)
+
+	assert.Equal(t, errExpectMaster, ExpectBuilder{}.Create(nil))
+}
@@ -14,8 +14,8 @@ import (
 	"github.com/mdlayher/netlink"
 )
 
-// No meaningful integration test possible until we can figure out how
-// to create expects from userspace.
+// Dump the empty expectation table of a new namespace. Expectations are
+// created in TestExpectBuilderCreate.
 func TestConnDumpExpect(t *testing.T) {
 
 	c, _, err := makeNSConn()
@@ -25,7 +25,8 @@ func TestConnDumpExpect(t *testing.T) {
 	require.NoError(t, err, "unexpected error dumping expect table")
 }
 
-// Attempt at creating conntrack expectation from userspace.
+// Creating an expectation of a connection without helper is refused.
+// See TestExpectBuilderCreate for a working expectation.
 func TestConnCreateExpect(t *testing.T) {
 
 	c, _, err := makeNSConn()
@@ -69,5 +70,84 @@ func TestConnCreateExpect(t *testing.T) {
 
 	opErr, ok := errors.Cause(err).(*netlink.OpError)
 	require.True(t, ok)
-	require.EqualError(t, opErr.Err, unix.EINVAL.Error())
+	require.EqualError(t, opErr.Err, unix.EOPNOTSUPP.Error())
+}
+
+func TestConnFlushExpect(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+
+	require.NoError(t, c.FlushExpect(), "unexpected error flushing expect table")
+	require.NoError(t, c.FlushExpectHelper("ftp"), "unexpected error flushing ftp expectations")
+
+	ex, err := c.DumpExpect()
+	require.NoError(t, err)
+	require.Empty(t, ex)
+}
+
+func TestConnDumpExpectFor(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+
+	f := NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 42000, 21, 120, 0)
+
+	_, err = c.DumpExpectFor(f)
+	opErr, ok := errors.Cause(err).(*netlink.OpError)
+	require.True(t, ok)
+	require.EqualError(t, opErr.Err, unix.ENOENT.Error(), "dump expectations of missing flow")
+
+	require.NoError(t, c.Create(f), "unexpected error creating flow", f)
+
+	ex, err := c.DumpExpectFor(f)
+	require.NoError(t, err, "unexpected error dumping expectations of flow")
+	require.Empty(t, ex)
+
+	// Look up the master by its reply tuple.
+	ex, err = c.DumpExpectFor(Flow{TupleReply: f.TupleReply})
+	require.NoError(t, err, "unexpected error dumping expectations of flow by reply tuple")
+	require.Empty(t, ex)
+
+	_, err = c.DumpExpectFor(Flow{})
+	require.Equal(t, errNeedTuples, err)
+}
+
+func TestExpectBuilderCreate(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	f := NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 42000, 21, 120, 0)
+	f.Helper = Helper{Name: "ftp"}
+	require.NoError(t, c.Create(f), "unexpected error creating flow")
+
+	master, err := c.Get(f)
+	require.NoError(t, err)
+
+	// Passive FTP data channel from the client to the server.
+	b := ExpectBuilder{
+		Master: master,
+		Tuple: Tuple{
+			IP:    IPTuple{SourceAddress: net.IPv4(1, 2, 3, 4), DestinationAddress: net.IPv4(5, 6, 7, 8)},
+			Proto: ProtoTuple{DestinationPort: 30000},
+		},
+		Timeout: 60,
+	}
+	require.NoError(t, b.Create(c))
+
+	exs, err := c.DumpExpect()
+	require.NoError(t, err)
+	require.Len(t, exs, 1)
+
+	ex := exs[0].Canonical()
+	require.Equal(t, uint16(30000), ex.Tuple.Proto.DestinationPort)
+	require.Equal(t, uint16(0), ex.Mask.Proto.SourcePort)
+	require.Equal(t, net.IPv4(5, 6, 7, 8).To4(), ex.Tuple.IP.DestinationAddress)
+	require.Equal(t, "ftp", ex.HelpName)
+
+	// The expectation is unique.
+	err = b.Create(c)
+	require.True(t, errors.Is(err, unix.EEXIST), "unexpected error: %v", err)
 }
@@ -9,7 +9,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 var corpusExpect = []struct {
@@ -338,7 +338,7 @@ func TestExpectMarshal(t *testing.T) {
 		},
 		{
 			Type: uint16(ctaExpectHelpName),
-			Data: []byte("ftp"),
+			Data: []byte("ftp\x00"),
 		},
 		{
 			Type: uint16(ctaExpectZone),
@@ -354,7 +354,7 @@ func TestExpectMarshal(t *testing.T) {
 		},
 		{
 			Type: uint16(ctaExpectFN),
-			Data: []byte("func"),
+			Data: []byte("func\x00"),
 		},
 		{
 			Type:   uint16(ctaExpectNAT),
@@ -511,3 +511,19 @@ func BenchmarkExpectUnmarshal(b *testing.B) {
 		_ = ex.unmarshal(iad)
 	}
 }
+
+func TestExpectCanonical(t *testing.T) {
+
+	tpl := Tuple{
+		IP:    IPTuple{SourceAddress: net.IPv4(192, 0, 2, 1), DestinationAddress: net.IPv4(192, 0, 2, 2)},
+		Proto: ProtoTuple{Protocol: 6, SourcePort: 1234, DestinationPort: 21},
+	}
+	ex := Expect{TupleMaster: tpl, Tuple: tpl, Mask: tpl, NAT: ExpectNAT{Tuple: tpl}}
+
+	c := ex.Canonical()
+	assert.Equal(t, net.IP{192, 0, 2, 1}, c.TupleMaster.IP.SourceAddress)
+	assert.Equal(t, net.IP{192, 0, 2, 2}, c.Tuple.IP.DestinationAddress)
+	assert.Equal(t, net.IP{192, 0, 2, 1}, c.Mask.IP.SourceAddress)
+	assert.Equal(t, net.IP{192, 0, 2, 2}, c.NAT.Tuple.IP.DestinationAddress)
+	assert.Len(t, ex.Tuple.IP.SourceAddress, net.IPv6len, "original is untouched")
+}
@@ -1,7 +1,7 @@
 package conntrack
 
 import (
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 // Filter is a structure used in dump operations to filter the response
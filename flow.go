@@ -2,10 +2,11 @@ package conntrack
 
 import (
 	"net"
+	"time"
 
 	"github.com/mdlayher/netlink"
 	"github.com/pkg/errors"
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 // Flow represents a snapshot of a Conntrack connection.
@@ -26,12 +27,24 @@ type Flow struct {
 
 	TupleOrig, TupleReply, TupleMaster Tuple
 
+	// NATSrc and NATDst are the source and destination NAT applied to a
+	// connection when it is created. They are never filled by the kernel.
+	NATSrc, NATDst NATRange
+
 	SeqAdjOrig, SeqAdjReply SequenceAdjust
 
 	Labels, LabelsMask []byte
 
 	Mark, Use uint32
 
+	// MarkMask selects the bits of Mark that are applied to the connection's mark
+	// when creating or updating it, leaving all other bits untouched. Bits of Mark
+	// outside MarkMask are not sent. Unlike `conntrack -U --mark value/mask`, they
+	// don't toggle the connection's bits, which the kernel does by computing
+	// (mark &^ MarkMask) ^ Mark. Zero overwrites the whole mark.
+	// It is never sent by the kernel.
+	MarkMask uint32
+
 	SynProxy SynProxy
 }
 
@@ -68,6 +81,74 @@ func NewFlow(proto uint8, status StatusFlag, srcAddr, destAddr net.IP, srcPort,
 	return f
 }
 
+// Age returns the time elapsed since the Flow was created, according to its
+// Timestamp. Returns ErrTimestampUnavailable if the Flow has no start timestamp,
+// which is the case when timestamping is disabled in the kernel.
+func (f Flow) Age() (time.Duration, error) {
+	return f.age(time.Now())
+}
+
+func (f Flow) age(now time.Time) (time.Duration, error) {
+
+	if f.Timestamp.Start.IsZero() {
+		return 0, ErrTimestampUnavailable
+	}
+
+	return now.Sub(f.Timestamp.Start), nil
+}
+
+// Duration returns the lifetime of the Flow according to its Timestamp: the time
+// between its creation and destruction for Flows received in EventDestroy Events,
+// or the time since its creation for Flows that are still active. Returns
+// ErrTimestampUnavailable if the Flow has no start timestamp, which is the case
+// when timestamping is disabled in the kernel.
+func (f Flow) Duration() (time.Duration, error) {
+	return f.duration(time.Now())
+}
+
+func (f Flow) duration(now time.Time) (time.Duration, error) {
+
+	if f.Timestamp.Stop.IsZero() {
+		return f.age(now)
+	}
+
+	if f.Timestamp.Start.IsZero() {
+		return 0, ErrTimestampUnavailable
+	}
+
+	return f.Timestamp.Stop.Sub(f.Timestamp.Start), nil
+}
+
+// Packets returns the amount of packets the Flow has seen in the given Direction.
+// Counters are only filled when accounting is enabled in the kernel using
+// `sysctl net.netfilter.nf_conntrack_acct=1`.
+func (f Flow) Packets(d Direction) uint64 {
+	return f.counter(d).Packets
+}
+
+// Bytes returns the amount of bytes the Flow has seen in the given Direction.
+func (f Flow) Bytes(d Direction) uint64 {
+	return f.counter(d).Bytes
+}
+
+// TotalPackets returns the amount of packets the Flow has seen in both directions.
+func (f Flow) TotalPackets() uint64 {
+	return f.CountersOrig.Packets + f.CountersReply.Packets
+}
+
+// TotalBytes returns the amount of bytes the Flow has seen in both directions.
+func (f Flow) TotalBytes() uint64 {
+	return f.CountersOrig.Bytes + f.CountersReply.Bytes
+}
+
+// counter returns the Flow's Counter in the given Direction.
+func (f Flow) counter(d Direction) Counter {
+	if d == DirReply {
+		return f.CountersReply
+	}
+	return f.CountersOrig
+}
+
 // unmarshal unmarshals a list of netfilter.Attributes into a Flow structure.
 func (f *Flow) unmarshal(ad *netlink.AttributeDecoder) error {
 
@@ -90,6 +171,10 @@ func (f *Flow) unmarshal(ad *netlink.AttributeDecoder) error {
 		// CTA_MARK is the connection's connmark
 		case ctaMark:
 			f.Mark = ad.Uint32()
+		// CTA_MARK_MASK is never sent by the kernel, but limits the bits of the
+		// connmark changed by set / update queries.
+		case ctaMarkMask:
+			f.MarkMask = ad.Uint32()
 		// CTA_ZONE describes the Conntrack zone the flow is placed in. This can be combined with a CTA_TUPLE_ZONE
 		// to specify which zone an event originates from.
 		case ctaZone:
@@ -189,6 +274,60 @@ func (f *Flow) unmarshal(ad *netlink.AttributeDecoder) error {
 	return ad.Err()
 }
 
+// Canonical returns a copy of f with the addresses of all its tuples and NAT ranges
+// in canonical form, so Flows describing the same connection compare equal using cmp
+// or reflect.DeepEqual and can be used in map keys. See IPTuple.Canonical.
+func (f Flow) Canonical() Flow {
+
+	f.TupleOrig = f.TupleOrig.canonical()
+	f.TupleReply = f.TupleReply.canonical()
+	f.TupleMaster = f.TupleMaster.canonical()
+
+	f.NATSrc.MinIP, f.NATSrc.MaxIP = canonicalIP(f.NATSrc.MinIP), canonicalIP(f.NATSrc.MaxIP)
+	f.NATDst.MinIP, f.NATDst.MaxIP = canonicalIP(f.NATDst.MinIP), canonicalIP(f.NATDst.MaxIP)
+
+	return f
+}
+
+// A FlowKey identifies a connection regardless of the direction it was observed
+// in. FlowKeys are comparable and can be used as map keys. See Flow.Key.
+type FlowKey struct {
+	tuple tupleKey
+	zone  uint16
+}
+
+// Key returns the FlowKey of f. Flows of the same connection have the same key,
+// even if their original and reply tuples were swapped, like when the connection
+// was observed from its responder's side.
+//
+// Without NAT, the reply tuple of a connection is the inverse of its original
+// tuple, and both normalize to the same Tuple. With NAT, the reply tuple holds
+// the translated addresses and ports, and the key is derived from whichever of
+// both normalized tuples sorts first. A Flow without a reply tuple, like one built
+// from a packet's Tuple, is keyed by its original tuple only, and is only certain
+// to match connections without NAT. See Tuple.Normalize.
+func (f Flow) Key() FlowKey {
+
+	k := f.TupleOrig.Normalize().key()
+
+	if f.TupleReply.filled() {
+		if r := f.TupleReply.Normalize().key(); r.less(k) {
+			k = r
+		}
+	}
+
+	return FlowKey{tuple: k, zone: f.Zone}
+}
+
+// family returns the protocol family of requests about f. It defaults to IPv4,
+// and is IPv6 if both the original and reply tuple are IPv6.
+func (f Flow) family() ProtoFamily {
+	if f.TupleOrig.IP.IsIPv6() && f.TupleReply.IP.IsIPv6() {
+		return ProtoIPv6
+	}
+	return ProtoIPv4
+}
+
 // marshal marshals a Flow object into a list of netfilter.Attributes.
 func (f Flow) marshal() ([]netfilter.Attribute, error) {
 
@@ -198,6 +337,12 @@ func (f Flow) marshal() ([]netfilter.Attribute, error) {
 		return nil, errNeedTuples
 	}
 
+	// A connection has a single zone, which applies to one or both directions.
+	if f.TupleOrig.Zone != 0 && f.TupleReply.Zone != 0 ||
+		f.Zone != 0 && (f.TupleOrig.Zone != 0 || f.TupleReply.Zone != 0) {
+		return nil, errZones
+	}
+
 	attrs := make([]netfilter.Attribute, 0, 12)
 
 	if f.TupleOrig.filled() {
@@ -227,9 +372,22 @@ func (f Flow) marshal() ([]netfilter.Attribute, error) {
 		attrs = append(attrs, f.Status.marshal())
 	}
 
-	if f.Mark != 0 {
+	// With a mask, a zero Mark is meaningful: it clears the masked bits. Bits
+	// outside the mask are dropped, the kernel would toggle them otherwise.
+	if f.Mark != 0 || f.MarkMask != 0 {
+		mark := f.Mark
+		if f.MarkMask != 0 {
+			mark &= f.MarkMask
+		}
+
 		a := netfilter.Attribute{Type: uint16(ctaMark)}
-		a.PutUint32(f.Mark)
+		a.PutUint32(mark)
+		attrs = append(attrs, a)
+	}
+
+	if f.MarkMask != 0 {
+		a := netfilter.Attribute{Type: uint16(ctaMarkMask)}
+		a.PutUint32(f.MarkMask)
 		attrs = append(attrs, a)
 	}
 
@@ -255,6 +413,22 @@ func (f Flow) marshal() ([]netfilter.Attribute, error) {
 		attrs = append(attrs, tm)
 	}
 
+	if f.NATSrc.filled() {
+		ns, err := f.NATSrc.marshal(uint16(ctaNatSrc))
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, ns)
+	}
+
+	if f.NATDst.filled() {
+		nd, err := f.NATDst.marshal(uint16(ctaNatDst))
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, nd)
+	}
+
 	if f.SeqAdjOrig.filled() {
 		attrs = append(attrs, f.SeqAdjOrig.marshal())
 	}
@@ -270,6 +444,81 @@ func (f Flow) marshal() ([]netfilter.Attribute, error) {
 	return attrs, nil
 }
 
+// MarshalBinary implements encoding.BinaryMarshaler. The Flow is encoded as a list of
+// netlink attributes, the same way the kernel encodes Flows in dumps and events.
+// In addition to the attributes sent to the kernel when creating or updating a Flow,
+// the encoding includes the Flow's ID, Use, Labels, counters, timestamps and
+// security context, so all data received from the kernel is retained.
+//
+// Like for queries, the Flow needs at least one of TupleOrig and TupleReply to be set.
+func (f Flow) MarshalBinary() ([]byte, error) {
+
+	// Directional attributes are encoded according to their Direction field.
+	f.SeqAdjOrig.Direction, f.SeqAdjReply.Direction = false, true
+	f.CountersOrig.Direction, f.CountersReply.Direction = false, true
+
+	// NAT ranges only apply to the creation of a connection and are never received from the kernel.
+	f.NATSrc, f.NATDst = NATRange{}, NATRange{}
+
+	attrs, err := f.marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	if f.ID != 0 {
+		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaID), Data: netfilter.Uint32Bytes(f.ID)})
+	}
+
+	if f.Use != 0 {
+		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaUse), Data: netfilter.Uint32Bytes(f.Use)})
+	}
+
+	if len(f.Labels) != 0 {
+		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaLabels), Data: f.Labels})
+	}
+
+	if len(f.LabelsMask) != 0 {
+		attrs = append(attrs, netfilter.Attribute{Type: uint16(ctaLabelsMask), Data: f.LabelsMask})
+	}
+
+	if f.CountersOrig.Packets != 0 || f.CountersOrig.Bytes != 0 {
+		attrs = append(attrs, f.CountersOrig.marshal())
+	}
+
+	if f.CountersReply.Packets != 0 || f.CountersReply.Bytes != 0 {
+		attrs = append(attrs, f.CountersReply.marshal())
+	}
+
+	if !f.Timestamp.Start.IsZero() || !f.Timestamp.Stop.IsZero() {
+		attrs = append(attrs, f.Timestamp.marshal())
+	}
+
+	if f.SecurityContext != "" {
+		attrs = append(attrs, f.SecurityContext.marshal())
+	}
+
+	return netfilter.MarshalAttributes(attrs)
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It decodes a Flow encoded
+// by MarshalBinary, or the attributes of a Flow message received from the kernel.
+func (f *Flow) UnmarshalBinary(b []byte) error {
+
+	ad, err := netfilter.NewAttributeDecoder(b)
+	if err != nil {
+		return err
+	}
+
+	var nf Flow
+	if err := nf.unmarshal(ad); err != nil {
+		return err
+	}
+
+	*f = nf
+
+	return nil
+}
+
 // unmarshalFlow unmarshals a Flow from a netlink.Message.
 // The Message must contain valid attributes.
 func unmarshalFlow(nlm netlink.Message) (Flow, error) {
@@ -3,6 +3,7 @@
 package conntrack
 
 import (
+	"context"
 	"net"
 	"testing"
 
@@ -13,6 +14,7 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/mdlayher/netlink"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 // Create a given number of flows with a randomized component and check the amount
@@ -275,6 +277,12 @@ func TestConnUpdateError(t *testing.T) {
 
 	err = c.Update(f)
 	require.EqualError(t, err, errUpdateMaster.Error())
+
+	f.TupleMaster = Tuple{}
+	f.NATSrc = NATRange{MinPort: 1024}
+
+	err = c.Update(f)
+	require.EqualError(t, err, errUpdateNAT.Error())
 }
 
 // Creates IPv4 and IPv6 flows and queries them using a simple get.
@@ -348,6 +356,39 @@ func TestConnDumpFilter(t *testing.T) {
 	assert.Len(t, d, len(flows))
 }
 
+func TestConnDumpFamily(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+
+	v4 := NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0xff)
+	v6 := NewFlow(17, 0, net.ParseIP("2a00:1450:400e:804::200e"), net.ParseIP("2a00:1450:400e:804::200f"), 1234, 80, 120, 0xff)
+
+	require.NoError(t, c.Create(v4), "creating IPv4 flow")
+	require.NoError(t, c.Create(v6), "creating IPv6 flow")
+
+	flows, err := c.Dump(DumpFamily(ProtoIPv4))
+	require.NoError(t, err, "dumping IPv4 flows")
+	require.Len(t, flows, 1)
+	assert.False(t, flows[0].TupleOrig.IP.IsIPv6())
+
+	flows, err = c.Dump(DumpFamily(ProtoIPv6))
+	require.NoError(t, err, "dumping IPv6 flows")
+	require.Len(t, flows, 1)
+	assert.True(t, flows[0].TupleOrig.IP.IsIPv6())
+
+	flows, err = c.Dump(DumpFamily(ProtoUnspec))
+	require.NoError(t, err, "dumping all flows")
+	assert.Len(t, flows, 2)
+
+	if findKsym("ctnetlink_alloc_filter") {
+		flows, err = c.DumpFilter(Filter{Mark: 0xff, Mask: 0xff}, DumpFamily(ProtoIPv6))
+		require.NoError(t, err, "dumping filtered IPv6 flows")
+		require.Len(t, flows, 1)
+		assert.True(t, flows[0].TupleOrig.IP.IsIPv6())
+	}
+}
+
 // Bench scenario that calls Conn.Create and Conn.Delete on the same Flow once per iteration.
 // This includes two marshaling operations for create/delete, two syscalls and output validation.
 func BenchmarkCreateDeleteFlow(b *testing.B) {
@@ -372,3 +413,286 @@ func BenchmarkCreateDeleteFlow(b *testing.B) {
 		}
 	}
 }
+
+func TestConnDumpPages(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, c.Create(NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), uint16(1000+i), 80, 120, 0)))
+	}
+	require.NoError(t, c.Create(NewFlow(17, 0, net.ParseIP("2a00:1450:400e:804::200e"), net.ParseIP("2a00:1450:400e:804::200f"), 1234, 80, 120, 0)))
+
+	var sizes []int
+	ports := make(map[uint16]bool)
+	err = c.DumpPages(context.Background(), 4, func(page []Flow) error {
+		sizes = append(sizes, len(page))
+		for _, f := range page {
+			ports[f.TupleOrig.Proto.SourcePort] = true
+		}
+		return nil
+	}, DumpFamily(ProtoIPv4))
+	require.NoError(t, err)
+	assert.Equal(t, []int{4, 4, 2}, sizes)
+	assert.Len(t, ports, 10)
+
+	// An error from the callback stops the dump.
+	errStop := errors.New("stop")
+	var pages int
+	err = c.DumpPages(context.Background(), 4, func([]Flow) error {
+		pages++
+		return errStop
+	})
+	assert.Equal(t, errStop, err)
+	assert.Equal(t, 1, pages)
+
+	// The rest of the stopped dump was discarded, the Conn can still be used.
+	flows, err := c.Dump()
+	require.NoError(t, err)
+	assert.Len(t, flows, 11)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = c.DumpPages(ctx, 4, func([]Flow) error { return nil })
+	assert.Equal(t, context.Canceled, err)
+
+	err = c.DumpPages(context.Background(), 0, func([]Flow) error { return nil })
+	assert.EqualError(t, err, "invalid page size 0")
+}
+
+func TestConnDumpPagesStream(t *testing.T) {
+
+	_, ns, err := makeNSConn()
+	require.NoError(t, err)
+
+	// Count the datagrams received, the dump is decoded as they arrive.
+	var receipts int
+	c, err := Dial(&netlink.Config{NetNS: ns}, WithReceiveHook(ReceiveHookFunc(func(_ context.Context, r Receipt) ([]netlink.Message, error) {
+		receipts++
+		return r.Messages, r.Err
+	})))
+	require.NoError(t, err)
+	defer c.Close()
+
+	numFlows := 1000
+	for i := 1; i <= numFlows; i++ {
+		require.NoError(t, c.Create(NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), uint16(i), 80, 120, 0)))
+	}
+	receipts = 0
+	received := c.ConnStats().MessagesReceived
+
+	var flows int
+	err = c.DumpPages(context.Background(), 100, func(page []Flow) error {
+		flows += len(page)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, numFlows, flows)
+	assert.Greater(t, receipts, 1)
+	assert.Equal(t, uint64(numFlows), c.ConnStats().MessagesReceived-received)
+
+	// AppendDump decodes the dump as it arrives as well.
+	receipts = 0
+	out, err := c.AppendDump(nil)
+	require.NoError(t, err)
+	assert.Len(t, out, numFlows)
+	assert.Greater(t, receipts, 1)
+}
+
+func TestConnAppendDump(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, c.Create(NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), uint16(1000+i), 80, 120, 0)))
+	}
+
+	flows, err := c.AppendDump(nil)
+	require.NoError(t, err)
+	require.Len(t, flows, 3)
+
+	// Dumping into the previous result reuses its storage.
+	again, err := c.AppendDump(flows[:0])
+	require.NoError(t, err)
+	require.Len(t, again, 3)
+	assert.Equal(t, &flows[0], &again[0])
+
+	// Flows are appended after existing elements.
+	prefix := []Flow{{ID: 42}}
+	out, err := c.AppendDump(prefix, DumpFamily(ProtoIPv6))
+	require.NoError(t, err)
+	assert.Equal(t, prefix, out)
+
+	out, err = c.AppendDump(prefix)
+	require.NoError(t, err)
+	require.Len(t, out, 4)
+	assert.Equal(t, uint32(42), out[0].ID)
+}
+
+func TestConnCreateDirectionalZone(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+
+	orig := NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0)
+	orig.TupleOrig.Zone = 1
+	require.NoError(t, c.Create(orig), "creating flow with zone in original direction")
+
+	reply := NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0)
+	reply.TupleReply.Zone = 2
+	require.NoError(t, c.Create(reply), "creating flow with zone in reply direction")
+
+	got, err := c.Get(orig)
+	require.NoError(t, err, "getting flow with zone in original direction")
+	assert.Equal(t, uint16(1), got.TupleOrig.Zone)
+	assert.Equal(t, uint16(0), got.TupleReply.Zone)
+	assert.Equal(t, uint16(0), got.Zone)
+
+	got, err = c.Get(reply)
+	require.NoError(t, err, "getting flow with zone in reply direction")
+	assert.Equal(t, uint16(0), got.TupleOrig.Zone)
+	assert.Equal(t, uint16(2), got.TupleReply.Zone)
+
+	both := NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0)
+	both.TupleOrig.Zone, both.TupleReply.Zone = 1, 2
+	assert.Equal(t, errZones, c.Create(both))
+}
+
+func TestConnDeleteWhere(t *testing.T) {
+
+	c, ns, err := makeNSConn()
+	require.NoError(t, err)
+
+	backend := net.IPv4(10, 0, 0, 42)
+
+	numFlows := 300
+	for i := 1; i <= numFlows; i++ {
+		dst := net.IPv4(10, 0, 0, 1)
+		if i%3 == 0 {
+			dst = backend
+		}
+
+		require.NoError(t, c.Create(NewFlow(6, 0, net.IPv4(1, 2, 3, 4), dst, uint16(i), 80, 120, 0)), "creating flow", i)
+	}
+
+	res, err := c.DeleteWhere(func(f Flow) bool {
+		return f.TupleOrig.IP.DestinationAddress.Equal(backend)
+	})
+	require.NoError(t, err)
+	assert.Equal(t, DeleteResult{Matched: 100, Deleted: 100}, res)
+
+	flows, err := c.Dump()
+	require.NoError(t, err)
+	assert.Len(t, flows, numFlows-100)
+
+	// A Flow with a stale ID is not deleted.
+	f := flows[0]
+	f.ID++
+	assert.True(t, errors.Is(c.delete(context.Background(), f, true), unix.ENOENT))
+	assert.NoError(t, c.delete(context.Background(), flows[0], true))
+
+	// Deletes are sent in batches through the Conn's hooks. A failed request
+	// is reported for its Flow without stopping the others.
+	errHook := errors.New("hook failure")
+	isDelete := func(req *netlink.Message) bool {
+		return req != nil && req.Header.Type == netlink.HeaderType(netfilter.NFSubsysCTNetlink)<<8|netlink.HeaderType(ctDelete)
+	}
+
+	var sent, acked int
+	hc, err := Dial(&netlink.Config{NetNS: ns},
+		WithSendHook(SendHookFunc(func(_ context.Context, req netlink.Message) (netlink.Message, error) {
+			if isDelete(&req) {
+				if sent++; sent == 1 {
+					return req, errHook
+				}
+			}
+			return req, nil
+		})),
+		WithReceiveHook(ReceiveHookFunc(func(_ context.Context, r Receipt) ([]netlink.Message, error) {
+			if isDelete(r.Request) && r.Err == nil {
+				acked++
+			}
+			return r.Messages, r.Err
+		})),
+	)
+	require.NoError(t, err)
+	defer hc.Close()
+
+	remaining := numFlows - 100 - 1
+	res, err = hc.DeleteWhere(func(Flow) bool { return true })
+	require.NoError(t, err)
+	assert.Equal(t, remaining, res.Matched)
+	assert.Equal(t, remaining-1, res.Deleted)
+	assert.Equal(t, remaining-1, acked)
+	require.Len(t, res.Errors, 1)
+	assert.True(t, errors.Is(res.Errors[0].Err, errHook))
+
+	flows, err = c.Dump()
+	require.NoError(t, err)
+	assert.Equal(t, []Flow{res.Errors[0].Flow}, flows)
+
+	// The socket is usable for queries after a batch.
+	_, err = hc.StatsGlobal()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = c.DeleteWhereContext(ctx, func(Flow) bool { return true })
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestConnCreateNAT(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+
+	// The kernel rewrites the reply tuple according to the NAT ranges.
+	f := NewFlow(6, 0, net.IPv4(10, 0, 0, 1), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0)
+	f.NATSrc = NATRange{MinIP: net.IPv4(192, 0, 2, 1), MinPort: 40000, MaxPort: 40010}
+	require.NoError(t, c.Create(f), "creating flow with source NAT")
+
+	got, err := c.Get(f)
+	require.NoError(t, err)
+	assert.True(t, got.Status.SrcNAT())
+	assert.True(t, got.TupleReply.IP.DestinationAddress.Equal(net.IPv4(192, 0, 2, 1)))
+	assert.GreaterOrEqual(t, got.TupleReply.Proto.DestinationPort, uint16(40000))
+	assert.LessOrEqual(t, got.TupleReply.Proto.DestinationPort, uint16(40010))
+
+	f = NewFlow(17, 0, net.IPv4(10, 0, 0, 1), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0)
+	f.NATDst = NATRange{MinIP: net.IPv4(10, 0, 0, 53), MinPort: 5353}
+	require.NoError(t, c.Create(f), "creating flow with destination NAT")
+
+	got, err = c.Get(f)
+	require.NoError(t, err)
+	assert.True(t, got.Status.DstNAT())
+	assert.True(t, got.TupleReply.IP.SourceAddress.Equal(net.IPv4(10, 0, 0, 53)))
+	assert.Equal(t, uint16(5353), got.TupleReply.Proto.SourcePort)
+}
+
+func TestConnUpdateMarkMask(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+
+	f := NewFlow(6, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0x12345678)
+	require.NoError(t, c.Create(f))
+
+	// Only the masked bits are changed, including bits cleared by a zero Mark.
+	require.NoError(t, c.Update(Flow{TupleOrig: f.TupleOrig, Mark: 0xab00, MarkMask: 0xff00}))
+	got, err := c.Get(f)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x1234ab78), got.Mark)
+
+	require.NoError(t, c.Update(Flow{TupleOrig: f.TupleOrig, MarkMask: 0xffff0000}))
+	got, err = c.Get(f)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x0000ab78), got.Mark)
+
+	// Bits of Mark outside the MarkMask are left untouched, not toggled.
+	require.NoError(t, c.Update(Flow{TupleOrig: f.TupleOrig, Mark: 0xffff00cd, MarkMask: 0xff}))
+	got, err = c.Get(f)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(0x0000abcd), got.Mark)
+}
@@ -12,7 +12,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 var (
@@ -465,6 +465,35 @@ func TestFlowMarshal(t *testing.T) {
 	_, err = Flow{}.marshal()
 	assert.EqualError(t, err, errNeedTuples.Error())
 
+	// A zero Mark is sent along with a MarkMask to clear the masked bits.
+	attrs, err := Flow{TupleOrig: flowIPPT, MarkMask: 0xff00}.marshal()
+	require.NoError(t, err)
+	assert.Equal(t, []netfilter.Attribute{
+		{Type: uint16(ctaMark), Data: []byte{0, 0, 0, 0}},
+		{Type: uint16(ctaMarkMask), Data: []byte{0, 0, 0xff, 0}},
+	}, attrs[1:])
+
+	// Bits of Mark outside the MarkMask are not sent.
+	attrs, err = Flow{TupleOrig: flowIPPT, Mark: 0x1234, MarkMask: 0xff00}.marshal()
+	require.NoError(t, err)
+	assert.Equal(t, netfilter.Attribute{Type: uint16(ctaMark), Data: []byte{0, 0, 0x12, 0}}, attrs[1])
+
+	// Can marshal with a zone in a single direction.
+	zoned := flowIPPT
+	zoned.Zone = 1
+	_, err = Flow{TupleOrig: zoned, TupleReply: flowIPPT}.marshal()
+	assert.NoError(t, err)
+	_, err = Flow{TupleOrig: flowIPPT, TupleReply: zoned}.marshal()
+	assert.NoError(t, err)
+
+	// Cannot marshal with zones in both directions, or combined with Flow.Zone.
+	_, err = Flow{TupleOrig: zoned, TupleReply: zoned}.marshal()
+	assert.EqualError(t, err, errZones.Error())
+	_, err = Flow{TupleOrig: zoned, TupleReply: flowIPPT, Zone: 1}.marshal()
+	assert.EqualError(t, err, errZones.Error())
+	_, err = Flow{TupleReply: zoned, Zone: 1}.marshal()
+	assert.EqualError(t, err, errZones.Error())
+
 	// Return error from orig/reply/master IPTuple marshals
 	_, err = Flow{TupleOrig: flowBadIPPT, TupleReply: flowIPPT}.marshal()
 	assert.EqualError(t, err, errBadIPTuple.Error())
@@ -474,6 +503,55 @@ func TestFlowMarshal(t *testing.T) {
 	assert.EqualError(t, err, errBadIPTuple.Error())
 }
 
+func TestFlowBinary(t *testing.T) {
+
+	master := flowIPPT
+	master.Zone = 3
+
+	f := Flow{
+		ID: 42, Timeout: 123, Status: Status{Value: StatusAssured | StatusSeenReply},
+		Timestamp: Timestamp{Start: time.Unix(0, 1000), Stop: time.Unix(0, 2000)},
+		TupleOrig: flowIPPT, TupleReply: flowIPPT, TupleMaster: master,
+		ProtoInfo:       ProtoInfo{TCP: &ProtoInfoTCP{State: 3, OriginalWindowScale: 7, ReplyFlags: 0x23}},
+		Helper:          Helper{Name: "ftp", Info: []byte{1, 2}},
+		Zone:            2,
+		CountersOrig:    Counter{Packets: 1, Bytes: 60},
+		CountersReply:   Counter{Direction: true, Packets: 2, Bytes: 120},
+		SecurityContext: "unconfined",
+		SeqAdjOrig:      SequenceAdjust{Position: 1, OffsetBefore: 2, OffsetAfter: 3},
+		SeqAdjReply:     SequenceAdjust{Direction: true, Position: 5, OffsetBefore: 6, OffsetAfter: 7},
+		Labels:          []byte{0xde, 0xad},
+		LabelsMask:      []byte{0xff, 0xff},
+		Mark:            0x1234, MarkMask: 0xffff, Use: 1,
+		SynProxy: SynProxy{ISN: 0x12345678, ITS: 0x87654321, TSOff: 0xabcdef00},
+	}
+
+	b, err := f.MarshalBinary()
+	require.NoError(t, err)
+
+	var got Flow
+	require.NoError(t, got.UnmarshalBinary(b))
+
+	if diff := cmp.Diff(f, got); diff != "" {
+		t.Fatalf("unexpected round-trip result (-want +got):\n%s", diff)
+	}
+
+	// The encoding is compatible with the attributes of Flows received from the kernel.
+	var kf Flow
+	attrs := append(append([]netfilter.Attribute{}, corpusFlow[0].attrs...), corpusFlow[1].attrs...)
+	require.NoError(t, kf.unmarshal(mustDecodeAttributes(attrs)))
+	b, err = kf.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, got.UnmarshalBinary(b))
+	assert.Equal(t, kf, got)
+
+	_, err = Flow{}.MarshalBinary()
+	assert.EqualError(t, err, errNeedTuples.Error())
+
+	assert.Error(t, got.UnmarshalBinary([]byte{1}))
+	assert.EqualError(t, got.UnmarshalBinary([]byte{4, 0, 1, 0}), "Tuple unmarshal: need a Nested attribute to decode this structure")
+}
+
 func TestUnmarshalFlowsError(t *testing.T) {
 
 	_, err := unmarshalFlows([]netlink.Message{{}})
@@ -552,3 +630,120 @@ func BenchmarkFlowUnmarshal(b *testing.B) {
 		_ = f.unmarshal(iad)
 	}
 }
+
+func TestFlowAgeDuration(t *testing.T) {
+
+	start := time.Unix(1000, 0)
+	now := start.Add(time.Minute)
+
+	var f Flow
+	_, err := f.age(now)
+	assert.Equal(t, ErrTimestampUnavailable, err)
+	_, err = f.duration(now)
+	assert.Equal(t, ErrTimestampUnavailable, err)
+
+	// Stop timestamp without a start timestamp.
+	f.Timestamp.Stop = start
+	_, err = f.duration(now)
+	assert.Equal(t, ErrTimestampUnavailable, err)
+
+	// Active flow.
+	f.Timestamp = Timestamp{Start: start}
+	age, err := f.age(now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, age)
+
+	d, err := f.duration(now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, d)
+
+	// Destroyed flow.
+	f.Timestamp.Stop = start.Add(10 * time.Second)
+	age, err = f.age(now)
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, age)
+
+	d, err = f.duration(now)
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, d)
+
+	age, err = f.Age()
+	require.NoError(t, err)
+	assert.True(t, age > 0)
+
+	d, err = f.Duration()
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Second, d)
+}
+
+func TestFlowCounters(t *testing.T) {
+
+	f := Flow{
+		CountersOrig:  Counter{Packets: 1, Bytes: 60},
+		CountersReply: Counter{Direction: true, Packets: 2, Bytes: 1500},
+	}
+
+	assert.Equal(t, uint64(1), f.Packets(DirOrig))
+	assert.Equal(t, uint64(2), f.Packets(DirReply))
+	assert.Equal(t, uint64(60), f.Bytes(DirOrig))
+	assert.Equal(t, uint64(1500), f.Bytes(DirReply))
+	assert.Equal(t, uint64(3), f.TotalPackets())
+	assert.Equal(t, uint64(1560), f.TotalBytes())
+
+	assert.Equal(t, "orig", DirOrig.String())
+	assert.Equal(t, DirReply, f.CountersReply.Dir())
+	assert.Equal(t, "[reply: 2 pkts/1500 B]", f.CountersReply.String())
+
+	var ctr Counter
+	ctr.SetDir(DirReply)
+	assert.True(t, ctr.Direction)
+	ctr.SetDir(DirOrig)
+	assert.Equal(t, DirOrig, ctr.Dir())
+}
+
+func TestFlowKey(t *testing.T) {
+
+	f := NewFlow(6, 0, net.IPv4(192, 0, 2, 2), net.IPv4(192, 0, 2, 1), 1234, 80, 0, 0)
+
+	// The same connection observed from the responder's side.
+	r := f
+	r.TupleOrig, r.TupleReply = f.TupleReply, f.TupleOrig
+	assert.Equal(t, f.Key(), r.Key())
+
+	// A Flow built from a single Tuple.
+	assert.Equal(t, f.Key(), Flow{TupleOrig: f.TupleReply}.Key())
+
+	// A masqueraded connection, swapped and not.
+	nat := f
+	nat.TupleReply.IP.DestinationAddress = net.IPv4(198, 51, 100, 1)
+	nat.TupleReply.Proto.DestinationPort = 61000
+	swapped := nat
+	swapped.TupleOrig, swapped.TupleReply = nat.TupleReply, nat.TupleOrig
+	assert.Equal(t, nat.Key(), swapped.Key())
+	assert.Equal(t, Flow{TupleOrig: nat.TupleOrig}.Key(), nat.Key(), "original tuple sorts first")
+
+	// Zones and other connections yield different keys.
+	z := f
+	z.Zone = 1
+	assert.NotEqual(t, f.Key(), z.Key())
+
+	o := NewFlow(6, 0, net.IPv4(192, 0, 2, 2), net.IPv4(192, 0, 2, 1), 1235, 80, 0, 0)
+	assert.NotEqual(t, f.Key(), o.Key())
+
+	keys := map[FlowKey]bool{f.Key(): true}
+	assert.True(t, keys[r.Key()])
+}
+
+func TestFlowCanonical(t *testing.T) {
+
+	f := NewFlow(6, 0, net.ParseIP("192.0.2.1"), net.IPv4(192, 0, 2, 2), 1234, 80, 0, 0)
+	f.NATSrc = NATRange{MinIP: net.ParseIP("198.51.100.1"), MaxIP: net.ParseIP("198.51.100.2")}
+
+	g := NewFlow(6, 0, net.IP{192, 0, 2, 1}, net.IP{192, 0, 2, 2}, 1234, 80, 0, 0)
+	g.NATSrc = NATRange{MinIP: net.IP{198, 51, 100, 1}, MaxIP: net.IP{198, 51, 100, 2}}
+
+	assert.NotEqual(t, f, g)
+	assert.Equal(t, f.Canonical(), g.Canonical())
+	assert.Equal(t, g, f.Canonical())
+	assert.Nil(t, f.Canonical().TupleMaster.IP.SourceAddress)
+}
@@ -1,6 +1,6 @@
 module github.com/ti-mo/conntrack
 
-go 1.12
+go 1.21
 
 require (
 	github.com/google/go-cmp v0.5.2
@@ -9,6 +9,12 @@ require (
 	github.com/stretchr/testify v1.4.0
 	github.com/ti-mo/netfilter v0.3.1
 	github.com/vishvananda/netns v0.0.0-20180720170159-13995c7128cc
-	golang.org/x/net v0.0.0-20201016165138-7b1cca2348c0 // indirect
 	golang.org/x/sys v0.0.0-20201017003518-b09fb700fbb7
 )
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/net v0.0.0-20201016165138-7b1cca2348c0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
+)
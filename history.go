@@ -0,0 +1,227 @@
+package conntrack
+
+import (
+	"encoding/json"
+	"io"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// A HistoryEntry is an Event retained by a History, along with the time it was added.
+type HistoryEntry struct {
+	Time  time.Time `json:"time"`
+	Event Event     `json:"event"`
+}
+
+// A History retains the last Events of the connections in the Conntrack table for
+// post-mortem debugging, answering what was seen for a connection recently. Feed it
+// the Events received from Listen using Add. Events without Flow, like those about
+// expectations, are not retained.
+//
+// A History can be dumped as JSON on demand, for example when receiving SIGUSR1:
+//
+//	sig := make(chan os.Signal, 1)
+//	signal.Notify(sig, syscall.SIGUSR1)
+//	go func() {
+//		for range sig {
+//			h.WriteJSON(os.Stderr)
+//		}
+//	}()
+//
+// A History is safe for concurrent use.
+type History struct {
+	size, perFlow int
+
+	now func() time.Time
+
+	mu sync.Mutex
+	// oldest and newest are the ends of the list of retained entries.
+	oldest, newest *historyEntry
+	n              int
+	// flows indexes the entries of each connection from oldest to newest.
+	flows map[FlowKey][]*historyEntry
+}
+
+// historyEntry is a HistoryEntry in a History's list of retained entries.
+type historyEntry struct {
+	HistoryEntry
+	key        FlowKey
+	prev, next *historyEntry
+}
+
+// NewHistory returns a History retaining the last size Events. If perFlow is positive,
+// at most the last perFlow Events are retained per connection, so busy connections
+// don't evict the Events of others. Events evicted this way free up their room,
+// which goes to the Events of other connections.
+func NewHistory(size, perFlow int) *History {
+	return &History{
+		size:    max(size, 1),
+		perFlow: perFlow,
+		now:     time.Now,
+		flows:   make(map[FlowKey][]*historyEntry),
+	}
+}
+
+// Add adds ev to the History, evicting the oldest Event of ev's connection when it
+// reached its maximum amount of Events, or the oldest Event overall when the History
+// is full. ev's Flow is copied.
+func (h *History) Add(ev Event) {
+
+	if ev.Flow == nil {
+		return
+	}
+
+	f := *ev.Flow
+	ev.Flow = &f
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	e := &historyEntry{
+		HistoryEntry: HistoryEntry{Time: h.now(), Event: ev},
+		key:          f.Key(),
+		prev:         h.newest,
+	}
+
+	if h.newest != nil {
+		h.newest.next = e
+	} else {
+		h.oldest = e
+	}
+	h.newest = e
+	h.n++
+
+	h.flows[e.key] = append(h.flows[e.key], e)
+
+	if h.perFlow > 0 && len(h.flows[e.key]) > h.perFlow {
+		h.remove(h.flows[e.key][0])
+	}
+	if h.n > h.size {
+		h.remove(h.oldest)
+	}
+}
+
+// remove removes e from the History. e must be the oldest entry of its connection.
+// Must be called with h.mu held.
+func (h *History) remove(e *historyEntry) {
+
+	if e.prev != nil {
+		e.prev.next = e.next
+	} else {
+		h.oldest = e.next
+	}
+	if e.next != nil {
+		e.next.prev = e.prev
+	} else {
+		h.newest = e.prev
+	}
+	e.prev, e.next = nil, nil
+	h.n--
+
+	entries := h.flows[e.key]
+	entries[0] = nil
+
+	if len(entries) == 1 {
+		delete(h.flows, e.key)
+		return
+	}
+
+	h.flows[e.key] = entries[1:]
+}
+
+// Len returns the amount of Events retained by the History.
+func (h *History) Len() int {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.n
+}
+
+// Flow returns the retained Events of the connection of f from oldest to newest,
+// regardless of the direction f was observed in. See Flow.Key.
+func (h *History) Flow(f Flow) []HistoryEntry {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := h.flows[f.Key()]
+	out := make([]HistoryEntry, 0, len(entries))
+	for _, e := range entries {
+		out = append(out, e.HistoryEntry)
+	}
+
+	return out
+}
+
+// A HistoryQuery selects Events retained by a History. Zero fields match all Events.
+type HistoryQuery struct {
+	// Prefix matches Events of which any address of the Flow's original
+	// or reply tuple is within the prefix.
+	Prefix netip.Prefix
+
+	// Protocol matches Events with the given layer 4 protocol number.
+	Protocol uint8
+
+	// Port matches Events of which any port of the Flow's original or reply
+	// tuple equals Port.
+	Port uint16
+
+	// Since matches Events added to the History at or after Since.
+	Since time.Time
+}
+
+// match returns true if the HistoryEntry is selected by q.
+func (q HistoryQuery) match(e HistoryEntry) bool {
+
+	if e.Time.Before(q.Since) {
+		return false
+	}
+
+	f := e.Event.Flow
+
+	if q.Protocol != 0 && f.TupleOrig.Proto.Protocol != q.Protocol {
+		return false
+	}
+
+	port, prefix := q.Port == 0, !q.Prefix.IsValid()
+	for _, t := range []Tuple{f.TupleOrig, f.TupleReply} {
+		port = port || t.Proto.SourcePort == q.Port || t.Proto.DestinationPort == q.Port
+		prefix = prefix || q.Prefix.Contains(t.Source().Addr()) || q.Prefix.Contains(t.Destination().Addr())
+	}
+
+	return port && prefix
+}
+
+// Query returns the retained Events selected by q from oldest to newest.
+func (h *History) Query(q HistoryQuery) []HistoryEntry {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var out []HistoryEntry
+	for e := h.oldest; e != nil; e = e.next {
+		if q.match(e.HistoryEntry) {
+			out = append(out, e.HistoryEntry)
+		}
+	}
+
+	return out
+}
+
+// Entries returns all retained Events from oldest to newest.
+func (h *History) Entries() []HistoryEntry {
+	return h.Query(HistoryQuery{})
+}
+
+// WriteJSON writes all retained Events to w as a JSON array, from oldest to newest.
+func (h *History) WriteJSON(w io.Writer) error {
+
+	entries := h.Entries()
+	if entries == nil {
+		entries = []HistoryEntry{}
+	}
+
+	return json.NewEncoder(w).Encode(entries)
+}
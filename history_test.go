@@ -0,0 +1,175 @@
+package conntrack
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// historyClock returns a clock for a History advancing by a second on every call.
+func historyClock() func() time.Time {
+	t := time.Unix(0, 0)
+	return func() time.Time {
+		t = t.Add(time.Second)
+		return t
+	}
+}
+
+func historyEvent(et eventType, proto uint8, src net.IP, sport uint16) Event {
+	dst := net.IPv4(192, 0, 2, 1)
+	if src.To4() == nil {
+		dst = net.ParseIP("2001:db8::53")
+	}
+	f := NewFlow(proto, 0, src, dst, sport, 53, 120, 0)
+	return Event{Type: et, Flow: &f}
+}
+
+func historyPorts(entries []HistoryEntry) []uint16 {
+	var ports []uint16
+	for _, e := range entries {
+		ports = append(ports, e.Event.Flow.TupleOrig.Proto.SourcePort)
+	}
+	return ports
+}
+
+func TestHistory(t *testing.T) {
+
+	h := NewHistory(4, 0)
+	h.now = historyClock()
+
+	h.Add(Event{Type: EventExpNew, Expect: &Expect{}})
+	assert.Equal(t, 0, h.Len())
+
+	for i := uint16(1); i <= 6; i++ {
+		h.Add(historyEvent(EventNew, 17, net.IPv4(198, 51, 100, 1), i))
+	}
+
+	assert.Equal(t, 4, h.Len())
+	assert.Equal(t, []uint16{3, 4, 5, 6}, historyPorts(h.Entries()))
+	assert.Equal(t, time.Unix(3, 0), h.Entries()[0].Time)
+
+	// Events are looked up regardless of direction.
+	ev := historyEvent(EventUpdate, 17, net.IPv4(198, 51, 100, 1), 6)
+	h.Add(ev)
+	r := *ev.Flow
+	r.TupleOrig, r.TupleReply = r.TupleReply, r.TupleOrig
+	entries := h.Flow(r)
+	require.Len(t, entries, 2)
+	assert.Equal(t, EventNew, entries[0].Event.Type)
+	assert.Equal(t, EventUpdate, entries[1].Event.Type)
+	assert.Empty(t, h.Flow(*historyEvent(EventNew, 17, net.IPv4(198, 51, 100, 1), 1).Flow))
+
+	// The Flow is copied.
+	ev.Flow.Mark = 1
+	assert.Zero(t, h.Flow(r)[1].Event.Flow.Mark)
+}
+
+func TestHistoryPerFlow(t *testing.T) {
+
+	h := NewHistory(8, 2)
+	h.now = historyClock()
+
+	h.Add(historyEvent(EventNew, 17, net.IPv4(198, 51, 100, 1), 1))
+	for i := 0; i < 4; i++ {
+		h.Add(historyEvent(EventUpdate, 17, net.IPv4(198, 51, 100, 1), 2))
+	}
+
+	assert.Equal(t, 3, h.Len())
+	assert.Equal(t, []uint16{1, 2, 2}, historyPorts(h.Entries()))
+	assert.Equal(t, time.Unix(5, 0), h.Entries()[2].Time)
+
+	// Evicted entries free up their room.
+	for i := uint16(10); i < 15; i++ {
+		h.Add(historyEvent(EventNew, 17, net.IPv4(198, 51, 100, 1), i))
+	}
+	assert.Equal(t, []uint16{1, 2, 2, 10, 11, 12, 13, 14}, historyPorts(h.Entries()))
+
+	h.Add(historyEvent(EventNew, 17, net.IPv4(198, 51, 100, 1), 15))
+	assert.Equal(t, []uint16{2, 2, 10, 11, 12, 13, 14, 15}, historyPorts(h.Entries()))
+
+	h.Add(historyEvent(EventNew, 17, net.IPv4(198, 51, 100, 1), 16))
+	assert.Equal(t, []uint16{2, 10, 11, 12, 13, 14, 15, 16}, historyPorts(h.Entries()))
+	assert.Len(t, h.Flow(*historyEvent(EventNew, 17, net.IPv4(198, 51, 100, 1), 2).Flow), 1)
+	assert.Equal(t, 8, h.Len())
+}
+
+func TestHistoryBusyFlow(t *testing.T) {
+
+	h := NewHistory(10, 2)
+	h.now = historyClock()
+
+	quiet := historyEvent(EventNew, 6, net.IPv4(198, 51, 100, 1), 1)
+	h.Add(quiet)
+	for i := 0; i < 20; i++ {
+		h.Add(historyEvent(EventUpdate, 17, net.IPv4(198, 51, 100, 2), 2))
+	}
+
+	// The busy connection keeps its last 2 Events, the quiet one isn't evicted.
+	assert.Equal(t, 3, h.Len())
+	assert.Equal(t, []uint16{1, 2, 2}, historyPorts(h.Entries()))
+	require.Len(t, h.Flow(*quiet.Flow), 1)
+	assert.Equal(t, time.Unix(1, 0), h.Flow(*quiet.Flow)[0].Time)
+	assert.Equal(t, time.Unix(21, 0), h.Entries()[2].Time)
+}
+
+func TestHistoryQuery(t *testing.T) {
+
+	h := NewHistory(10, 0)
+	h.now = historyClock()
+
+	h.Add(historyEvent(EventNew, 17, net.IPv4(198, 51, 100, 1), 1))
+	h.Add(historyEvent(EventNew, 6, net.IPv4(198, 51, 100, 2), 2))
+	h.Add(historyEvent(EventNew, 6, net.IPv4(203, 0, 113, 1), 3))
+	h.Add(historyEvent(EventNew, 17, net.ParseIP("2001:db8::1"), 4))
+
+	tests := []struct {
+		name  string
+		q     HistoryQuery
+		ports []uint16
+	}{
+		{name: "all", ports: []uint16{1, 2, 3, 4}},
+		{name: "prefix", q: HistoryQuery{Prefix: netip.MustParsePrefix("198.51.100.0/24")}, ports: []uint16{1, 2}},
+		{name: "destination prefix", q: HistoryQuery{Prefix: netip.MustParsePrefix("192.0.2.1/32")}, ports: []uint16{1, 2, 3}},
+		{name: "v6 prefix", q: HistoryQuery{Prefix: netip.MustParsePrefix("2001:db8::/32")}, ports: []uint16{4}},
+		{name: "protocol", q: HistoryQuery{Protocol: 6}, ports: []uint16{2, 3}},
+		{name: "source port", q: HistoryQuery{Port: 3}, ports: []uint16{3}},
+		{name: "destination port", q: HistoryQuery{Port: 53, Protocol: 17}, ports: []uint16{1, 4}},
+		{name: "since", q: HistoryQuery{Since: time.Unix(3, 0)}, ports: []uint16{3, 4}},
+		{name: "combined", q: HistoryQuery{Prefix: netip.MustParsePrefix("198.51.100.0/24"), Port: 2}, ports: []uint16{2}},
+		{name: "none", q: HistoryQuery{Port: 5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.ports, historyPorts(h.Query(tt.q)))
+		})
+	}
+}
+
+func TestHistoryWriteJSON(t *testing.T) {
+
+	h := NewHistory(2, 0)
+	h.now = historyClock()
+
+	var buf bytes.Buffer
+	require.NoError(t, h.WriteJSON(&buf))
+	assert.Equal(t, "[]\n", buf.String())
+
+	h.Add(historyEvent(EventDestroy, 17, net.IPv4(198, 51, 100, 1), 1))
+
+	buf.Reset()
+	require.NoError(t, h.WriteJSON(&buf))
+
+	var entries []HistoryEntry
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entries))
+	require.Len(t, entries, 1)
+	assert.True(t, entries[0].Time.Equal(time.Unix(1, 0)))
+	assert.Equal(t, EventDestroy, entries[0].Event.Type)
+	assert.Contains(t, buf.String(), `"type":"destroy"`)
+}
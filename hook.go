@@ -0,0 +1,162 @@
+package conntrack
+
+import (
+	"context"
+	"time"
+
+	"github.com/mdlayher/netlink"
+)
+
+// A SendHook is called with every request a Conn sends to the kernel, like the
+// requests made by Dump, Get or Create, before it is sent. BeforeSend may inspect
+// or modify the request, or return an error to fail the operation without sending
+// it, for example to inject faults in tests. ctx is the context of the operation.
+// BeforeSend may be called by multiple goroutines concurrently.
+type SendHook interface {
+	BeforeSend(ctx context.Context, req netlink.Message) (netlink.Message, error)
+}
+
+// SendHookFunc adapts a function to a SendHook.
+type SendHookFunc func(ctx context.Context, req netlink.Message) (netlink.Message, error)
+
+// BeforeSend calls f.
+func (f SendHookFunc) BeforeSend(ctx context.Context, req netlink.Message) (netlink.Message, error) {
+	return f(ctx, req)
+}
+
+// A Receipt describes Netlink messages received by a Conn, either the replies
+// to a request or an event received by a Listen worker. The replies to a dump
+// decoded as they are read from the socket, like DumpPages', are described by
+// one Receipt per datagram, all with the same Request.
+type Receipt struct {
+	// Request is the request the messages reply to, as sent to the kernel after
+	// all SendHooks. Nil for events.
+	Request *netlink.Message
+
+	// Messages are the messages received. Nil if Err is set.
+	Messages []netlink.Message
+
+	// Err is the error receiving the messages, like an error returned by the kernel.
+	Err error
+
+	// Start is the time the request was sent, or the time the Listen worker
+	// started waiting for the event. For the later datagrams of a streamed dump,
+	// the time the Conn started reading the datagram.
+	Start time.Time
+
+	// Duration is the time the Conn waited for the messages. For requests, the
+	// round trip time to the kernel. For events, the time the worker was idle.
+	Duration time.Duration
+}
+
+// A ReceiveHook is called with all Netlink messages a Conn receives, before they
+// are decoded. AfterReceive returns the messages and error the Conn continues with,
+// allowing it to inspect, modify or drop messages, observe latencies, or replace
+// the outcome with an error. Returning an error stops a Listen worker like any
+// other receive error. ctx is the context of the operation as returned by the Conn's
+// OperationHooks, which derive it from the background context for events.
+// AfterReceive may be called by multiple goroutines concurrently.
+// Dropping all replies to a request expecting one, like Get's, fails the request.
+//
+// Messages are accounted for in ConnStats and passed to the Conn's Recorder as
+// read from the socket, before ReceiveHooks are called.
+type ReceiveHook interface {
+	AfterReceive(ctx context.Context, r Receipt) ([]netlink.Message, error)
+}
+
+// ReceiveHookFunc adapts a function to a ReceiveHook.
+type ReceiveHookFunc func(ctx context.Context, r Receipt) ([]netlink.Message, error)
+
+// AfterReceive calls f.
+func (f ReceiveHookFunc) AfterReceive(ctx context.Context, r Receipt) ([]netlink.Message, error) {
+	return f(ctx, r)
+}
+
+// An OperationHook is called when a Conn starts an operation, like a call to Dump,
+// Get or Create, or a Listen worker reading and decoding an event. name is the name
+// of the Conn method, or "Listen" for events. StartOperation returns the context the
+// operation continues with, passed to the Conn's SendHooks and ReceiveHooks, and a
+// function called when the operation finished, including retries of interrupted dumps
+// and decoding the replies. err is the error the operation returns, and n the amount
+// of Flows, Expects, Stats or Events it produced if err is nil. StartOperation may
+// be called by multiple goroutines concurrently.
+type OperationHook interface {
+	StartOperation(ctx context.Context, name string) (context.Context, func(n int, err error))
+}
+
+// OperationHookFunc adapts a function to an OperationHook.
+type OperationHookFunc func(ctx context.Context, name string) (context.Context, func(n int, err error))
+
+// StartOperation calls f.
+func (f OperationHookFunc) StartOperation(ctx context.Context, name string) (context.Context, func(n int, err error)) {
+	return f(ctx, name)
+}
+
+// WithSendHook adds h to the Conn's SendHooks. SendHooks are called in the order
+// they were added, each with the request returned by the previous one.
+func WithSendHook(h SendHook) Option {
+	return func(c *Conn) {
+		c.sendHooks = append(c.sendHooks, h)
+	}
+}
+
+// WithReceiveHook adds h to the Conn's ReceiveHooks. ReceiveHooks are called in the
+// order they were added, each with the messages and error returned by the previous one.
+func WithReceiveHook(h ReceiveHook) Option {
+	return func(c *Conn) {
+		c.receiveHooks = append(c.receiveHooks, h)
+	}
+}
+
+// WithOperationHook adds h to the Conn's OperationHooks. OperationHooks are started
+// in the order they were added, each with the context returned by the previous one,
+// and finished in reverse order.
+func WithOperationHook(h OperationHook) Option {
+	return func(c *Conn) {
+		c.operationHooks = append(c.operationHooks, h)
+	}
+}
+
+// startOperation starts the Conn's OperationHooks for the operation name. Returns
+// the context to continue the operation with, and the function finishing the hooks.
+func (c *Conn) startOperation(ctx context.Context, name string) (context.Context, func(int, error)) {
+
+	if c.operationHooks == nil {
+		return ctx, func(int, error) {}
+	}
+
+	ends := make([]func(int, error), len(c.operationHooks))
+	for i, h := range c.operationHooks {
+		ctx, ends[i] = h.StartOperation(ctx, name)
+	}
+
+	return ctx, func(n int, err error) {
+		for i := len(ends) - 1; i >= 0; i-- {
+			ends[i](n, err)
+		}
+	}
+}
+
+// beforeSend passes req through the Conn's SendHooks.
+func (c *Conn) beforeSend(ctx context.Context, req netlink.Message) (netlink.Message, error) {
+
+	var err error
+	for _, h := range c.sendHooks {
+		if req, err = h.BeforeSend(ctx, req); err != nil {
+			return req, err
+		}
+	}
+
+	return req, nil
+}
+
+// afterReceive passes r through the Conn's ReceiveHooks and returns the messages
+// and error returned by the last one.
+func (c *Conn) afterReceive(ctx context.Context, r Receipt) ([]netlink.Message, error) {
+
+	for _, h := range c.receiveHooks {
+		r.Messages, r.Err = h.AfterReceive(ctx, r)
+	}
+
+	return r.Messages, r.Err
+}
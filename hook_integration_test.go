@@ -0,0 +1,222 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+func TestConnHooks(t *testing.T) {
+
+	c, nsid, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	errFault := errors.New("injected fault")
+	deleteType := netlink.HeaderType(netfilter.NFSubsysCTNetlink)<<8 | netlink.HeaderType(ctDelete)
+
+	var sent int
+	WithSendHook(SendHookFunc(func(_ context.Context, req netlink.Message) (netlink.Message, error) {
+		sent++
+		if req.Header.Type == deleteType {
+			return req, errFault
+		}
+		return req, nil
+	}))(c)
+
+	var receipts []Receipt
+	WithReceiveHook(ReceiveHookFunc(func(_ context.Context, r Receipt) ([]netlink.Message, error) {
+		receipts = append(receipts, r)
+		return r.Messages, r.Err
+	}))(c)
+
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0)
+	require.NoError(t, c.Create(f))
+
+	got, err := c.Get(f)
+	require.NoError(t, err)
+	assert.Equal(t, f.TupleOrig.Proto, got.TupleOrig.Proto)
+
+	// The injected fault fails Delete without sending the request.
+	assert.Equal(t, errFault, c.Delete(f))
+	assert.Equal(t, 3, sent)
+	require.Len(t, receipts, 2)
+
+	for _, r := range receipts {
+		require.NotNil(t, r.Request)
+		assert.NoError(t, r.Err)
+		assert.NotEmpty(t, r.Messages)
+		assert.False(t, r.Start.IsZero())
+		assert.True(t, r.Duration > 0)
+	}
+
+	// Kernel errors are passed to ReceiveHooks.
+	_, err = c.Get(NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 54, 120, 0))
+	require.Error(t, err)
+	require.Len(t, receipts, 3)
+	assert.Equal(t, err, receipts[2].Err)
+
+	// A ReceiveHook dropping the events of the first Flow.
+	lc, err := Dial(&netlink.Config{NetNS: nsid}, WithReceiveHook(ReceiveHookFunc(func(_ context.Context, r Receipt) ([]netlink.Message, error) {
+		if r.Request != nil || r.Err != nil {
+			return r.Messages, r.Err
+		}
+		var ev Event
+		if err := ev.unmarshal(r.Messages[0]); err == nil && ev.Flow.TupleOrig.Proto.DestinationPort == 53 {
+			return nil, nil
+		}
+		return r.Messages, nil
+	})))
+	require.NoError(t, err)
+
+	var mu sync.Mutex
+	var ports []uint16
+	lc.OnNew(func(f Flow) {
+		mu.Lock()
+		ports = append(ports, f.TupleOrig.Proto.DestinationPort)
+		mu.Unlock()
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error)
+	go func() { served <- lc.Serve(ctx, 1) }()
+
+	// Wait for the worker to join the group.
+	time.Sleep(100 * time.Millisecond)
+
+	c.sendHooks = nil
+	require.NoError(t, c.Delete(f))
+	require.NoError(t, c.Create(f))
+	f.TupleOrig.Proto.DestinationPort, f.TupleReply.Proto.SourcePort = 55, 55
+	require.NoError(t, c.Create(f))
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(ports) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-served)
+
+	mu.Lock()
+	assert.Equal(t, []uint16{55}, ports)
+	mu.Unlock()
+}
+
+func TestConnHooksDropReplies(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0)
+	require.NoError(t, c.Create(f))
+
+	// A ReceiveHook dropping all replies fails requests expecting one.
+	WithReceiveHook(ReceiveHookFunc(func(_ context.Context, r Receipt) ([]netlink.Message, error) {
+		return nil, r.Err
+	}))(c)
+
+	_, err = c.Get(f)
+	assert.Equal(t, errNoReply, err)
+
+	_, err = c.StatsGlobal()
+	assert.Equal(t, errNoReply, err)
+
+	// Dumps are empty.
+	flows, err := c.Dump()
+	require.NoError(t, err)
+	assert.Empty(t, flows)
+}
+
+func TestConnOperationHooks(t *testing.T) {
+
+	_, nsid, err := makeNSConn()
+	require.NoError(t, err)
+
+	type key struct{}
+	type op struct {
+		name string
+		n    int
+		err  error
+	}
+
+	var mu sync.Mutex
+	var ops []op
+	var inOp []bool
+
+	opts := []Option{
+		WithOperationHook(OperationHookFunc(func(ctx context.Context, name string) (context.Context, func(int, error)) {
+			return context.WithValue(ctx, key{}, name), func(n int, err error) {
+				mu.Lock()
+				ops = append(ops, op{name, n, err})
+				mu.Unlock()
+			}
+		})),
+		WithReceiveHook(ReceiveHookFunc(func(ctx context.Context, r Receipt) ([]netlink.Message, error) {
+			mu.Lock()
+			inOp = append(inOp, ctx.Value(key{}) != nil)
+			mu.Unlock()
+			return r.Messages, r.Err
+		})),
+	}
+
+	c, err := Dial(&netlink.Config{NetNS: nsid}, opts...)
+	require.NoError(t, err)
+	defer c.Close()
+
+	// The listening Conn is not closed, since its worker can't be stopped
+	// while it is waiting in Receive.
+	lc, err := Dial(&netlink.Config{NetNS: nsid}, opts...)
+	require.NoError(t, err)
+
+	evChan := make(chan Event, 8)
+	errChan, err := lc.Listen(evChan, 1, GroupsCT)
+	require.NoError(t, err)
+
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0)
+	require.NoError(t, c.Create(f))
+
+	select {
+	case <-evChan:
+	case err := <-errChan:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+
+	flows, err := c.Dump()
+	require.NoError(t, err)
+	require.Len(t, flows, 1)
+
+	_, err = c.Get(NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 54, 120, 0))
+	require.Error(t, err)
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The event may be decoded before Create returns.
+	assert.ElementsMatch(t, []op{
+		{"Create", 0, nil},
+		{"Listen", 1, nil},
+		{"Dump", 1, nil},
+		{"Get", 1, err},
+	}, ops)
+
+	// Replies and events are received within their operation.
+	assert.Len(t, inOp, 4)
+	for _, in := range inOp {
+		assert.True(t, in)
+	}
+}
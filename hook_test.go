@@ -0,0 +1,115 @@
+package conntrack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnBeforeSend(t *testing.T) {
+
+	var c Conn
+
+	req, err := c.beforeSend(context.Background(), netlink.Message{Data: []byte{1}})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, req.Data)
+
+	var calls []string
+	WithSendHook(SendHookFunc(func(_ context.Context, req netlink.Message) (netlink.Message, error) {
+		calls = append(calls, "first")
+		req.Data = append(req.Data, 2)
+		return req, nil
+	}))(&c)
+	WithSendHook(SendHookFunc(func(_ context.Context, req netlink.Message) (netlink.Message, error) {
+		calls = append(calls, "second")
+		req.Header.Flags |= netlink.Acknowledge
+		return req, nil
+	}))(&c)
+
+	req, err = c.beforeSend(context.Background(), netlink.Message{Data: []byte{1}})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, req.Data)
+	assert.Equal(t, netlink.Acknowledge, req.Header.Flags)
+	assert.Equal(t, []string{"first", "second"}, calls)
+
+	// A failing hook stops the chain.
+	errFault := errors.New("injected fault")
+	c.sendHooks = append([]SendHook{SendHookFunc(func(context.Context, netlink.Message) (netlink.Message, error) {
+		return netlink.Message{}, errFault
+	})}, c.sendHooks...)
+
+	calls = nil
+	_, err = c.beforeSend(context.Background(), netlink.Message{})
+	assert.Equal(t, errFault, err)
+	assert.Empty(t, calls)
+}
+
+func TestConnAfterReceive(t *testing.T) {
+
+	var c Conn
+
+	msgs := []netlink.Message{{Data: []byte{1}}, {Data: []byte{2}}}
+	req := netlink.Message{Data: []byte{0}}
+	start := time.Now()
+	errFault := errors.New("injected fault")
+
+	var seen []Receipt
+	WithReceiveHook(ReceiveHookFunc(func(_ context.Context, r Receipt) ([]netlink.Message, error) {
+		seen = append(seen, r)
+		return r.Messages[1:], nil
+	}))(&c)
+	WithReceiveHook(ReceiveHookFunc(func(_ context.Context, r Receipt) ([]netlink.Message, error) {
+		seen = append(seen, r)
+		return nil, errFault
+	}))(&c)
+
+	out, err := c.afterReceive(context.Background(), Receipt{Request: &req, Messages: msgs, Start: start, Duration: time.Millisecond})
+	assert.Nil(t, out)
+	assert.Equal(t, errFault, err)
+
+	require.Len(t, seen, 2)
+	assert.Equal(t, msgs, seen[0].Messages)
+	assert.Equal(t, msgs[1:], seen[1].Messages)
+	assert.Equal(t, &req, seen[1].Request)
+	assert.Equal(t, start, seen[1].Start)
+	assert.Equal(t, time.Millisecond, seen[1].Duration)
+}
+
+func TestConnStartOperation(t *testing.T) {
+
+	var c Conn
+
+	ctx, end := c.startOperation(context.Background(), "Dump")
+	assert.Equal(t, context.Background(), ctx)
+	end(0, nil)
+
+	type key string
+	var calls []string
+	for _, name := range []string{"first", "second"} {
+		name := name
+		WithOperationHook(OperationHookFunc(func(ctx context.Context, op string) (context.Context, func(int, error)) {
+			calls = append(calls, name+" start "+op)
+			return context.WithValue(ctx, key(name), true), func(n int, err error) {
+				calls = append(calls, fmt.Sprintf("%s end %d %v", name, n, err))
+			}
+		}))(&c)
+	}
+
+	ctx, end = c.startOperation(context.Background(), "Dump")
+	assert.Equal(t, true, ctx.Value(key("first")))
+	assert.Equal(t, true, ctx.Value(key("second")))
+
+	end(3, errors.New("injected fault"))
+	assert.Equal(t, []string{
+		"first start Dump",
+		"second start Dump",
+		"second end 3 injected fault",
+		"first end 3 injected fault",
+	}, calls)
+}
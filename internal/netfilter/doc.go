@@ -0,0 +1,9 @@
+// Package netfilter provides the parts of github.com/ti-mo/netfilter used by this
+// module, so the module can be built on platforms other than Linux.
+//
+// github.com/ti-mo/netfilter only builds on Linux, since its Dial depends on the
+// Netlink protocol number of Netfilter. On Linux, this package re-exports it using
+// type aliases, so its types are interchangeable with the ones of this package. On
+// other platforms, it only declares its types and constants, and encoding and
+// decoding messages fails with ErrNotImplemented.
+package netfilter
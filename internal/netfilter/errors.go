@@ -0,0 +1,7 @@
+package netfilter
+
+import "errors"
+
+// ErrNotImplemented is returned on platforms other than Linux by everything
+// needing the Netfilter Netlink protocol, like encoding and decoding messages.
+var ErrNotImplemented = errors.New("conntrack is only implemented on Linux")
@@ -0,0 +1,77 @@
+package netfilter
+
+import (
+	"github.com/mdlayher/netlink"
+	"github.com/ti-mo/netfilter"
+)
+
+type (
+	Attribute    = netfilter.Attribute
+	Header       = netfilter.Header
+	MessageType  = netfilter.MessageType
+	NetlinkGroup = netfilter.NetlinkGroup
+	ProtoFamily  = netfilter.ProtoFamily
+	SubsystemID  = netfilter.SubsystemID
+)
+
+const (
+	NFSubsysCTNetlink    = netfilter.NFSubsysCTNetlink
+	NFSubsysCTNetlinkExp = netfilter.NFSubsysCTNetlinkExp
+	NFSubsysQueue        = netfilter.NFSubsysQueue
+
+	ProtoUnspec = netfilter.ProtoUnspec
+	ProtoIPv4   = netfilter.ProtoIPv4
+	ProtoIPv6   = netfilter.ProtoIPv6
+
+	GroupCTNew        = netfilter.GroupCTNew
+	GroupCTUpdate     = netfilter.GroupCTUpdate
+	GroupCTDestroy    = netfilter.GroupCTDestroy
+	GroupCTExpNew     = netfilter.GroupCTExpNew
+	GroupCTExpUpdate  = netfilter.GroupCTExpUpdate
+	GroupCTExpDestroy = netfilter.GroupCTExpDestroy
+)
+
+var (
+	GroupsCT    = netfilter.GroupsCT
+	GroupsCTExp = netfilter.GroupsCTExp
+)
+
+// NewAttributeDecoder returns a netlink.AttributeDecoder decoding big-endian attributes.
+func NewAttributeDecoder(b []byte) (*netlink.AttributeDecoder, error) {
+	return netfilter.NewAttributeDecoder(b)
+}
+
+// DecodeNetlink returns msg's Netfilter header and an AttributeDecoder of its attributes.
+func DecodeNetlink(msg netlink.Message) (Header, *netlink.AttributeDecoder, error) {
+	return netfilter.DecodeNetlink(msg)
+}
+
+// UnmarshalNetlink unmarshals a netlink.Message into a Netfilter Header and Attributes.
+func UnmarshalNetlink(msg netlink.Message) (Header, []Attribute, error) {
+	return netfilter.UnmarshalNetlink(msg)
+}
+
+// MarshalNetlink marshals a Netfilter Header and Attributes into a netlink.Message.
+func MarshalNetlink(h Header, attrs []Attribute) (netlink.Message, error) {
+	return netfilter.MarshalNetlink(h, attrs)
+}
+
+// MarshalAttributes marshals a nested attribute structure into a byte slice.
+func MarshalAttributes(attrs []Attribute) ([]byte, error) {
+	return netfilter.MarshalAttributes(attrs)
+}
+
+// Uint16Bytes returns the big-endian representation of u.
+func Uint16Bytes(u uint16) []byte {
+	return netfilter.Uint16Bytes(u)
+}
+
+// Uint32Bytes returns the big-endian representation of u.
+func Uint32Bytes(u uint32) []byte {
+	return netfilter.Uint32Bytes(u)
+}
+
+// Uint64Bytes returns the big-endian representation of u.
+func Uint64Bytes(u uint64) []byte {
+	return netfilter.Uint64Bytes(u)
+}
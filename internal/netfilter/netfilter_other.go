@@ -0,0 +1,119 @@
+//go:build !linux
+
+package netfilter
+
+import (
+	"encoding/binary"
+
+	"github.com/mdlayher/netlink"
+)
+
+// Stubs of github.com/ti-mo/netfilter's types, so code using them can be built.
+// Encoding and decoding messages fails with ErrNotImplemented.
+
+type (
+	SubsystemID  uint8
+	MessageType  uint8
+	ProtoFamily  uint8
+	NetlinkGroup uint8
+)
+
+const (
+	NFSubsysCTNetlink    SubsystemID = 1 // NFNL_SUBSYS_CTNETLINK
+	NFSubsysCTNetlinkExp SubsystemID = 2 // NFNL_SUBSYS_CTNETLINK_EXP
+	NFSubsysQueue        SubsystemID = 3 // NFNL_SUBSYS_QUEUE
+
+	ProtoUnspec ProtoFamily = 0  // NFPROTO_UNSPEC
+	ProtoIPv4   ProtoFamily = 2  // NFPROTO_IPV4
+	ProtoIPv6   ProtoFamily = 10 // NFPROTO_IPV6
+
+	GroupCTNew        NetlinkGroup = 1 // NFNLGRP_CONNTRACK_NEW
+	GroupCTUpdate     NetlinkGroup = 2 // NFNLGRP_CONNTRACK_UPDATE
+	GroupCTDestroy    NetlinkGroup = 3 // NFNLGRP_CONNTRACK_DESTROY
+	GroupCTExpNew     NetlinkGroup = 4 // NFNLGRP_CONNTRACK_EXP_NEW
+	GroupCTExpUpdate  NetlinkGroup = 5 // NFNLGRP_CONNTRACK_EXP_UPDATE
+	GroupCTExpDestroy NetlinkGroup = 6 // NFNLGRP_CONNTRACK_EXP_DESTROY
+)
+
+var (
+	GroupsCT    = []NetlinkGroup{GroupCTNew, GroupCTUpdate, GroupCTDestroy}
+	GroupsCTExp = []NetlinkGroup{GroupCTExpNew, GroupCTExpUpdate, GroupCTExpDestroy}
+)
+
+// Header is a stub of github.com/ti-mo/netfilter's Header.
+type Header struct {
+	Flags netlink.HeaderFlags
+
+	SubsystemID SubsystemID
+	MessageType MessageType
+
+	Family     ProtoFamily
+	Version    uint8
+	ResourceID uint16
+}
+
+// Attribute is a stub of github.com/ti-mo/netfilter's Attribute.
+type Attribute struct {
+	Type uint16
+	Data []byte
+
+	Nested   bool
+	Children []Attribute
+
+	NetByteOrder bool
+}
+
+// Uint32 interprets the Attribute's data in network byte order as a uint32.
+func (a Attribute) Uint32() uint32 {
+	return binary.BigEndian.Uint32(a.Data)
+}
+
+// PutUint16 sets the Attribute's data to v in network byte order.
+func (a *Attribute) PutUint16(v uint16) {
+	a.Data = Uint16Bytes(v)
+}
+
+// PutUint32 sets the Attribute's data to v in network byte order.
+func (a *Attribute) PutUint32(v uint32) {
+	a.Data = Uint32Bytes(v)
+}
+
+// NewAttributeDecoder always returns ErrNotImplemented.
+func NewAttributeDecoder([]byte) (*netlink.AttributeDecoder, error) {
+	return nil, ErrNotImplemented
+}
+
+// DecodeNetlink always returns ErrNotImplemented.
+func DecodeNetlink(netlink.Message) (Header, *netlink.AttributeDecoder, error) {
+	return Header{}, nil, ErrNotImplemented
+}
+
+// UnmarshalNetlink always returns ErrNotImplemented.
+func UnmarshalNetlink(netlink.Message) (Header, []Attribute, error) {
+	return Header{}, nil, ErrNotImplemented
+}
+
+// MarshalNetlink always returns ErrNotImplemented.
+func MarshalNetlink(Header, []Attribute) (netlink.Message, error) {
+	return netlink.Message{}, ErrNotImplemented
+}
+
+// MarshalAttributes always returns ErrNotImplemented.
+func MarshalAttributes([]Attribute) ([]byte, error) {
+	return nil, ErrNotImplemented
+}
+
+// Uint16Bytes returns the big-endian representation of u.
+func Uint16Bytes(u uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, u)
+}
+
+// Uint32Bytes returns the big-endian representation of u.
+func Uint32Bytes(u uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, u)
+}
+
+// Uint64Bytes returns the big-endian representation of u.
+func Uint64Bytes(u uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, u)
+}
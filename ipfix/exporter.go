@@ -0,0 +1,243 @@
+// Package ipfix exports Conntrack Flows as IPFIX (RFC 7011) flow records.
+//
+// Conntrack destroy events carry the final accounting counters and timestamps
+// of a connection, which makes them a natural source of flow records. An
+// Exporter encodes each Flow it is given as a single bidirectional record
+// (RFC 5103), using the reply tuple to fill in the post-NAT addresses and ports.
+// IPv4 and IPv6 Flows are exported using separate templates. Records are
+// buffered and sent in messages that fit a single UDP datagram.
+//
+// Accounting (sysctl net.netfilter.nf_conntrack_acct) and timestamping
+// (sysctl net.netfilter.nf_conntrack_timestamp) need to be enabled in the kernel
+// for destroy events to carry counters and start and stop times. Flows without
+// timestamps are exported as ending at the time they are given to the Exporter.
+//
+// NetFlow v9 is not supported, most collectors accept IPFIX as well.
+package ipfix
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+)
+
+const (
+	// maxMessageSize keeps messages within the payload of a single
+	// UDP datagram on a link with a regular MTU.
+	maxMessageSize = 1400
+
+	// templateRefresh is the interval at which templates are resent.
+	// Collectors receiving over UDP rely on this to (re)learn templates.
+	templateRefresh = time.Minute
+)
+
+var errNoTuple = errors.New("ipfix: flow has no original tuple")
+
+// An Exporter sends Flows as IPFIX records to a collector. It is safe for concurrent use.
+type Exporter struct {
+	w      io.Writer
+	domain uint32
+
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// sets holds the encoded data records pending per template ID.
+	sets map[uint16][]byte
+	// records is the amount of data records pending.
+	records uint32
+	// seq is the amount of data records sent.
+	seq          uint32
+	lastTemplate time.Time
+}
+
+// NewExporter returns an Exporter writing IPFIX messages to w, using observation
+// domain ID domainID. Each message is passed to w in a single Write call.
+func NewExporter(w io.Writer, domainID uint32) *Exporter {
+	return &Exporter{
+		w:      w,
+		domain: domainID,
+		now:    time.Now,
+		sets:   make(map[uint16][]byte),
+	}
+}
+
+// Dial returns an Exporter sending IPFIX messages over UDP to the collector at addr.
+func Dial(addr string, domainID uint32) (*Exporter, error) {
+
+	c, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewExporter(c, domainID), nil
+}
+
+// Export buffers a record describing f. Buffered records are sent when they no
+// longer fit in a single message, or when Flush is called. Returns an error if
+// sending the buffered records fails.
+func (e *Exporter) Export(f conntrack.Flow) error {
+
+	if f.TupleOrig.IP.SourceAddress == nil {
+		return errNoTuple
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	id := templateFor(f)
+
+	var err error
+	if e.size(id)+recordLen(id) > maxMessageSize {
+		err = e.flush()
+	}
+
+	e.sets[id] = appendRecord(e.sets[id], id, f, e.now())
+	e.records++
+
+	return err
+}
+
+// ExportEvent exports the Flow carried by a destroy event. Other Events are ignored.
+func (e *Exporter) ExportEvent(ev conntrack.Event) error {
+
+	if ev.Type != conntrack.EventDestroy || ev.Flow == nil {
+		return nil
+	}
+
+	return e.Export(*ev.Flow)
+}
+
+// Run exports destroy events received on in until in is closed, sending
+// buffered records at least every interval. Buffered records are sent when
+// in is closed. Returns the first error encountered.
+func (e *Exporter) Run(in <-chan conntrack.Event, interval time.Duration) error {
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case ev, ok := <-in:
+			if !ok {
+				return e.Flush()
+			}
+			if err := e.ExportEvent(ev); err != nil {
+				return err
+			}
+		case <-t.C:
+			if err := e.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Flush sends all buffered records. Templates are included in the message
+// when they were last sent more than a minute ago.
+func (e *Exporter) Flush() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.flush()
+}
+
+// Close sends all buffered records and closes the underlying writer if it
+// implements io.Closer.
+func (e *Exporter) Close() error {
+
+	err := e.Flush()
+
+	if c, ok := e.w.(io.Closer); ok {
+		if cerr := c.Close(); err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// templateDue returns true if templates need to be included in the next message.
+// Must be called with e.mu held.
+func (e *Exporter) templateDue(now time.Time) bool {
+	return e.lastTemplate.IsZero() || now.Sub(e.lastTemplate) >= templateRefresh
+}
+
+// size returns the size of the next message if a record of template id were
+// added to it. Must be called with e.mu held.
+func (e *Exporter) size(id uint16) int {
+
+	n := headerLen
+	if e.templateDue(e.now()) {
+		n += len(appendTemplateSet(nil))
+	}
+
+	for _, set := range e.sets {
+		if len(set) > 0 {
+			n += setHeaderLen + len(set)
+		}
+	}
+
+	if len(e.sets[id]) == 0 {
+		n += setHeaderLen
+	}
+
+	return n
+}
+
+// flush implements Flush. Buffered records are discarded even if sending them
+// fails, since there is no guarantee a retry would succeed.
+// Must be called with e.mu held.
+func (e *Exporter) flush() error {
+
+	now := e.now()
+	tmpl := e.templateDue(now)
+
+	if e.records == 0 && !tmpl {
+		return nil
+	}
+
+	b := make([]byte, headerLen, maxMessageSize)
+	binary.BigEndian.PutUint16(b[0:2], version)
+	binary.BigEndian.PutUint32(b[4:8], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(b[8:12], e.seq)
+	binary.BigEndian.PutUint32(b[12:16], e.domain)
+
+	if tmpl {
+		b = appendTemplateSet(b)
+	}
+
+	for _, t := range templates {
+		set := e.sets[t.id]
+		if len(set) == 0 {
+			continue
+		}
+
+		b = binary.BigEndian.AppendUint16(b, t.id)
+		b = binary.BigEndian.AppendUint16(b, uint16(setHeaderLen+len(set)))
+		b = append(b, set...)
+
+		e.sets[t.id] = set[:0]
+	}
+
+	binary.BigEndian.PutUint16(b[2:4], uint16(len(b)))
+
+	records := e.records
+	e.records = 0
+
+	if _, err := e.w.Write(b); err != nil {
+		return err
+	}
+
+	e.seq += records
+	if tmpl {
+		e.lastTemplate = now
+	}
+
+	return nil
+}
@@ -0,0 +1,256 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// messageWriter records every message written to it.
+type messageWriter struct {
+	msgs [][]byte
+}
+
+func (mw *messageWriter) Write(b []byte) (int, error) {
+	mw.msgs = append(mw.msgs, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+// message is a decoded IPFIX message. Data records are split into fields
+// using the templates found in the message.
+type message struct {
+	seq, domain uint32
+	exportTime  uint32
+	templates   map[uint16][]field
+	records     map[uint16][][][]byte
+}
+
+func decode(t *testing.T, b []byte, known map[uint16][]field) message {
+
+	t.Helper()
+
+	require.GreaterOrEqual(t, len(b), headerLen)
+	require.Equal(t, uint16(version), binary.BigEndian.Uint16(b[0:2]))
+	require.Equal(t, len(b), int(binary.BigEndian.Uint16(b[2:4])))
+
+	m := message{
+		exportTime: binary.BigEndian.Uint32(b[4:8]),
+		seq:        binary.BigEndian.Uint32(b[8:12]),
+		domain:     binary.BigEndian.Uint32(b[12:16]),
+		templates:  make(map[uint16][]field),
+		records:    make(map[uint16][][][]byte),
+	}
+
+	b = b[headerLen:]
+	for len(b) > 0 {
+		id := binary.BigEndian.Uint16(b[0:2])
+		l := int(binary.BigEndian.Uint16(b[2:4]))
+		require.LessOrEqual(t, l, len(b))
+		set := b[setHeaderLen:l]
+		b = b[l:]
+
+		if id == templateSetID {
+			for len(set) > 0 {
+				tid := binary.BigEndian.Uint16(set[0:2])
+				n := int(binary.BigEndian.Uint16(set[2:4]))
+				set = set[4:]
+
+				var fields []field
+				for i := 0; i < n; i++ {
+					f := field{id: binary.BigEndian.Uint16(set[0:2]), length: binary.BigEndian.Uint16(set[2:4])}
+					set = set[4:]
+					if f.id&0x8000 != 0 {
+						f.id &^= 0x8000
+						f.pen = binary.BigEndian.Uint32(set[0:4])
+						set = set[4:]
+					}
+					fields = append(fields, f)
+				}
+				m.templates[tid] = fields
+				known[tid] = fields
+			}
+			continue
+		}
+
+		fields, ok := known[id]
+		require.True(t, ok, "data set for unknown template %d", id)
+
+		for len(set) > 0 {
+			var rec [][]byte
+			for _, f := range fields {
+				rec = append(rec, set[:f.length])
+				set = set[f.length:]
+			}
+			m.records[id] = append(m.records[id], rec)
+		}
+	}
+
+	return m
+}
+
+func testFlow() conntrack.Flow {
+
+	f := conntrack.NewFlow(6, 0, net.IPv4(10, 0, 0, 1), net.IPv4(192, 0, 2, 1), 40000, 443, 0, 0)
+	f.TupleReply.IP.DestinationAddress = net.IPv4(198, 51, 100, 1)
+	f.TupleReply.Proto.DestinationPort = 50000
+	f.ID = 42
+	f.CountersOrig = conntrack.Counter{Packets: 10, Bytes: 1000}
+	f.CountersReply = conntrack.Counter{Direction: true, Packets: 20, Bytes: 30000}
+	f.Timestamp = conntrack.Timestamp{Start: time.UnixMilli(1000000), Stop: time.UnixMilli(1005000)}
+
+	return f
+}
+
+func TestExporter(t *testing.T) {
+
+	now := time.Unix(2000, 0)
+
+	var mw messageWriter
+	e := NewExporter(&mw, 7)
+	e.now = func() time.Time { return now }
+
+	tf := testFlow()
+	require.NoError(t, e.ExportEvent(conntrack.Event{Type: conntrack.EventDestroy, Flow: &tf}))
+	require.NoError(t, e.ExportEvent(conntrack.Event{Type: conntrack.EventNew, Flow: &tf}))
+	require.NoError(t, e.Flush())
+	require.Len(t, mw.msgs, 1)
+
+	known := make(map[uint16][]field)
+	m := decode(t, mw.msgs[0], known)
+
+	assert.Equal(t, uint32(7), m.domain)
+	assert.Equal(t, uint32(0), m.seq)
+	assert.Equal(t, uint32(2000), m.exportTime)
+	assert.Len(t, m.templates, 2)
+	require.Len(t, m.records[templateIPv4], 1)
+
+	rec := m.records[templateIPv4][0]
+	fields := m.templates[templateIPv4]
+	get := func(id uint16, pen uint32) []byte {
+		for i, f := range fields {
+			if f.id == id && f.pen == pen {
+				return rec[i]
+			}
+		}
+		t.Fatalf("field %d/%d not in template", pen, id)
+		return nil
+	}
+	u64 := binary.BigEndian.Uint64
+
+	assert.Equal(t, []byte{10, 0, 0, 1}, get(ieSourceIPv4Address, 0))
+	assert.Equal(t, []byte{192, 0, 2, 1}, get(ieDestinationIPv4Address, 0))
+	assert.Equal(t, []byte{198, 51, 100, 1}, get(iePostNATSourceIPv4, 0))
+	assert.Equal(t, []byte{192, 0, 2, 1}, get(iePostNATDestinationIPv4, 0))
+	assert.Equal(t, []byte{0xc3, 0x50}, get(iePostNAPTSourcePort, 0))
+	assert.Equal(t, []byte{0x01, 0xbb}, get(ieDestinationTransportPort, 0))
+	assert.Equal(t, []byte{6}, get(ieProtocolIdentifier, 0))
+	assert.Equal(t, uint64(1000), u64(get(ieOctetDeltaCount, 0)))
+	assert.Equal(t, uint64(20), u64(get(iePacketDeltaCount, reversePEN)))
+	assert.Equal(t, uint64(30000), u64(get(ieOctetDeltaCount, reversePEN)))
+	assert.Equal(t, uint64(1000000), u64(get(ieFlowStartMilliseconds, 0)))
+	assert.Equal(t, uint64(1005000), u64(get(ieFlowEndMilliseconds, 0)))
+	assert.Equal(t, uint64(42), u64(get(ieFlowID, 0)))
+
+	// An IPv6 Flow without timestamps, templates are not resent.
+	f := conntrack.NewFlow(58, 0, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 0, 0, 0, 0)
+	f.TupleOrig.Proto = conntrack.ProtoTuple{Protocol: 58, ICMPv6: true, ICMPType: 128}
+
+	require.NoError(t, e.Export(f))
+	require.NoError(t, e.Flush())
+	require.Len(t, mw.msgs, 2)
+
+	m = decode(t, mw.msgs[1], known)
+	assert.Equal(t, uint32(1), m.seq)
+	assert.Empty(t, m.templates)
+	require.Len(t, m.records[templateIPv6], 1)
+
+	rec = m.records[templateIPv6][0]
+	fields = known[templateIPv6]
+	assert.Equal(t, net.ParseIP("2001:db8::1").To16(), net.IP(get(ieSourceIPv6Address, 0)))
+	assert.Equal(t, []byte{128, 0}, get(ieICMPTypeCodeIPv6, 0))
+	assert.Equal(t, uint64(now.UnixMilli()), u64(get(ieFlowStartMilliseconds, 0)))
+	assert.Equal(t, uint64(now.UnixMilli()), u64(get(ieFlowEndMilliseconds, 0)))
+
+	// Nothing to send.
+	require.NoError(t, e.Flush())
+	assert.Len(t, mw.msgs, 2)
+
+	// Templates are resent periodically, even without records.
+	now = now.Add(templateRefresh)
+	require.NoError(t, e.Flush())
+	require.Len(t, mw.msgs, 3)
+	assert.Len(t, decode(t, mw.msgs[2], known).templates, 2)
+
+	assert.EqualError(t, e.Export(conntrack.Flow{}), errNoTuple.Error())
+}
+
+func TestExporterMessageSize(t *testing.T) {
+
+	var mw messageWriter
+	e := NewExporter(&mw, 0)
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, e.Export(testFlow()))
+	}
+	require.NoError(t, e.Close())
+
+	require.Greater(t, len(mw.msgs), 1)
+
+	known := make(map[uint16][]field)
+	var seq uint32
+	for _, b := range mw.msgs {
+		assert.LessOrEqual(t, len(b), maxMessageSize)
+
+		m := decode(t, b, known)
+		assert.Equal(t, seq, m.seq)
+		seq += uint32(len(m.records[templateIPv4]))
+	}
+
+	assert.Equal(t, uint32(50), seq)
+}
+
+func TestExporterRun(t *testing.T) {
+
+	var mw messageWriter
+	e := NewExporter(&mw, 0)
+
+	f := testFlow()
+	in := make(chan conntrack.Event, 2)
+	in <- conntrack.Event{Type: conntrack.EventDestroy, Flow: &f}
+	in <- conntrack.Event{Type: conntrack.EventUpdate, Flow: &f}
+	close(in)
+
+	require.NoError(t, e.Run(in, time.Hour))
+	require.Len(t, mw.msgs, 1)
+
+	m := decode(t, mw.msgs[0], make(map[uint16][]field))
+	assert.Len(t, m.records[templateIPv4], 1)
+}
+
+func TestDialExport(t *testing.T) {
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer pc.Close()
+
+	e, err := Dial(pc.LocalAddr().String(), 1)
+	require.NoError(t, err)
+
+	require.NoError(t, e.Export(testFlow()))
+	require.NoError(t, e.Close())
+
+	require.NoError(t, pc.SetReadDeadline(time.Now().Add(5*time.Second)))
+	b := make([]byte, 65535)
+	n, _, err := pc.ReadFrom(b)
+	require.NoError(t, err)
+
+	m := decode(t, b[:n], make(map[uint16][]field))
+	assert.Equal(t, uint32(1), m.domain)
+	assert.Len(t, m.records[templateIPv4], 1)
+}
@@ -0,0 +1,213 @@
+package ipfix
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+)
+
+const (
+	version = 10
+
+	headerLen    = 16
+	setHeaderLen = 4
+
+	templateSetID = 2
+
+	// Template IDs of the data sets emitted by an Exporter.
+	templateIPv4 = 256
+	templateIPv6 = 257
+
+	// reversePEN is the Private Enterprise Number of the reverse Information
+	// Elements defined by RFC 5103 (Bidirectional Flow Export).
+	reversePEN = 29305
+)
+
+// A field is an Information Element specifier in a template.
+type field struct {
+	id     uint16
+	length uint16
+	pen    uint32
+}
+
+// IANA Information Element identifiers used by the templates.
+const (
+	ieOctetDeltaCount          = 1
+	iePacketDeltaCount         = 2
+	ieProtocolIdentifier       = 4
+	ieSourceTransportPort      = 7
+	ieSourceIPv4Address        = 8
+	ieDestinationTransportPort = 11
+	ieDestinationIPv4Address   = 12
+	ieSourceIPv6Address        = 27
+	ieDestinationIPv6Address   = 28
+	ieICMPTypeCodeIPv4         = 32
+	ieICMPTypeCodeIPv6         = 139
+	ieFlowID                   = 148
+	ieFlowStartMilliseconds    = 152
+	ieFlowEndMilliseconds      = 153
+	iePostNATSourceIPv4        = 225
+	iePostNATDestinationIPv4   = 226
+	iePostNAPTSourcePort       = 227
+	iePostNAPTDestinationPort  = 228
+	iePostNATSourceIPv6        = 281
+	iePostNATDestinationIPv6   = 282
+)
+
+// commonFields are the fields shared by both templates, following the addresses.
+var commonFields = []field{
+	{id: ieSourceTransportPort, length: 2},
+	{id: ieDestinationTransportPort, length: 2},
+	{id: iePostNAPTSourcePort, length: 2},
+	{id: iePostNAPTDestinationPort, length: 2},
+	{id: ieProtocolIdentifier, length: 1},
+	{id: ieOctetDeltaCount, length: 8},
+	{id: iePacketDeltaCount, length: 8},
+	{id: ieOctetDeltaCount, length: 8, pen: reversePEN},
+	{id: iePacketDeltaCount, length: 8, pen: reversePEN},
+	{id: ieFlowStartMilliseconds, length: 8},
+	{id: ieFlowEndMilliseconds, length: 8},
+	{id: ieFlowID, length: 8},
+}
+
+var templates = []struct {
+	id     uint16
+	fields []field
+}{
+	{
+		id: templateIPv4,
+		fields: append([]field{
+			{id: ieSourceIPv4Address, length: 4},
+			{id: ieDestinationIPv4Address, length: 4},
+			{id: iePostNATSourceIPv4, length: 4},
+			{id: iePostNATDestinationIPv4, length: 4},
+			{id: ieICMPTypeCodeIPv4, length: 2},
+		}, commonFields...),
+	},
+	{
+		id: templateIPv6,
+		fields: append([]field{
+			{id: ieSourceIPv6Address, length: 16},
+			{id: ieDestinationIPv6Address, length: 16},
+			{id: iePostNATSourceIPv6, length: 16},
+			{id: iePostNATDestinationIPv6, length: 16},
+			{id: ieICMPTypeCodeIPv6, length: 2},
+		}, commonFields...),
+	},
+}
+
+// recordLen returns the length of a data record of the given template.
+func recordLen(id uint16) int {
+
+	var n int
+	for _, t := range templates {
+		if t.id != id {
+			continue
+		}
+		for _, f := range t.fields {
+			n += int(f.length)
+		}
+	}
+
+	return n
+}
+
+// appendTemplateSet appends a template set describing all templates to b.
+func appendTemplateSet(b []byte) []byte {
+
+	start := len(b)
+	b = binary.BigEndian.AppendUint16(b, templateSetID)
+	b = binary.BigEndian.AppendUint16(b, 0)
+
+	for _, t := range templates {
+		b = binary.BigEndian.AppendUint16(b, t.id)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(t.fields)))
+
+		for _, f := range t.fields {
+			if f.pen != 0 {
+				b = binary.BigEndian.AppendUint16(b, f.id|0x8000)
+				b = binary.BigEndian.AppendUint16(b, f.length)
+				b = binary.BigEndian.AppendUint32(b, f.pen)
+				continue
+			}
+			b = binary.BigEndian.AppendUint16(b, f.id)
+			b = binary.BigEndian.AppendUint16(b, f.length)
+		}
+	}
+
+	binary.BigEndian.PutUint16(b[start+2:], uint16(len(b)-start))
+
+	return b
+}
+
+// templateFor returns the ID of the template used to export f.
+func templateFor(f conntrack.Flow) uint16 {
+	if f.TupleOrig.IP.SourceAddress.To4() != nil {
+		return templateIPv4
+	}
+	return templateIPv6
+}
+
+// appendRecord appends a data record describing f to b, in the layout of
+// template id. end is used as the Flow's end time when it carries no timestamps.
+func appendRecord(b []byte, id uint16, f conntrack.Flow, end time.Time) []byte {
+
+	orig, reply := f.TupleOrig, f.TupleReply
+
+	// The reply tuple holds the addresses as they are seen after NAT,
+	// with source and destination swapped.
+	b = appendAddr(b, id, orig.IP.SourceAddress)
+	b = appendAddr(b, id, orig.IP.DestinationAddress)
+	b = appendAddr(b, id, reply.IP.DestinationAddress)
+	b = appendAddr(b, id, reply.IP.SourceAddress)
+
+	var icmp uint16
+	if orig.Proto.ICMPv4 || orig.Proto.ICMPv6 {
+		icmp = uint16(orig.Proto.ICMPType)<<8 | uint16(orig.Proto.ICMPCode)
+	}
+	b = binary.BigEndian.AppendUint16(b, icmp)
+
+	b = binary.BigEndian.AppendUint16(b, orig.Proto.SourcePort)
+	b = binary.BigEndian.AppendUint16(b, orig.Proto.DestinationPort)
+	b = binary.BigEndian.AppendUint16(b, reply.Proto.DestinationPort)
+	b = binary.BigEndian.AppendUint16(b, reply.Proto.SourcePort)
+	b = append(b, orig.Proto.Protocol)
+
+	b = binary.BigEndian.AppendUint64(b, f.CountersOrig.Bytes)
+	b = binary.BigEndian.AppendUint64(b, f.CountersOrig.Packets)
+	b = binary.BigEndian.AppendUint64(b, f.CountersReply.Bytes)
+	b = binary.BigEndian.AppendUint64(b, f.CountersReply.Packets)
+
+	start, stop := f.Timestamp.Start, f.Timestamp.Stop
+	if stop.IsZero() {
+		stop = end
+	}
+	if start.IsZero() {
+		start = stop
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(start.UnixMilli()))
+	b = binary.BigEndian.AppendUint64(b, uint64(stop.UnixMilli()))
+
+	b = binary.BigEndian.AppendUint64(b, uint64(f.ID))
+
+	return b
+}
+
+// appendAddr appends ip to b in the address size of template id.
+// Appends the unspecified address if ip is not set.
+func appendAddr(b []byte, id uint16, ip net.IP) []byte {
+
+	if id == templateIPv4 {
+		if ip4 := ip.To4(); ip4 != nil {
+			return append(b, ip4...)
+		}
+		return append(b, make([]byte, net.IPv4len)...)
+	}
+
+	if ip16 := ip.To16(); ip16 != nil {
+		return append(b, ip16...)
+	}
+	return append(b, make([]byte, net.IPv6len)...)
+}
@@ -0,0 +1,577 @@
+package conntrack
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// The JSON encodings of the types in this package use stable, lowercase field names,
+// render IP addresses as strings and Status flags as a list of their names.
+// Zero-valued members are omitted. The *JSON types below mirror the package's types
+// and carry their JSON field names; values are converted between them directly.
+
+type ipTupleJSON struct {
+	SourceAddress      net.IP `json:"src,omitempty"`
+	DestinationAddress net.IP `json:"dst,omitempty"`
+}
+
+type protoTupleJSON struct {
+	Protocol        uint8  `json:"proto,omitempty"`
+	SourcePort      uint16 `json:"sport,omitempty"`
+	DestinationPort uint16 `json:"dport,omitempty"`
+
+	ICMPv4 bool `json:"icmpv4,omitempty"`
+	ICMPv6 bool `json:"icmpv6,omitempty"`
+
+	ICMPID   uint16 `json:"icmp_id,omitempty"`
+	ICMPType uint8  `json:"icmp_type,omitempty"`
+	ICMPCode uint8  `json:"icmp_code,omitempty"`
+}
+
+// tupleJSON flattens a Tuple's IPTuple and ProtoTuple into a single object.
+type tupleJSON struct {
+	ipTupleJSON
+	protoTupleJSON
+	Zone uint16 `json:"zone,omitempty"`
+}
+
+type counterJSON struct {
+	Direction bool `json:"-"`
+
+	Packets uint64 `json:"packets"`
+	Bytes   uint64 `json:"bytes"`
+}
+
+type seqAdjJSON struct {
+	Direction bool `json:"-"`
+
+	Position     uint32 `json:"position"`
+	OffsetBefore uint32 `json:"offset_before"`
+	OffsetAfter  uint32 `json:"offset_after"`
+}
+
+type helperJSON struct {
+	Name string `json:"name,omitempty"`
+	Info []byte `json:"info,omitempty"`
+}
+
+type synProxyJSON struct {
+	ISN   uint32 `json:"isn"`
+	ITS   uint32 `json:"its"`
+	TSOff uint32 `json:"tsoff"`
+}
+
+type protoInfoJSON struct {
+	TCP  *ProtoInfoTCP  `json:"tcp,omitempty"`
+	DCCP *ProtoInfoDCCP `json:"dccp,omitempty"`
+	SCTP *ProtoInfoSCTP `json:"sctp,omitempty"`
+}
+
+type protoInfoTCPJSON struct {
+	State               uint8  `json:"state"`
+	OriginalWindowScale uint8  `json:"wscale_orig"`
+	ReplyWindowScale    uint8  `json:"wscale_reply"`
+	OriginalFlags       uint16 `json:"flags_orig"`
+	ReplyFlags          uint16 `json:"flags_reply"`
+}
+
+type protoInfoDCCPJSON struct {
+	State        uint8  `json:"state"`
+	Role         uint8  `json:"role"`
+	HandshakeSeq uint64 `json:"handshake_seq"`
+}
+
+type protoInfoSCTPJSON struct {
+	State        uint8  `json:"state"`
+	VTagOriginal uint32 `json:"vtag_orig"`
+	VTagReply    uint32 `json:"vtag_reply"`
+}
+
+type timestampJSON struct {
+	Start *time.Time `json:"start,omitempty"`
+	Stop  *time.Time `json:"stop,omitempty"`
+}
+
+type flowJSON struct {
+	ID        uint32          `json:"id,omitempty"`
+	Timeout   uint32          `json:"timeout,omitempty"`
+	Timestamp *Timestamp      `json:"timestamp,omitempty"`
+	Status    Status          `json:"status"`
+	ProtoInfo *ProtoInfo      `json:"protoinfo,omitempty"`
+	Helper    *Helper         `json:"helper,omitempty"`
+	Zone      uint16          `json:"zone,omitempty"`
+	Orig      *Tuple          `json:"orig,omitempty"`
+	Reply     *Tuple          `json:"reply,omitempty"`
+	Master    *Tuple          `json:"master,omitempty"`
+	CtrOrig   *Counter        `json:"counters_orig,omitempty"`
+	CtrReply  *Counter        `json:"counters_reply,omitempty"`
+	SecCtx    Security        `json:"secctx,omitempty"`
+	SeqOrig   *SequenceAdjust `json:"seqadj_orig,omitempty"`
+	SeqReply  *SequenceAdjust `json:"seqadj_reply,omitempty"`
+	Labels    hexBytes        `json:"labels,omitempty"`
+	LabelMask hexBytes        `json:"labels_mask,omitempty"`
+	Mark      uint32          `json:"mark,omitempty"`
+	Use       uint32          `json:"use,omitempty"`
+	SynProxy  *SynProxy       `json:"synproxy,omitempty"`
+}
+
+type expectNATJSON struct {
+	Direction bool  `json:"dir_reply,omitempty"`
+	Tuple     Tuple `json:"tuple"`
+}
+
+type expectJSON struct {
+	ID      uint32 `json:"id,omitempty"`
+	Timeout uint32 `json:"timeout,omitempty"`
+
+	TupleMaster Tuple `json:"master"`
+	Tuple       Tuple `json:"tuple"`
+	Mask        Tuple `json:"mask"`
+
+	Zone uint16 `json:"zone,omitempty"`
+
+	HelpName string `json:"helper,omitempty"`
+	Function string `json:"fn,omitempty"`
+
+	Flags uint32 `json:"flags,omitempty"`
+	Class uint32 `json:"class,omitempty"`
+
+	NAT *ExpectNAT `json:"nat,omitempty"`
+}
+
+type eventJSON struct {
+	Type   string  `json:"type"`
+	Flow   *Flow   `json:"flow,omitempty"`
+	Expect *Expect `json:"expect,omitempty"`
+}
+
+// hexBytes is a byte slice encoded as a hexadecimal string.
+type hexBytes []byte
+
+func (hb hexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(hb)), nil
+}
+
+func (hb *hexBytes) UnmarshalText(b []byte) error {
+	d, err := hex.DecodeString(string(b))
+	*hb = d
+	return err
+}
+
+// ptrIf returns a pointer to v if ok is true, nil otherwise.
+func ptrIf[T any](ok bool, v T) *T {
+	if !ok {
+		return nil
+	}
+	return &v
+}
+
+// MarshalJSON implements json.Marshaler.
+func (ipt IPTuple) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ipTupleJSON(ipt))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (ipt *IPTuple) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*ipTupleJSON)(ipt))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (pt ProtoTuple) MarshalJSON() ([]byte, error) {
+	return json.Marshal(protoTupleJSON(pt))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (pt *ProtoTuple) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*protoTupleJSON)(pt))
+}
+
+// MarshalJSON implements json.Marshaler. The Tuple's IP and protocol
+// information are rendered as members of a single object.
+func (t Tuple) MarshalJSON() ([]byte, error) {
+	return json.Marshal(tupleJSON{ipTupleJSON(t.IP), protoTupleJSON(t.Proto), t.Zone})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (t *Tuple) UnmarshalJSON(b []byte) error {
+
+	var tj tupleJSON
+	if err := json.Unmarshal(b, &tj); err != nil {
+		return err
+	}
+
+	*t = Tuple{IP: IPTuple(tj.ipTupleJSON), Proto: ProtoTuple(tj.protoTupleJSON), Zone: tj.Zone}
+
+	return nil
+}
+
+// isZero returns true if none of the Tuple's members are set.
+func (t Tuple) isZero() bool {
+	return t.IP.SourceAddress == nil && t.IP.DestinationAddress == nil &&
+		t.Proto == ProtoTuple{} && t.Zone == 0
+}
+
+// MarshalJSON implements json.Marshaler. The Status is rendered as a list of flag names
+// as they appear in Status.String. Unnamed flags are rendered as their decimal value.
+func (s Status) MarshalJSON() ([]byte, error) {
+
+	flags := make([]string, 0)
+	for i := uint32(0); i < 32; i++ {
+		f := StatusFlag(1 << i)
+		if s.Value&f == 0 {
+			continue
+		}
+
+		if int(i) < len(statusNames) {
+			flags = append(flags, statusNames[i])
+		} else {
+			flags = append(flags, strconv.FormatUint(uint64(f), 10))
+		}
+	}
+
+	return json.Marshal(flags)
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Status) UnmarshalJSON(b []byte) error {
+
+	var flags []string
+	if err := json.Unmarshal(b, &flags); err != nil {
+		return err
+	}
+
+	var v StatusFlag
+	for _, name := range flags {
+		f, err := parseStatusFlag(name)
+		if err != nil {
+			return err
+		}
+		v |= f
+	}
+
+	s.Value = v
+
+	return nil
+}
+
+// parseStatusFlag returns the StatusFlag named name, or represented by its decimal value.
+func parseStatusFlag(name string) (StatusFlag, error) {
+
+	for i, n := range statusNames {
+		if n == name {
+			return StatusFlag(1 << uint32(i)), nil
+		}
+	}
+
+	if v, err := strconv.ParseUint(name, 10, 32); err == nil {
+		return StatusFlag(v), nil
+	}
+
+	return 0, fmt.Errorf(errUnknownStatusFlag, name)
+}
+
+// MarshalJSON implements json.Marshaler. The Counter's Direction is not
+// encoded, it is implied by the Flow member holding the Counter.
+func (ctr Counter) MarshalJSON() ([]byte, error) {
+	return json.Marshal(counterJSON(ctr))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The Counter's Direction is left untouched.
+func (ctr *Counter) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*counterJSON)(ctr))
+}
+
+// MarshalJSON implements json.Marshaler. The SequenceAdjust's Direction is not
+// encoded, it is implied by the Flow member holding the SequenceAdjust.
+func (seq SequenceAdjust) MarshalJSON() ([]byte, error) {
+	return json.Marshal(seqAdjJSON(seq))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The SequenceAdjust's Direction is left untouched.
+func (seq *SequenceAdjust) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*seqAdjJSON)(seq))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (hlp Helper) MarshalJSON() ([]byte, error) {
+	return json.Marshal(helperJSON(hlp))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (hlp *Helper) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*helperJSON)(hlp))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (sp SynProxy) MarshalJSON() ([]byte, error) {
+	return json.Marshal(synProxyJSON(sp))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (sp *SynProxy) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*synProxyJSON)(sp))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (pi ProtoInfo) MarshalJSON() ([]byte, error) {
+	return json.Marshal(protoInfoJSON(pi))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (pi *ProtoInfo) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*protoInfoJSON)(pi))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (tpi ProtoInfoTCP) MarshalJSON() ([]byte, error) {
+	return json.Marshal(protoInfoTCPJSON(tpi))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (tpi *ProtoInfoTCP) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*protoInfoTCPJSON)(tpi))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (dpi ProtoInfoDCCP) MarshalJSON() ([]byte, error) {
+	return json.Marshal(protoInfoDCCPJSON(dpi))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (dpi *ProtoInfoDCCP) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*protoInfoDCCPJSON)(dpi))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (spi ProtoInfoSCTP) MarshalJSON() ([]byte, error) {
+	return json.Marshal(protoInfoSCTPJSON(spi))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (spi *ProtoInfoSCTP) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*protoInfoSCTPJSON)(spi))
+}
+
+// MarshalJSON implements json.Marshaler. Times are rendered in RFC 3339 format.
+func (ts Timestamp) MarshalJSON() ([]byte, error) {
+	return json.Marshal(timestampJSON{
+		Start: ptrIf(!ts.Start.IsZero(), ts.Start),
+		Stop:  ptrIf(!ts.Stop.IsZero(), ts.Stop),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (ts *Timestamp) UnmarshalJSON(b []byte) error {
+
+	var tj timestampJSON
+	if err := json.Unmarshal(b, &tj); err != nil {
+		return err
+	}
+
+	*ts = Timestamp{}
+	if tj.Start != nil {
+		ts.Start = *tj.Start
+	}
+	if tj.Stop != nil {
+		ts.Stop = *tj.Stop
+	}
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler. Labels are rendered as hexadecimal strings.
+func (f Flow) MarshalJSON() ([]byte, error) {
+
+	ctrFilled := func(ctr Counter) bool { return ctr.Packets != 0 || ctr.Bytes != 0 }
+	seqFilled := func(seq SequenceAdjust) bool {
+		return seq.Position != 0 || seq.OffsetBefore != 0 || seq.OffsetAfter != 0
+	}
+
+	return json.Marshal(flowJSON{
+		ID:        f.ID,
+		Timeout:   f.Timeout,
+		Timestamp: ptrIf(!f.Timestamp.Start.IsZero() || !f.Timestamp.Stop.IsZero(), f.Timestamp),
+		Status:    f.Status,
+		ProtoInfo: ptrIf(f.ProtoInfo.filled(), f.ProtoInfo),
+		Helper:    ptrIf(f.Helper.filled(), f.Helper),
+		Zone:      f.Zone,
+		Orig:      ptrIf(!f.TupleOrig.isZero(), f.TupleOrig),
+		Reply:     ptrIf(!f.TupleReply.isZero(), f.TupleReply),
+		Master:    ptrIf(!f.TupleMaster.isZero(), f.TupleMaster),
+		CtrOrig:   ptrIf(ctrFilled(f.CountersOrig), f.CountersOrig),
+		CtrReply:  ptrIf(ctrFilled(f.CountersReply), f.CountersReply),
+		SecCtx:    f.SecurityContext,
+		SeqOrig:   ptrIf(seqFilled(f.SeqAdjOrig), f.SeqAdjOrig),
+		SeqReply:  ptrIf(seqFilled(f.SeqAdjReply), f.SeqAdjReply),
+		Labels:    f.Labels,
+		LabelMask: f.LabelsMask,
+		Mark:      f.Mark,
+		Use:       f.Use,
+		SynProxy:  ptrIf(f.SynProxy.filled(), f.SynProxy),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (f *Flow) UnmarshalJSON(b []byte) error {
+
+	var fj flowJSON
+	if err := json.Unmarshal(b, &fj); err != nil {
+		return err
+	}
+
+	*f = Flow{
+		ID:              fj.ID,
+		Timeout:         fj.Timeout,
+		Status:          fj.Status,
+		Zone:            fj.Zone,
+		SecurityContext: fj.SecCtx,
+		Labels:          fj.Labels,
+		LabelsMask:      fj.LabelMask,
+		Mark:            fj.Mark,
+		Use:             fj.Use,
+	}
+
+	if fj.Timestamp != nil {
+		f.Timestamp = *fj.Timestamp
+	}
+	if fj.ProtoInfo != nil {
+		f.ProtoInfo = *fj.ProtoInfo
+	}
+	if fj.Helper != nil {
+		f.Helper = *fj.Helper
+	}
+	if fj.Orig != nil {
+		f.TupleOrig = *fj.Orig
+	}
+	if fj.Reply != nil {
+		f.TupleReply = *fj.Reply
+	}
+	if fj.Master != nil {
+		f.TupleMaster = *fj.Master
+	}
+	if fj.CtrOrig != nil {
+		f.CountersOrig = *fj.CtrOrig
+	}
+	if fj.CtrReply != nil {
+		f.CountersReply = *fj.CtrReply
+	}
+	if fj.SeqOrig != nil {
+		f.SeqAdjOrig = *fj.SeqOrig
+	}
+	if fj.SeqReply != nil {
+		f.SeqAdjReply = *fj.SeqReply
+	}
+	if fj.SynProxy != nil {
+		f.SynProxy = *fj.SynProxy
+	}
+
+	f.CountersReply.Direction = true
+	f.SeqAdjReply.Direction = true
+
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler.
+func (en ExpectNAT) MarshalJSON() ([]byte, error) {
+	return json.Marshal(expectNATJSON(en))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (en *ExpectNAT) UnmarshalJSON(b []byte) error {
+	return json.Unmarshal(b, (*expectNATJSON)(en))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (ex Expect) MarshalJSON() ([]byte, error) {
+	return json.Marshal(expectJSON{
+		ID:          ex.ID,
+		Timeout:     ex.Timeout,
+		TupleMaster: ex.TupleMaster,
+		Tuple:       ex.Tuple,
+		Mask:        ex.Mask,
+		Zone:        ex.Zone,
+		HelpName:    ex.HelpName,
+		Function:    ex.Function,
+		Flags:       ex.Flags,
+		Class:       ex.Class,
+		NAT:         ptrIf(ex.NAT.Direction || !ex.NAT.Tuple.isZero(), ex.NAT),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (ex *Expect) UnmarshalJSON(b []byte) error {
+
+	var ej expectJSON
+	if err := json.Unmarshal(b, &ej); err != nil {
+		return err
+	}
+
+	*ex = Expect{
+		ID:          ej.ID,
+		Timeout:     ej.Timeout,
+		TupleMaster: ej.TupleMaster,
+		Tuple:       ej.Tuple,
+		Mask:        ej.Mask,
+		Zone:        ej.Zone,
+		HelpName:    ej.HelpName,
+		Function:    ej.Function,
+		Flags:       ej.Flags,
+		Class:       ej.Class,
+	}
+
+	if ej.NAT != nil {
+		ex.NAT = *ej.NAT
+	}
+
+	return nil
+}
+
+// eventTypeNames holds the JSON names of the Event types.
+var eventTypeNames = map[eventType]string{
+	EventUnknown:    "unknown",
+	EventNew:        "new",
+	EventUpdate:     "update",
+	EventDestroy:    "destroy",
+	EventExpNew:     "expnew",
+	EventExpDestroy: "expdestroy",
+}
+
+// Name returns the name of the Event type as rendered in JSON, one of 'new',
+// 'update', 'destroy', 'expnew', 'expdestroy' or 'unknown'.
+func (et eventType) Name() string {
+
+	name, ok := eventTypeNames[et]
+	if !ok {
+		return eventTypeNames[EventUnknown]
+	}
+
+	return name
+}
+
+// MarshalJSON implements json.Marshaler. The Event's type is rendered by its Name.
+func (e Event) MarshalJSON() ([]byte, error) {
+	return json.Marshal(eventJSON{Type: e.Type.Name(), Flow: e.Flow, Expect: e.Expect})
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (e *Event) UnmarshalJSON(b []byte) error {
+
+	var ej eventJSON
+	if err := json.Unmarshal(b, &ej); err != nil {
+		return err
+	}
+
+	*e = Event{Flow: ej.Flow, Expect: ej.Expect}
+	for et, name := range eventTypeNames {
+		if name == ej.Type {
+			e.Type = et
+			return nil
+		}
+	}
+
+	return fmt.Errorf(errUnknownEventTypeName, ej.Type)
+}
@@ -0,0 +1,118 @@
+package conntrack
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlowJSON(t *testing.T) {
+
+	f := NewFlow(6, StatusConfirmed|StatusAssured, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0)
+	f.ID = 42
+	f.CountersOrig = Counter{Packets: 1, Bytes: 60}
+
+	b, err := json.Marshal(f)
+	require.NoError(t, err)
+
+	assert.JSONEq(t, `{
+		"id": 42,
+		"timeout": 120,
+		"status": ["ASSURED", "CONFIRMED"],
+		"orig": {"src": "1.2.3.4", "dst": "5.6.7.8", "proto": 6, "sport": 1234, "dport": 80},
+		"reply": {"src": "5.6.7.8", "dst": "1.2.3.4", "proto": 6, "sport": 80, "dport": 1234},
+		"counters_orig": {"packets": 1, "bytes": 60}
+	}`, string(b))
+}
+
+func TestFlowJSONRoundTrip(t *testing.T) {
+
+	f := NewFlow(6, StatusSeenReply|1<<20, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 1234, 80, 120, 0xff)
+	f.ID = 1
+	f.Zone = 2
+	f.Timestamp = Timestamp{Start: time.Unix(1, 2).UTC(), Stop: time.Unix(3, 4).UTC()}
+	f.ProtoInfo = ProtoInfo{TCP: &ProtoInfoTCP{State: 3, OriginalWindowScale: 7, ReplyFlags: 0x23}}
+	f.Helper = Helper{Name: "ftp", Info: []byte{1, 2}}
+	f.TupleMaster = Tuple{Proto: ProtoTuple{Protocol: 1, ICMPv4: true, ICMPID: 3, ICMPType: 8}, Zone: 1}
+	f.CountersOrig = Counter{Packets: 1, Bytes: 2}
+	f.CountersReply = Counter{Direction: true, Packets: 3, Bytes: 4}
+	f.SecurityContext = "system_u:object_r:unlabeled_t:s0"
+	f.SeqAdjOrig = SequenceAdjust{Position: 1, OffsetBefore: 2, OffsetAfter: 3}
+	f.SeqAdjReply = SequenceAdjust{Direction: true, Position: 4}
+	f.Labels = []byte{0xde, 0xad}
+	f.LabelsMask = []byte{0xff, 0xff}
+	f.Use = 1
+	f.SynProxy = SynProxy{ISN: 1, ITS: 2, TSOff: 3}
+
+	b, err := json.Marshal(f)
+	require.NoError(t, err)
+
+	var got Flow
+	require.NoError(t, json.Unmarshal(b, &got))
+
+	if diff := cmp.Diff(f, got); diff != "" {
+		t.Fatalf("unexpected round-trip result (-want +got):\n%s", diff)
+	}
+
+	// Status flags and labels are human-readable.
+	assert.Contains(t, string(b), `"status":["SEEN_REPLY","1048576"]`)
+	assert.Contains(t, string(b), `"labels":"dead"`)
+}
+
+func TestStatusJSON(t *testing.T) {
+
+	b, err := json.Marshal(Status{})
+	require.NoError(t, err)
+	assert.Equal(t, `[]`, string(b))
+
+	var s Status
+	require.NoError(t, json.Unmarshal([]byte(`["DYING", "OFFLOAD"]`), &s))
+	assert.Equal(t, StatusDying|StatusOffload, s.Value)
+
+	assert.EqualError(t, json.Unmarshal([]byte(`["BOGUS"]`), &s), "unknown status flag 'BOGUS'")
+}
+
+func TestEventJSON(t *testing.T) {
+
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 53, 53, 30, 0)
+	f.CountersReply.Direction = true
+	f.SeqAdjReply.Direction = true
+
+	ex := Expect{
+		ID: 1, Timeout: 2,
+		TupleMaster: f.TupleOrig, Tuple: f.TupleReply,
+		Mask:     Tuple{Proto: ProtoTuple{DestinationPort: 0xffff}},
+		HelpName: "ftp", Function: "fn", Flags: ExpectFlagPermanent, Class: 1,
+		NAT: ExpectNAT{Direction: true, Tuple: f.TupleOrig},
+	}
+
+	for _, ev := range []Event{
+		{Type: EventDestroy, Flow: &f},
+		{Type: EventExpNew, Expect: &ex},
+	} {
+		b, err := json.Marshal(ev)
+		require.NoError(t, err)
+
+		var got Event
+		require.NoError(t, json.Unmarshal(b, &got))
+
+		if diff := cmp.Diff(ev, got); diff != "" {
+			t.Fatalf("unexpected round-trip result (-want +got):\n%s", diff)
+		}
+	}
+
+	b, err := json.Marshal(Event{Type: EventUpdate})
+	require.NoError(t, err)
+	assert.Equal(t, `{"type":"update"}`, string(b))
+
+	assert.Equal(t, "expnew", EventExpNew.Name())
+	assert.Equal(t, "unknown", eventType(255).Name())
+
+	var ev Event
+	assert.EqualError(t, json.Unmarshal([]byte(`{"type":"bogus"}`), &ev), "unknown event type 'bogus'")
+}
@@ -0,0 +1,104 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// The kernel stores a connection's labels as an array of unsigned longs and sends
+// them to userspace as-is, in CTA_LABELS. The byte holding a label bit therefore
+// depends on the byte order and word size of the machine: bit 0 lives in the first
+// byte on little-endian machines, but in the last byte of the first word on big-endian
+// machines like mips or ppc64 routers. Unlike all other attributes, which the kernel
+// sends in network byte order, Flow.Labels and Flow.LabelsMask are in host order.
+// On big-endian machines, label bits are misplaced when running a 32-bit program on
+// a 64-bit kernel, since their word sizes differ.
+
+// hostBigEndian is true if the host stores words in big-endian byte order.
+var hostBigEndian = binary.NativeEndian.Uint16([]byte{0x12, 0x34}) == 0x1234
+
+// hostWordSize is the size of the host's unsigned long in bytes.
+const hostWordSize = bits.UintSize / 8
+
+// labelPosition returns the index of the byte holding label bit in a label bitfield
+// made of words of wordSize bytes, stored in big- or little-endian byte order, along
+// with the bit's mask in that byte.
+func labelPosition(bit uint, bigEndian bool, wordSize uint) (int, byte) {
+
+	word, offset := bit/(wordSize*8), bit%(wordSize*8)
+
+	b := offset / 8
+	if bigEndian {
+		b = wordSize - 1 - b
+	}
+
+	return int(word*wordSize + b), 1 << (offset % 8)
+}
+
+// HasLabel returns true if the Flow has the given connlabel bit set in its Labels.
+// Labels are numbered like in iptables' connlabel match.
+func (f Flow) HasLabel(bit uint) bool {
+
+	i, m := labelPosition(bit, hostBigEndian, hostWordSize)
+
+	return i < len(f.Labels) && f.Labels[i]&m != 0
+}
+
+// SetLabel sets the given connlabel bit in the Flow's Labels, growing them to a
+// whole amount of words if needed. To change a single label of an existing connection
+// using Update, set the same bit in LabelsMask using SetLabelMask.
+func (f *Flow) SetLabel(bit uint) {
+
+	f.Labels = setLabel(f.Labels, bit, true)
+	if len(f.LabelsMask) != 0 {
+		f.LabelsMask = growLabels(f.LabelsMask, len(f.Labels))
+	}
+}
+
+// ClearLabel clears the given connlabel bit in the Flow's Labels, like SetLabel.
+func (f *Flow) ClearLabel(bit uint) {
+	f.Labels = setLabel(f.Labels, bit, false)
+}
+
+// SetLabelMask sets the given connlabel bit in the Flow's LabelsMask, limiting the
+// labels changed by Update to the ones set in the mask. LabelsMask needs to be as long
+// as Labels, which SetLabelMask takes care of if both were built using these methods.
+func (f *Flow) SetLabelMask(bit uint) {
+
+	f.LabelsMask = growLabels(setLabel(f.LabelsMask, bit, true), len(f.Labels))
+	f.Labels = growLabels(f.Labels, len(f.LabelsMask))
+}
+
+// setLabel sets or clears label bit in b in host order, growing b to hold it.
+func setLabel(b []byte, bit uint, set bool) []byte {
+
+	i, m := labelPosition(bit, hostBigEndian, hostWordSize)
+
+	if i >= len(b) {
+		if !set {
+			return b
+		}
+		b = growLabels(b, i+1)
+	}
+
+	if set {
+		b[i] |= m
+	} else {
+		b[i] &^= m
+	}
+
+	return b
+}
+
+// growLabels grows the label bitfield b to at least n bytes, rounded up to a whole
+// amount of words. The kernel expects the labels' length to be a multiple of 32 bits.
+func growLabels(b []byte, n int) []byte {
+
+	if len(b) >= n {
+		return b
+	}
+
+	n = (n + hostWordSize - 1) / hostWordSize * hostWordSize
+
+	return append(b, make([]byte, n-len(b))...)
+}
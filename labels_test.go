@@ -0,0 +1,75 @@
+package conntrack
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelPosition(t *testing.T) {
+
+	tests := []struct {
+		bit       uint
+		bigEndian bool
+		wordSize  uint
+		index     int
+		mask      byte
+	}{
+		{bit: 0, wordSize: 8, index: 0, mask: 0x01},
+		{bit: 10, wordSize: 8, index: 1, mask: 0x04},
+		{bit: 127, wordSize: 8, index: 15, mask: 0x80},
+		{bit: 10, wordSize: 4, index: 1, mask: 0x04},
+		{bit: 0, bigEndian: true, wordSize: 8, index: 7, mask: 0x01},
+		{bit: 10, bigEndian: true, wordSize: 8, index: 6, mask: 0x04},
+		{bit: 64, bigEndian: true, wordSize: 8, index: 15, mask: 0x01},
+		{bit: 127, bigEndian: true, wordSize: 8, index: 8, mask: 0x80},
+		{bit: 0, bigEndian: true, wordSize: 4, index: 3, mask: 0x01},
+		{bit: 32, bigEndian: true, wordSize: 4, index: 7, mask: 0x01},
+		{bit: 63, bigEndian: true, wordSize: 4, index: 4, mask: 0x80},
+	}
+
+	for _, tt := range tests {
+		i, m := labelPosition(tt.bit, tt.bigEndian, tt.wordSize)
+		assert.Equal(t, tt.index, i, "bit %d, big endian %t, word size %d", tt.bit, tt.bigEndian, tt.wordSize)
+		assert.Equal(t, tt.mask, m, "bit %d, big endian %t, word size %d", tt.bit, tt.bigEndian, tt.wordSize)
+	}
+}
+
+func TestFlowLabels(t *testing.T) {
+
+	var f Flow
+
+	assert.False(t, f.HasLabel(10))
+	f.ClearLabel(10)
+	assert.Nil(t, f.Labels)
+
+	f.SetLabel(10)
+	assert.True(t, f.HasLabel(10))
+	assert.False(t, f.HasLabel(11))
+	assert.Len(t, f.Labels, hostWordSize)
+
+	f.SetLabel(100)
+	assert.True(t, f.HasLabel(100))
+	assert.Len(t, f.Labels, 16)
+
+	f.ClearLabel(10)
+	assert.False(t, f.HasLabel(10))
+	assert.True(t, f.HasLabel(100))
+
+	// The mask is kept as long as the labels.
+	f.SetLabelMask(10)
+	assert.Len(t, f.LabelsMask, 16)
+	assert.True(t, Flow{Labels: f.LabelsMask}.HasLabel(10))
+
+	var g Flow
+	g.SetLabelMask(100)
+	g.SetLabel(1)
+	assert.Equal(t, len(g.Labels), len(g.LabelsMask))
+}
+
+func TestGrowLabels(t *testing.T) {
+
+	assert.Len(t, growLabels(nil, 1), hostWordSize)
+	assert.Len(t, growLabels(make([]byte, 16), 1), 16)
+	assert.Len(t, growLabels(make([]byte, 3), 4), hostWordSize)
+}
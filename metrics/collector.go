@@ -0,0 +1,188 @@
+// Package metrics exports Conntrack table and event statistics as Prometheus metrics.
+//
+// A Collector is a prometheus.Collector querying the kernel for global and per-CPU
+// statistics on every scrape, and keeping running counters of the Events and errors
+// it observes from a Conn that is listening for Conntrack events. Since a Conn that
+// has joined multicast groups can no longer be used for queries, two separate Conns
+// are needed: one to pass to NewCollector and one to call Listen on.
+//
+//	c := metrics.NewCollector(conn)
+//	prometheus.MustRegister(c)
+//	http.Handle("/metrics", promhttp.Handler())
+//
+// This package lives in its own module, so the conntrack package does not depend on
+// the Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// A StatsQuerier can query the kernel for Conntrack statistics.
+// It is implemented by *conntrack.Conn.
+type StatsQuerier interface {
+	Stats() ([]conntrack.Stats, error)
+	StatsGlobal() (conntrack.StatsGlobal, error)
+}
+
+var (
+	entriesDesc = prometheus.NewDesc("conntrack_entries",
+		"Number of entries in the Conntrack table.", nil, nil)
+	entriesMaxDesc = prometheus.NewDesc("conntrack_entries_max",
+		"Maximum number of entries in the Conntrack table, zero if unknown.", nil, nil)
+	eventsDesc = prometheus.NewDesc("conntrack_events_total",
+		"Number of Conntrack events received, by event type.", []string{"type"}, nil)
+	decodeErrorsDesc = prometheus.NewDesc("conntrack_event_decode_errors_total",
+		"Number of errors encountered while receiving or decoding Conntrack events.", nil, nil)
+)
+
+// A Collector gathers Conntrack statistics and exports them as Prometheus metrics.
+// It implements prometheus.Collector.
+type Collector struct {
+	q StatsQuerier
+
+	mu           sync.Mutex
+	events       map[string]uint64
+	decodeErrors uint64
+}
+
+// NewCollector returns a Collector that queries q for statistics when it is collected.
+func NewCollector(q StatsQuerier) *Collector {
+	return &Collector{
+		q:      q,
+		events: make(map[string]uint64),
+	}
+}
+
+// ObserveEvent increments the event counter for the type of ev. Event types are
+// labeled with the names they are rendered with in JSON, like 'new' or 'expnew'.
+func (c *Collector) ObserveEvent(ev conntrack.Event) {
+	c.mu.Lock()
+	c.events[ev.Type.Name()]++
+	c.mu.Unlock()
+}
+
+// ObserveError increments the decode error counter. It is meant to be called
+// for every error received on the error channel returned by Conn.Listen.
+func (c *Collector) ObserveError(err error) {
+	if err == nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.decodeErrors++
+	c.mu.Unlock()
+}
+
+// Forward observes all Events received on in and passes them on to out.
+// Errors received on errs are observed and dropped. Forward returns when both
+// in and errs are closed, or when in is closed and errs is nil.
+// out may be nil, in which case Events are observed and discarded.
+func (c *Collector) Forward(in <-chan conntrack.Event, errs <-chan error, out chan<- conntrack.Event) {
+
+	for in != nil || errs != nil {
+		select {
+		case ev, ok := <-in:
+			if !ok {
+				in = nil
+				continue
+			}
+			c.ObserveEvent(ev)
+			if out != nil {
+				out <- ev
+			}
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			c.ObserveError(err)
+		}
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+
+	ch <- entriesDesc
+	ch <- entriesMaxDesc
+	for _, cs := range cpuStats {
+		ch <- cs.desc
+	}
+	ch <- eventsDesc
+	ch <- decodeErrorsDesc
+}
+
+// Collect implements prometheus.Collector. It queries the kernel for Conntrack
+// statistics, failing the collection of the table and per-CPU metrics if that
+// fails. The event counters are always collected.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+
+	c.collectStats(ch)
+
+	c.mu.Lock()
+	events := make(map[string]uint64, len(c.events))
+	for typ, n := range c.events {
+		events[typ] = n
+	}
+	decodeErrors := c.decodeErrors
+	c.mu.Unlock()
+
+	for typ, n := range events {
+		ch <- prometheus.MustNewConstMetric(eventsDesc, prometheus.CounterValue, float64(n), typ)
+	}
+	ch <- prometheus.MustNewConstMetric(decodeErrorsDesc, prometheus.CounterValue, float64(decodeErrors))
+}
+
+// collectStats queries the kernel for global and per-CPU statistics and sends
+// them to ch, or sends an invalid metric carrying the error if a query fails.
+func (c *Collector) collectStats(ch chan<- prometheus.Metric) {
+
+	sg, err := c.q.StatsGlobal()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(entriesDesc, fmt.Errorf("querying global stats: %w", err))
+		return
+	}
+
+	stats, err := c.q.Stats()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(cpuStats[0].desc, fmt.Errorf("querying per-cpu stats: %w", err))
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(entriesDesc, prometheus.GaugeValue, float64(sg.Entries))
+	ch <- prometheus.MustNewConstMetric(entriesMaxDesc, prometheus.GaugeValue, float64(sg.MaxEntries))
+
+	for _, cs := range cpuStats {
+		for _, s := range stats {
+			ch <- prometheus.MustNewConstMetric(cs.desc, prometheus.CounterValue, float64(cs.get(s)), strconv.Itoa(int(s.CPUID)))
+		}
+	}
+}
+
+// cpuStat returns the description of the per-CPU counter name.
+func cpuStat(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc("conntrack_cpu_"+name+"_total", help, []string{"cpu"}, nil)
+}
+
+// cpuStats lists the per-CPU performance counters exported by a Collector.
+var cpuStats = []struct {
+	desc *prometheus.Desc
+	get  func(conntrack.Stats) uint32
+}{
+	{cpuStat("found", "Number of successful Conntrack table lookups."), func(s conntrack.Stats) uint32 { return s.Found }},
+	{cpuStat("invalid", "Number of packets that could not be tracked."), func(s conntrack.Stats) uint32 { return s.Invalid }},
+	{cpuStat("ignore", "Number of packets that were already tracked or untracked."), func(s conntrack.Stats) uint32 { return s.Ignore }},
+	{cpuStat("insert", "Number of entries inserted into the Conntrack table."), func(s conntrack.Stats) uint32 { return s.Insert }},
+	{cpuStat("insert_failed", "Number of failed Conntrack table insertions."), func(s conntrack.Stats) uint32 { return s.InsertFailed }},
+	{cpuStat("drop", "Number of packets dropped due to Conntrack failures."), func(s conntrack.Stats) uint32 { return s.Drop }},
+	{cpuStat("early_drop", "Number of entries dropped to make room for new ones when the table was full."), func(s conntrack.Stats) uint32 { return s.EarlyDrop }},
+	{cpuStat("error", "Number of packets that failed Conntrack processing."), func(s conntrack.Stats) uint32 { return s.Error }},
+	{cpuStat("search_restart", "Number of Conntrack table lookups restarted due to hash resizing."), func(s conntrack.Stats) uint32 { return s.SearchRestart }},
+}
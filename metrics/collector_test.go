@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntrack"
+)
+
+type fakeQuerier struct {
+	stats  []conntrack.Stats
+	global conntrack.StatsGlobal
+	err    error
+}
+
+func (fq fakeQuerier) Stats() ([]conntrack.Stats, error) {
+	return fq.stats, fq.err
+}
+
+func (fq fakeQuerier) StatsGlobal() (conntrack.StatsGlobal, error) {
+	return fq.global, fq.err
+}
+
+func TestCollectorCollect(t *testing.T) {
+
+	c := NewCollector(fakeQuerier{
+		stats: []conntrack.Stats{
+			{CPUID: 0, Found: 1, InsertFailed: 2, EarlyDrop: 3},
+			{CPUID: 1, Found: 4, Drop: 5, SearchRestart: 6},
+		},
+		global: conntrack.StatsGlobal{Entries: 42, MaxEntries: 65536},
+	})
+
+	c.ObserveEvent(conntrack.Event{Type: conntrack.EventNew})
+	c.ObserveEvent(conntrack.Event{Type: conntrack.EventNew})
+	c.ObserveEvent(conntrack.Event{Type: conntrack.EventDestroy})
+	c.ObserveEvent(conntrack.Event{Type: conntrack.EventExpNew})
+	c.ObserveError(errors.New("decode failure"))
+	c.ObserveError(nil)
+
+	// The pedantic registry checks collected metrics against their descriptions.
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(c))
+
+	want := `
+# HELP conntrack_entries Number of entries in the Conntrack table.
+# TYPE conntrack_entries gauge
+conntrack_entries 42
+# HELP conntrack_entries_max Maximum number of entries in the Conntrack table, zero if unknown.
+# TYPE conntrack_entries_max gauge
+conntrack_entries_max 65536
+# HELP conntrack_cpu_found_total Number of successful Conntrack table lookups.
+# TYPE conntrack_cpu_found_total counter
+conntrack_cpu_found_total{cpu="0"} 1
+conntrack_cpu_found_total{cpu="1"} 4
+# HELP conntrack_cpu_early_drop_total Number of entries dropped to make room for new ones when the table was full.
+# TYPE conntrack_cpu_early_drop_total counter
+conntrack_cpu_early_drop_total{cpu="0"} 3
+conntrack_cpu_early_drop_total{cpu="1"} 0
+# HELP conntrack_events_total Number of Conntrack events received, by event type.
+# TYPE conntrack_events_total counter
+conntrack_events_total{type="destroy"} 1
+conntrack_events_total{type="expnew"} 1
+conntrack_events_total{type="new"} 2
+# HELP conntrack_event_decode_errors_total Number of errors encountered while receiving or decoding Conntrack events.
+# TYPE conntrack_event_decode_errors_total counter
+conntrack_event_decode_errors_total 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(want),
+		"conntrack_entries", "conntrack_entries_max", "conntrack_cpu_found_total",
+		"conntrack_cpu_early_drop_total", "conntrack_events_total", "conntrack_event_decode_errors_total"))
+
+	// 2 table metrics, 9 per-CPU counters for 2 CPUs, 3 event types and decode errors.
+	assert.Equal(t, 2+9*2+3+1, testutil.CollectAndCount(c))
+}
+
+func TestCollectorQueryError(t *testing.T) {
+
+	c := NewCollector(fakeQuerier{err: errors.New("netlink failure")})
+
+	reg := prometheus.NewPedanticRegistry()
+	require.NoError(t, reg.Register(c))
+
+	_, err := reg.Gather()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "querying global stats: netlink failure")
+}
+
+func TestCollectorForward(t *testing.T) {
+
+	c := NewCollector(fakeQuerier{})
+
+	in := make(chan conntrack.Event, 2)
+	errs := make(chan error, 1)
+	out := make(chan conntrack.Event, 2)
+
+	in <- conntrack.Event{Type: conntrack.EventUpdate}
+	in <- conntrack.Event{Type: conntrack.EventUpdate}
+	errs <- errors.New("decode failure")
+	close(in)
+	close(errs)
+
+	c.Forward(in, errs, out)
+
+	assert.Len(t, out, 2)
+	assert.EqualValues(t, 2, c.events["update"])
+	assert.EqualValues(t, 1, c.decodeErrors)
+}
@@ -0,0 +1,210 @@
+// Package monitor periodically reports the utilization of the Conntrack table,
+// answering whether the table is about to overflow.
+//
+// A Monitor combines the kernel's global and per-CPU Conntrack statistics with
+// the nf_conntrack_max tunable into Reports holding the amount of entries in the
+// table versus its maximum, and the rate at which connections failed to be
+// inserted or were evicted early to make room for new ones. A rising early drop
+// rate means the table is full and established connections are being dropped.
+//
+// Callbacks are invoked for every Report and for Reports exceeding any of the
+// configured Thresholds.
+package monitor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/conntrack/sysctl"
+)
+
+var errInterval = errors.New("monitor: need a positive interval")
+
+// A StatsQuerier can query the kernel for Conntrack statistics.
+// It is implemented by *conntrack.Conn.
+type StatsQuerier interface {
+	Stats() ([]conntrack.Stats, error)
+	StatsGlobal() (conntrack.StatsGlobal, error)
+}
+
+// A Report describes the state of the Conntrack table at a point in time.
+type Report struct {
+	Time time.Time
+
+	// Entries and MaxEntries are the current and maximum amount of entries
+	// in the Conntrack table.
+	Entries, MaxEntries uint32
+
+	// InsertFailedRate and EarlyDropRate are the amount of failed insertions
+	// and early drops per second since the previous Report, summed over all
+	// CPUs. They are zero in the first Report of a Monitor.
+	InsertFailedRate, EarlyDropRate float64
+}
+
+// Utilization returns the ratio of Entries to MaxEntries. New connections
+// start being dropped when it reaches 1. Returns 0 if MaxEntries is unknown.
+func (r Report) Utilization() float64 {
+	if r.MaxEntries == 0 {
+		return 0
+	}
+	return float64(r.Entries) / float64(r.MaxEntries)
+}
+
+// Thresholds are the values at or above which a Report is considered exceeding.
+// A zero threshold is disabled.
+type Thresholds struct {
+	Utilization      float64
+	InsertFailedRate float64
+	EarlyDropRate    float64
+}
+
+// Exceeded returns true if r reaches any of the enabled thresholds in t.
+func (t Thresholds) Exceeded(r Report) bool {
+	return exceeds(r.Utilization(), t.Utilization) ||
+		exceeds(r.InsertFailedRate, t.InsertFailedRate) ||
+		exceeds(r.EarlyDropRate, t.EarlyDropRate)
+}
+
+func exceeds(v, threshold float64) bool {
+	return threshold > 0 && v >= threshold
+}
+
+// Config configures a Monitor.
+type Config struct {
+	// Interval is the time between two Reports.
+	Interval time.Duration
+
+	// Thresholds determine which Reports OnExceeded is called for.
+	Thresholds Thresholds
+
+	// OnReport, if set, is called with every Report.
+	OnReport func(Report)
+
+	// OnExceeded, if set, is called with every Report exceeding Thresholds,
+	// after OnReport. It keeps being called every Interval for as long as the
+	// table remains above any threshold.
+	OnExceeded func(Report)
+
+	// Sysctl holds the tunables nf_conntrack_max is read from on kernels older
+	// than 4.18, which do not report the maximum size of the table over Netlink.
+	// Defaults to sysctl.Default, which is only correct for StatsQueriers in the
+	// network namespace of the calling process.
+	Sysctl sysctl.Dir
+}
+
+// A Monitor periodically queries Conntrack statistics and produces Reports.
+type Monitor struct {
+	q   StatsQuerier
+	cfg Config
+
+	// Per-CPU counters and time of the previous sample.
+	prev     map[uint16]conntrack.Stats
+	prevTime time.Time
+}
+
+// New returns a Monitor that queries q for statistics. Since a Conn that has
+// joined multicast groups can no longer be used for queries, q must not be
+// listening for events.
+func New(q StatsQuerier, cfg Config) *Monitor {
+
+	if cfg.Sysctl == "" {
+		cfg.Sysctl = sysctl.Default
+	}
+
+	return &Monitor{q: q, cfg: cfg}
+}
+
+// Run produces a Report immediately and then every Interval, until ctx is done
+// or querying statistics fails. Returns ctx.Err() or the query error.
+// Run must not be called concurrently on the same Monitor.
+func (m *Monitor) Run(ctx context.Context) error {
+
+	if m.cfg.Interval <= 0 {
+		return errInterval
+	}
+
+	t := time.NewTicker(m.cfg.Interval)
+	defer t.Stop()
+
+	for {
+		r, err := m.Sample()
+		if err != nil {
+			return err
+		}
+
+		if m.cfg.OnReport != nil {
+			m.cfg.OnReport(r)
+		}
+		if m.cfg.OnExceeded != nil && m.cfg.Thresholds.Exceeded(r) {
+			m.cfg.OnExceeded(r)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Sample queries the kernel and returns a Report, with rates calculated against
+// the previous call to Sample. It does not invoke any callbacks.
+func (m *Monitor) Sample() (Report, error) {
+	return m.sample(time.Now())
+}
+
+// sample returns a Report for the given time.
+func (m *Monitor) sample(now time.Time) (Report, error) {
+
+	r := Report{Time: now}
+
+	sg, err := m.q.StatsGlobal()
+	if err != nil {
+		return r, err
+	}
+	r.Entries, r.MaxEntries = sg.Entries, sg.MaxEntries
+
+	if r.MaxEntries == 0 {
+		n, err := m.cfg.Sysctl.Max()
+		if err != nil {
+			return r, err
+		}
+		r.MaxEntries = uint32(n)
+	}
+
+	stats, err := m.q.Stats()
+	if err != nil {
+		return r, err
+	}
+
+	cur := make(map[uint16]conntrack.Stats, len(stats))
+	for _, s := range stats {
+		cur[s.CPUID] = s
+	}
+
+	if m.prev != nil {
+		var insertFailed, earlyDrop uint64
+
+		// Per-CPU counters are 32 bits wide, subtract them individually
+		// so a wrapped counter still yields the correct difference.
+		for cpu, s := range cur {
+			p, ok := m.prev[cpu]
+			if !ok {
+				continue
+			}
+			insertFailed += uint64(s.InsertFailed - p.InsertFailed)
+			earlyDrop += uint64(s.EarlyDrop - p.EarlyDrop)
+		}
+
+		if secs := now.Sub(m.prevTime).Seconds(); secs > 0 {
+			r.InsertFailedRate = float64(insertFailed) / secs
+			r.EarlyDropRate = float64(earlyDrop) / secs
+		}
+	}
+
+	m.prev, m.prevTime = cur, now
+
+	return r, nil
+}
@@ -0,0 +1,133 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ti-mo/conntrack"
+	"github.com/ti-mo/conntrack/sysctl"
+)
+
+type fakeQuerier struct {
+	mu     sync.Mutex
+	global conntrack.StatsGlobal
+	stats  []conntrack.Stats
+	err    error
+}
+
+func (fq *fakeQuerier) Stats() ([]conntrack.Stats, error) {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	return append([]conntrack.Stats(nil), fq.stats...), fq.err
+}
+
+func (fq *fakeQuerier) StatsGlobal() (conntrack.StatsGlobal, error) {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+	return fq.global, fq.err
+}
+
+func TestMonitorSample(t *testing.T) {
+
+	fq := &fakeQuerier{
+		global: conntrack.StatsGlobal{Entries: 750, MaxEntries: 1000},
+		stats: []conntrack.Stats{
+			{CPUID: 0, InsertFailed: 10, EarlyDrop: 100},
+			{CPUID: 1, InsertFailed: math.MaxUint32 - 1, EarlyDrop: 0},
+		},
+	}
+	m := New(fq, Config{})
+
+	start := time.Unix(1000, 0)
+	r, err := m.sample(start)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Time: start, Entries: 750, MaxEntries: 1000}, r)
+	assert.Equal(t, 0.75, r.Utilization())
+
+	// CPU 1's counter wraps around, CPU 2 has no baseline yet.
+	fq.stats = []conntrack.Stats{
+		{CPUID: 0, InsertFailed: 12, EarlyDrop: 120},
+		{CPUID: 1, InsertFailed: 2, EarlyDrop: 0},
+		{CPUID: 2, InsertFailed: 50, EarlyDrop: 50},
+	}
+
+	r, err = m.sample(start.Add(2 * time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, 3.0, r.InsertFailedRate)
+	assert.Equal(t, 10.0, r.EarlyDropRate)
+
+	fq.err = errors.New("netlink failure")
+	_, err = m.sample(start.Add(4 * time.Second))
+	assert.Equal(t, fq.err, err)
+}
+
+func TestMonitorSysctlMax(t *testing.T) {
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "nf_conntrack_max"), []byte("4000\n"), 0644))
+
+	// Kernels before 4.18 don't report MaxEntries.
+	fq := &fakeQuerier{global: conntrack.StatsGlobal{Entries: 1000}}
+
+	r, err := New(fq, Config{Sysctl: sysctl.Dir(dir)}).Sample()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4000), r.MaxEntries)
+	assert.Equal(t, 0.25, r.Utilization())
+
+	_, err = New(fq, Config{Sysctl: sysctl.Dir(t.TempDir())}).Sample()
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestThresholds(t *testing.T) {
+
+	r := Report{Entries: 900, MaxEntries: 1000, InsertFailedRate: 1}
+
+	assert.False(t, Thresholds{}.Exceeded(r))
+	assert.True(t, Thresholds{Utilization: 0.9}.Exceeded(r))
+	assert.False(t, Thresholds{Utilization: 0.95}.Exceeded(r))
+	assert.True(t, Thresholds{Utilization: 0.95, InsertFailedRate: 1}.Exceeded(r))
+	assert.False(t, Thresholds{EarlyDropRate: 1}.Exceeded(r))
+
+	assert.Equal(t, 0.0, Report{Entries: 1}.Utilization())
+}
+
+func TestMonitorRun(t *testing.T) {
+
+	fq := &fakeQuerier{global: conntrack.StatsGlobal{Entries: 950, MaxEntries: 1000}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var reports, exceeded int
+	m := New(fq, Config{
+		Interval:   time.Millisecond,
+		Thresholds: Thresholds{Utilization: 0.9},
+		OnReport: func(Report) {
+			reports++
+		},
+		OnExceeded: func(Report) {
+			exceeded++
+			if exceeded == 3 {
+				cancel()
+			}
+		},
+	})
+
+	assert.Equal(t, context.Canceled, m.Run(ctx))
+	assert.Equal(t, 3, reports)
+	assert.Equal(t, 3, exceeded)
+
+	fq.err = errors.New("netlink failure")
+	assert.Equal(t, fq.err, m.Run(context.Background()))
+
+	assert.Equal(t, errInterval, New(fq, Config{}).Run(context.Background()))
+}
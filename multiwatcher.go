@@ -0,0 +1,366 @@
+package conntrack
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/pkg/errors"
+)
+
+// A NamespaceEvent is an Event received by a MultiWatcher, along with the
+// name of the network namespace it occurred in.
+type NamespaceEvent struct {
+	Namespace string
+	Event
+}
+
+// A NamespaceFlow is a Flow dumped by a MultiWatcher, along with the name
+// of the network namespace it lives in.
+type NamespaceFlow struct {
+	Namespace string
+	Flow
+}
+
+// watchConn is the subset of Conn used by a MultiWatcher.
+type watchConn interface {
+	Listen(evChan chan<- Event, numWorkers uint8, groups []NetlinkGroup) (chan error, error)
+	Dump(opts ...DumpOption) ([]Flow, error)
+	Close() error
+	interrupt()
+}
+
+// A MultiWatcher listens for Events in many network namespaces at once, like the
+// namespaces of all pods on a Kubernetes node, and merges them into a single stream
+// of NamespaceEvents. Each network namespace has its own Conntrack table, so a Conn
+// only sees the connections of the namespace it was dialed into.
+//
+// Namespaces are added by file descriptor or by path, like /var/run/netns/cni-1234,
+// and identified by a name chosen by the caller. Watch keeps the watched namespaces
+// in line with the namespaces bind-mounted in a directory, the way CNI plugins and
+// `ip netns` expose them.
+//
+// A MultiWatcher holds two Conns per namespace, one listening for Events and one
+// for dumping its table. The sockets keep their namespace alive, so namespaces must
+// be removed from the MultiWatcher when they are torn down.
+type MultiWatcher struct {
+	evChan     chan<- NamespaceEvent
+	numWorkers uint8
+	groups     []NetlinkGroup
+
+	dial    func(ns int) (watchConn, error)
+	onError func(namespace string, err error)
+
+	mu         sync.Mutex
+	closed     bool
+	namespaces map[string]*watchedNS
+}
+
+// watchedNS is a network namespace watched by a MultiWatcher.
+type watchedNS struct {
+	name string
+
+	// path and info identify the file the namespace was added from, if any.
+	path string
+	info os.FileInfo
+
+	listener, querier watchConn
+
+	// done is closed to stop the namespace's forwarder, which closes stopped
+	// after closing the namespace's Conns.
+	done, stopped chan struct{}
+}
+
+// NewMultiWatcher returns a MultiWatcher sending the Events of all namespaces to
+// evChan. The Conns of each namespace are dialed using opts, and listen to groups
+// with numWorkers workers, like in Conn.Listen.
+func NewMultiWatcher(evChan chan<- NamespaceEvent, numWorkers uint8, groups []NetlinkGroup, opts ...Option) *MultiWatcher {
+	return &MultiWatcher{
+		evChan:     evChan,
+		numWorkers: numWorkers,
+		groups:     groups,
+		dial: func(ns int) (watchConn, error) {
+			return Dial(&netlink.Config{NetNS: ns}, opts...)
+		},
+		namespaces: make(map[string]*watchedNS),
+	}
+}
+
+// OnError registers fn to be called when watching a namespace fails, for example
+// because its Conn's receive buffer overran, or when a namespace found by Watch
+// cannot be added. A namespace that fails is no longer watched, but is added again
+// by the next Sync if it still exists. OnError must be called before adding namespaces.
+func (mw *MultiWatcher) OnError(fn func(namespace string, err error)) {
+	mw.onError = fn
+}
+
+// Add starts watching the network namespace referred to by the file descriptor fd
+// under the given name. The file descriptor is only used while Add runs.
+func (mw *MultiWatcher) Add(name string, fd int) error {
+	return mw.add(&watchedNS{name: name}, fd)
+}
+
+// AddPath starts watching the network namespace bind-mounted at path, or referred
+// to by a path like /proc/<pid>/ns/net, under the given name.
+func (mw *MultiWatcher) AddPath(name, path string) error {
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	return mw.add(&watchedNS{name: name, path: path, info: info}, int(f.Fd()))
+}
+
+func (mw *MultiWatcher) add(ns *watchedNS, fd int) error {
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	if mw.closed {
+		return errMultiWatcherClosed
+	}
+	if mw.namespaces[ns.name] != nil {
+		return errors.Errorf(errNamespaceWatched, ns.name)
+	}
+
+	var err error
+	if ns.querier, err = mw.dial(fd); err != nil {
+		return err
+	}
+	if ns.listener, err = mw.dial(fd); err != nil {
+		ns.querier.Close()
+		return err
+	}
+
+	evChan := make(chan Event, 1024)
+	errChan, err := ns.listener.Listen(evChan, mw.numWorkers, mw.groups)
+	if err != nil {
+		ns.listener.Close()
+		ns.querier.Close()
+		return err
+	}
+
+	ns.done, ns.stopped = make(chan struct{}), make(chan struct{})
+	mw.namespaces[ns.name] = ns
+
+	go mw.forward(ns, evChan, errChan)
+
+	return nil
+}
+
+// forward tags the Events of a namespace's listener and sends them to the
+// MultiWatcher's event channel, until the namespace is removed or a worker fails.
+func (mw *MultiWatcher) forward(ns *watchedNS, evChan chan Event, errChan chan error) {
+
+	defer close(ns.stopped)
+
+	running := int(mw.numWorkers)
+
+	func() {
+		for {
+			select {
+			case ev := <-evChan:
+				select {
+				case mw.evChan <- NamespaceEvent{Namespace: ns.name, Event: ev}:
+				case <-ns.done:
+					return
+				}
+			case err := <-errChan:
+				running--
+				mw.mu.Lock()
+				if mw.namespaces[ns.name] == ns {
+					delete(mw.namespaces, ns.name)
+				}
+				mw.mu.Unlock()
+				if mw.onError != nil {
+					mw.onError(ns.name, err)
+				}
+				return
+			case <-ns.done:
+				return
+			}
+		}
+	}()
+
+	// Stop the remaining workers, which report the interrupted read on errChan.
+	ns.listener.interrupt()
+	for running > 0 {
+		select {
+		case <-evChan:
+		case <-errChan:
+			running--
+		}
+	}
+
+	ns.listener.Close()
+	ns.querier.Close()
+}
+
+// Remove stops watching the namespace with the given name, and waits for its
+// Events to stop being sent to the event channel.
+func (mw *MultiWatcher) Remove(name string) error {
+
+	mw.mu.Lock()
+	ns := mw.namespaces[name]
+	delete(mw.namespaces, name)
+	mw.mu.Unlock()
+
+	if ns == nil {
+		return errors.Errorf(errNamespaceUnknown, name)
+	}
+
+	close(ns.done)
+	<-ns.stopped
+
+	return nil
+}
+
+// Close stops watching all namespaces. Namespaces can't be added after Close.
+func (mw *MultiWatcher) Close() error {
+
+	mw.mu.Lock()
+	mw.closed = true
+	nss := mw.namespaces
+	mw.namespaces = make(map[string]*watchedNS)
+	mw.mu.Unlock()
+
+	for _, ns := range nss {
+		close(ns.done)
+	}
+	for _, ns := range nss {
+		<-ns.stopped
+	}
+
+	return nil
+}
+
+// Namespaces returns the sorted names of the watched namespaces.
+func (mw *MultiWatcher) Namespaces() []string {
+
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	names := make([]string, 0, len(mw.namespaces))
+	for name := range mw.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Dump dumps the Conntrack tables of all watched namespaces in order of their name,
+// honouring opts like Conn.Dump. Returns the first error dumping a namespace.
+func (mw *MultiWatcher) Dump(opts ...DumpOption) ([]NamespaceFlow, error) {
+
+	mw.mu.Lock()
+	nss := make([]*watchedNS, 0, len(mw.namespaces))
+	for _, ns := range mw.namespaces {
+		nss = append(nss, ns)
+	}
+	mw.mu.Unlock()
+
+	sort.Slice(nss, func(i, j int) bool { return nss[i].name < nss[j].name })
+
+	var out []NamespaceFlow
+	for _, ns := range nss {
+		flows, err := ns.querier.Dump(opts...)
+		if err != nil {
+			return nil, errors.Wrapf(err, errNamespaceDump, ns.name)
+		}
+		for _, f := range flows {
+			out = append(out, NamespaceFlow{Namespace: ns.name, Flow: f})
+		}
+	}
+
+	return out, nil
+}
+
+// Sync watches the namespaces bind-mounted in dir, named after their file, and stops
+// watching namespaces previously added from dir that are gone. A file replaced by
+// another namespace under the same name is watched again. Namespaces added by other
+// means are left alone. Failures to add a namespace are reported to the OnError
+// callback, since namespaces can disappear at any time. A missing dir holds no
+// namespaces. Returns an error if dir can't be read.
+func (mw *MultiWatcher) Sync(dir string) error {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	found := make(map[string]os.FileInfo, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		// Stat through the bind mount to identify the namespace itself.
+		info, err := os.Stat(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		found[e.Name()] = info
+	}
+
+	mw.mu.Lock()
+	var stale []string
+	for name, ns := range mw.namespaces {
+		if ns.path != filepath.Join(dir, name) {
+			continue
+		}
+		if info, ok := found[name]; !ok || !os.SameFile(info, ns.info) {
+			stale = append(stale, name)
+		}
+	}
+	mw.mu.Unlock()
+
+	for _, name := range stale {
+		_ = mw.Remove(name)
+	}
+
+	for name := range found {
+		mw.mu.Lock()
+		watched := mw.namespaces[name] != nil
+		mw.mu.Unlock()
+		if watched {
+			continue
+		}
+
+		if err := mw.AddPath(name, filepath.Join(dir, name)); err != nil && mw.onError != nil {
+			mw.onError(name, err)
+		}
+	}
+
+	return nil
+}
+
+// Watch calls Sync for dir immediately and then every interval, until ctx is done
+// or Sync fails. Returns ctx.Err() or the error of Sync.
+func (mw *MultiWatcher) Watch(ctx context.Context, dir string, interval time.Duration) error {
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		if err := mw.Sync(dir); err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
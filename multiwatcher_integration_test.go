@@ -0,0 +1,65 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultiWatcher(t *testing.T) {
+
+	ca, nsa, err := makeNSConn()
+	require.NoError(t, err)
+	defer ca.Close()
+
+	cb, nsb, err := makeNSConn()
+	require.NoError(t, err)
+	defer cb.Close()
+
+	evChan := make(chan NamespaceEvent, 16)
+	mw := NewMultiWatcher(evChan, 1, []NetlinkGroup{GroupCTNew})
+	defer mw.Close()
+
+	require.NoError(t, mw.Add("a", nsa))
+	require.NoError(t, mw.Add("b", nsb))
+
+	// The same connection lives in both namespaces.
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0)
+	require.NoError(t, ca.Create(f))
+	require.NoError(t, cb.Create(f))
+
+	seen := make(map[string]bool)
+	for len(seen) < 2 {
+		select {
+		case ev := <-evChan:
+			assert.Equal(t, EventNew, ev.Type)
+			assert.Equal(t, f.TupleOrig.Proto, ev.Flow.TupleOrig.Proto)
+			seen[ev.Namespace] = true
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for events")
+		}
+	}
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, seen)
+
+	flows, err := mw.Dump()
+	require.NoError(t, err)
+	require.Len(t, flows, 2)
+	assert.Equal(t, "a", flows[0].Namespace)
+	assert.Equal(t, "b", flows[1].Namespace)
+
+	require.NoError(t, mw.Remove("a"))
+	require.NoError(t, cb.Delete(f))
+	require.NoError(t, cb.Create(f))
+
+	select {
+	case ev := <-evChan:
+		assert.Equal(t, "b", ev.Namespace)
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for event")
+	}
+}
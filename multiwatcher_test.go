@@ -0,0 +1,197 @@
+package conntrack
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var errFakeInterrupted = errors.New("fake conn interrupted")
+
+// fakeWatchConn is a watchConn whose workers run until it is interrupted.
+type fakeWatchConn struct {
+	flows []Flow
+
+	evChan  chan<- Event
+	errChan chan error
+
+	once        sync.Once
+	interrupted chan struct{}
+	closed      bool
+}
+
+func (c *fakeWatchConn) Listen(evChan chan<- Event, numWorkers uint8, _ []NetlinkGroup) (chan error, error) {
+
+	c.evChan = evChan
+	c.errChan = make(chan error)
+	for i := uint8(0); i < numWorkers; i++ {
+		go func() {
+			<-c.interrupted
+			c.errChan <- errFakeInterrupted
+		}()
+	}
+
+	return c.errChan, nil
+}
+
+func (c *fakeWatchConn) Dump(...DumpOption) ([]Flow, error) {
+	return c.flows, nil
+}
+
+func (c *fakeWatchConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func (c *fakeWatchConn) interrupt() {
+	c.once.Do(func() { close(c.interrupted) })
+}
+
+// fakeDialer hands out fakeWatchConns, recording them per dial.
+type fakeDialer struct {
+	mu    sync.Mutex
+	conns []*fakeWatchConn
+	flows []Flow
+}
+
+func (d *fakeDialer) dial(int) (watchConn, error) {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	c := &fakeWatchConn{flows: d.flows, interrupted: make(chan struct{})}
+	d.conns = append(d.conns, c)
+
+	return c, nil
+}
+
+// listener returns the listening Conn of the i-th added namespace.
+func (d *fakeDialer) listener(i int) *fakeWatchConn {
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.conns[2*i+1]
+}
+
+func newTestMultiWatcher(evChan chan NamespaceEvent) (*MultiWatcher, *fakeDialer) {
+
+	d := &fakeDialer{flows: []Flow{{ID: 1}, {ID: 2}}}
+	mw := NewMultiWatcher(evChan, 2, GroupsCT)
+	mw.dial = d.dial
+
+	return mw, d
+}
+
+func TestMultiWatcherEvents(t *testing.T) {
+
+	evChan := make(chan NamespaceEvent)
+	mw, d := newTestMultiWatcher(evChan)
+
+	require.NoError(t, mw.Add("pod-a", 0))
+	require.NoError(t, mw.Add("pod-b", 0))
+	assert.Equal(t, []string{"pod-a", "pod-b"}, mw.Namespaces())
+
+	assert.EqualError(t, mw.Add("pod-a", 0), "network namespace 'pod-a' is already watched")
+
+	d.listener(1).evChan <- Event{Type: EventNew, Flow: &Flow{ID: 42}}
+	ev := <-evChan
+	assert.Equal(t, "pod-b", ev.Namespace)
+	assert.Equal(t, uint32(42), ev.Flow.ID)
+
+	flows, err := mw.Dump()
+	require.NoError(t, err)
+	assert.Equal(t, []NamespaceFlow{
+		{"pod-a", Flow{ID: 1}}, {"pod-a", Flow{ID: 2}},
+		{"pod-b", Flow{ID: 1}}, {"pod-b", Flow{ID: 2}},
+	}, flows)
+
+	// Removing a namespace with an Event waiting to be delivered doesn't block.
+	d.listener(0).evChan <- Event{Type: EventNew, Flow: &Flow{ID: 43}}
+	require.NoError(t, mw.Remove("pod-a"))
+	assert.Equal(t, []string{"pod-b"}, mw.Namespaces())
+	assert.Error(t, mw.Remove("pod-a"))
+
+	require.NoError(t, mw.Close())
+	assert.Empty(t, mw.Namespaces())
+	assert.Equal(t, errMultiWatcherClosed, mw.Add("pod-c", 0))
+
+	for _, c := range d.conns {
+		assert.True(t, c.closed, "conn was not closed")
+	}
+}
+
+func TestMultiWatcherWorkerError(t *testing.T) {
+
+	mw, d := newTestMultiWatcher(make(chan NamespaceEvent))
+
+	errs := make(chan error, 1)
+	mw.OnError(func(ns string, err error) {
+		assert.Equal(t, "pod-a", ns)
+		errs <- err
+	})
+
+	require.NoError(t, mw.Add("pod-a", 0))
+
+	// A failing worker stops watching the namespace.
+	d.listener(0).errChan <- errors.New("overrun")
+	assert.EqualError(t, <-errs, "overrun")
+	assert.Empty(t, mw.Namespaces())
+
+	require.NoError(t, mw.Close())
+}
+
+func TestMultiWatcherSync(t *testing.T) {
+
+	dir := t.TempDir()
+	mw, _ := newTestMultiWatcher(make(chan NamespaceEvent))
+	defer mw.Close()
+
+	mw.OnError(func(ns string, err error) {
+		t.Errorf("unexpected error for namespace %s: %s", ns, err)
+	})
+
+	// A missing directory holds no namespaces.
+	require.NoError(t, mw.Sync(filepath.Join(dir, "missing")))
+	assert.Empty(t, mw.Namespaces())
+
+	touch := func(name string) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o600))
+	}
+
+	touch("cni-1")
+	touch("cni-2")
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "subdir"), 0o700))
+	require.NoError(t, mw.Add("other", 0))
+
+	require.NoError(t, mw.Sync(dir))
+	assert.Equal(t, []string{"cni-1", "cni-2", "other"}, mw.Namespaces())
+
+	mw.mu.Lock()
+	cni2 := mw.namespaces["cni-2"]
+	mw.mu.Unlock()
+
+	// cni-1 disappears, cni-2 is replaced by another namespace.
+	require.NoError(t, os.Remove(filepath.Join(dir, "cni-1")))
+	touch("cni-2.new")
+	require.NoError(t, os.Rename(filepath.Join(dir, "cni-2.new"), filepath.Join(dir, "cni-2")))
+	touch("cni-3")
+
+	require.NoError(t, mw.Sync(dir))
+	assert.Equal(t, []string{"cni-2", "cni-3", "other"}, mw.Namespaces())
+
+	mw.mu.Lock()
+	assert.True(t, cni2 != mw.namespaces["cni-2"], "namespace was not watched again")
+	mw.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, mw.Watch(ctx, dir, time.Hour))
+}
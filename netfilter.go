@@ -0,0 +1,38 @@
+package conntrack
+
+import "github.com/ti-mo/conntrack/internal/netfilter"
+
+// ProtoFamily is the protocol family of a Netfilter message, like the family
+// DumpFamily restricts a dump to. On Linux, it is an alias of
+// github.com/ti-mo/netfilter's ProtoFamily.
+type ProtoFamily = netfilter.ProtoFamily
+
+// Protocol families of Netfilter messages.
+const (
+	ProtoUnspec = netfilter.ProtoUnspec
+	ProtoIPv4   = netfilter.ProtoIPv4
+	ProtoIPv6   = netfilter.ProtoIPv6
+)
+
+// NetlinkGroup is a Netfilter multicast group, like the groups a Conn Listens
+// to for events. On Linux, it is an alias of github.com/ti-mo/netfilter's
+// NetlinkGroup.
+type NetlinkGroup = netfilter.NetlinkGroup
+
+// Multicast groups of Conntrack events.
+const (
+	GroupCTNew        = netfilter.GroupCTNew
+	GroupCTUpdate     = netfilter.GroupCTUpdate
+	GroupCTDestroy    = netfilter.GroupCTDestroy
+	GroupCTExpNew     = netfilter.GroupCTExpNew
+	GroupCTExpUpdate  = netfilter.GroupCTExpUpdate
+	GroupCTExpDestroy = netfilter.GroupCTExpDestroy
+)
+
+var (
+	// GroupsCT lists the multicast groups of Flow events.
+	GroupsCT = netfilter.GroupsCT
+
+	// GroupsCTExp lists the multicast groups of Expect events.
+	GroupsCTExp = netfilter.GroupsCTExp
+)
@@ -0,0 +1,131 @@
+package conntrack
+
+import (
+	"context"
+	"log/slog"
+)
+
+// An Option configures optional behaviour of a Conn. Options are passed to Dial.
+type Option func(*Conn)
+
+// WithLogger sets the logger the Conn reports non-fatal conditions to,
+// like receive buffer overruns and messages skipped in lenient mode.
+// By default, nothing is logged.
+func WithLogger(l *slog.Logger) Option {
+	return func(c *Conn) {
+		if l != nil {
+			c.logger = l
+		}
+	}
+}
+
+// WithLenientDecoding puts the Conn in lenient mode. Netlink messages that fail
+// to decode, for example because they carry attributes unknown to this package,
+// are logged and skipped instead of halting a Listen worker or failing a dump.
+// The amount of skipped messages is reflected in ConnStats.DecodeErrors, and
+// broken down by message type and failing attribute in Conn.DecodeErrorStats.
+func WithLenientDecoding() Option {
+	return func(c *Conn) {
+		c.lenient = true
+	}
+}
+
+// WithDecodeErrorCapture makes the Conn keep a copy of the last Netlink message
+// that failed to decode, returned by Conn.LastDecodeError. Meant for debugging,
+// the message is copied on every failure.
+func WithDecodeErrorCapture() Option {
+	return func(c *Conn) {
+		c.decodeErrors.capture = true
+	}
+}
+
+// WithStrictCheck enables strict checking (NETLINK_GET_STRICT_CHK) on the Conn's
+// socket, making the kernel reject malformed dump and get requests instead of
+// ignoring the parts it doesn't understand. Dial fails if the kernel doesn't
+// support it, which requires Linux 4.20 or later.
+func WithStrictCheck() Option {
+	return func(c *Conn) {
+		c.strict = true
+	}
+}
+
+// WithRecorder makes the Conn pass all Netlink messages it receives, both events
+// and replies to queries, to r. Use a Replayer to feed the recording back through
+// the event decoder later on.
+func WithRecorder(r *Recorder) Option {
+	return func(c *Conn) {
+		c.recorder = r
+	}
+}
+
+// WithCanonicalAddresses makes the Conn return all Flows, Expects and Events it
+// decodes with their addresses in canonical form: IPv4 addresses in their 4-byte
+// form, IPv6 addresses in their 16-byte form. By default, IPv4 addresses are decoded
+// in their 16-byte form. See Flow.Canonical.
+func WithCanonicalAddresses() Option {
+	return func(c *Conn) {
+		c.canonical = true
+	}
+}
+
+// discardHandler is a slog.Handler that drops all log records.
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (d discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return d }
+func (d discardHandler) WithGroup(string) slog.Handler           { return d }
+
+// A DumpOption configures a single dump of the Conntrack table. DumpOptions are
+// passed to Conn.Dump and Conn.DumpFilter.
+type DumpOption func(*DumpConfig)
+
+// DumpConfig is the configuration of a dump resulting from a list of DumpOptions.
+// It allows other implementations of Conntracker to honour DumpOptions.
+type DumpConfig struct {
+	// Family is the protocol family of the Flows to dump.
+	// ProtoUnspec dumps Flows of all families.
+	Family ProtoFamily
+
+	// Retries is the amount of times an interrupted dump is retried
+	// before giving up with ErrDumpInterrupted.
+	Retries int
+}
+
+// defaultDumpRetries is the amount of times an interrupted dump is retried by default.
+const defaultDumpRetries = 3
+
+// NewDumpConfig applies opts over the defaults, which dump Flows of all families
+// and retry interrupted dumps 3 times.
+func NewDumpConfig(opts ...DumpOption) DumpConfig {
+
+	dc := DumpConfig{Retries: defaultDumpRetries}
+	for _, opt := range opts {
+		opt(&dc)
+	}
+
+	return dc
+}
+
+// DumpFamily restricts a dump to Flows of the given protocol family, ProtoIPv4 or
+// ProtoIPv6. The kernel skips Flows of other families, so they are never sent to
+// or decoded by the Conn. ProtoUnspec dumps Flows of all families, which is the
+// default.
+func DumpFamily(pf ProtoFamily) DumpOption {
+	return func(dc *DumpConfig) {
+		dc.Family = pf
+	}
+}
+
+// DumpRetries sets the amount of times a dump is retried when the kernel reports
+// it was interrupted by changes to the table, before giving up with
+// ErrDumpInterrupted. Zero disables retries. Every retry is logged as a warning
+// to the Conn's logger, see WithLogger.
+func DumpRetries(n int) DumpOption {
+	return func(dc *DumpConfig) {
+		if n < 0 {
+			n = 0
+		}
+		dc.Retries = n
+	}
+}
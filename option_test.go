@@ -0,0 +1,169 @@
+package conntrack
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+// badFlowMessage is a Netlink message holding a non-nested CTA_TUPLE_ORIG attribute.
+var badFlowMessage = netlink.Message{
+	Header: netlink.Header{Type: netlink.HeaderType(netfilter.NFSubsysCTNetlink) << 8},
+	Data: []byte{
+		1, 2, 3, 4, // random 4-byte nfgenmsg
+		4, 0, 1, 0, // 4-byte (empty) netlink attribute of type 1
+	},
+}
+
+func TestOptions(t *testing.T) {
+
+	var c Conn
+
+	WithLogger(nil)(&c)
+	assert.Nil(t, c.logger, "nil logger must not be applied")
+
+	l := slog.Default()
+	WithLogger(l)(&c)
+	assert.Equal(t, l, c.logger)
+
+	WithLenientDecoding()(&c)
+	assert.True(t, c.lenient)
+
+	WithCanonicalAddresses()(&c)
+	assert.True(t, c.canonical)
+
+	WithDecodeErrorCapture()(&c)
+	assert.True(t, c.decodeErrors.capture)
+
+	WithStrictCheck()(&c)
+	assert.True(t, c.strict)
+}
+
+func TestConnUnmarshalFlowsLenient(t *testing.T) {
+
+	good, err := netfilter.MarshalNetlink(netfilter.Header{SubsystemID: netfilter.NFSubsysCTNetlink}, nil)
+	require.NoError(t, err)
+
+	nlm := []netlink.Message{good, badFlowMessage, good}
+
+	var buf bytes.Buffer
+	c := Conn{logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+	_, err = c.unmarshalFlows(nlm)
+	require.EqualError(t, err, "Tuple unmarshal: need a Nested attribute to decode this structure")
+
+	c.lenient = true
+
+	flows, err := c.unmarshalFlows(nlm)
+	require.NoError(t, err)
+	assert.Len(t, flows, 2)
+	assert.EqualValues(t, 1, c.ConnStats().DecodeErrors)
+	assert.Contains(t, buf.String(), `level=WARN msg="skipping undecodable flow" error="Tuple unmarshal: need a Nested attribute`)
+}
+
+func TestDiscardHandler(t *testing.T) {
+	l := slog.New(discardHandler{}).With("key", "value").WithGroup("group")
+	assert.False(t, l.Enabled(nil, slog.LevelError))
+	l.Error("dropped")
+}
+
+func TestDumpConfig(t *testing.T) {
+
+	assert.Equal(t, DumpConfig{Family: ProtoUnspec, Retries: 3}, NewDumpConfig())
+	assert.Equal(t, DumpConfig{Family: ProtoIPv6, Retries: 3}, NewDumpConfig(DumpFamily(ProtoIPv4), DumpFamily(ProtoIPv6)))
+	assert.Equal(t, DumpConfig{Retries: 0}, NewDumpConfig(DumpRetries(-1)))
+	assert.Equal(t, DumpConfig{Retries: 10}, NewDumpConfig(DumpRetries(10)))
+}
+
+func TestRetryDump(t *testing.T) {
+
+	intr := []netlink.Message{{Header: netlink.Header{Flags: netlink.Multi | netlink.DumpInterrupted}}}
+	ok := []netlink.Message{{Header: netlink.Header{Flags: netlink.Multi}}}
+
+	// query returns the given replies in order, repeating the last one.
+	query := func(calls *int, replies ...[]netlink.Message) func() ([]netlink.Message, error) {
+		return func() ([]netlink.Message, error) {
+			r := replies[min(*calls, len(replies)-1)]
+			*calls++
+			return r, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	c := &Conn{logger: slog.New(slog.NewTextHandler(&buf, nil))}
+
+	var calls int
+	nlm, err := c.retryDump(query(&calls, intr, intr, ok), 3)
+	require.NoError(t, err)
+	assert.Equal(t, ok, nlm)
+	assert.Equal(t, 3, calls)
+	assert.EqualValues(t, 2, c.stats.dumpsInterrupted)
+
+	// Every retry is logged with the number of the interrupted attempt.
+	assert.Contains(t, buf.String(), `level=WARN msg="retrying dump interrupted by changes to the table" attempt=1 retries=3`)
+	assert.Contains(t, buf.String(), `level=WARN msg="retrying dump interrupted by changes to the table" attempt=2 retries=3`)
+	assert.NotContains(t, buf.String(), "attempt=3")
+
+	calls, c.stats.dumpsInterrupted = 0, 0
+	buf.Reset()
+	_, err = c.retryDump(query(&calls, intr), 2)
+	assert.Equal(t, ErrDumpInterrupted, err)
+	assert.Equal(t, 3, calls)
+	assert.EqualValues(t, 3, c.stats.dumpsInterrupted)
+	assert.Equal(t, 2, strings.Count(buf.String(), "\n"))
+
+	calls = 0
+	_, err = c.retryDump(query(&calls, intr, ok), 0)
+	assert.Equal(t, ErrDumpInterrupted, err)
+	assert.Equal(t, 1, calls)
+
+	_, err = c.retryDump(func() ([]netlink.Message, error) { return nil, errors.New("query failed") }, 3)
+	assert.EqualError(t, err, "query failed")
+}
+
+func TestConnUnmarshalCanonical(t *testing.T) {
+
+	f := NewFlow(17, 0, net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2), 1234, 53, 120, 0)
+	attrs, err := f.marshal()
+	require.NoError(t, err)
+
+	nlm, err := netfilter.MarshalNetlink(netfilter.Header{
+		SubsystemID: netfilter.NFSubsysCTNetlink,
+		MessageType: netfilter.MessageType(ctNew),
+		Flags:       netlink.Create | netlink.Excl,
+	}, attrs)
+	require.NoError(t, err)
+
+	c := Conn{logger: slog.New(discardHandler{})}
+
+	flows, err := c.unmarshalFlows([]netlink.Message{nlm})
+	require.NoError(t, err)
+	assert.Len(t, flows[0].TupleOrig.IP.SourceAddress, net.IPv6len)
+
+	c.canonical = true
+
+	flows, err = c.unmarshalFlows([]netlink.Message{nlm})
+	require.NoError(t, err)
+	assert.Equal(t, net.IP{192, 0, 2, 1}, flows[0].TupleOrig.IP.SourceAddress)
+	assert.Equal(t, net.IP{192, 0, 2, 1}, flows[0].TupleReply.IP.DestinationAddress)
+
+	c.lenient = true
+
+	flows, err = c.unmarshalFlows([]netlink.Message{nlm})
+	require.NoError(t, err)
+	assert.Len(t, flows[0].TupleOrig.IP.DestinationAddress, net.IPv4len)
+
+	ev, ok, err := c.decodeEvent(0, nlm)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, EventNew, ev.Type)
+	assert.Equal(t, net.IP{192, 0, 2, 2}, ev.Flow.TupleOrig.IP.DestinationAddress)
+}
@@ -0,0 +1,23 @@
+module github.com/ti-mo/conntrack/otelconntrack
+
+go 1.25.0
+
+require (
+	github.com/mdlayher/netlink v1.1.2-0.20201013204415-ded538f7f4be
+	github.com/stretchr/testify v1.12.1
+	github.com/ti-mo/conntrack v0.0.0
+	github.com/vishvananda/netns v0.0.0-20180720170159-13995c7128cc
+	go.opentelemetry.io/otel v1.46.0
+	go.opentelemetry.io/otel/trace v1.46.0
+)
+
+require (
+	github.com/cespare/xxhash/v2 v2.3.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/ti-mo/netfilter v0.3.1 // indirect
+	go.yaml.in/yaml/v3 v3.0.5 // indirect
+	golang.org/x/net v0.0.0-20201016165138-7b1cca2348c0 // indirect
+	golang.org/x/sys v0.0.0-20201017003518-b09fb700fbb7 // indirect
+)
+
+replace github.com/ti-mo/conntrack => ../
@@ -0,0 +1,258 @@
+// Package otelconntrack traces the Netlink requests and events of a conntrack.Conn
+// using OpenTelemetry.
+//
+// A Hook is a conntrack.OperationHook starting a span for every operation of a Conn,
+// like Dump, Get, Create, Update and Delete, covering the whole call including retries
+// of interrupted dumps and decoding the replies. It is also a conntrack.ReceiveHook
+// starting a child span for every request the operation makes to the kernel, covering
+// the round trip up until all replies were received. Use the Context variants of the
+// Conn's methods, like DumpContext, to make the operation spans children of the span
+// in the operation's context:
+//
+//	h := otelconntrack.NewHook(tp)
+//	c, err := conntrack.Dial(nil, conntrack.WithOperationHook(h), conntrack.WithReceiveHook(h))
+//	...
+//	flows, err := c.DumpContext(ctx)
+//
+// Listen workers of a Conn with a Hook start a root span for every event they read
+// and decode, with a child span covering the time the worker waited for the event.
+// Reads that return a burst of events immediately mean the workers are falling behind
+// the kernel.
+//
+// Operation spans carry the amount of Flows, Expects, Stats or Events produced. Request
+// spans carry the amount of messages and bytes exchanged with the kernel, and the amount
+// of Flows or Expects received. This package lives in its own module, so the conntrack
+// package does not depend on OpenTelemetry.
+package otelconntrack
+
+import (
+	"context"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// ScopeName is the instrumentation scope name of the Tracer used by a Hook.
+const ScopeName = "github.com/ti-mo/conntrack/otelconntrack"
+
+// Attribute keys of the spans started by a Hook.
+const (
+	// The amount of Flows, Expects, Stats or Events produced by an operation.
+	ResultsKey = attribute.Key("conntrack.results")
+	// The amount of Netlink messages received.
+	MessagesKey = attribute.Key("conntrack.messages")
+	// The amount of messages received carrying a Flow or Expect.
+	FlowsKey = attribute.Key("conntrack.flows")
+	// The size in bytes of the request sent to the kernel.
+	RequestBytesKey = attribute.Key("conntrack.request.bytes")
+	// The size in bytes of all messages received.
+	ResponseBytesKey = attribute.Key("conntrack.response.bytes")
+	// Set if the kernel reported a dump as interrupted. The dump is retried in
+	// another request span of the same operation, according to conntrack.DumpRetries.
+	DumpInterruptedKey = attribute.Key("conntrack.dump.interrupted")
+	// The type of an event, like EventNew, EventUpdate or EventDestroy.
+	EventTypeKey = attribute.Key("conntrack.event.type")
+)
+
+const (
+	// nlmsgHeaderLen is the length of a Netlink message header.
+	nlmsgHeaderLen = 16
+	// nfHeaderLen is the length of the Netfilter header preceding the attributes.
+	nfHeaderLen = 4
+	// attrTypeMask strips the nested and byte order flags from an attribute type.
+	attrTypeMask = 0x3fff
+)
+
+// Netfilter subsystems and Conntrack message types, from the kernel's
+// enum cntl_msg_types and enum ctnl_exp_msg_types.
+const (
+	subsysCT    = 1
+	subsysCTExp = 2
+
+	msgNew         = 0
+	msgGet         = 1
+	msgDelete      = 2
+	msgGetCtrZero  = 3
+	msgGetStatsCPU = 4
+	msgGetStats    = 5
+	msgGetDying    = 6
+	msgGetUnconf   = 7
+
+	// CTA_TUPLE_ORIG
+	ctaTupleOrig = 1
+)
+
+// A Hook starts OpenTelemetry spans for the operations, requests and events of a Conn.
+// Add it to a Conn using both conntrack.WithOperationHook and conntrack.WithReceiveHook.
+// A Hook is safe for concurrent use.
+type Hook struct {
+	tracer trace.Tracer
+}
+
+var (
+	_ conntrack.OperationHook = (*Hook)(nil)
+	_ conntrack.ReceiveHook   = (*Hook)(nil)
+)
+
+// NewHook returns a Hook starting spans using a Tracer of tp.
+func NewHook(tp trace.TracerProvider) *Hook {
+	return &Hook{tracer: tp.Tracer(ScopeName)}
+}
+
+// StartOperation starts a span for the operation of a Conn with the given name, named
+// after the Conn's method, like conntrack.Conn.Dump. Returns a context holding the span
+// and the function ending it.
+func (h *Hook) StartOperation(ctx context.Context, name string) (context.Context, func(int, error)) {
+
+	ctx, span := h.tracer.Start(ctx, "conntrack.Conn."+name, trace.WithSpanKind(trace.SpanKindInternal))
+
+	return ctx, func(n int, err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		} else {
+			span.SetAttributes(ResultsKey.Int(n))
+		}
+		span.End()
+	}
+}
+
+// AfterReceive starts and ends a span for the request or event described by r,
+// and returns r's messages and error unmodified.
+func (h *Hook) AfterReceive(ctx context.Context, r conntrack.Receipt) ([]netlink.Message, error) {
+
+	name, kind := "conntrack.Receive", trace.SpanKindConsumer
+	if r.Request != nil {
+		name, kind = spanName(*r.Request), trace.SpanKindClient
+	}
+
+	_, span := h.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithTimestamp(r.Start))
+	defer span.End(trace.WithTimestamp(r.Start.Add(r.Duration)))
+
+	var flows, bytes int
+	var interrupted bool
+	for _, m := range r.Messages {
+		bytes += nlmsgHeaderLen + len(m.Data)
+		if s := m.Header.Type >> 8; s == subsysCT || s == subsysCTExp {
+			flows++
+		}
+		if m.Header.Flags&netlink.DumpInterrupted != 0 {
+			interrupted = true
+		}
+	}
+
+	span.SetAttributes(
+		MessagesKey.Int(len(r.Messages)),
+		FlowsKey.Int(flows),
+		ResponseBytesKey.Int(bytes),
+	)
+	if r.Request != nil {
+		span.SetAttributes(RequestBytesKey.Int(nlmsgHeaderLen + len(r.Request.Data)))
+	}
+	if interrupted {
+		span.SetAttributes(DumpInterruptedKey.Bool(true))
+	}
+	if r.Request == nil && len(r.Messages) == 1 {
+		if t := eventType(r.Messages[0].Header); t != "" {
+			span.SetAttributes(EventTypeKey.String(t))
+		}
+	}
+
+	if r.Err != nil {
+		span.RecordError(r.Err)
+		span.SetStatus(codes.Error, r.Err.Error())
+	}
+
+	return r.Messages, r.Err
+}
+
+// spanName returns the name of the span of a request, after the Conn method
+// making it.
+func spanName(req netlink.Message) string {
+
+	h := req.Header
+	dump := h.Flags&netlink.Dump == netlink.Dump
+
+	switch subsys, msg := h.Type>>8, h.Type&0xff; subsys {
+	case subsysCT:
+		switch msg {
+		case msgNew:
+			if h.Flags&netlink.Create != 0 {
+				return "conntrack.Create"
+			}
+			return "conntrack.Update"
+		case msgGet:
+			if dump {
+				return "conntrack.Dump"
+			}
+			return "conntrack.Get"
+		case msgDelete:
+			// Deletes look up an entry by its tuple, flushes carry no tuple.
+			if firstAttribute(req.Data) == ctaTupleOrig {
+				return "conntrack.Delete"
+			}
+			return "conntrack.Flush"
+		case msgGetCtrZero:
+			return "conntrack.DumpZero"
+		case msgGetStatsCPU:
+			return "conntrack.Stats"
+		case msgGetStats:
+			return "conntrack.StatsGlobal"
+		case msgGetDying:
+			return "conntrack.DumpDying"
+		case msgGetUnconf:
+			return "conntrack.DumpUnconfirmed"
+		}
+	case subsysCTExp:
+		switch msg {
+		case msgNew:
+			return "conntrack.CreateExpect"
+		case msgGet:
+			return "conntrack.DumpExpect"
+		case msgDelete:
+			return "conntrack.FlushExpect"
+		case msgGetCtrZero:
+			return "conntrack.StatsExpect"
+		}
+	}
+
+	return "conntrack.Query"
+}
+
+// firstAttribute returns the type of the first attribute in the payload b of a
+// Netfilter message, or 0 if it has no attributes.
+func firstAttribute(b []byte) uint16 {
+
+	// Skip the Netfilter header and the attribute's length.
+	if len(b) < nfHeaderLen+4 {
+		return 0
+	}
+
+	return nlenc.Uint16(b[nfHeaderLen+2:nfHeaderLen+4]) & attrTypeMask
+}
+
+// eventType returns the name of the type of the event in a message with header h,
+// like conntrack.EventNew, or an empty string if h is not an event.
+func eventType(h netlink.Header) string {
+
+	switch subsys, msg := h.Type>>8, h.Type&0xff; {
+	case subsys == subsysCT && msg == msgNew:
+		if h.Flags&(netlink.Create|netlink.Excl) != 0 {
+			return "EventNew"
+		}
+		return "EventUpdate"
+	case subsys == subsysCT && msg == msgDelete:
+		return "EventDestroy"
+	case subsys == subsysCTExp && msg == msgNew:
+		return "EventExpNew"
+	case subsys == subsysCTExp && msg == msgDelete:
+		return "EventExpDestroy"
+	}
+
+	return ""
+}
@@ -0,0 +1,82 @@
+//go:build integration
+
+package otelconntrack
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netns"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ti-mo/conntrack"
+)
+
+func TestHookConn(t *testing.T) {
+
+	ns, err := netns.New()
+	require.NoError(t, err)
+	defer ns.Close()
+
+	var tp recorder
+	h := NewHook(&tp)
+	c, err := conntrack.Dial(&netlink.Config{NetNS: int(ns)}, conntrack.WithOperationHook(h), conntrack.WithReceiveHook(h))
+	require.NoError(t, err)
+	defer c.Close()
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{2}})
+	ctx := trace.ContextWithSpanContext(context.Background(), parent)
+
+	f := conntrack.NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0)
+	require.NoError(t, c.CreateContext(ctx, f))
+	require.NoError(t, c.UpdateContext(ctx, f))
+	_, err = c.GetContext(ctx, f)
+	require.NoError(t, err)
+
+	flows, err := c.DumpContext(ctx)
+	require.NoError(t, err)
+	require.Len(t, flows, 1)
+
+	require.NoError(t, c.DeleteContext(ctx, f))
+	assert.Error(t, c.DeleteContext(ctx, f))
+
+	// Operations without a context start root spans.
+	require.NoError(t, c.Flush())
+
+	var names []string
+	for _, s := range tp.spans {
+		names = append(names, s.name)
+		assert.True(t, s.ended)
+	}
+	assert.Equal(t, []string{
+		"conntrack.Conn.Create", "conntrack.Create",
+		"conntrack.Conn.Update", "conntrack.Update",
+		"conntrack.Conn.Get", "conntrack.Get",
+		"conntrack.Conn.Dump", "conntrack.Dump",
+		"conntrack.Conn.Delete", "conntrack.Delete",
+		"conntrack.Conn.Delete", "conntrack.Delete",
+		"conntrack.Conn.Flush", "conntrack.Flush",
+	}, names)
+
+	// Every operation span has its request span as child.
+	for i := 0; i < len(tp.spans); i += 2 {
+		op, req := tp.spans[i], tp.spans[i+1]
+		assert.Equal(t, op.sc, req.parent)
+		assert.False(t, req.start.Before(op.start))
+		assert.False(t, req.end.Before(req.start))
+		if i < 12 {
+			assert.Equal(t, parent, op.parent)
+		}
+	}
+	assert.False(t, tp.spans[12].parent.IsValid())
+
+	assert.Equal(t, attribute.IntValue(1), tp.spans[6].attrs[ResultsKey])
+	assert.Equal(t, attribute.IntValue(1), tp.spans[7].attrs[FlowsKey])
+	assert.Len(t, tp.spans[10].errs, 1)
+	assert.Len(t, tp.spans[11].errs, 1)
+}
@@ -0,0 +1,290 @@
+package otelconntrack
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// recorder is a TracerProvider recording the spans started by its Tracers.
+type recorder struct {
+	embedded.TracerProvider
+
+	mu    sync.Mutex
+	scope string
+	spans []*span
+}
+
+type tracer struct {
+	embedded.Tracer
+	r *recorder
+}
+
+type span struct {
+	noop.Span
+
+	name   string
+	sc     trace.SpanContext
+	parent trace.SpanContext
+	kind   trace.SpanKind
+	start  time.Time
+	end    time.Time
+	ended  bool
+	attrs  map[attribute.Key]attribute.Value
+	status codes.Code
+	errs   []error
+}
+
+func (r *recorder) Tracer(name string, _ ...trace.TracerOption) trace.Tracer {
+	r.scope = name
+	return tracer{r: r}
+}
+
+func (t tracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+
+	cfg := trace.NewSpanStartConfig(opts...)
+	s := &span{
+		name:   name,
+		parent: trace.SpanContextFromContext(ctx),
+		kind:   cfg.SpanKind(),
+		start:  cfg.Timestamp(),
+		attrs:  make(map[attribute.Key]attribute.Value),
+	}
+
+	t.r.mu.Lock()
+	t.r.spans = append(t.r.spans, s)
+	s.sc = trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1}, SpanID: trace.SpanID{0xff, byte(len(t.r.spans))}})
+	t.r.mu.Unlock()
+
+	return trace.ContextWithSpan(ctx, s), s
+}
+
+func (s *span) SpanContext() trace.SpanContext {
+	return s.sc
+}
+
+func (s *span) End(opts ...trace.SpanEndOption) {
+	cfg := trace.NewSpanEndConfig(opts...)
+	s.end, s.ended = cfg.Timestamp(), true
+}
+
+func (s *span) SetAttributes(kv ...attribute.KeyValue) {
+	for _, a := range kv {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *span) SetStatus(c codes.Code, _ string) {
+	s.status = c
+}
+
+func (s *span) RecordError(err error, _ ...trace.EventOption) {
+	s.errs = append(s.errs, err)
+}
+
+// message returns a message of the given subsystem and type, with a single
+// nested attribute of type attr if attr is non-zero.
+func message(subsys, msg netlink.HeaderType, flags netlink.HeaderFlags, attr byte) netlink.Message {
+
+	m := netlink.Message{
+		Header: netlink.Header{Type: subsys<<8 | msg, Flags: flags},
+		Data:   []byte{2, 0, 0, 0},
+	}
+	if attr != 0 {
+		m.Data = append(m.Data, 8, 0, attr, 0x80, 4, 0, 0, 0)
+	}
+
+	return m
+}
+
+func TestHookRequest(t *testing.T) {
+
+	var tp recorder
+	h := NewHook(&tp)
+	assert.Equal(t, ScopeName, tp.scope)
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID: trace.TraceID{1},
+		SpanID:  trace.SpanID{2},
+	})
+	ctx := trace.ContextWithSpanContext(context.Background(), parent)
+
+	req := message(subsysCT, msgGet, netlink.Request|netlink.Dump, 0)
+	replies := []netlink.Message{
+		message(subsysCT, msgNew, netlink.Multi, 1),
+		message(subsysCT, msgNew, netlink.Multi|netlink.DumpInterrupted, 1),
+		{Header: netlink.Header{Type: netlink.Done}, Data: []byte{0, 0, 0, 0}},
+	}
+	start := time.Unix(1000, 0)
+
+	out, err := h.AfterReceive(ctx, conntrack.Receipt{
+		Request:  &req,
+		Messages: replies,
+		Start:    start,
+		Duration: time.Millisecond,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, replies, out)
+
+	require.Len(t, tp.spans, 1)
+	s := tp.spans[0]
+	assert.Equal(t, "conntrack.Dump", s.name)
+	assert.Equal(t, parent, s.parent)
+	assert.Equal(t, trace.SpanKindClient, s.kind)
+	assert.Equal(t, start, s.start)
+	assert.Equal(t, start.Add(time.Millisecond), s.end)
+	assert.True(t, s.ended)
+	assert.Equal(t, map[attribute.Key]attribute.Value{
+		MessagesKey:        attribute.IntValue(3),
+		FlowsKey:           attribute.IntValue(2),
+		RequestBytesKey:    attribute.IntValue(20),
+		ResponseBytesKey:   attribute.IntValue(3*16 + 12 + 12 + 4),
+		DumpInterruptedKey: attribute.BoolValue(true),
+	}, s.attrs)
+	assert.Equal(t, codes.Unset, s.status)
+
+	// Errors are recorded and returned.
+	errKernel := errors.New("no such file or directory")
+	req = message(subsysCT, msgDelete, netlink.Request|netlink.Acknowledge, 1)
+	out, err = h.AfterReceive(ctx, conntrack.Receipt{Request: &req, Err: errKernel, Start: start})
+	assert.Nil(t, out)
+	assert.Equal(t, errKernel, err)
+
+	require.Len(t, tp.spans, 2)
+	s = tp.spans[1]
+	assert.Equal(t, "conntrack.Delete", s.name)
+	assert.Equal(t, codes.Error, s.status)
+	assert.Equal(t, []error{errKernel}, s.errs)
+	assert.Equal(t, attribute.IntValue(0), s.attrs[FlowsKey])
+}
+
+func TestHookOperation(t *testing.T) {
+
+	var tp recorder
+	h := NewHook(&tp)
+
+	ctx, end := h.StartOperation(context.Background(), "Dump")
+
+	req := message(subsysCT, msgGet, netlink.Request|netlink.Dump, 0)
+	_, err := h.AfterReceive(ctx, conntrack.Receipt{Request: &req, Start: time.Now()})
+	require.NoError(t, err)
+
+	end(3, nil)
+
+	require.Len(t, tp.spans, 2)
+	op, s := tp.spans[0], tp.spans[1]
+	assert.Equal(t, "conntrack.Conn.Dump", op.name)
+	assert.False(t, op.parent.IsValid())
+	assert.Equal(t, trace.SpanKindInternal, op.kind)
+	assert.True(t, op.ended)
+	assert.Equal(t, map[attribute.Key]attribute.Value{ResultsKey: attribute.IntValue(3)}, op.attrs)
+	assert.Equal(t, codes.Unset, op.status)
+
+	// Requests made by the operation are its children.
+	assert.Equal(t, op.sc, s.parent)
+
+	// Errors are recorded instead of the amount of results.
+	errKernel := errors.New("no such file or directory")
+	_, end = h.StartOperation(context.Background(), "Get")
+	end(1, errKernel)
+
+	require.Len(t, tp.spans, 3)
+	op = tp.spans[2]
+	assert.Equal(t, "conntrack.Conn.Get", op.name)
+	assert.Equal(t, codes.Error, op.status)
+	assert.Equal(t, []error{errKernel}, op.errs)
+	assert.Empty(t, op.attrs)
+}
+
+func TestHookEvent(t *testing.T) {
+
+	var tp recorder
+	h := NewHook(&tp)
+
+	ev := message(subsysCT, msgNew, netlink.Create|netlink.Excl, 1)
+	start := time.Unix(1000, 0)
+
+	_, err := h.AfterReceive(context.Background(), conntrack.Receipt{
+		Messages: []netlink.Message{ev},
+		Start:    start,
+		Duration: time.Second,
+	})
+	require.NoError(t, err)
+
+	require.Len(t, tp.spans, 1)
+	s := tp.spans[0]
+	assert.Equal(t, "conntrack.Receive", s.name)
+	assert.False(t, s.parent.IsValid())
+	assert.Equal(t, trace.SpanKindConsumer, s.kind)
+	assert.Equal(t, start.Add(time.Second), s.end)
+	assert.Equal(t, attribute.StringValue("EventNew"), s.attrs[EventTypeKey])
+	assert.Equal(t, attribute.IntValue(1), s.attrs[FlowsKey])
+	_, ok := s.attrs[RequestBytesKey]
+	assert.False(t, ok)
+}
+
+func TestSpanName(t *testing.T) {
+
+	req := netlink.Request | netlink.Acknowledge
+	dump := netlink.Request | netlink.Dump
+
+	tests := []struct {
+		msg  netlink.Message
+		name string
+	}{
+		{message(subsysCT, msgNew, req|netlink.Create|netlink.Excl, 1), "conntrack.Create"},
+		{message(subsysCT, msgNew, req, 1), "conntrack.Update"},
+		{message(subsysCT, msgGet, req, 1), "conntrack.Get"},
+		{message(subsysCT, msgGet, dump, 0), "conntrack.Dump"},
+		{message(subsysCT, msgDelete, req, 1), "conntrack.Delete"},
+		{message(subsysCT, msgDelete, req, 0), "conntrack.Flush"},
+		{message(subsysCT, msgDelete, req, 8), "conntrack.Flush"},
+		{message(subsysCT, msgGetCtrZero, dump, 0), "conntrack.DumpZero"},
+		{message(subsysCT, msgGetStatsCPU, dump, 0), "conntrack.Stats"},
+		{message(subsysCT, msgGetStats, req, 0), "conntrack.StatsGlobal"},
+		{message(subsysCT, msgGetDying, dump, 0), "conntrack.DumpDying"},
+		{message(subsysCT, msgGetUnconf, dump, 0), "conntrack.DumpUnconfirmed"},
+		{message(subsysCTExp, msgNew, req|netlink.Create, 1), "conntrack.CreateExpect"},
+		{message(subsysCTExp, msgGet, dump, 0), "conntrack.DumpExpect"},
+		{message(subsysCTExp, msgDelete, req, 0), "conntrack.FlushExpect"},
+		{message(subsysCTExp, msgGetCtrZero, dump, 0), "conntrack.StatsExpect"},
+		{message(subsysCT, 9, req, 0), "conntrack.Query"},
+		{message(3, msgGet, req, 0), "conntrack.Query"},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.name, spanName(tt.msg))
+	}
+}
+
+func TestEventType(t *testing.T) {
+
+	tests := []struct {
+		h    netlink.Header
+		name string
+	}{
+		{netlink.Header{Type: subsysCT<<8 | msgNew, Flags: netlink.Create | netlink.Excl}, "EventNew"},
+		{netlink.Header{Type: subsysCT<<8 | msgNew}, "EventUpdate"},
+		{netlink.Header{Type: subsysCT<<8 | msgDelete}, "EventDestroy"},
+		{netlink.Header{Type: subsysCTExp<<8 | msgNew}, "EventExpNew"},
+		{netlink.Header{Type: subsysCTExp<<8 | msgDelete}, "EventExpDestroy"},
+		{netlink.Header{Type: subsysCT<<8 | msgGet}, ""},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.name, eventType(tt.h))
+	}
+}
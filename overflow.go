@@ -0,0 +1,216 @@
+package conntrack
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// dropLogInterval is the minimum time between two warnings about Events dropped
+// by an OverflowPolicy.
+const dropLogInterval = 10 * time.Second
+
+// An OverflowPolicy decides what happens to Events decoded by Listen workers when
+// the consumer of evChan falls behind. See WithEventOverflow.
+type OverflowPolicy uint8
+
+// Policies for Events that don't fit in evChan or the Conn's event buffer.
+const (
+	// OverflowBlock blocks the Listen workers until there is room for the Event.
+	// Events pile up in the socket's receive buffer in the meantime, and are
+	// dropped by the kernel when it overruns.
+	OverflowBlock OverflowPolicy = iota
+
+	// OverflowDropNewest drops the Event that didn't fit.
+	OverflowDropNewest
+
+	// OverflowDropOldest drops the oldest Event waiting in the event buffer
+	// to make room for the new one.
+	OverflowDropOldest
+)
+
+// WithEventOverflow sets what Listen does with Events when evChan is full. When size is
+// positive, Events that don't fit in evChan spill into a ring buffer holding up to size
+// Events, which is drained into evChan as the consumer catches up. The policy applies
+// once the buffer is full, or to evChan itself when size is 0. OverflowDropOldest always
+// buffers at least a single Event. Events dropped by the policy are counted in
+// ConnStats.EventsDropped, and reported as a warning to the Conn's logger at most
+// once every 10 seconds.
+//
+// By default, Listen uses OverflowBlock without buffer.
+func WithEventOverflow(policy OverflowPolicy, size int) Option {
+	return func(c *Conn) {
+		c.overflow = policy
+		c.eventBuffer = max(size, 0)
+		if policy == OverflowDropOldest {
+			c.eventBuffer = max(size, 1)
+		}
+	}
+}
+
+// eventSink returns the function Listen workers hand their Events to, according to
+// the Conn's OverflowPolicy and event buffer. If the Events are buffered, the returned
+// eventQueue needs to be closed when the workers have stopped.
+func (c *Conn) eventSink(evChan chan<- Event) (func(Event), *eventQueue) {
+
+	dropped := newDropCounter(&c.stats.eventsDropped, c.logger)
+
+	if c.eventBuffer > 0 {
+		q := newEventQueue(c.eventBuffer, c.overflow, dropped)
+		go q.forward(evChan)
+		return q.push, q
+	}
+
+	if c.overflow == OverflowDropNewest {
+		return func(ev Event) {
+			select {
+			case evChan <- ev:
+			default:
+				dropped.drop()
+			}
+		}, nil
+	}
+
+	return func(ev Event) { evChan <- ev }, nil
+}
+
+// A dropCounter counts the Events dropped by an OverflowPolicy, and logs the amount
+// dropped at most once per dropLogInterval. Drops following a warning are reported
+// by the first drop after the interval, so a consumer falling behind only briefly
+// may leave some drops unreported in the log.
+type dropCounter struct {
+	total  *uint64
+	logger *slog.Logger
+	now    func() time.Time
+
+	mu      sync.Mutex
+	last    time.Time
+	pending uint64
+}
+
+func newDropCounter(total *uint64, logger *slog.Logger) *dropCounter {
+	return &dropCounter{
+		total:  total,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// drop counts a dropped Event, and logs a warning if none was logged for
+// dropLogInterval.
+func (d *dropCounter) drop() {
+
+	atomic.AddUint64(d.total, 1)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pending++
+
+	now := d.now()
+	if !d.last.IsZero() && now.Sub(d.last) < dropLogInterval {
+		return
+	}
+
+	d.logger.Warn("evChan consumer is falling behind, dropped events", "dropped", d.pending)
+	d.last, d.pending = now, 0
+}
+
+// An eventQueue is a bounded ring buffer of Events between Listen workers and
+// the consumer's evChan.
+type eventQueue struct {
+	mu sync.Mutex
+	// cond is signaled when Events are pushed or popped, or the queue is closed.
+	cond *sync.Cond
+
+	ring    []Event
+	head, n int
+	closed  bool
+
+	policy  OverflowPolicy
+	dropped *dropCounter
+}
+
+func newEventQueue(size int, policy OverflowPolicy, dropped *dropCounter) *eventQueue {
+
+	q := &eventQueue{
+		ring:    make([]Event, size),
+		policy:  policy,
+		dropped: dropped,
+	}
+	q.cond = sync.NewCond(&q.mu)
+
+	return q
+}
+
+// push adds ev to the queue. When the queue is full, ev is dropped, the oldest
+// Event is dropped or push waits for room, depending on the queue's policy.
+func (q *eventQueue) push(ev Event) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.n == len(q.ring) {
+		switch q.policy {
+		case OverflowDropNewest:
+			q.dropped.drop()
+			return
+		case OverflowDropOldest:
+			q.ring[q.head] = Event{}
+			q.head = (q.head + 1) % len(q.ring)
+			q.n--
+			q.dropped.drop()
+		default:
+			q.cond.Wait()
+		}
+	}
+
+	q.ring[(q.head+q.n)%len(q.ring)] = ev
+	q.n++
+	q.cond.Broadcast()
+}
+
+// pop removes the oldest Event from the queue, waiting for one to be pushed
+// if it is empty. Returns false when the queue is closed and empty.
+func (q *eventQueue) pop() (Event, bool) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for q.n == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.n == 0 {
+		return Event{}, false
+	}
+
+	ev := q.ring[q.head]
+	q.ring[q.head] = Event{}
+	q.head = (q.head + 1) % len(q.ring)
+	q.n--
+	q.cond.Broadcast()
+
+	return ev, true
+}
+
+// close makes forward return after sending the Events left in the queue.
+func (q *eventQueue) close() {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// forward sends the queue's Events to evChan until the queue is closed.
+func (q *eventQueue) forward(evChan chan<- Event) {
+	for {
+		ev, ok := q.pop()
+		if !ok {
+			return
+		}
+		evChan <- ev
+	}
+}
@@ -0,0 +1,54 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnListenDropOldest(t *testing.T) {
+
+	sc, nsid, err := makeNSConn()
+	require.NoError(t, err)
+	defer sc.Close()
+
+	lc, err := Dial(&netlink.Config{NetNS: nsid}, WithEventOverflow(OverflowDropOldest, 4))
+	require.NoError(t, err)
+
+	evChan := make(chan Event)
+	_, err = lc.Listen(evChan, 1, []NetlinkGroup{GroupCTNew})
+	require.NoError(t, err)
+
+	numFlows := 50
+	for i := 1; i <= numFlows; i++ {
+		require.NoError(t, sc.Create(NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), uint16(i), 53, 120, 0)))
+	}
+
+	// Wait for the worker to decode all events without anyone reading evChan.
+	require.Eventually(t, func() bool {
+		return lc.ConnStats().EventsDecoded == uint64(numFlows)
+	}, time.Second, time.Millisecond)
+
+	// The buffer keeps the newest Events. Depending on when the forwarder got
+	// to run, it holds on to one more Event waiting to be sent to evChan.
+	var ports []uint16
+	for done := false; !done; {
+		select {
+		case ev := <-evChan:
+			ports = append(ports, ev.Flow.TupleOrig.Proto.SourcePort)
+		case <-time.After(50 * time.Millisecond):
+			done = true
+		}
+	}
+
+	require.NotEmpty(t, ports)
+	assert.LessOrEqual(t, len(ports), 5)
+	assert.Equal(t, uint16(numFlows), ports[len(ports)-1])
+	assert.Equal(t, uint64(numFlows-len(ports)), lc.ConnStats().EventsDropped)
+}
@@ -0,0 +1,162 @@
+package conntrack
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEvent returns an Event carrying a Flow with the given ID.
+func testEvent(id uint32) Event {
+	return Event{Type: EventNew, Flow: &Flow{ID: id}}
+}
+
+// drain returns the IDs of the Flows of the Events buffered in evChan.
+func drain(evChan chan Event) []uint32 {
+
+	var ids []uint32
+	for {
+		select {
+		case ev := <-evChan:
+			ids = append(ids, ev.Flow.ID)
+		default:
+			return ids
+		}
+	}
+}
+
+func TestWithEventOverflow(t *testing.T) {
+
+	var c Conn
+
+	WithEventOverflow(OverflowDropNewest, -1)(&c)
+	assert.Equal(t, OverflowDropNewest, c.overflow)
+	assert.Equal(t, 0, c.eventBuffer)
+
+	WithEventOverflow(OverflowDropOldest, 0)(&c)
+	assert.Equal(t, 1, c.eventBuffer)
+
+	WithEventOverflow(OverflowBlock, 16)(&c)
+	assert.Equal(t, 16, c.eventBuffer)
+}
+
+func TestEventSinkDropNewest(t *testing.T) {
+
+	c := Conn{overflow: OverflowDropNewest, logger: slog.New(discardHandler{})}
+	evChan := make(chan Event, 2)
+
+	deliver, q := c.eventSink(evChan)
+	assert.Nil(t, q)
+
+	for i := uint32(1); i <= 4; i++ {
+		deliver(testEvent(i))
+	}
+
+	assert.Equal(t, []uint32{1, 2}, drain(evChan))
+	assert.Equal(t, uint64(2), c.ConnStats().EventsDropped)
+}
+
+func TestDropCounter(t *testing.T) {
+
+	var total uint64
+	var buf bytes.Buffer
+	d := newDropCounter(&total, slog.New(slog.NewTextHandler(&buf, nil)))
+
+	now := time.Unix(1000, 0)
+	d.now = func() time.Time { return now }
+
+	// The first drop is logged right away, the ones following it are
+	// held back for dropLogInterval.
+	for i := 0; i < 5; i++ {
+		d.drop()
+		now = now.Add(time.Second)
+	}
+	assert.Equal(t, uint64(5), total)
+	assert.Equal(t, 1, strings.Count(buf.String(), "\n"))
+	assert.Contains(t, buf.String(), `level=WARN msg="evChan consumer is falling behind, dropped events" dropped=1`)
+
+	buf.Reset()
+	now = now.Add(dropLogInterval)
+	d.drop()
+	assert.Equal(t, uint64(6), total)
+	assert.Contains(t, buf.String(), `dropped=5`)
+}
+
+func TestEventQueueDropOldest(t *testing.T) {
+
+	var dropped uint64
+	q := newEventQueue(3, OverflowDropOldest, newDropCounter(&dropped, slog.New(discardHandler{})))
+
+	for i := uint32(1); i <= 5; i++ {
+		q.push(testEvent(i))
+	}
+	assert.Equal(t, uint64(2), dropped)
+
+	q.close()
+
+	evChan := make(chan Event, 5)
+	q.forward(evChan)
+	assert.Equal(t, []uint32{3, 4, 5}, drain(evChan))
+}
+
+func TestEventQueueDropNewest(t *testing.T) {
+
+	var dropped uint64
+	q := newEventQueue(2, OverflowDropNewest, newDropCounter(&dropped, slog.New(discardHandler{})))
+
+	for i := uint32(1); i <= 4; i++ {
+		q.push(testEvent(i))
+	}
+	assert.Equal(t, uint64(2), dropped)
+
+	q.close()
+
+	evChan := make(chan Event, 4)
+	q.forward(evChan)
+	assert.Equal(t, []uint32{1, 2}, drain(evChan))
+}
+
+func TestEventQueueSpill(t *testing.T) {
+
+	c := Conn{overflow: OverflowBlock, eventBuffer: 2, logger: slog.New(discardHandler{})}
+	evChan := make(chan Event)
+
+	deliver, q := c.eventSink(evChan)
+	require.NotNil(t, q)
+
+	// One Event is held by the forwarder, two are buffered.
+	pushed := make(chan uint32, 4)
+	go func() {
+		for i := uint32(1); i <= 4; i++ {
+			deliver(testEvent(i))
+			pushed <- i
+		}
+		q.close()
+	}()
+
+	for i := uint32(1); i <= 3; i++ {
+		assert.Equal(t, i, <-pushed)
+	}
+
+	// The fourth Event blocks until the consumer catches up.
+	select {
+	case <-pushed:
+		t.Fatal("push did not block on full buffer")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	var got []uint32
+	for ev := range evChan {
+		got = append(got, ev.Flow.ID)
+		if len(got) == 4 {
+			break
+		}
+	}
+	assert.Equal(t, []uint32{1, 2, 3, 4}, got)
+	assert.Equal(t, uint64(0), c.ConnStats().EventsDropped)
+}
@@ -0,0 +1,176 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// IPv6 extension headers skipped when looking for a packet's layer 4 header.
+const (
+	ipv6HopByHop = 0
+	ipv6Routing  = 43
+	ipv6Fragment = 44
+	ipv6DestOpts = 60
+)
+
+// icmpInverse maps the ICMP and ICMPv6 query types tracked by the kernel
+// to the type of their response and vice versa, like the kernel's invmap.
+var icmpInverse = map[uint8]map[uint8]uint8{
+	protoICMP:   {8: 0, 0: 8, 13: 14, 14: 13, 15: 16, 16: 15, 17: 18, 18: 17},
+	protoICMPv6: {128: 129, 129: 128, 139: 140, 140: 139},
+}
+
+// TupleFromPacket returns the Tuple of a raw IPv4 or IPv6 packet, starting at its IP
+// header. With gopacket, pass the contents and payload of the packet's network layer.
+//
+// Ports are read for TCP, UDP, UDP-Lite, DCCP and SCTP, and type, code and identifier
+// for ICMP and ICMPv6. Other protocols only fill in the Tuple's protocol number.
+// Non-initial fragments are rejected, since they don't carry a layer 4 header.
+func TupleFromPacket(b []byte) (Tuple, error) {
+
+	var t Tuple
+
+	if len(b) < 1 {
+		return t, errPacketShort
+	}
+
+	var l4 []byte
+
+	switch v := b[0] >> 4; v {
+	case 4:
+		ihl := int(b[0]&0x0f) * 4
+		if len(b) < 20 || ihl < 20 || len(b) < ihl {
+			return t, errPacketShort
+		}
+
+		// Fragment offset is non-zero for all but the first fragment.
+		if binary.BigEndian.Uint16(b[6:8])&0x1fff != 0 {
+			return t, errPacketFragment
+		}
+
+		t.Proto.Protocol = b[9]
+		t.IP.SourceAddress = net.IP(append([]byte(nil), b[12:16]...))
+		t.IP.DestinationAddress = net.IP(append([]byte(nil), b[16:20]...))
+		l4 = b[ihl:]
+
+	case 6:
+		if len(b) < 40 {
+			return t, errPacketShort
+		}
+
+		t.IP.SourceAddress = net.IP(append([]byte(nil), b[8:24]...))
+		t.IP.DestinationAddress = net.IP(append([]byte(nil), b[24:40]...))
+
+		next, off := b[6], 40
+		for next == ipv6HopByHop || next == ipv6Routing || next == ipv6DestOpts || next == ipv6Fragment {
+			if len(b) < off+8 {
+				return t, errPacketShort
+			}
+
+			if next == ipv6Fragment {
+				if binary.BigEndian.Uint16(b[off+2:off+4])&0xfff8 != 0 {
+					return t, errPacketFragment
+				}
+				next, off = b[off], off+8
+				continue
+			}
+
+			next, off = b[off], off+(int(b[off+1])+1)*8
+		}
+		if len(b) < off {
+			return t, errPacketShort
+		}
+
+		t.Proto.Protocol = next
+		l4 = b[off:]
+
+	default:
+		return t, errors.Errorf(errPacketVersion, v)
+	}
+
+	switch t.Proto.Protocol {
+	case protoTCP, protoUDP, protoUDPLite, protoDCCP, protoSCTP:
+		if len(l4) < 4 {
+			return t, errPacketShort
+		}
+		t.Proto.SourcePort = binary.BigEndian.Uint16(l4[0:2])
+		t.Proto.DestinationPort = binary.BigEndian.Uint16(l4[2:4])
+
+	case protoICMP, protoICMPv6:
+		if len(l4) < 8 {
+			return t, errPacketShort
+		}
+		t.Proto.ICMPv4 = t.Proto.Protocol == protoICMP
+		t.Proto.ICMPv6 = t.Proto.Protocol == protoICMPv6
+		t.Proto.ICMPType, t.Proto.ICMPCode = l4[0], l4[1]
+		t.Proto.ICMPID = binary.BigEndian.Uint16(l4[4:6])
+	}
+
+	return t, nil
+}
+
+// invert returns the Tuple of a packet travelling in the opposite direction.
+// ICMP queries are inverted into their responses and vice versa.
+func (t Tuple) invert() Tuple {
+
+	t.IP.SourceAddress, t.IP.DestinationAddress = t.IP.DestinationAddress, t.IP.SourceAddress
+	t.Proto.SourcePort, t.Proto.DestinationPort = t.Proto.DestinationPort, t.Proto.SourcePort
+
+	if inv, ok := icmpInverse[t.Proto.Protocol][t.Proto.ICMPType]; ok {
+		t.Proto.ICMPType = inv
+	}
+
+	return t
+}
+
+// LookupTuple looks up the Flow a packet with Tuple t belongs to using ct, and
+// returns the Direction the packet travels in.
+//
+// The kernel finds connections by their original and reply tuples alike. Packets
+// captured after NAT was applied, for example on the outside of a masquerading
+// gateway, don't match either tuple. For those, the connection is looked up using
+// the inverse of t, which matches the tuple expected for the response.
+// Returns the error of the last lookup if no Flow was found.
+func LookupTuple(ct Conntracker, t Tuple) (Flow, Direction, error) {
+
+	f, err := ct.Get(Flow{TupleOrig: t})
+	if err == nil {
+		return f, packetDirection(f, t, false), nil
+	}
+
+	inv := t.invert()
+
+	f, err = ct.Get(Flow{TupleOrig: inv})
+	if err != nil {
+		return Flow{}, DirOrig, err
+	}
+
+	return f, packetDirection(f, inv, true), nil
+}
+
+// LookupPacket looks up the Flow a raw IP packet belongs to using ct, like
+// LookupTuple. See TupleFromPacket for the packets supported.
+func LookupPacket(ct Conntracker, b []byte) (Flow, Direction, error) {
+
+	t, err := TupleFromPacket(b)
+	if err != nil {
+		return Flow{}, DirOrig, err
+	}
+
+	return LookupTuple(ct, t)
+}
+
+// packetDirection returns the Direction of a packet with Tuple t in f. If inverted is
+// set, t is the inverse of the packet's tuple and the opposite Direction is returned.
+func packetDirection(f Flow, t Tuple, inverted bool) Direction {
+
+	k := t.key()
+	k.zone = 0
+
+	r := f.TupleReply.key()
+	r.zone = 0
+
+	return Direction((k == r) != inverted)
+}
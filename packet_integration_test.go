@@ -0,0 +1,42 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnLookupPacket(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	client, server, gw := net.IPv4(10, 0, 0, 1), net.IPv4(192, 0, 2, 1), net.IPv4(198, 51, 100, 1)
+
+	f := NewFlow(6, 0, client, server, 1234, 80, 120, 0)
+	f.NATSrc = NATRange{MinIP: gw, MinPort: 40000}
+	require.NoError(t, c.Create(f))
+
+	// Packets on the inside of the gateway match the original tuple.
+	got, dir, err := LookupPacket(c, ipv4Packet(6, client, server, 0x04, 0xd2, 0, 80))
+	require.NoError(t, err)
+	assert.Equal(t, DirOrig, dir)
+	assert.True(t, got.Status.SrcNAT())
+
+	// Masqueraded packets on the outside are matched in both directions.
+	_, dir, err = LookupPacket(c, ipv4Packet(6, gw, server, 0x9c, 0x40, 0, 80))
+	require.NoError(t, err)
+	assert.Equal(t, DirOrig, dir)
+
+	_, dir, err = LookupPacket(c, ipv4Packet(6, server, gw, 0, 80, 0x9c, 0x40))
+	require.NoError(t, err)
+	assert.Equal(t, DirReply, dir)
+
+	_, _, err = LookupPacket(c, ipv4Packet(6, client, server, 0, 1, 0, 2))
+	assert.Error(t, err)
+}
@@ -0,0 +1,165 @@
+package conntrack
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ipv4Packet(proto uint8, src, dst net.IP, l4 ...byte) []byte {
+	b := []byte{0x45, 0, 0, 0, 0, 0, 0, 0, 64, proto, 0, 0}
+	b = append(b, src.To4()...)
+	b = append(b, dst.To4()...)
+	return append(b, l4...)
+}
+
+func ipv6Packet(next uint8, src, dst net.IP, payload ...byte) []byte {
+	b := []byte{0x60, 0, 0, 0, 0, 0, next, 64}
+	b = append(b, src.To16()...)
+	b = append(b, dst.To16()...)
+	return append(b, payload...)
+}
+
+func TestTupleFromPacket(t *testing.T) {
+
+	src, dst := net.IPv4(192, 0, 2, 1), net.IPv4(192, 0, 2, 2)
+	src6, dst6 := net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2")
+
+	tests := []struct {
+		name string
+		pkt  []byte
+		want Tuple
+		err  error
+	}{
+		{
+			name: "ipv4 tcp",
+			pkt:  ipv4Packet(6, src, dst, 0x04, 0xd2, 0x00, 0x50, 0, 0, 0, 0),
+			want: Tuple{IP: IPTuple{src.To4(), dst.To4()}, Proto: ProtoTuple{Protocol: 6, SourcePort: 1234, DestinationPort: 80}},
+		},
+		{
+			name: "ipv4 icmp echo",
+			pkt:  ipv4Packet(1, src, dst, 8, 0, 0, 0, 0x12, 0x34, 0, 1),
+			want: Tuple{IP: IPTuple{src.To4(), dst.To4()}, Proto: ProtoTuple{Protocol: 1, ICMPv4: true, ICMPType: 8, ICMPID: 0x1234}},
+		},
+		{
+			name: "ipv4 gre",
+			pkt:  ipv4Packet(47, src, dst),
+			want: Tuple{IP: IPTuple{src.To4(), dst.To4()}, Proto: ProtoTuple{Protocol: 47}},
+		},
+		{
+			name: "ipv6 udp after hop-by-hop and initial fragment",
+			pkt: ipv6Packet(0, src6, dst6,
+				44, 0, 1, 4, 0, 0, 0, 0, // hop-by-hop, 8 bytes
+				17, 0, 0, 1, 0, 0, 0, 1, // fragment, offset 0, more fragments
+				0, 53, 0x14, 0xe9, 0, 0, 0, 0),
+			want: Tuple{IP: IPTuple{src6, dst6}, Proto: ProtoTuple{Protocol: 17, SourcePort: 53, DestinationPort: 5353}},
+		},
+		{
+			name: "ipv6 icmpv6 echo reply",
+			pkt:  ipv6Packet(58, src6, dst6, 129, 0, 0, 0, 0, 7, 0, 1),
+			want: Tuple{IP: IPTuple{src6, dst6}, Proto: ProtoTuple{Protocol: 58, ICMPv6: true, ICMPType: 129, ICMPID: 7}},
+		},
+		{
+			name: "ipv6 non-initial fragment",
+			pkt:  ipv6Packet(44, src6, dst6, 17, 0, 0, 8, 0, 0, 0, 1),
+			err:  errPacketFragment,
+		},
+		{
+			name: "ipv4 non-initial fragment",
+			pkt:  append(ipv4Packet(6, src, dst)[:6], append([]byte{0, 1}, ipv4Packet(6, src, dst)[8:]...)...),
+			err:  errPacketFragment,
+		},
+		{name: "empty", err: errPacketShort},
+		{name: "ipv4 short header", pkt: []byte{0x45, 0, 0}, err: errPacketShort},
+		{name: "ipv4 short tcp", pkt: ipv4Packet(6, src, dst, 0, 1), err: errPacketShort},
+		{name: "ipv4 short icmp", pkt: ipv4Packet(1, src, dst, 8, 0), err: errPacketShort},
+		{name: "ipv6 short header", pkt: []byte{0x60, 0, 0}, err: errPacketShort},
+		{name: "ipv6 short extension", pkt: ipv6Packet(60, src6, dst6, 6, 1), err: errPacketShort},
+		{name: "ipv6 extension beyond packet", pkt: ipv6Packet(60, src6, dst6, 6, 1, 0, 0, 0, 0, 0, 0), err: errPacketShort},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TupleFromPacket(tt.pkt)
+			if tt.err != nil {
+				assert.Equal(t, tt.err, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, err := TupleFromPacket([]byte{0x50})
+	assert.EqualError(t, err, "unknown IP version 5")
+}
+
+// getter is a Conntracker that only implements Get, finding Flows by either tuple.
+type getter struct {
+	Conntracker
+	flows []Flow
+}
+
+func (g getter) Get(q Flow) (Flow, error) {
+	for _, f := range g.flows {
+		if k := q.TupleOrig.key(); k == f.TupleOrig.key() || k == f.TupleReply.key() {
+			return f, nil
+		}
+	}
+	return Flow{}, syscall.ENOENT
+}
+
+func TestLookupTuple(t *testing.T) {
+
+	client, server, gw := net.IPv4(10, 0, 0, 1).To4(), net.IPv4(192, 0, 2, 1).To4(), net.IPv4(198, 51, 100, 1).To4()
+
+	// Connection from client to server, masqueraded to the gateway's address.
+	f := NewFlow(6, 0, client, server, 1234, 80, 120, 0)
+	f.TupleReply.IP.DestinationAddress = gw
+	f.TupleReply.Proto.DestinationPort = 40000
+
+	ping := NewFlow(1, 0, client, server, 0, 0, 30, 0)
+	ping.TupleOrig.Proto = ProtoTuple{Protocol: 1, ICMPType: 8, ICMPID: 1}
+	ping.TupleReply.Proto = ProtoTuple{Protocol: 1, ICMPType: 0, ICMPID: 1}
+
+	g := getter{flows: []Flow{f, ping}}
+
+	tcp := func(src, dst net.IP, sport, dport uint16) Tuple {
+		return Tuple{IP: IPTuple{src, dst}, Proto: ProtoTuple{Protocol: 6, SourcePort: sport, DestinationPort: dport}}
+	}
+
+	tests := []struct {
+		name string
+		t    Tuple
+		dir  Direction
+	}{
+		{"orig inside", tcp(client, server, 1234, 80), DirOrig},
+		{"reply outside", tcp(server, gw, 80, 40000), DirReply},
+		{"orig outside", tcp(gw, server, 40000, 80), DirOrig},
+		{"reply inside", tcp(server, client, 80, 1234), DirReply},
+		{"icmp echo reply", Tuple{IP: IPTuple{server, client}, Proto: ProtoTuple{Protocol: 1, ICMPType: 0, ICMPID: 1}}, DirReply},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, dir, err := LookupTuple(g, tt.t)
+			require.NoError(t, err)
+			assert.Equal(t, tt.t.Proto.Protocol, got.TupleOrig.Proto.Protocol)
+			assert.Equal(t, tt.dir, dir)
+		})
+	}
+
+	_, _, err := LookupTuple(g, tcp(client, server, 1, 2))
+	assert.Equal(t, syscall.ENOENT, err)
+
+	got, dir, err := LookupPacket(g, ipv4Packet(6, gw, server, 0x9c, 0x40, 0, 80))
+	require.NoError(t, err)
+	assert.Equal(t, f, got)
+	assert.Equal(t, DirOrig, dir)
+
+	_, _, err = LookupPacket(g, nil)
+	assert.Equal(t, errPacketShort, err)
+}
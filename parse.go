@@ -0,0 +1,296 @@
+package conntrack
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Protocol numbers with a dedicated representation in conntrack(8) output.
+const (
+	protoICMP    = 1
+	protoTCP     = 6
+	protoUDP     = 17
+	protoDCCP    = 33
+	protoGRE     = 47
+	protoICMPv6  = 58
+	protoSCTP    = 132
+	protoUDPLite = 136
+)
+
+// ctProtoName returns the name conntrack(8) uses for protocol p.
+func ctProtoName(p uint8) string {
+	switch p {
+	case protoICMP:
+		return "icmp"
+	case protoTCP:
+		return "tcp"
+	case protoUDP:
+		return "udp"
+	case protoDCCP:
+		return "dccp"
+	case protoGRE:
+		return "gre"
+	case protoICMPv6:
+		return "icmpv6"
+	case protoSCTP:
+		return "sctp"
+	case protoUDPLite:
+		return "udplite"
+	}
+	return "unknown"
+}
+
+// Connection state names, from enum tcp_conntrack, ct_dccp_states and sctp_conntrack.
+// uapi/linux/netfilter/nf_conntrack_{tcp,sctp}.h, linux/netfilter/nf_conntrack_dccp.h
+var (
+	tcpStateNames = []string{
+		"NONE", "SYN_SENT", "SYN_RECV", "ESTABLISHED", "FIN_WAIT",
+		"CLOSE_WAIT", "LAST_ACK", "TIME_WAIT", "CLOSE", "SYN_SENT2",
+	}
+	dccpStateNames = []string{
+		"NONE", "REQUEST", "RESPOND", "PARTOPEN", "OPEN",
+		"CLOSEREQ", "CLOSING", "TIMEWAIT", "IGNORE", "INVALID",
+	}
+	sctpStateNames = []string{
+		"NONE", "CLOSED", "COOKIE_WAIT", "COOKIE_ECHOED", "ESTABLISHED",
+		"SHUTDOWN_SENT", "SHUTDOWN_RECD", "SHUTDOWN_ACK_SENT", "HEARTBEAT_SENT",
+	}
+)
+
+// stateName returns the name of the connection state held by the ProtoInfo.
+// Returns an empty string if the ProtoInfo is empty.
+func (pi ProtoInfo) stateName() string {
+
+	name := func(names []string, s uint8) string {
+		if int(s) < len(names) {
+			return names[s]
+		}
+		return strconv.Itoa(int(s))
+	}
+
+	switch {
+	case pi.TCP != nil:
+		return name(tcpStateNames, pi.TCP.State)
+	case pi.DCCP != nil:
+		return name(dccpStateNames, pi.DCCP.State)
+	case pi.SCTP != nil:
+		return name(sctpStateNames, pi.SCTP.State)
+	}
+
+	return ""
+}
+
+// parseState returns a ProtoInfo holding the connection state named s for protocol proto.
+func parseState(proto uint8, s string) (ProtoInfo, error) {
+
+	lookup := func(names []string) (uint8, error) {
+		for i, n := range names {
+			if n == s {
+				return uint8(i), nil
+			}
+		}
+		if v, err := strconv.ParseUint(s, 10, 8); err == nil {
+			return uint8(v), nil
+		}
+		return 0, fmt.Errorf(errParseState, s, ctProtoName(proto))
+	}
+
+	var pi ProtoInfo
+	var err error
+
+	switch proto {
+	case protoTCP:
+		pi.TCP = &ProtoInfoTCP{}
+		pi.TCP.State, err = lookup(tcpStateNames)
+	case protoDCCP:
+		pi.DCCP = &ProtoInfoDCCP{}
+		pi.DCCP.State, err = lookup(dccpStateNames)
+	case protoSCTP:
+		pi.SCTP = &ProtoInfoSCTP{}
+		pi.SCTP.State, err = lookup(sctpStateNames)
+	default:
+		err = fmt.Errorf(errParseState, s, ctProtoName(proto))
+	}
+
+	return pi, err
+}
+
+// ParseFlow parses a line in the format used by conntrack(8) when listing the
+// Conntrack table, as returned by Flow.String, into a Flow.
+//
+// Only the status flags represented in the format are restored: StatusSeenReply
+// is set unless the line is marked [UNREPLIED], StatusAssured and StatusOffload are
+// set when marked [ASSURED] and [OFFLOAD] respectively. IPv4 addresses are stored
+// in their 4-byte canonical form, like in Flows received by a Conn dialed with
+// WithCanonicalAddresses. Other Conns decode them in their 16-byte form, so use
+// net.IP.Equal or Flow.Canonical to compare parsed Flows to the ones they receive.
+// Unknown keys are ignored, so lines produced by conntrack(8) with additional output
+// options are accepted.
+func ParseFlow(s string) (Flow, error) {
+
+	var f Flow
+
+	fields := strings.Fields(s)
+	if len(fields) < 2 {
+		return f, errParseFlowShort
+	}
+
+	proto, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return f, fmt.Errorf(errParseValue, "protocol", fields[1])
+	}
+	f.TupleOrig.Proto.Protocol = uint8(proto)
+	f.TupleReply.Proto.Protocol = uint8(proto)
+
+	icmp4, icmp6 := proto == protoICMP, proto == protoICMPv6
+	f.TupleOrig.Proto.ICMPv4, f.TupleReply.Proto.ICMPv4 = icmp4, icmp4
+	f.TupleOrig.Proto.ICMPv6, f.TupleReply.Proto.ICMPv6 = icmp6, icmp6
+
+	fields = fields[2:]
+
+	// The timeout and protocol state precede the first tuple.
+	if len(fields) > 0 {
+		if v, err := strconv.ParseUint(fields[0], 10, 32); err == nil {
+			f.Timeout = uint32(v)
+			fields = fields[1:]
+		}
+	}
+	if len(fields) > 0 && !strings.ContainsAny(fields[0], "=[") {
+		if f.ProtoInfo, err = parseState(uint8(proto), fields[0]); err != nil {
+			return f, err
+		}
+		fields = fields[1:]
+	}
+
+	// The section of the line being parsed. Tuple keys and counters apply to the
+	// original direction until the second 'src' key is encountered.
+	const (
+		secOrig = iota
+		secReply
+		secTrailer
+	)
+
+	sec := secOrig
+	tuple, ctr := &f.TupleOrig, &f.CountersOrig
+	seenSrc := false
+	replied := true
+
+	for _, field := range fields {
+
+		switch field {
+		case "[UNREPLIED]":
+			replied = false
+			continue
+		case "[ASSURED]":
+			f.Status.Value |= StatusAssured
+			continue
+		case "[OFFLOAD]", "[HW_OFFLOAD]":
+			f.Status.Value |= StatusOffload
+			continue
+		}
+
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return f, fmt.Errorf(errParseToken, field)
+		}
+
+		switch key {
+		case "src":
+			if seenSrc {
+				if sec != secOrig {
+					return f, fmt.Errorf(errParseToken, field)
+				}
+				sec = secReply
+				tuple, ctr = &f.TupleReply, &f.CountersReply
+				ctr.Direction = true
+			}
+			seenSrc = true
+			tuple.IP.SourceAddress, err = parseIP(val)
+		case "dst":
+			tuple.IP.DestinationAddress, err = parseIP(val)
+		case "sport":
+			tuple.Proto.SourcePort, err = parseUint16(val, 10)
+		case "dport":
+			tuple.Proto.DestinationPort, err = parseUint16(val, 10)
+		case "srckey":
+			tuple.Proto.SourcePort, err = parseUint16(val, 0)
+		case "dstkey":
+			tuple.Proto.DestinationPort, err = parseUint16(val, 0)
+		case "type":
+			tuple.Proto.ICMPType, err = parseUint8(val)
+		case "code":
+			tuple.Proto.ICMPCode, err = parseUint8(val)
+		case "id":
+			if sec == secTrailer {
+				f.ID, err = parseUint32(val)
+			} else {
+				tuple.Proto.ICMPID, err = parseUint16(val, 10)
+			}
+		case "zone-orig":
+			f.TupleOrig.Zone, err = parseUint16(val, 10)
+		case "zone-reply":
+			f.TupleReply.Zone, err = parseUint16(val, 10)
+		case "packets":
+			ctr.Packets, err = strconv.ParseUint(val, 10, 64)
+		case "bytes":
+			ctr.Bytes, err = strconv.ParseUint(val, 10, 64)
+		default:
+			// All other keys follow both tuples.
+			sec = secTrailer
+			switch key {
+			case "mark":
+				f.Mark, err = parseUint32(val)
+			case "use":
+				f.Use, err = parseUint32(val)
+			case "zone":
+				f.Zone, err = parseUint16(val, 10)
+			case "secctx":
+				f.SecurityContext = Security(val)
+			case "helper":
+				f.Helper.Name = val
+			}
+		}
+
+		if err != nil {
+			return f, fmt.Errorf(errParseValue, key, val)
+		}
+	}
+
+	if replied {
+		f.Status.Value |= StatusSeenReply
+	}
+
+	return f, nil
+}
+
+// parseIP parses an IP address, returning IPv4 addresses in their 4-byte form.
+func parseIP(s string) (net.IP, error) {
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errParseIP
+	}
+
+	if ip4 := ip.To4(); ip4 != nil && !strings.Contains(s, ":") {
+		return ip4, nil
+	}
+
+	return ip, nil
+}
+
+func parseUint8(s string) (uint8, error) {
+	v, err := strconv.ParseUint(s, 10, 8)
+	return uint8(v), err
+}
+
+func parseUint16(s string, base int) (uint16, error) {
+	v, err := strconv.ParseUint(s, base, 16)
+	return uint16(v), err
+}
+
+func parseUint32(s string) (uint32, error) {
+	v, err := strconv.ParseUint(s, 10, 32)
+	return uint32(v), err
+}
@@ -0,0 +1,99 @@
+package conntrack
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFlow(t *testing.T) {
+
+	tcp := NewFlow(6, StatusSeenReply|StatusAssured, net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4(), 51234, 22, 431999, 0)
+	tcp.ProtoInfo.TCP = &ProtoInfoTCP{State: 3}
+	tcp.CountersOrig = Counter{Packets: 3, Bytes: 180}
+	tcp.CountersReply = Counter{Direction: true, Packets: 2, Bytes: 120}
+	tcp.Use = 1
+
+	udp := NewFlow(17, 0, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 5353, 53, 29, 0xf)
+	udp.CountersReply.Direction = true
+	udp.Use = 2
+	udp.Zone = 1
+
+	gre := NewFlow(47, StatusSeenReply, net.IPv4(1, 1, 1, 1).To4(), net.IPv4(2, 2, 2, 2).To4(), 0, 0x1234, 170, 0)
+	gre.CountersReply.Direction = true
+
+	icmp := NewFlow(1, StatusSeenReply, net.IPv4(10, 0, 0, 1).To4(), net.IPv4(10, 0, 0, 2).To4(), 0, 0, 29, 0)
+	icmp.TupleOrig.Proto = ProtoTuple{Protocol: 1, ICMPv4: true, ICMPType: 8, ICMPID: 7}
+	icmp.TupleReply.Proto = ProtoTuple{Protocol: 1, ICMPv4: true, ICMPID: 7}
+	icmp.TupleOrig.Zone = 4
+	icmp.CountersReply.Direction = true
+	icmp.Helper.Name = "ftp"
+	icmp.SecurityContext = "system_u:object_r:unlabeled_t:s0"
+	icmp.ID = 1234
+
+	tests := []struct {
+		name string
+		line string
+		flow Flow
+	}{
+		{
+			name: "tcp from conntrack(8)",
+			line: "tcp      6 431999 ESTABLISHED src=10.0.0.1 dst=10.0.0.2 sport=51234 dport=22 packets=3 bytes=180 src=10.0.0.2 dst=10.0.0.1 sport=22 dport=51234 packets=2 bytes=120 [ASSURED] mark=0 use=1",
+			flow: tcp,
+		},
+		{
+			name: "unreplied udp over ipv6",
+			line: "udp      17 29 src=2001:db8::1 dst=2001:db8::2 sport=5353 dport=53 [UNREPLIED] src=2001:db8::2 dst=2001:db8::1 sport=53 dport=5353 mark=15 zone=1 use=2",
+			flow: udp,
+		},
+		{
+			name: "gre keys",
+			line: "gre      47 170 src=1.1.1.1 dst=2.2.2.2 srckey=0x0 dstkey=0x1234 src=2.2.2.2 dst=1.1.1.1 srckey=0x1234 dstkey=0x0 mark=0 use=0",
+			flow: gre,
+		},
+		{
+			name: "icmp with trailing id",
+			line: "icmp     1 29 src=10.0.0.1 dst=10.0.0.2 type=8 code=0 id=7 zone-orig=4 src=10.0.0.2 dst=10.0.0.1 type=0 code=0 id=7 mark=0 secctx=system_u:object_r:unlabeled_t:s0 helper=ftp delta-time=12 use=0 id=1234",
+			flow: icmp,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFlow(tt.line)
+			require.NoError(t, err)
+
+			if diff := cmp.Diff(tt.flow, f); diff != "" {
+				t.Fatalf("unexpected parsed Flow (-want +got):\n%s", diff)
+			}
+
+			// Round trip, modulo unknown keys.
+			f2, err := ParseFlow(f.String())
+			require.NoError(t, err)
+			if diff := cmp.Diff(f, f2); diff != "" {
+				t.Fatalf("unexpected round-trip result (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseFlowError(t *testing.T) {
+
+	for _, line := range []string{
+		"",
+		"tcp",
+		"tcp x",
+		"tcp 6 10 BOGUS src=1.1.1.1",
+		"udp 17 10 CLOSE src=1.1.1.1",
+		"tcp 6 10 src=1.1.1.1.1",
+		"tcp 6 10 src=1.1.1.1 sport=70000",
+		"tcp 6 10 src=1.1.1.1 foo",
+		"tcp 6 10 src=1.1.1.1 src=1.1.1.2 use=1 src=1.1.1.3",
+	} {
+		_, err := ParseFlow(line)
+		assert.Error(t, err, line)
+	}
+}
@@ -0,0 +1,387 @@
+package conntrack
+
+import (
+	"sync"
+	"syscall"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+const (
+	// pipelineBufferSize is the size of the buffer replies are read into. Replies
+	// to mutations are a few hundred bytes at most, one per datagram.
+	pipelineBufferSize = 32 * 1024
+
+	// nlmsgHeaderLen is the length of a Netlink message header.
+	nlmsgHeaderLen = 16
+)
+
+// A Pipeline sends Conntrack mutations without waiting for the kernel to reply to
+// each of them, for bulk operations like restoring or synchronizing a table. Replies
+// are received in the background and matched to the Flow of their request. Errors
+// are collected and can be harvested at any time using Errors, or after all requests
+// sent so far were processed by the kernel using Wait.
+//
+// By default, the kernel acknowledges each request and Wait reports requests whose
+// reply was lost, for example because the socket's receive buffer overflowed. With
+// WithoutAck, the kernel only replies to failed requests.
+//
+// A Pipeline is safe for concurrent use by multiple goroutines.
+type Pipeline struct {
+	conn *netlink.Conn
+	ack  bool
+
+	// mu protects the fields below, and is held while sending a request
+	// so its sequence number is registered before the kernel replies.
+	mu       sync.Mutex
+	pending  map[uint32]Flow
+	barriers map[uint32]chan struct{}
+	errs     []FlowError
+	overrun  bool
+	err      error
+
+	done chan struct{}
+}
+
+// A PipelineOption configures optional behaviour of a Pipeline.
+// PipelineOptions are passed to DialPipeline.
+type PipelineOption func(*Pipeline)
+
+// WithoutAck makes the Pipeline send requests without the Netlink acknowledgement
+// flag, so the kernel only replies to requests that failed. This halves the work
+// done per request, but successful requests are not accounted for individually.
+func WithoutAck() PipelineOption {
+	return func(p *Pipeline) {
+		p.ack = false
+	}
+}
+
+// DialPipeline opens a Netfilter Netlink connection and returns it wrapped in a
+// Pipeline. Any PipelineOptions given are applied before it is returned.
+func DialPipeline(config *netlink.Config, opts ...PipelineOption) (*Pipeline, error) {
+
+	nlc, err := dialNetlink(config)
+	if err != nil {
+		return nil, err
+	}
+
+	rc, err := nlc.SyscallConn()
+	if err != nil {
+		nlc.Close()
+		return nil, err
+	}
+
+	p := &Pipeline{
+		conn:     nlc,
+		ack:      true,
+		pending:  make(map[uint32]Flow),
+		barriers: make(map[uint32]chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	go p.receive(rc)
+
+	return p, nil
+}
+
+// Close closes the Pipeline. Replies to requests in flight are discarded.
+func (p *Pipeline) Close() error {
+
+	err := p.conn.Close()
+	<-p.done
+
+	return err
+}
+
+// SetReadBuffer sets the size of the operating system's receive buffer associated
+// with the Pipeline. The buffer holds replies until they are processed, so it needs
+// to be larger when more requests are kept in flight.
+func (p *Pipeline) SetReadBuffer(bytes int) error {
+	return p.conn.SetReadBuffer(bytes)
+}
+
+// Create sends a request creating f, without waiting for the kernel to process it.
+// Returns an error if the request could not be sent. Errors returned by the kernel
+// are reported by Errors and Wait.
+func (p *Pipeline) Create(f Flow) error {
+
+	req, err := createRequest(f, p.flags())
+	if err != nil {
+		return err
+	}
+
+	return p.send(req, f)
+}
+
+// Update sends a request updating f, like Create.
+func (p *Pipeline) Update(f Flow) error {
+
+	req, err := updateRequest(f, p.flags())
+	if err != nil {
+		return err
+	}
+
+	return p.send(req, f)
+}
+
+// Delete sends a request deleting f, like Create.
+func (p *Pipeline) Delete(f Flow) error {
+
+	req, err := deleteRequest(f, false, p.flags())
+	if err != nil {
+		return err
+	}
+
+	return p.send(req, f)
+}
+
+// Errors returns the errors the kernel replied with since the last call to
+// Errors or Wait, along with the Flow of the failed request.
+func (p *Pipeline) Errors() []FlowError {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	errs := p.errs
+	p.errs = nil
+
+	return errs
+}
+
+// Wait waits for the kernel to process all requests sent before it was called, and
+// returns the errors collected since the last call to Errors or Wait. The error is
+// non-nil if the Pipeline's socket failed, or if its receive buffer overran since the
+// last call to Wait. With WithoutAck, replies to failed requests may have been lost
+// in the overrun. Otherwise, requests without reply are reported as FlowErrors.
+func (p *Pipeline) Wait() ([]FlowError, error) {
+
+	req, err := barrierRequest(netlink.Acknowledge)
+	if err != nil {
+		return nil, err
+	}
+
+	barrier := make(chan struct{})
+
+	p.mu.Lock()
+	if p.err != nil {
+		p.mu.Unlock()
+		return p.Errors(), p.err
+	}
+	req, err = p.conn.Send(req)
+	if err == nil {
+		p.barriers[req.Header.Sequence] = barrier
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-barrier:
+	case <-p.done:
+	}
+
+	p.mu.Lock()
+	err = p.err
+	if err == nil && p.overrun {
+		err = errPipelineOverrun
+	}
+	p.overrun = false
+	p.mu.Unlock()
+
+	return p.Errors(), err
+}
+
+// flags returns the header flags of requests sent by the Pipeline.
+func (p *Pipeline) flags() netlink.HeaderFlags {
+	if p.ack {
+		return netlink.Acknowledge
+	}
+	return 0
+}
+
+// send sends req and registers f as the Flow of its sequence number.
+func (p *Pipeline) send(req netlink.Message, f Flow) error {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.err != nil {
+		return p.err
+	}
+
+	req, err := p.conn.Send(req)
+	if err != nil {
+		return err
+	}
+
+	p.pending[req.Header.Sequence] = f
+
+	return nil
+}
+
+// receive reads replies from rc until the socket is closed or fails. Instead of
+// netlink.Conn.Receive, which returns the first error among the messages it reads,
+// replies are read from the socket directly to match all of them to their request.
+func (p *Pipeline) receive(rc syscall.RawConn) {
+
+	defer close(p.done)
+
+	b := make([]byte, pipelineBufferSize)
+
+	for {
+		n, err := readDatagram(rc, b)
+		if err == syscall.ENOBUFS {
+			// Replies were dropped by the kernel.
+			p.mu.Lock()
+			p.overrun = true
+			p.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			p.fail(err)
+			return
+		}
+
+		msgs, err := splitMessages(b[:n])
+		if err != nil {
+			p.fail(err)
+			return
+		}
+
+		p.mu.Lock()
+		for _, m := range msgs {
+			p.reply(m)
+		}
+		p.mu.Unlock()
+	}
+}
+
+// reply handles a reply from the kernel. Must be called with p.mu held.
+func (p *Pipeline) reply(m netlink.Message) {
+
+	seq := m.Header.Sequence
+
+	if barrier, ok := p.barriers[seq]; ok {
+		// All requests before the barrier were processed. Without acknowledgements,
+		// the ones left succeeded. With acknowledgements, their replies were lost.
+		for s, f := range p.pending {
+			// Compare sequence numbers as a signed distance to handle wraparound.
+			if int32(s-seq) >= 0 {
+				continue
+			}
+			if p.ack {
+				p.errs = append(p.errs, FlowError{Flow: f, Err: errPipelineNoReply})
+			}
+			delete(p.pending, s)
+		}
+
+		delete(p.barriers, seq)
+		close(barrier)
+		return
+	}
+
+	f, ok := p.pending[seq]
+	if !ok || m.Header.Type != netlink.Error {
+		return
+	}
+	delete(p.pending, seq)
+
+	if err := ackError(m); err != nil {
+		p.errs = append(p.errs, FlowError{Flow: f, Err: err})
+	}
+}
+
+// barrierRequest returns a request for the global statistics of the table, with
+// flags set in its header. Netlink requests are processed in order, so the reply
+// to a request made after all others signals that the others were processed.
+// Global statistics are always replied to, without modifying the table.
+func barrierRequest(flags netlink.HeaderFlags) (netlink.Message, error) {
+	return netfilter.MarshalNetlink(
+		netfilter.Header{
+			SubsystemID: netfilter.NFSubsysCTNetlink,
+			MessageType: netfilter.MessageType(ctGetStats),
+			Family:      ProtoUnspec,
+			Flags:       netlink.Request | flags,
+		}, nil)
+}
+
+// ackError returns the error carried by the Netlink error message m, or nil if m
+// acknowledges a successful request.
+func ackError(m netlink.Message) error {
+
+	if len(m.Data) < 4 {
+		return errMessageError
+	}
+	if code := nlenc.Int32(m.Data[0:4]); code != 0 {
+		return &netlink.OpError{Op: "receive", Err: syscall.Errno(-code)}
+	}
+
+	return nil
+}
+
+// fail records err as the reason the Pipeline stopped receiving replies.
+func (p *Pipeline) fail(err error) {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.err = err
+}
+
+// readDatagram reads a single datagram from the socket rc into b, waiting for one
+// to arrive according to the socket's read deadline.
+func readDatagram(rc syscall.RawConn, b []byte) (int, error) {
+
+	var n int
+	var rerr error
+	err := rc.Read(func(fd uintptr) bool {
+		n, rerr = recvfrom(fd, b)
+		return rerr != syscall.EAGAIN
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, rerr
+}
+
+// splitMessages splits a datagram read from a Netlink socket into its messages.
+func splitMessages(b []byte) ([]netlink.Message, error) {
+
+	var msgs []netlink.Message
+
+	for len(b) >= nlmsgHeaderLen {
+		l := int(nlenc.Uint32(b[0:4]))
+		if l < nlmsgHeaderLen || l > len(b) {
+			return nil, errMessageLength
+		}
+
+		msgs = append(msgs, netlink.Message{
+			Header: netlink.Header{
+				Length:   uint32(l),
+				Type:     netlink.HeaderType(nlenc.Uint16(b[4:6])),
+				Flags:    netlink.HeaderFlags(nlenc.Uint16(b[6:8])),
+				Sequence: nlenc.Uint32(b[8:12]),
+				PID:      nlenc.Uint32(b[12:16]),
+			},
+			Data: b[nlmsgHeaderLen:l],
+		})
+
+		// Messages are padded to a multiple of 4 bytes.
+		l = (l + 3) &^ 3
+		if l > len(b) {
+			break
+		}
+		b = b[l:]
+	}
+
+	return msgs, nil
+}
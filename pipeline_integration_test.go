@@ -0,0 +1,82 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"net"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+func TestPipeline(t *testing.T) {
+
+	ns, err := netns.New()
+	require.NoError(t, err)
+	defer ns.Close()
+
+	c, err := Dial(&netlink.Config{NetNS: int(ns)})
+	require.NoError(t, err)
+	defer c.Close()
+
+	for _, tt := range []struct {
+		name string
+		opts []PipelineOption
+	}{
+		{name: "ack"},
+		{name: "no ack", opts: []PipelineOption{WithoutAck()}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+
+			p, err := DialPipeline(&netlink.Config{NetNS: int(ns)}, tt.opts...)
+			require.NoError(t, err)
+			defer p.Close()
+
+			require.NoError(t, p.SetReadBuffer(4<<20))
+
+			numFlows := 2000
+			flow := func(i int) Flow {
+				return NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), uint16(i), 53, 120, 0)
+			}
+
+			for i := 1; i <= numFlows; i++ {
+				require.NoError(t, p.Create(flow(i)))
+			}
+
+			// Create the first Flow again.
+			require.NoError(t, p.Create(flow(1)))
+
+			errs, err := p.Wait()
+			require.NoError(t, err)
+			require.Len(t, errs, 1)
+			assert.Equal(t, uint16(1), errs[0].Flow.TupleOrig.Proto.SourcePort)
+			assert.True(t, errors.Is(errs[0], unix.EEXIST))
+
+			flows, err := c.Dump()
+			require.NoError(t, err)
+			assert.Len(t, flows, numFlows)
+
+			// Delete all Flows and one that doesn't exist.
+			for i := 1; i <= numFlows+1; i++ {
+				require.NoError(t, p.Delete(flow(i)))
+			}
+
+			errs, err = p.Wait()
+			require.NoError(t, err)
+			require.Len(t, errs, 1)
+			assert.Equal(t, uint16(numFlows+1), errs[0].Flow.TupleOrig.Proto.SourcePort)
+			assert.True(t, errors.Is(errs[0], unix.ENOENT))
+
+			flows, err = c.Dump()
+			require.NoError(t, err)
+			assert.Empty(t, flows)
+
+			assert.Nil(t, p.Errors())
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package conntrack
+
+import (
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitMessages(t *testing.T) {
+
+	b := []byte{
+		// Length 21, type 2, flags 0, seq 1, pid 0, 5 bytes of data padded to 8.
+		21, 0, 0, 0, 2, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0,
+		1, 2, 3, 4, 5, 0, 0, 0,
+		// Length 20, seq 2, 4 bytes of data.
+		20, 0, 0, 0, 2, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0,
+		6, 7, 8, 9,
+	}
+
+	msgs, err := splitMessages(b)
+	require.NoError(t, err)
+	require.Len(t, msgs, 2)
+	assert.Equal(t, uint32(1), msgs[0].Header.Sequence)
+	assert.Equal(t, netlink.Error, msgs[0].Header.Type)
+	assert.Equal(t, []byte{1, 2, 3, 4, 5}, msgs[0].Data)
+	assert.Equal(t, uint32(2), msgs[1].Header.Sequence)
+	assert.Equal(t, []byte{6, 7, 8, 9}, msgs[1].Data)
+
+	// Header claims more data than available.
+	_, err = splitMessages(b[:18])
+	assert.Equal(t, errMessageLength, err)
+}
+
+// errorReply returns a Netlink error message replying to seq with errno.
+func errorReply(seq uint32, errno syscall.Errno) netlink.Message {
+	return netlink.Message{
+		Header: netlink.Header{Type: netlink.Error, Sequence: seq},
+		Data:   nlenc.Int32Bytes(-int32(errno)),
+	}
+}
+
+func TestPipelineReply(t *testing.T) {
+
+	f1 := NewFlow(6, 0, nil, nil, 1, 80, 0, 0)
+	f2 := NewFlow(6, 0, nil, nil, 2, 80, 0, 0)
+	f3 := NewFlow(6, 0, nil, nil, 3, 80, 0, 0)
+
+	p := &Pipeline{
+		ack:      true,
+		pending:  map[uint32]Flow{1: f1, 2: f2, 3: f3, 5: f3},
+		barriers: make(map[uint32]chan struct{}),
+	}
+
+	p.reply(errorReply(1, 0))
+	p.reply(errorReply(2, syscall.EEXIST))
+	p.reply(errorReply(99, syscall.ENOENT))
+
+	errs := p.Errors()
+	require.Len(t, errs, 1)
+	assert.Equal(t, f2, errs[0].Flow)
+	assert.True(t, errors.Is(errs[0], syscall.EEXIST))
+	assert.Nil(t, p.Errors())
+
+	// The reply to 3 was lost, 5 was sent after the barrier.
+	barrier := make(chan struct{})
+	p.barriers[4] = barrier
+	p.reply(netlink.Message{Header: netlink.Header{Sequence: 4}})
+
+	<-barrier
+	assert.Empty(t, p.barriers)
+	assert.Equal(t, map[uint32]Flow{5: f3}, p.pending)
+	assert.Equal(t, []FlowError{{Flow: f3, Err: errPipelineNoReply}}, p.Errors())
+
+	// Without acks, requests before the barrier succeeded.
+	p.ack = false
+	p.barriers[6] = make(chan struct{})
+	p.reply(netlink.Message{Header: netlink.Header{Sequence: 6}})
+	assert.Empty(t, p.pending)
+	assert.Nil(t, p.Errors())
+
+	// Sequence numbers wrap around.
+	p.pending[0xffffffff] = f1
+	p.barriers[1] = make(chan struct{})
+	p.reply(netlink.Message{Header: netlink.Header{Sequence: 1}})
+	assert.Empty(t, p.pending)
+}
+
+// Requests need to be well-formed to be accepted when strict checking is enabled:
+// a version 0 nfgenmsg header without resource ID, followed by attributes only.
+func TestRequestsWellFormed(t *testing.T) {
+
+	f := NewFlow(6, 0, net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), 1234, 80, 120, 0)
+
+	create, err := createRequest(f, netlink.Acknowledge)
+	require.NoError(t, err)
+	update, err := updateRequest(f, 0)
+	require.NoError(t, err)
+	del, err := deleteRequest(f, true, 0)
+	require.NoError(t, err)
+
+	for _, req := range []netlink.Message{create, update, del} {
+		require.GreaterOrEqual(t, len(req.Data), 4)
+		assert.Equal(t, uint8(ProtoIPv6), req.Data[0], "family")
+		assert.Equal(t, uint8(0), req.Data[1], "version")
+		assert.Equal(t, []byte{0, 0}, req.Data[2:4], "resource ID")
+
+		ad, err := netlink.NewAttributeDecoder(req.Data[4:])
+		require.NoError(t, err, "trailing data after attributes")
+		for ad.Next() {
+		}
+		require.NoError(t, ad.Err())
+	}
+
+	assert.Equal(t, netlink.Request|netlink.Excl|netlink.Create|netlink.Acknowledge, create.Header.Flags)
+	assert.Equal(t, netlink.Request, update.Header.Flags)
+	assert.Equal(t, netlink.Request, del.Header.Flags)
+}
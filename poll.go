@@ -0,0 +1,155 @@
+package conntrack
+
+import (
+	"sync"
+	"time"
+)
+
+// dumper is the subset of Conn used by a Poller.
+type dumper interface {
+	Dump(opts ...DumpOption) ([]Flow, error)
+}
+
+// A Poller periodically dumps the Conntrack table and compares the result against
+// the previous dump, emitting synthetic EventNew, EventUpdate and EventDestroy Events
+// for the differences. It is a fallback for environments where Conntrack events
+// (sysctl net.netfilter.nf_conntrack_events) cannot be enabled, and offers the same
+// consumer API as Conn.Listen.
+//
+// Synthetic events are an approximation of kernel events. Flows that are created and
+// destroyed between two polls are never seen, and an EventUpdate only carries the
+// state of the Flow at the time of the poll. Like kernel events, changes to a Flow's
+// Timeout and Counters alone do not produce an EventUpdate.
+type Poller struct {
+	d        dumper
+	interval time.Duration
+
+	mu      sync.Mutex
+	running bool
+	done    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewPoller returns a Poller that dumps the Conntrack table over c every interval.
+// The Conn must not be used for listening to multicast groups.
+func NewPoller(c *Conn, interval time.Duration) *Poller {
+	return &Poller{d: c, interval: interval}
+}
+
+// Listen starts polling the Conntrack table and produces Events on evChan.
+// The first dump establishes a baseline and does not produce any Events.
+// Returns an error channel the Poller will return any dump errors on. Any error
+// is fatal and stops the Poller. Listen can only be called once per Poller.
+func (p *Poller) Listen(evChan chan<- Event) (chan error, error) {
+
+	if p.interval <= 0 {
+		return nil, errPollInterval
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.running || p.done != nil {
+		return nil, errPollerStarted
+	}
+
+	p.running = true
+	p.done = make(chan struct{})
+
+	errChan := make(chan error)
+
+	p.wg.Add(1)
+	go p.poll(evChan, errChan)
+
+	return errChan, nil
+}
+
+// Close stops the Poller and waits for it to return. No more Events will be
+// produced on the Poller's event channel after Close returns.
+func (p *Poller) Close() error {
+
+	p.mu.Lock()
+	if p.running {
+		p.running = false
+		close(p.done)
+	}
+	p.mu.Unlock()
+
+	p.wg.Wait()
+
+	return nil
+}
+
+// poll is the Poller's worker function. It dumps the table every interval
+// and emits Events for the differences with the previous dump.
+func (p *Poller) poll(evChan chan<- Event, errChan chan<- error) {
+
+	defer p.wg.Done()
+
+	prev, err := p.d.Dump()
+	if err != nil {
+		p.fail(errChan, err)
+		return
+	}
+
+	t := time.NewTicker(p.interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+		}
+
+		cur, err := p.d.Dump()
+		if err != nil {
+			p.fail(errChan, err)
+			return
+		}
+
+		for _, ev := range diffEvents(prev, cur) {
+			select {
+			case evChan <- ev:
+			case <-p.done:
+				return
+			}
+		}
+
+		prev = cur
+	}
+}
+
+// fail delivers err on errChan unless the Poller is closed in the meantime.
+func (p *Poller) fail(errChan chan<- error, err error) {
+	select {
+	case errChan <- err:
+	case <-p.done:
+	}
+}
+
+// diffEvents returns the Events describing the transition from the Flows in old
+// to the Flows in cur. Destroy events are emitted first, so a tuple reused by a new
+// connection produces a destroy event followed by a new event, like in the kernel.
+func diffEvents(old, cur []Flow) []Event {
+
+	d := Diff(old, cur)
+
+	evs := make([]Event, 0, len(d.Destroyed)+len(d.Created)+len(d.Changed))
+
+	for i := range d.Destroyed {
+		evs = append(evs, Event{Type: EventDestroy, Flow: &d.Destroyed[i]})
+	}
+
+	for i := range d.Created {
+		evs = append(evs, Event{Type: EventNew, Flow: &d.Created[i]})
+	}
+
+	for i := range d.Changed {
+		if d.Changed[i].Fields&FieldsEvent != 0 {
+			evs = append(evs, Event{Type: EventUpdate, Flow: &d.Changed[i].New})
+		}
+	}
+
+	return evs
+}
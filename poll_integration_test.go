@@ -0,0 +1,44 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPollerListenIntegration(t *testing.T) {
+
+	c, _, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	p := NewPoller(c, 10*time.Millisecond)
+
+	evChan := make(chan Event)
+	errChan, err := p.Listen(evChan)
+	require.NoError(t, err)
+	defer p.Close()
+
+	// Give the Poller time to establish its baseline.
+	time.Sleep(20 * time.Millisecond)
+
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 80, 120, 0)
+	require.NoError(t, c.Create(f))
+
+	ev := <-evChan
+	assert.Equal(t, EventNew, ev.Type)
+	assert.Equal(t, f.TupleOrig.Proto.DestinationPort, ev.Flow.TupleOrig.Proto.DestinationPort)
+
+	require.NoError(t, c.Delete(f))
+
+	ev = <-evChan
+	assert.Equal(t, EventDestroy, ev.Type)
+	assert.Equal(t, f.TupleOrig.Proto.DestinationPort, ev.Flow.TupleOrig.Proto.DestinationPort)
+
+	assert.Len(t, errChan, 0)
+}
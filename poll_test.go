@@ -0,0 +1,128 @@
+package conntrack
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDumper returns a scripted sequence of dumps, repeating the last one.
+type fakeDumper struct {
+	mu    sync.Mutex
+	dumps [][]Flow
+	err   error
+}
+
+func (fd *fakeDumper) Dump(...DumpOption) ([]Flow, error) {
+
+	fd.mu.Lock()
+	defer fd.mu.Unlock()
+
+	if fd.err != nil {
+		return nil, fd.err
+	}
+
+	d := fd.dumps[0]
+	if len(fd.dumps) > 1 {
+		fd.dumps = fd.dumps[1:]
+	}
+
+	return d, nil
+}
+
+func pollFlow(port uint16, id uint32) Flow {
+	f := NewFlow(6, StatusConfirmed, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, port, 120, 0)
+	f.ID = id
+	return f
+}
+
+func TestDiffEvents(t *testing.T) {
+
+	a, b, c := pollFlow(1, 1), pollFlow(2, 2), pollFlow(3, 3)
+
+	// Timeout and counters changes alone are not updates.
+	b2 := b
+	b2.Timeout = 60
+	b2.CountersOrig = Counter{Packets: 1, Bytes: 64}
+
+	// Mark change is an update.
+	c2 := c
+	c2.Mark = 0xff
+
+	// Same tuple with a different ID is a new connection.
+	a2 := pollFlow(1, 42)
+
+	d := pollFlow(4, 4)
+
+	evs := diffEvents([]Flow{a, b, c}, []Flow{a2, b2, c2, d})
+
+	require.Len(t, evs, 4)
+
+	assert.Equal(t, EventDestroy, evs[0].Type)
+	assert.Equal(t, a, *evs[0].Flow)
+	assert.Equal(t, EventNew, evs[1].Type)
+	assert.Equal(t, a2, *evs[1].Flow)
+	assert.Equal(t, EventNew, evs[2].Type)
+	assert.Equal(t, d, *evs[2].Flow)
+	assert.Equal(t, EventUpdate, evs[3].Type)
+	assert.Equal(t, c2, *evs[3].Flow)
+
+	evs = diffEvents([]Flow{a, b, c}, []Flow{b})
+	require.Len(t, evs, 2)
+	assert.Equal(t, Event{Type: EventDestroy, Flow: &a}, evs[0])
+	assert.Equal(t, Event{Type: EventDestroy, Flow: &c}, evs[1])
+
+	assert.Empty(t, diffEvents(nil, nil))
+}
+
+func TestPollerListen(t *testing.T) {
+
+	a, b := pollFlow(1, 1), pollFlow(2, 2)
+
+	p := &Poller{
+		d:        &fakeDumper{dumps: [][]Flow{{a}, {a, b}, {b}}},
+		interval: time.Millisecond,
+	}
+
+	evChan := make(chan Event)
+	errChan, err := p.Listen(evChan)
+	require.NoError(t, err)
+
+	_, err = p.Listen(evChan)
+	require.EqualError(t, err, errPollerStarted.Error())
+
+	// The baseline dump produces no events.
+	ev := <-evChan
+	assert.Equal(t, EventNew, ev.Type)
+	assert.Equal(t, b, *ev.Flow)
+
+	ev = <-evChan
+	assert.Equal(t, EventDestroy, ev.Type)
+	assert.Equal(t, a, *ev.Flow)
+
+	require.NoError(t, p.Close())
+	assert.Len(t, errChan, 0)
+
+	_, err = p.Listen(evChan)
+	require.EqualError(t, err, errPollerStarted.Error())
+}
+
+func TestPollerError(t *testing.T) {
+
+	p := NewPoller(nil, 0)
+	_, err := p.Listen(make(chan Event))
+	require.EqualError(t, err, errPollInterval.Error())
+
+	p = &Poller{d: &fakeDumper{err: errors.New("dump failed")}, interval: time.Millisecond}
+
+	errChan, err := p.Listen(make(chan Event))
+	require.NoError(t, err)
+
+	assert.EqualError(t, <-errChan, "dump failed")
+	require.NoError(t, p.Close())
+}
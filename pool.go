@@ -0,0 +1,147 @@
+package conntrack
+
+import (
+	"sync/atomic"
+
+	"github.com/mdlayher/netlink"
+	"github.com/pkg/errors"
+)
+
+// A Pool spreads Conntrack requests over multiple Conns. A Conn waits for the
+// kernel's acknowledgement of a request before sending the next one, limiting
+// the throughput of bulk mutations like restoring a table to the round-trip time
+// of a single socket. A Pool is safe for concurrent use by multiple goroutines;
+// each goroutine's request is sent over the next idle Conn in line.
+//
+// A Conn sends its requests one at a time, so each Conn of a Pool has a single
+// request in flight. Size the Pool after the amount of concurrent requests.
+type Pool struct {
+	conns []*Conn
+
+	// slots holds a semaphore per Conn, taken while it has a request in flight.
+	slots []chan struct{}
+
+	next uint32
+}
+
+// DialPool opens size Conns with the given config and Options, and returns them
+// wrapped in a Pool. When all Conns have a request in flight, further requests
+// wait for one of them to finish.
+func DialPool(size int, config *netlink.Config, opts ...Option) (*Pool, error) {
+
+	if size < 1 {
+		return nil, errors.Errorf(errPoolSize, size)
+	}
+
+	p := &Pool{
+		conns: make([]*Conn, 0, size),
+		slots: make([]chan struct{}, 0, size),
+	}
+
+	for i := 0; i < size; i++ {
+		c, err := Dial(config, opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.conns = append(p.conns, c)
+		p.slots = append(p.slots, make(chan struct{}, 1))
+	}
+
+	return p, nil
+}
+
+// Close closes all Conns in the Pool. Returns the first error encountered.
+func (p *Pool) Close() error {
+
+	var err error
+	for _, c := range p.conns {
+		if cerr := c.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// Len returns the amount of Conns in the Pool.
+func (p *Pool) Len() int {
+	return len(p.conns)
+}
+
+// ConnStats returns the sum of the ConnStats of all Conns in the Pool.
+func (p *Pool) ConnStats() ConnStats {
+
+	var s ConnStats
+	for _, c := range p.conns {
+		cs := c.ConnStats()
+		s.MessagesReceived += cs.MessagesReceived
+		s.BytesReceived += cs.BytesReceived
+		s.EventsDecoded += cs.EventsDecoded
+		s.DecodeErrors += cs.DecodeErrors
+		s.Overruns += cs.Overruns
+		s.EventsSkipped += cs.EventsSkipped
+		s.EventsDropped += cs.EventsDropped
+		s.DumpsInterrupted += cs.DumpsInterrupted
+		s.CallbackPanics += cs.CallbackPanics
+	}
+
+	return s
+}
+
+// Create creates a new Conntrack entry using one of the Pool's Conns.
+func (p *Pool) Create(f Flow) error {
+	return p.do(func(c *Conn) error { return c.Create(f) })
+}
+
+// Update updates a Conntrack entry using one of the Pool's Conns.
+func (p *Pool) Update(f Flow) error {
+	return p.do(func(c *Conn) error { return c.Update(f) })
+}
+
+// Delete deletes a Conntrack entry using one of the Pool's Conns.
+func (p *Pool) Delete(f Flow) error {
+	return p.do(func(c *Conn) error { return c.Delete(f) })
+}
+
+// Get queries a Conntrack entry using one of the Pool's Conns.
+func (p *Pool) Get(f Flow) (Flow, error) {
+
+	var out Flow
+	err := p.do(func(c *Conn) (err error) {
+		out, err = c.Get(f)
+		return
+	})
+
+	return out, err
+}
+
+// do calls fn with a Conn without request in flight. Conns are tried in round-robin
+// order starting at the next one in line. When all of them are busy, do waits for
+// the next Conn in line to finish its request.
+func (p *Pool) do(fn func(*Conn) error) error {
+
+	start := int(atomic.AddUint32(&p.next, 1)-1) % len(p.conns)
+
+	i := p.acquire(start)
+	defer func() { <-p.slots[i] }()
+
+	return fn(p.conns[i])
+}
+
+// acquire takes a slot of one of the Pool's Conns and returns its index.
+func (p *Pool) acquire(start int) int {
+
+	for n := 0; n < len(p.conns); n++ {
+		i := (start + n) % len(p.conns)
+		select {
+		case p.slots[i] <- struct{}{}:
+			return i
+		default:
+		}
+	}
+
+	p.slots[start] <- struct{}{}
+
+	return start
+}
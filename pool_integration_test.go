@@ -0,0 +1,71 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netns"
+)
+
+func TestPoolCreateDelete(t *testing.T) {
+
+	ns, err := netns.New()
+	require.NoError(t, err)
+	defer ns.Close()
+
+	p, err := DialPool(4, &netlink.Config{NetNS: int(ns)})
+	require.NoError(t, err)
+	defer p.Close()
+	assert.Equal(t, 4, p.Len())
+
+	c, err := Dial(&netlink.Config{NetNS: int(ns)})
+	require.NoError(t, err)
+	defer c.Close()
+
+	numFlows := 1000
+
+	var wg sync.WaitGroup
+	errs := make(chan error, numFlows)
+	for i := 1; i <= numFlows; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- p.Create(NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), uint16(i), 53, 120, 0))
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+
+	flows, err := c.Dump()
+	require.NoError(t, err)
+	assert.Len(t, flows, numFlows)
+
+	f := flows[0]
+	f.Mark = 42
+	f.Timeout = 60
+	require.NoError(t, p.Update(f))
+
+	got, err := p.Get(f)
+	require.NoError(t, err)
+	assert.Equal(t, uint32(42), got.Mark)
+
+	for _, f := range flows {
+		require.NoError(t, p.Delete(f))
+	}
+
+	flows, err = c.Dump()
+	require.NoError(t, err)
+	assert.Empty(t, flows)
+
+	assert.NotZero(t, p.ConnStats().MessagesReceived)
+}
@@ -0,0 +1,88 @@
+package conntrack
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialPoolError(t *testing.T) {
+	_, err := DialPool(0, nil)
+	assert.EqualError(t, err, "invalid pool size 0")
+}
+
+// testPool returns a Pool of size nil Conns, for exercising its scheduling.
+func testPool(size int) *Pool {
+
+	p := &Pool{conns: make([]*Conn, size)}
+	for i := 0; i < size; i++ {
+		p.slots = append(p.slots, make(chan struct{}, 1))
+	}
+
+	return p
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	p := testPool(3)
+
+	var got []int
+	for i := 0; i < 6; i++ {
+		start := int(p.next) % p.Len()
+		require.NoError(t, p.do(func(*Conn) error {
+			got = append(got, start)
+			return nil
+		}))
+	}
+	assert.Equal(t, []int{0, 1, 2, 0, 1, 2}, got)
+
+	// Busy Conns are skipped.
+	p.slots[1] <- struct{}{}
+	assert.Equal(t, 2, p.acquire(1))
+	assert.Equal(t, 0, p.acquire(1))
+}
+
+func TestPoolInFlight(t *testing.T) {
+	p := testPool(2)
+
+	var (
+		mu        sync.Mutex
+		cur, peak int
+		release   = make(chan struct{})
+		wg        sync.WaitGroup
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = p.do(func(*Conn) error {
+				mu.Lock()
+				cur++
+				if cur > peak {
+					peak = cur
+				}
+				mu.Unlock()
+
+				<-release
+
+				mu.Lock()
+				cur--
+				mu.Unlock()
+				return nil
+			})
+		}()
+	}
+
+	// Let both slots fill up before releasing the requests one by one.
+	for i := 0; i < 10; i++ {
+		release <- struct{}{}
+	}
+	wg.Wait()
+
+	assert.LessOrEqual(t, peak, 2)
+	for _, s := range p.slots {
+		assert.Len(t, s, 0)
+	}
+}
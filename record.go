@@ -0,0 +1,207 @@
+package conntrack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/mdlayher/netlink"
+)
+
+// recordingMagic starts every recording, followed by the version of the format.
+//
+// A recording is a sequence of records, each consisting of the time the message was
+// received in nanoseconds since the Unix epoch (int64), the length of the message
+// (uint32) and the message in its wire format. All integers are big-endian.
+var recordingMagic = []byte("ctrec\x00\x00\x01")
+
+const recordHeaderLen = 12
+
+// minNetfilterMessageType is the lowest Netlink message type that does not denote a
+// control message (NLMSG_MIN_TYPE).
+const minNetfilterMessageType = 0x10
+
+// A Recorder writes Netlink messages received by a Conn to an io.Writer, along with
+// the time they were received. Attach it to a Conn using WithRecorder and replay the
+// recording using a Replayer. It is safe for concurrent use.
+//
+// Recording stops at the first error writing to the underlying io.Writer,
+// which is returned by Err.
+type Recorder struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	w       io.Writer
+	started bool
+	err     error
+}
+
+// NewRecorder returns a Recorder writing to w. Each record is passed to w
+// in a single Write call.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w, now: time.Now}
+}
+
+// Record writes msgs to the recording, timestamped with the current time.
+func (r *Recorder) Record(msgs []netlink.Message) error {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.err != nil {
+		return r.err
+	}
+
+	if !r.started {
+		if _, r.err = r.w.Write(recordingMagic); r.err != nil {
+			return r.err
+		}
+		r.started = true
+	}
+
+	ts := r.now().UnixNano()
+
+	for _, m := range msgs {
+		b, err := m.MarshalBinary()
+		if err != nil {
+			r.err = err
+			return err
+		}
+
+		rec := make([]byte, recordHeaderLen, recordHeaderLen+len(b))
+		binary.BigEndian.PutUint64(rec[0:8], uint64(ts))
+		binary.BigEndian.PutUint32(rec[8:12], uint32(len(b)))
+		rec = append(rec, b...)
+
+		if _, r.err = r.w.Write(rec); r.err != nil {
+			return r.err
+		}
+	}
+
+	return nil
+}
+
+// Err returns the error that stopped the recording, if any.
+func (r *Recorder) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.err
+}
+
+// A Replayer reads a recording made by a Recorder and feeds the recorded messages
+// through the same decoding pipeline as Conn.Listen, as if they were received live.
+// Options like WithLenientDecoding and WithLogger given to NewReplayer apply to the
+// replay, and its ConnStats reflect the replayed messages.
+type Replayer struct {
+	r *bufio.Reader
+
+	// c holds the Options of the Replayer and accounts for its statistics.
+	// It has no Netlink socket.
+	c *Conn
+
+	started bool
+}
+
+// NewReplayer returns a Replayer reading a recording from r.
+func NewReplayer(r io.Reader, opts ...Option) *Replayer {
+
+	c := &Conn{logger: slog.New(discardHandler{})}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return &Replayer{r: bufio.NewReader(r), c: c}
+}
+
+// Next returns the next message in the recording and the time it was received.
+// Returns io.EOF at the end of the recording.
+func (rp *Replayer) Next() (netlink.Message, time.Time, error) {
+
+	if !rp.started {
+		magic := make([]byte, len(recordingMagic))
+		if _, err := io.ReadFull(rp.r, magic); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				err = errRecordingHeader
+			}
+			return netlink.Message{}, time.Time{}, err
+		}
+		if !bytes.Equal(magic, recordingMagic) {
+			return netlink.Message{}, time.Time{}, errRecordingHeader
+		}
+		rp.started = true
+	}
+
+	hdr := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(rp.r, hdr); err != nil {
+		return netlink.Message{}, time.Time{}, err
+	}
+
+	ts := time.Unix(0, int64(binary.BigEndian.Uint64(hdr[0:8])))
+
+	b := make([]byte, binary.BigEndian.Uint32(hdr[8:12]))
+	if _, err := io.ReadFull(rp.r, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return netlink.Message{}, time.Time{}, err
+	}
+
+	var m netlink.Message
+	if err := m.UnmarshalBinary(b); err != nil {
+		return netlink.Message{}, time.Time{}, err
+	}
+
+	return m, ts, nil
+}
+
+// Replay decodes all remaining messages in the recording into Events and sends them
+// on evChan. Netlink control messages, like the end of a dump, are skipped. When
+// realtime is true, the original time between messages is reproduced.
+//
+// Returns nil at the end of the recording, or the first error encountered reading
+// the recording or decoding a message. Decoding errors are skipped in lenient mode.
+func (rp *Replayer) Replay(evChan chan<- Event, realtime bool) error {
+
+	var last time.Time
+
+	for {
+		m, ts, err := rp.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if realtime && !last.IsZero() && ts.After(last) {
+			time.Sleep(ts.Sub(last))
+		}
+		last = ts
+
+		rp.c.stats.receive([]netlink.Message{m})
+
+		if m.Header.Type < netlink.HeaderType(minNetfilterMessageType) {
+			continue
+		}
+
+		ev, ok, err := rp.c.decodeEvent(0, m)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			continue
+		}
+
+		evChan <- ev
+	}
+}
+
+// ConnStats returns the statistics of the replay so far.
+func (rp *Replayer) ConnStats() ConnStats {
+	return rp.c.stats.snapshot()
+}
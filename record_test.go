@@ -0,0 +1,142 @@
+package conntrack
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+// mustEventMessage returns a Netlink message carrying an event about f.
+func mustEventMessage(t *testing.T, mt messageType, flags netlink.HeaderFlags, f Flow) netlink.Message {
+
+	t.Helper()
+
+	attrs, err := f.marshal()
+	require.NoError(t, err)
+
+	nlm, err := netfilter.MarshalNetlink(netfilter.Header{
+		SubsystemID: netfilter.NFSubsysCTNetlink,
+		MessageType: netfilter.MessageType(mt),
+		Flags:       flags,
+	}, attrs)
+	require.NoError(t, err)
+
+	return withLength(nlm)
+}
+
+// withLength sets the length of nlm's header, like it is set on received messages.
+func withLength(nlm netlink.Message) netlink.Message {
+	nlm.Header.Length = uint32(16 + len(nlm.Data))
+	return nlm
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("write error") }
+
+func TestRecordReplay(t *testing.T) {
+
+	f := NewFlow(6, 0, net.IPv4(1, 2, 3, 4).To4(), net.IPv4(5, 6, 7, 8).To4(), 1234, 80, 120, 0)
+
+	newEv := mustEventMessage(t, ctNew, netlink.Create|netlink.Excl, f)
+	destroyEv := mustEventMessage(t, ctDelete, 0, f)
+	done := withLength(netlink.Message{Header: netlink.Header{Type: netlink.Done}, Data: make([]byte, 4)})
+
+	var buf bytes.Buffer
+	r := NewRecorder(&buf)
+
+	now := time.Unix(1000, 0)
+	r.now = func() time.Time { return now }
+
+	// Record through a Conn's receive path.
+	c := &Conn{}
+	WithRecorder(r)(c)
+	c.receive([]netlink.Message{newEv})
+
+	now = now.Add(10 * time.Millisecond)
+	require.NoError(t, r.Record([]netlink.Message{destroyEv, done}))
+	require.NoError(t, r.Err())
+
+	// Read raw records.
+	rp := NewReplayer(bytes.NewReader(buf.Bytes()))
+	m, ts, err := rp.Next()
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1000, 0), ts)
+	assert.Equal(t, newEv, m)
+
+	// Replay into Events.
+	rp = NewReplayer(bytes.NewReader(buf.Bytes()))
+	evChan := make(chan Event, 3)
+
+	start := time.Now()
+	require.NoError(t, rp.Replay(evChan, true))
+	assert.True(t, time.Since(start) >= 10*time.Millisecond, "replay did not wait between messages")
+
+	close(evChan)
+	var evs []Event
+	for ev := range evChan {
+		evs = append(evs, ev)
+	}
+
+	want := []Event{{Type: EventNew, Flow: &f}, {Type: EventDestroy, Flow: &f}}
+	if diff := cmp.Diff(want, evs); diff != "" {
+		t.Fatalf("unexpected replayed events (-want +got):\n%s", diff)
+	}
+
+	assert.Equal(t, ConnStats{MessagesReceived: 3, BytesReceived: uint64(newEv.Header.Length + destroyEv.Header.Length + done.Header.Length), EventsDecoded: 2}, rp.ConnStats())
+}
+
+func TestReplayLenient(t *testing.T) {
+
+	var buf bytes.Buffer
+	require.NoError(t, NewRecorder(&buf).Record([]netlink.Message{withLength(badFlowMessage)}))
+
+	err := NewReplayer(bytes.NewReader(buf.Bytes())).Replay(make(chan Event), false)
+	assert.EqualError(t, err, "Tuple unmarshal: need a Nested attribute to decode this structure")
+
+	rp := NewReplayer(bytes.NewReader(buf.Bytes()), WithLenientDecoding())
+	require.NoError(t, rp.Replay(make(chan Event), false))
+	assert.EqualValues(t, 1, rp.ConnStats().DecodeErrors)
+}
+
+func TestReplayError(t *testing.T) {
+
+	// An empty recording holds no messages.
+	assert.NoError(t, NewReplayer(bytes.NewReader(nil)).Replay(make(chan Event), false))
+
+	_, _, err := NewReplayer(bytes.NewReader([]byte("ctrec"))).Next()
+	assert.EqualError(t, err, errRecordingHeader.Error())
+
+	_, _, err = NewReplayer(bytes.NewReader([]byte("notarecording"))).Next()
+	assert.EqualError(t, err, errRecordingHeader.Error())
+
+	// Record truncated halfway through the message.
+	var buf bytes.Buffer
+	require.NoError(t, NewRecorder(&buf).Record([]netlink.Message{withLength(badFlowMessage)}))
+	rec := buf.Bytes()[:buf.Len()-4]
+
+	err = NewReplayer(bytes.NewReader(rec)).Replay(make(chan Event), false)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestRecorderError(t *testing.T) {
+
+	r := NewRecorder(errWriter{})
+	assert.EqualError(t, r.Record(nil), "write error")
+	assert.EqualError(t, r.Err(), "write error")
+
+	// Messages without a valid length cannot be recorded.
+	var buf bytes.Buffer
+	r = NewRecorder(&buf)
+	assert.Error(t, r.Record([]netlink.Message{{}}))
+	assert.Error(t, r.Record(nil))
+}
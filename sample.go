@@ -0,0 +1,135 @@
+package conntrack
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+// A Sampler decides whether Listen workers decode an event or skip it. Sample is
+// called for every event of the type the Sampler was registered for, before the
+// event is decoded, and may be called by multiple workers concurrently.
+type Sampler interface {
+	Sample() bool
+}
+
+// SamplerFunc adapts a function to a Sampler.
+type SamplerFunc func() bool
+
+// Sample calls f.
+func (f SamplerFunc) Sample() bool {
+	return f()
+}
+
+// WithEventSampler makes Listen workers consult s for each event of type t, like
+// EventUpdate, and skip the ones s rejects without decoding them. Skipped events are
+// counted in ConnStats.EventsSkipped. Events of types without a Sampler are all
+// decoded. Registering another Sampler for the same type replaces the previous one.
+func WithEventSampler(t eventType, s Sampler) Option {
+	return func(c *Conn) {
+		if c.samplers == nil {
+			c.samplers = make(map[eventType]Sampler)
+		}
+		c.samplers[t] = s
+	}
+}
+
+// SampleEvery returns a Sampler accepting 1 in n events, starting with the first.
+// An n of 0 or 1 accepts all events.
+func SampleEvery(n uint64) Sampler {
+
+	var count uint64
+
+	return SamplerFunc(func() bool {
+		if n <= 1 {
+			return true
+		}
+		return (atomic.AddUint64(&count, 1)-1)%n == 0
+	})
+}
+
+// SampleProbability returns a Sampler accepting each event with probability p,
+// between 0 and 1.
+func SampleProbability(p float64) Sampler {
+	return SamplerFunc(func() bool {
+		return rand.Float64() < p
+	})
+}
+
+// A tokenBucket is a Sampler accepting events at a sustained rate per second,
+// with bursts of up to burst events.
+type tokenBucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// RateLimit returns a Sampler accepting at most rate events per second on average.
+// Up to burst events are accepted at once after a quiet period. Events exceeding
+// the rate are rejected.
+func RateLimit(rate float64, burst int) Sampler {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		now:    time.Now,
+	}
+}
+
+// Sample takes a token from the bucket, if there is one.
+func (tb *tokenBucket) Sample() bool {
+
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.now()
+	if !tb.last.IsZero() {
+		tb.tokens = min(tb.burst, tb.tokens+now.Sub(tb.last).Seconds()*tb.rate)
+	}
+	tb.last = now
+
+	if tb.tokens < 1 {
+		return false
+	}
+	tb.tokens--
+
+	return true
+}
+
+// sample returns false if the Conn's Sampler for the event in nlm rejects it.
+// The event's type is read from its header, without decoding its attributes.
+func (c *Conn) sample(nlm netlink.Message) bool {
+
+	if c.samplers == nil {
+		return true
+	}
+
+	h := netfilter.Header{
+		SubsystemID: netfilter.SubsystemID(nlm.Header.Type >> 8),
+		MessageType: netfilter.MessageType(nlm.Header.Type & 0xff),
+		Flags:       nlm.Header.Flags,
+	}
+
+	var et eventType
+	if err := et.unmarshal(h); err != nil {
+		// Leave the error to the decoder.
+		return true
+	}
+
+	s, ok := c.samplers[et]
+	if !ok || s.Sample() {
+		return true
+	}
+
+	atomic.AddUint64(&c.stats.eventsSkipped, 1)
+
+	return false
+}
@@ -0,0 +1,87 @@
+package conntrack
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/ti-mo/conntrack/internal/netfilter"
+)
+
+// samples returns the outcome of n calls to s.Sample.
+func samples(s Sampler, n int) []bool {
+	out := make([]bool, n)
+	for i := range out {
+		out[i] = s.Sample()
+	}
+	return out
+}
+
+func TestSampleEvery(t *testing.T) {
+	assert.Equal(t, []bool{true, false, false, true, false, false, true}, samples(SampleEvery(3), 7))
+	assert.Equal(t, []bool{true, true, true}, samples(SampleEvery(1), 3))
+	assert.Equal(t, []bool{true, true, true}, samples(SampleEvery(0), 3))
+}
+
+func TestSampleProbability(t *testing.T) {
+	assert.NotContains(t, samples(SampleProbability(0), 100), true)
+	assert.NotContains(t, samples(SampleProbability(1), 100), false)
+
+	var n int
+	for _, ok := range samples(SampleProbability(0.5), 10000) {
+		if ok {
+			n++
+		}
+	}
+	assert.InDelta(t, 5000, n, 500)
+}
+
+func TestRateLimit(t *testing.T) {
+
+	now := time.Unix(0, 0)
+	tb := RateLimit(10, 2).(*tokenBucket)
+	tb.now = func() time.Time { return now }
+
+	// Burst, then nothing until tokens are refilled.
+	assert.Equal(t, []bool{true, true, false}, samples(tb, 3))
+
+	now = now.Add(100 * time.Millisecond)
+	assert.Equal(t, []bool{true, false}, samples(tb, 2))
+
+	// Tokens don't accumulate beyond the burst.
+	now = now.Add(time.Hour)
+	assert.Equal(t, []bool{true, true, false}, samples(tb, 3))
+}
+
+func TestConnSample(t *testing.T) {
+
+	msg := func(mt messageType, flags netlink.HeaderFlags) netlink.Message {
+		nlm, err := netfilter.MarshalNetlink(netfilter.Header{
+			SubsystemID: netfilter.NFSubsysCTNetlink,
+			MessageType: netfilter.MessageType(mt),
+			Flags:       flags,
+		}, nil)
+		require.NoError(t, err)
+		return nlm
+	}
+
+	newEv := msg(ctNew, netlink.Create|netlink.Excl)
+	updateEv := msg(ctNew, 0)
+	destroyEv := msg(ctDelete, 0)
+
+	var c Conn
+	assert.True(t, c.sample(updateEv), "no samplers")
+
+	WithEventSampler(EventUpdate, SamplerFunc(func() bool { return false }))(&c)
+	WithEventSampler(EventDestroy, SampleEvery(2))(&c)
+
+	assert.True(t, c.sample(newEv))
+	assert.False(t, c.sample(updateEv))
+	assert.True(t, c.sample(destroyEv))
+	assert.False(t, c.sample(destroyEv))
+	assert.True(t, c.sample(netlink.Message{}), "undecodable header")
+
+	assert.Equal(t, uint64(2), c.ConnStats().EventsSkipped)
+}
@@ -7,7 +7,7 @@ import (
 	"github.com/mdlayher/netlink"
 
 	"github.com/stretchr/testify/assert"
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 func TestStatsUnmarshal(t *testing.T) {
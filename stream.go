@@ -0,0 +1,151 @@
+package conntrack
+
+import (
+	"bytes"
+	"context"
+	"syscall"
+	"time"
+
+	"github.com/mdlayher/netlink"
+)
+
+// dumpBufferSize is the size of the buffer the datagrams of a streamed dump are
+// read into. The kernel fills each datagram up to the size of the buffers it was
+// read with before, and never beyond 32KiB.
+const dumpBufferSize = 32 * 1024
+
+// streamDump sends the dump request req over the Conn's Netlink socket and calls fn
+// with each message of the kernel's reply as it is read from the socket, instead of
+// reading the whole reply first like query. Returns true if the kernel flagged the
+// dump as interrupted. The Conn's timeouts apply to the whole dump.
+//
+// Each datagram read is accounted for in the Conn's ConnStats, recorded by its
+// Recorder and passed through its ReceiveHooks like the replies to a query. After
+// fn or a ReceiveHook returned an error, the rest of the dump is read and discarded
+// to keep the socket usable for the next query, and the error is returned.
+//
+// The socket is reserved for the dump until streamDump returns, so fn must not
+// make queries over the Conn.
+func (c *Conn) streamDump(ctx context.Context, req netlink.Message, fn func(netlink.Message) error) (bool, error) {
+
+	req, err := c.beforeSend(ctx, req)
+	if err != nil {
+		return false, err
+	}
+
+	c.queryMu.Lock()
+	defer c.queryMu.Unlock()
+
+	done, err := c.deadlines(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer done()
+
+	rc, err := c.conn.SyscallConn()
+	if err != nil {
+		return false, err
+	}
+
+	start := time.Now()
+	sent, err := c.send(req)
+	if err != nil {
+		err = contextError(ctx, err)
+		if c.receiveHooks != nil {
+			_, err = c.afterReceive(ctx, Receipt{Request: &req, Err: err, Start: start, Duration: time.Since(start)})
+		}
+		return false, err
+	}
+	req = sent
+
+	var interrupted bool
+	var ferr error
+
+	b := make([]byte, dumpBufferSize)
+	for {
+		msgs, last, err := readDump(rc, b, req)
+		if err != nil {
+			err = contextError(ctx, err)
+			last = true
+		} else {
+			interrupted = interrupted || dumpInterrupted(msgs)
+			if last {
+				msgs = msgs[:len(msgs)-1]
+			}
+			c.receive(msgs)
+		}
+
+		if ferr == nil && c.receiveHooks != nil {
+			msgs, err = c.afterReceive(ctx, Receipt{Request: &req, Messages: msgs, Err: err, Start: start, Duration: time.Since(start)})
+			if err != nil && !last {
+				ferr, err = err, nil
+			}
+		}
+
+		if err != nil {
+			if ferr != nil {
+				return interrupted, ferr
+			}
+			return interrupted, err
+		}
+
+		for _, m := range msgs {
+			if ferr != nil {
+				break
+			}
+			ferr = fn(m)
+		}
+
+		if last {
+			return interrupted, ferr
+		}
+		start = time.Now()
+	}
+}
+
+// readDump reads a datagram of the reply to the dump request req from the socket
+// rc into b, and returns its messages. last is true if the final message returned
+// ends the dump. Returns the error the kernel ended the dump with, if any. The
+// messages don't share memory with b, so ReceiveHooks may retain them.
+func readDump(rc syscall.RawConn, b []byte, req netlink.Message) ([]netlink.Message, bool, error) {
+
+	n, err := readDatagram(rc, b)
+	if err != nil {
+		return nil, false, &netlink.OpError{Op: "receive", Err: err}
+	}
+
+	msgs, err := splitMessages(bytes.Clone(b[:n]))
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := netlink.Validate(req, msgs); err != nil {
+		return nil, false, err
+	}
+
+	for i, m := range msgs {
+		last, err := dumpEnd(m)
+		if err != nil {
+			return nil, true, err
+		}
+		if last {
+			return msgs[:i+1], true, nil
+		}
+	}
+
+	return msgs, false, nil
+}
+
+// dumpEnd returns true if m is the final message of the reply to a dump. The kernel
+// ends a dump with a Done message, or with an Error message if the dump failed.
+func dumpEnd(m netlink.Message) (bool, error) {
+
+	switch m.Header.Type {
+	case netlink.Done:
+		return true, nil
+	case netlink.Error:
+		return true, ackError(m)
+	}
+
+	return m.Header.Flags&netlink.Multi == 0, nil
+}
@@ -0,0 +1,60 @@
+package conntrack
+
+import (
+	"syscall"
+	"testing"
+
+	"github.com/mdlayher/netlink"
+	"github.com/mdlayher/netlink/nlenc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDumpEnd(t *testing.T) {
+
+	tests := []struct {
+		name string
+		msg  netlink.Message
+		last bool
+		err  error
+	}{
+		{
+			name: "flow",
+			msg:  netlink.Message{Header: netlink.Header{Flags: netlink.Multi}},
+		},
+		{
+			name: "single part",
+			msg:  netlink.Message{},
+			last: true,
+		},
+		{
+			name: "done",
+			msg:  netlink.Message{Header: netlink.Header{Type: netlink.Done, Flags: netlink.Multi}},
+			last: true,
+		},
+		{
+			name: "ack",
+			msg:  netlink.Message{Header: netlink.Header{Type: netlink.Error}, Data: nlenc.Int32Bytes(0)},
+			last: true,
+		},
+		{
+			name: "error",
+			msg:  netlink.Message{Header: netlink.Header{Type: netlink.Error}, Data: nlenc.Int32Bytes(-int32(syscall.EINVAL))},
+			last: true,
+			err:  &netlink.OpError{Op: "receive", Err: syscall.EINVAL},
+		},
+		{
+			name: "short error",
+			msg:  netlink.Message{Header: netlink.Header{Type: netlink.Error}, Data: []byte{1}},
+			last: true,
+			err:  errMessageError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			last, err := dumpEnd(tt.msg)
+			assert.Equal(t, tt.last, last)
+			assert.Equal(t, tt.err, err)
+		})
+	}
+}
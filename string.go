@@ -3,6 +3,7 @@ package conntrack
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 // protoLookup translates a protocol integer into its string representation.
@@ -28,29 +29,31 @@ func protoLookup(p uint8) string {
 	return strconv.FormatUint(uint64(p), 10)
 }
 
+// statusNames holds the names of the Status flags, indexed by bit position.
+var statusNames = []string{
+	"EXPECTED",
+	"SEEN_REPLY",
+	"ASSURED",
+	"CONFIRMED",
+	"SRC_NAT",
+	"DST_NAT",
+	"SEQ_ADJUST",
+	"SRC_NAT_DONE",
+	"DST_NAT_DONE",
+	"DYING",
+	"FIXED_TIMEOUT",
+	"TEMPLATE",
+	"UNTRACKED",
+	"HELPER",
+	"OFFLOAD",
+}
+
 func (s Status) String() string {
-	names := []string{
-		"EXPECTED",
-		"SEEN_REPLY",
-		"ASSURED",
-		"CONFIRMED",
-		"SRC_NAT",
-		"DST_NAT",
-		"SEQ_ADJUST",
-		"SRC_NAT_DONE",
-		"DST_NAT_DONE",
-		"DYING",
-		"FIXED_TIMEOUT",
-		"TEMPLATE",
-		"UNTRACKED",
-		"HELPER",
-		"OFFLOAD",
-	}
 
 	var rs string
 
 	// Loop over the field's bits
-	for i, name := range names {
+	for i, name := range statusNames {
 		if s.Value&(1<<uint32(i)) != 0 {
 			if rs != "" {
 				rs += "|"
@@ -131,3 +134,86 @@ func (e Event) String() string {
 	}
 
 }
+
+// String returns a representation of the Flow in the format used by conntrack(8)
+// when listing the Conntrack table, for example:
+//
+//	tcp      6 431999 ESTABLISHED src=10.0.0.1 dst=10.0.0.2 sport=51234 dport=22 packets=3 bytes=180 src=10.0.0.2 dst=10.0.0.1 sport=22 dport=51234 packets=2 bytes=120 [ASSURED] mark=0 use=1
+//
+// Counters are only included when they are non-zero. The result can be parsed
+// back into a Flow using ParseFlow.
+func (f Flow) String() string {
+
+	var b strings.Builder
+
+	proto := f.TupleOrig.Proto.Protocol
+	fmt.Fprintf(&b, "%-8s %d %d ", ctProtoName(proto), proto, f.Timeout)
+
+	if state := f.ProtoInfo.stateName(); state != "" {
+		b.WriteString(state + " ")
+	}
+
+	writeTuple(&b, f.TupleOrig, "zone-orig")
+	writeCounter(&b, f.CountersOrig)
+
+	if !f.Status.SeenReply() {
+		b.WriteString("[UNREPLIED] ")
+	}
+
+	writeTuple(&b, f.TupleReply, "zone-reply")
+	writeCounter(&b, f.CountersReply)
+
+	if f.Status.Assured() {
+		b.WriteString("[ASSURED] ")
+	}
+	if f.Status.Offload() {
+		b.WriteString("[OFFLOAD] ")
+	}
+
+	fmt.Fprintf(&b, "mark=%d ", f.Mark)
+
+	if f.SecurityContext != "" {
+		fmt.Fprintf(&b, "secctx=%s ", f.SecurityContext)
+	}
+	if f.Zone != 0 {
+		fmt.Fprintf(&b, "zone=%d ", f.Zone)
+	}
+	if f.Helper.Name != "" {
+		fmt.Fprintf(&b, "helper=%s ", f.Helper.Name)
+	}
+
+	fmt.Fprintf(&b, "use=%d", f.Use)
+
+	if f.ID != 0 {
+		fmt.Fprintf(&b, " id=%d", f.ID)
+	}
+
+	return b.String()
+}
+
+// writeTuple writes the conntrack(8) representation of t to b. A non-zero Zone
+// is written using the given key.
+func writeTuple(b *strings.Builder, t Tuple, zoneKey string) {
+
+	fmt.Fprintf(b, "src=%s dst=%s ", t.IP.SourceAddress, t.IP.DestinationAddress)
+
+	switch {
+	case t.Proto.ICMPv4 || t.Proto.ICMPv6:
+		fmt.Fprintf(b, "type=%d code=%d id=%d ", t.Proto.ICMPType, t.Proto.ICMPCode, t.Proto.ICMPID)
+	case t.Proto.Protocol == protoGRE:
+		fmt.Fprintf(b, "srckey=%#x dstkey=%#x ", t.Proto.SourcePort, t.Proto.DestinationPort)
+	case t.Proto.SourcePort != 0 || t.Proto.DestinationPort != 0:
+		fmt.Fprintf(b, "sport=%d dport=%d ", t.Proto.SourcePort, t.Proto.DestinationPort)
+	}
+
+	if t.Zone != 0 {
+		fmt.Fprintf(b, "%s=%d ", zoneKey, t.Zone)
+	}
+}
+
+// writeCounter writes the conntrack(8) representation of a non-zero ctr to b.
+func writeCounter(b *strings.Builder, ctr Counter) {
+	if ctr.Packets != 0 || ctr.Bytes != 0 {
+		fmt.Fprintf(b, "packets=%d bytes=%d ", ctr.Packets, ctr.Bytes)
+	}
+}
@@ -83,3 +83,29 @@ func TestStatsString(t *testing.T) {
 	s := Stats{CPUID: 42, Found: 2, SearchRestart: 999}
 	assert.Equal(t, "<CPU 42 - Found: 2, Invalid: 0, Ignore: 0, Insert: 0, InsertFailed: 0, Drop: 0, EarlyDrop: 0, Error: 0, SearchRestart: 999>", s.String())
 }
+
+func TestFlowString(t *testing.T) {
+
+	f := NewFlow(6, StatusSeenReply|StatusAssured, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), 51234, 22, 431999, 0)
+	f.ProtoInfo.TCP = &ProtoInfoTCP{State: 3}
+	f.CountersOrig = Counter{Packets: 3, Bytes: 180}
+	f.CountersReply = Counter{Direction: true, Packets: 2, Bytes: 120}
+	f.Use = 1
+
+	assert.Equal(t,
+		"tcp      6 431999 ESTABLISHED src=10.0.0.1 dst=10.0.0.2 sport=51234 dport=22 packets=3 bytes=180 src=10.0.0.2 dst=10.0.0.1 sport=22 dport=51234 packets=2 bytes=120 [ASSURED] mark=0 use=1",
+		f.String())
+
+	f = NewFlow(1, 0, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 0, 2), 0, 0, 30, 0xff)
+	f.TupleOrig.Proto = ProtoTuple{Protocol: 1, ICMPv4: true, ICMPType: 8, ICMPID: 1234}
+	f.TupleReply.Proto = ProtoTuple{Protocol: 1, ICMPv4: true, ICMPID: 1234}
+	f.TupleOrig.Zone = 2
+	f.Zone = 3
+	f.SecurityContext = "unconfined"
+	f.Helper.Name = "ftp"
+	f.ID = 42
+
+	assert.Equal(t,
+		"icmp     1 30 src=10.0.0.1 dst=10.0.0.2 type=8 code=0 id=1234 zone-orig=2 [UNREPLIED] src=10.0.0.2 dst=10.0.0.1 type=0 code=0 id=1234 mark=255 secctx=unconfined zone=3 helper=ftp use=0 id=42",
+		f.String())
+}
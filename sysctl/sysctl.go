@@ -0,0 +1,183 @@
+// Package sysctl reads and writes the kernel's Conntrack tunables, like the
+// maximum size of the table and the timeouts of connections per protocol.
+//
+// Tunables are files in /proc/sys/net/netfilter and belong to the network
+// namespace of the process accessing them. Writing requires CAP_NET_ADMIN,
+// and some tunables, like nf_conntrack_max, can only be written from the
+// initial network namespace.
+package sysctl
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// prefix is the common prefix of the names of Conntrack tunables.
+const prefix = "nf_conntrack_"
+
+var errNoMax = errors.New("sysctl: nf_conntrack_max is 0, table size is unlimited")
+
+// A Dir is a directory holding Conntrack tunables. Tunables are named without
+// their nf_conntrack_ prefix, like 'max' or 'tcp_timeout_established'.
+type Dir string
+
+// Default is the directory holding the Conntrack tunables of the calling
+// process' network namespace.
+const Default Dir = "/proc/sys/net/netfilter"
+
+// Int reads the tunable with the given name as an integer.
+func (d Dir) Int(name string) (int, error) {
+
+	b, err := os.ReadFile(d.path(name))
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return 0, fmt.Errorf("sysctl: parsing %s%s: %w", prefix, name, err)
+	}
+
+	return v, nil
+}
+
+// SetInt writes v to the existing tunable with the given name.
+func (d Dir) SetInt(name string, v int) error {
+
+	f, err := os.OpenFile(d.path(name), os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(strconv.Itoa(v) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Max returns the maximum amount of entries in the Conntrack table (nf_conntrack_max).
+func (d Dir) Max() (int, error) {
+	return d.Int("max")
+}
+
+// SetMax sets the maximum amount of entries in the Conntrack table.
+func (d Dir) SetMax(n int) error {
+	return d.SetInt("max", n)
+}
+
+// Count returns the current amount of entries in the Conntrack table (nf_conntrack_count).
+func (d Dir) Count() (int, error) {
+	return d.Int("count")
+}
+
+// Utilization returns the ratio between the current and the maximum amount of
+// entries in the Conntrack table. New connections are dropped when it reaches 1.
+func (d Dir) Utilization() (float64, error) {
+
+	count, err := d.Count()
+	if err != nil {
+		return 0, err
+	}
+
+	max, err := d.Max()
+	if err != nil {
+		return 0, err
+	}
+
+	if max == 0 {
+		return 0, errNoMax
+	}
+
+	return float64(count) / float64(max), nil
+}
+
+// Accounting returns whether packet and byte counters are kept for new
+// connections (nf_conntrack_acct).
+func (d Dir) Accounting() (bool, error) {
+	return d.bool("acct")
+}
+
+// SetAccounting enables or disables counters for new connections.
+func (d Dir) SetAccounting(enable bool) error {
+	return d.setBool("acct", enable)
+}
+
+// Timestamps returns whether start and stop times are recorded for new
+// connections (nf_conntrack_timestamp).
+func (d Dir) Timestamps() (bool, error) {
+	return d.bool("timestamp")
+}
+
+// SetTimestamps enables or disables timestamps for new connections.
+func (d Dir) SetTimestamps(enable bool) error {
+	return d.setBool("timestamp", enable)
+}
+
+// Timeout returns the timeout with the given name, like 'udp_timeout' or
+// 'tcp_timeout_established'.
+func (d Dir) Timeout(name string) (time.Duration, error) {
+
+	s, err := d.Int(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(s) * time.Second, nil
+}
+
+// SetTimeout sets the timeout with the given name. The kernel only supports
+// whole seconds, t is truncated.
+func (d Dir) SetTimeout(name string, t time.Duration) error {
+	return d.SetInt(name, int(t/time.Second))
+}
+
+// Timeouts returns all per-protocol timeouts in d, keyed by their name.
+func (d Dir) Timeouts() (map[string]time.Duration, error) {
+
+	paths, err := filepath.Glob(d.path("*_timeout*"))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]time.Duration, len(paths))
+	for _, p := range paths {
+		name := strings.TrimPrefix(filepath.Base(p), prefix)
+
+		// IPv6 fragment reassembly shares the directory, but isn't Conntrack's.
+		if strings.HasPrefix(name, "frag6_") {
+			continue
+		}
+
+		t, err := d.Timeout(name)
+		if err != nil {
+			return nil, err
+		}
+		out[name] = t
+	}
+
+	return out, nil
+}
+
+func (d Dir) bool(name string) (bool, error) {
+	v, err := d.Int(name)
+	return v != 0, err
+}
+
+func (d Dir) setBool(name string, v bool) error {
+	if v {
+		return d.SetInt(name, 1)
+	}
+	return d.SetInt(name, 0)
+}
+
+// path returns the path of the tunable with the given name.
+func (d Dir) path(name string) string {
+	return filepath.Join(string(d), prefix+name)
+}
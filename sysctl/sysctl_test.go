@@ -0,0 +1,96 @@
+package sysctl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDir(t *testing.T, tunables map[string]string) Dir {
+
+	dir := t.TempDir()
+	for name, val := range tunables {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, prefix+name), []byte(val+"\n"), 0644))
+	}
+
+	return Dir(dir)
+}
+
+func TestDirTable(t *testing.T) {
+
+	d := testDir(t, map[string]string{"max": "262144", "count": "65536"})
+
+	max, err := d.Max()
+	require.NoError(t, err)
+	assert.Equal(t, 262144, max)
+
+	u, err := d.Utilization()
+	require.NoError(t, err)
+	assert.Equal(t, 0.25, u)
+
+	require.NoError(t, d.SetMax(0))
+	_, err = d.Utilization()
+	assert.Equal(t, errNoMax, err)
+
+	_, err = Dir(t.TempDir()).Count()
+	assert.True(t, os.IsNotExist(err))
+
+	// Tunables are never created.
+	assert.True(t, os.IsNotExist(Dir(t.TempDir()).SetMax(1)))
+}
+
+func TestDirToggles(t *testing.T) {
+
+	d := testDir(t, map[string]string{"acct": "0", "timestamp": "1"})
+
+	acct, err := d.Accounting()
+	require.NoError(t, err)
+	assert.False(t, acct)
+
+	require.NoError(t, d.SetAccounting(true))
+	acct, err = d.Accounting()
+	require.NoError(t, err)
+	assert.True(t, acct)
+
+	require.NoError(t, d.SetTimestamps(false))
+	ts, err := d.Timestamps()
+	require.NoError(t, err)
+	assert.False(t, ts)
+
+	require.NoError(t, os.WriteFile(d.path("acct"), []byte("on\n"), 0644))
+	_, err = d.Accounting()
+	assert.Error(t, err)
+}
+
+func TestDirTimeouts(t *testing.T) {
+
+	d := testDir(t, map[string]string{
+		"udp_timeout":             "30",
+		"tcp_timeout_established": "432000",
+		"frag6_timeout":           "60",
+		"max":                     "1",
+	})
+
+	require.NoError(t, d.SetTimeout("udp_timeout", 45*time.Second+time.Millisecond))
+
+	tos, err := d.Timeouts()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]time.Duration{
+		"udp_timeout":             45 * time.Second,
+		"tcp_timeout_established": 5 * 24 * time.Hour,
+	}, tos)
+}
+
+func TestDefault(t *testing.T) {
+
+	if _, err := os.Stat(string(Default)); err != nil {
+		t.Skip("no conntrack tunables in this network namespace")
+	}
+
+	_, err := Default.Max()
+	assert.NoError(t, err)
+}
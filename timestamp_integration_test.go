@@ -0,0 +1,66 @@
+//go:build integration
+
+package conntrack
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netns"
+)
+
+// Timestamps are decoded as wall clock time, without conversion.
+func TestFlowTimestamp(t *testing.T) {
+
+	// Enable timestamping in the new namespace, which /proc/sys/net refers to
+	// for the thread that created it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	orig, err := netns.Get()
+	require.NoError(t, err)
+	defer netns.Set(orig)
+
+	c, nsid, err := makeNSConn()
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, os.WriteFile("/proc/sys/net/netfilter/nf_conntrack_timestamp", []byte("1"), 0o644))
+
+	// Like in TestConnListen, the listener can't be closed while its worker
+	// is blocked in Receive, so it is left open.
+	lc, err := Dial(&netlink.Config{NetNS: nsid})
+	require.NoError(t, err)
+
+	evChan := make(chan Event, 1)
+	_, err = lc.Listen(evChan, 1, []NetlinkGroup{GroupCTDestroy})
+	require.NoError(t, err)
+
+	before := time.Now()
+
+	f := NewFlow(17, 0, net.IPv4(1, 2, 3, 4), net.IPv4(5, 6, 7, 8), 1234, 53, 120, 0)
+	require.NoError(t, c.Create(f))
+
+	got, err := c.Get(f)
+	require.NoError(t, err)
+	assert.WithinDuration(t, before, got.Timestamp.Start, time.Second)
+	assert.True(t, got.Timestamp.Stop.IsZero())
+
+	require.NoError(t, c.Delete(f))
+
+	select {
+	case ev := <-evChan:
+		ts := ev.Flow.Timestamp
+		assert.Equal(t, got.Timestamp.Start, ts.Start)
+		assert.WithinDuration(t, time.Now(), ts.Stop, time.Second)
+		assert.False(t, ts.Stop.Before(ts.Start))
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for destroy event")
+	}
+}
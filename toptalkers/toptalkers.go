@@ -0,0 +1,248 @@
+// Package toptalkers aggregates Conntrack accounting data into top-N tables.
+//
+// An Aggregator is fed Flows, either from periodic dumps or from Events, and
+// attributes the traffic each Flow saw since its previous observation to a key
+// derived from the Flow, like its source address. Traffic totals per key decay
+// exponentially over time, so the resulting tables reflect recent activity.
+//
+// Accounting needs to be enabled in the kernel (sysctl net.netfilter.nf_conntrack_acct)
+// for Flows to carry the counters an Aggregator relies on.
+package toptalkers
+
+import (
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+)
+
+// A KeyFunc derives the aggregation key of a Flow. Flows for which the
+// KeyFunc returns false are not aggregated.
+type KeyFunc func(f conntrack.Flow) (string, bool)
+
+// BySource groups Flows by the source address of their original tuple.
+func BySource(f conntrack.Flow) (string, bool) {
+	return ipKey(f.TupleOrig.IP.SourceAddress)
+}
+
+// ByDestination groups Flows by the destination address of their original tuple.
+func ByDestination(f conntrack.Flow) (string, bool) {
+	return ipKey(f.TupleOrig.IP.DestinationAddress)
+}
+
+// ByDestinationPort groups Flows by the protocol and destination port of their
+// original tuple, formatted like 'tcp/443'.
+func ByDestinationPort(f conntrack.Flow) (string, bool) {
+
+	p := f.TupleOrig.Proto
+	if p.Protocol == 0 {
+		return "", false
+	}
+
+	return protoName(p.Protocol) + "/" + strconv.Itoa(int(p.DestinationPort)), true
+}
+
+func ipKey(ip net.IP) (string, bool) {
+	if len(ip) == 0 {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+func protoName(p uint8) string {
+	switch p {
+	case 6:
+		return "tcp"
+	case 17:
+		return "udp"
+	case 33:
+		return "dccp"
+	case 132:
+		return "sctp"
+	case 136:
+		return "udplite"
+	}
+	return strconv.Itoa(int(p))
+}
+
+// Metric selects the value to rank an Aggregator's entries by.
+type Metric uint8
+
+// Metrics an Aggregator can rank its entries by.
+const (
+	Bytes Metric = iota
+	Packets
+)
+
+// An Entry is a row in a top-N table. Bytes and Packets hold the (decayed)
+// amount of traffic attributed to Key, in both directions.
+type Entry struct {
+	Key            string
+	Bytes, Packets float64
+}
+
+// entry holds an Entry and the last time its values were decayed.
+type entry struct {
+	Entry
+	updated time.Time
+}
+
+// prune is the value below which decayed entries are removed from an Aggregator.
+const prune = 0.5
+
+// An Aggregator maintains traffic totals per key. It is safe for concurrent use.
+type Aggregator struct {
+	key      KeyFunc
+	halfLife time.Duration
+
+	acct *conntrack.AccountingTracker
+
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+// New returns an Aggregator grouping Flows using key. Totals decay exponentially,
+// losing half of their value every halfLife. A zero halfLife disables decay,
+// making the Aggregator keep cumulative totals.
+func New(key KeyFunc, halfLife time.Duration) *Aggregator {
+	return &Aggregator{
+		key:      key,
+		halfLife: halfLife,
+		acct:     conntrack.NewAccountingTracker(),
+		entries:  make(map[string]*entry),
+	}
+}
+
+// Observe attributes the traffic seen by f since its previous observation to
+// f's key, at time t. The first observation of a Flow attributes all of its traffic.
+func (a *Aggregator) Observe(f conntrack.Flow, t time.Time) {
+	a.mu.Lock()
+	a.observe(f, t)
+	a.mu.Unlock()
+}
+
+// ObserveEvent observes the Flow carried by ev at time t. The Flow is forgotten
+// after a destroy event, so a later connection with the same tuple is counted anew.
+// Events without a Flow are ignored.
+func (a *Aggregator) ObserveEvent(ev conntrack.Event, t time.Time) {
+
+	if ev.Flow == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.observe(*ev.Flow, t)
+
+	if ev.Type == conntrack.EventDestroy {
+		a.acct.Forget(*ev.Flow)
+	}
+}
+
+// ObserveDump observes all Flows in a dump of the Conntrack table taken at time t.
+// Flows missing from the dump that were last observed before t are forgotten.
+func (a *Aggregator) ObserveDump(flows []conntrack.Flow, t time.Time) {
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, f := range flows {
+		a.observe(f, t)
+	}
+
+	a.acct.Expire(t)
+}
+
+// observe implements Observe. Must be called with a.mu held.
+func (a *Aggregator) observe(f conntrack.Flow, t time.Time) {
+
+	k, ok := a.key(f)
+	if !ok {
+		return
+	}
+
+	d := a.acct.Observe(f, t)
+
+	b := float64(d.Orig.Bytes + d.Reply.Bytes)
+	p := float64(d.Orig.Packets + d.Reply.Packets)
+	if b == 0 && p == 0 {
+		return
+	}
+
+	e, ok := a.entries[k]
+	if !ok {
+		e = &entry{Entry: Entry{Key: k}, updated: t}
+		a.entries[k] = e
+	}
+
+	a.decay(e, t)
+	e.Bytes += b
+	e.Packets += p
+}
+
+// Top returns the n entries with the highest value of m at time t, in descending order.
+// Returns all entries if n is not positive.
+func (a *Aggregator) Top(n int, m Metric, t time.Time) []Entry {
+
+	a.mu.Lock()
+
+	out := make([]Entry, 0, len(a.entries))
+	for k, e := range a.entries {
+		a.decay(e, t)
+		if e.Bytes < prune && e.Packets < prune {
+			delete(a.entries, k)
+			continue
+		}
+		out = append(out, e.Entry)
+	}
+
+	a.mu.Unlock()
+
+	sort.Slice(out, func(i, j int) bool {
+		vi, vj := out[i].value(m), out[j].value(m)
+		if vi != vj {
+			return vi > vj
+		}
+		return out[i].Key < out[j].Key
+	})
+
+	if n > 0 && len(out) > n {
+		out = out[:n]
+	}
+
+	return out
+}
+
+// Reset removes all entries and forgets all observed Flows.
+func (a *Aggregator) Reset() {
+	a.mu.Lock()
+	a.entries = make(map[string]*entry)
+	a.acct = conntrack.NewAccountingTracker()
+	a.mu.Unlock()
+}
+
+// decay applies the exponential decay between the entry's last update and t.
+// Must be called with a.mu held.
+func (a *Aggregator) decay(e *entry, t time.Time) {
+
+	if a.halfLife <= 0 || !t.After(e.updated) {
+		return
+	}
+
+	f := math.Exp2(-float64(t.Sub(e.updated)) / float64(a.halfLife))
+	e.Bytes *= f
+	e.Packets *= f
+	e.updated = t
+}
+
+func (e Entry) value(m Metric) float64 {
+	if m == Packets {
+		return e.Packets
+	}
+	return e.Bytes
+}
@@ -0,0 +1,121 @@
+package toptalkers
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ti-mo/conntrack"
+)
+
+func flow(src string, sport, dport uint16, pkts, bytes uint64) conntrack.Flow {
+	f := conntrack.NewFlow(6, 0, net.ParseIP(src), net.IPv4(10, 0, 0, 1), sport, dport, 120, 0)
+	f.CountersOrig = conntrack.Counter{Packets: pkts, Bytes: bytes}
+	return f
+}
+
+func TestKeyFuncs(t *testing.T) {
+
+	f := flow("192.0.2.1", 1234, 443, 0, 0)
+
+	k, ok := BySource(f)
+	assert.True(t, ok)
+	assert.Equal(t, "192.0.2.1", k)
+
+	k, ok = ByDestination(f)
+	assert.True(t, ok)
+	assert.Equal(t, "10.0.0.1", k)
+
+	k, ok = ByDestinationPort(f)
+	assert.True(t, ok)
+	assert.Equal(t, "tcp/443", k)
+
+	f.TupleOrig.Proto.Protocol = 47
+	k, _ = ByDestinationPort(f)
+	assert.Equal(t, "47/443", k)
+
+	_, ok = BySource(conntrack.Flow{})
+	assert.False(t, ok)
+	_, ok = ByDestinationPort(conntrack.Flow{})
+	assert.False(t, ok)
+}
+
+func TestAggregatorCumulative(t *testing.T) {
+
+	a := New(BySource, 0)
+	now := time.Unix(1000, 0)
+
+	a.Observe(flow("192.0.2.1", 1, 80, 10, 1000), now)
+	a.Observe(flow("192.0.2.1", 2, 80, 1, 5000), now)
+	a.Observe(flow("192.0.2.2", 1, 80, 100, 2000), now)
+
+	// Second observation of the first Flow only adds its delta.
+	a.Observe(flow("192.0.2.1", 1, 80, 15, 1500), now.Add(time.Second))
+
+	assert.Equal(t, []Entry{
+		{Key: "192.0.2.1", Bytes: 6500, Packets: 16},
+		{Key: "192.0.2.2", Bytes: 2000, Packets: 100},
+	}, a.Top(0, Bytes, now))
+
+	assert.Equal(t, []Entry{
+		{Key: "192.0.2.2", Bytes: 2000, Packets: 100},
+	}, a.Top(1, Packets, now))
+
+	a.Reset()
+	assert.Empty(t, a.Top(0, Bytes, now))
+}
+
+func TestAggregatorDecay(t *testing.T) {
+
+	a := New(ByDestinationPort, time.Minute)
+	now := time.Unix(1000, 0)
+
+	a.Observe(flow("192.0.2.1", 1, 80, 8, 800), now)
+
+	top := a.Top(0, Bytes, now.Add(time.Minute))
+	assert.Equal(t, []Entry{{Key: "tcp/80", Bytes: 400, Packets: 4}}, top)
+
+	top = a.Top(0, Bytes, now.Add(3*time.Minute))
+	assert.Equal(t, []Entry{{Key: "tcp/80", Bytes: 100, Packets: 1}}, top)
+
+	// Entries that decayed to (almost) nothing are pruned.
+	assert.Empty(t, a.Top(0, Bytes, now.Add(time.Hour)))
+}
+
+func TestAggregatorEvents(t *testing.T) {
+
+	a := New(BySource, 0)
+	now := time.Unix(1000, 0)
+
+	f := flow("192.0.2.1", 1, 80, 1, 100)
+	a.ObserveEvent(conntrack.Event{Type: conntrack.EventUpdate, Flow: &f}, now)
+
+	f = flow("192.0.2.1", 1, 80, 2, 200)
+	a.ObserveEvent(conntrack.Event{Type: conntrack.EventDestroy, Flow: &f}, now)
+
+	// A new connection reusing the tuple is counted from scratch.
+	f = flow("192.0.2.1", 1, 80, 1, 50)
+	a.ObserveEvent(conntrack.Event{Type: conntrack.EventNew, Flow: &f}, now)
+
+	a.ObserveEvent(conntrack.Event{Type: conntrack.EventExpNew}, now)
+
+	assert.Equal(t, []Entry{{Key: "192.0.2.1", Bytes: 250, Packets: 3}}, a.Top(0, Bytes, now))
+}
+
+func TestAggregatorDump(t *testing.T) {
+
+	a := New(BySource, 0)
+	now := time.Unix(1000, 0)
+
+	a.ObserveDump([]conntrack.Flow{flow("192.0.2.1", 1, 80, 1, 100)}, now)
+
+	// The Flow disappears from the table and is forgotten.
+	a.ObserveDump(nil, now.Add(time.Second))
+
+	// The same tuple shows up again with lower counters, all of its traffic is counted.
+	a.ObserveDump([]conntrack.Flow{flow("192.0.2.1", 1, 80, 1, 100)}, now.Add(2*time.Second))
+
+	assert.Equal(t, []Entry{{Key: "192.0.2.1", Bytes: 200, Packets: 2}}, a.Top(0, Bytes, now))
+}
@@ -1,16 +1,16 @@
 package conntrack
 
 import (
+	"bytes"
 	"fmt"
 	"net"
+	"net/netip"
 	"strconv"
-	"syscall"
 
 	"github.com/mdlayher/netlink"
 	"github.com/pkg/errors"
-	"golang.org/x/sys/unix"
 
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 const (
@@ -20,6 +20,11 @@ const (
 )
 
 // A Tuple holds an IPTuple, ProtoTuple and a Zone.
+//
+// A non-zero Zone on a Flow's TupleOrig or TupleReply puts the connection in that
+// zone in the tuple's direction only, while the other direction remains in the
+// default zone. The kernel supports a directional zone on one of both tuples, and
+// not in combination with Flow.Zone, which applies to both directions.
 type Tuple struct {
 	IP    IPTuple
 	Proto ProtoTuple
@@ -41,6 +46,112 @@ func (t Tuple) String() string {
 	)
 }
 
+// Source returns the Tuple's source address and port. IPv4 addresses are returned
+// in their 4-byte form. The port is zero for protocols without ports, like ICMP.
+// Returns the zero netip.AddrPort if the Tuple has no valid source address.
+func (t Tuple) Source() netip.AddrPort {
+	return addrPort(t.IP.SourceAddress, t.Proto.SourcePort)
+}
+
+// Destination returns the Tuple's destination address and port, like Source.
+func (t Tuple) Destination() netip.AddrPort {
+	return addrPort(t.IP.DestinationAddress, t.Proto.DestinationPort)
+}
+
+// addrPort converts ip and port to a netip.AddrPort, unmapping IPv4 addresses.
+func addrPort(ip net.IP, port uint16) netip.AddrPort {
+
+	a, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.AddrPort{}
+	}
+
+	return netip.AddrPortFrom(a.Unmap(), port)
+}
+
+// tupleKey is a comparable representation of a Tuple, used to index Flows in maps.
+// IPv4 addresses are stored in their IPv4-mapped IPv6 form.
+type tupleKey struct {
+	src, dst [16]byte
+
+	proto        uint8
+	sport, dport uint16
+
+	icmpID             uint16
+	icmpType, icmpCode uint8
+
+	zone uint16
+}
+
+// key returns the Tuple's tupleKey.
+func (t Tuple) key() tupleKey {
+
+	k := tupleKey{
+		proto:    t.Proto.Protocol,
+		sport:    t.Proto.SourcePort,
+		dport:    t.Proto.DestinationPort,
+		icmpID:   t.Proto.ICMPID,
+		icmpType: t.Proto.ICMPType,
+		icmpCode: t.Proto.ICMPCode,
+		zone:     t.Zone,
+	}
+
+	copy(k.src[:], t.IP.SourceAddress.To16())
+	copy(k.dst[:], t.IP.DestinationAddress.To16())
+
+	return k
+}
+
+// less returns true if k sorts before o. Keys are ordered by their addresses
+// first, then by their ports, ICMP fields and zone.
+func (k tupleKey) less(o tupleKey) bool {
+
+	if c := bytes.Compare(k.src[:], o.src[:]); c != 0 {
+		return c < 0
+	}
+	if c := bytes.Compare(k.dst[:], o.dst[:]); c != 0 {
+		return c < 0
+	}
+
+	switch {
+	case k.proto != o.proto:
+		return k.proto < o.proto
+	case k.sport != o.sport:
+		return k.sport < o.sport
+	case k.dport != o.dport:
+		return k.dport < o.dport
+	case k.icmpID != o.icmpID:
+		return k.icmpID < o.icmpID
+	case k.icmpType != o.icmpType:
+		return k.icmpType < o.icmpType
+	case k.icmpCode != o.icmpCode:
+		return k.icmpCode < o.icmpCode
+	}
+
+	return k.zone < o.zone
+}
+
+// Normalize returns the Tuple or its inverse, whichever sorts first, so the tuples
+// of packets travelling in opposite directions of a connection normalize to the
+// same Tuple. Endpoints are ordered by address, then by port. Like the kernel,
+// the type of ICMP and ICMPv6 queries is swapped with the type of their response
+// when inverting the Tuple, so an echo request and its reply normalize alike.
+func (t Tuple) Normalize() Tuple {
+
+	inv := t.invert()
+	if inv.key().less(t.key()) {
+		return inv
+	}
+
+	return t
+}
+
+// canonical returns a copy of the Tuple with its addresses in canonical form.
+func (t Tuple) canonical() Tuple {
+	t.IP = t.IP.Canonical()
+	return t
+}
+
 // unmarshal unmarshals a netfilter.Attribute into a Tuple.
 func (t *Tuple) unmarshal(ad *netlink.AttributeDecoder) error {
 
@@ -100,10 +211,36 @@ func (ipt IPTuple) filled() bool {
 	return len(ipt.SourceAddress) != 0 && len(ipt.DestinationAddress) != 0
 }
 
+// Canonical returns a copy of the IPTuple with its addresses in canonical form:
+// IPv4 addresses, including IPv4-mapped IPv6 addresses, in their 4-byte form, and
+// IPv6 addresses in their 16-byte form. Decoded addresses, net.ParseIP and net.IPv4
+// return the 16-byte form of IPv4 addresses, which compares unequal to the 4-byte
+// form using cmp or reflect.DeepEqual. Invalid addresses are copied as-is.
+func (ipt IPTuple) Canonical() IPTuple {
+	return IPTuple{
+		SourceAddress:      canonicalIP(ipt.SourceAddress),
+		DestinationAddress: canonicalIP(ipt.DestinationAddress),
+	}
+}
+
+// canonicalIP returns a copy of ip in canonical form. See IPTuple.Canonical.
+func canonicalIP(ip net.IP) net.IP {
+
+	if ip == nil {
+		return nil
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	return append(net.IP(nil), ip...)
+}
+
 // unmarshal unmarshals a netfilter.Attribute into an IPTuple.
-// IPv4 addresses will be represented by a 4-byte net.IP, IPv6 addresses by 16-byte.
-// The net.IP object is created with the raw bytes, NOT with net.ParseIP().
-// Use IP.Equal() to compare addresses in implementations and tests.
+// IPv4 addresses will be represented by a 16-byte net.IP in IPv4-mapped form, as
+// returned by net.IPv4, IPv6 addresses by 16-byte. The net.IP object is created with
+// the raw bytes, NOT with net.ParseIP(). Use IP.Equal() to compare addresses in
+// implementations and tests, or decode using WithCanonicalAddresses.
 func (ipt *IPTuple) unmarshal(ad *netlink.AttributeDecoder) error {
 
 	if ad.Len() != 2 {
@@ -203,9 +340,9 @@ func (pt *ProtoTuple) unmarshal(ad *netlink.AttributeDecoder) error {
 		case ctaProtoNum:
 			pt.Protocol = ad.Uint8()
 
-			if pt.Protocol == syscall.IPPROTO_ICMP {
+			if pt.Protocol == protoICMP {
 				pt.ICMPv4 = true
-			} else if pt.Protocol == syscall.IPPROTO_ICMPV6 {
+			} else if pt.Protocol == protoICMPv6 {
 				pt.ICMPv6 = true
 			}
 		case ctaProtoSrcPort:
@@ -234,11 +371,11 @@ func (pt ProtoTuple) marshal() netfilter.Attribute {
 	nfa.Children[0] = netfilter.Attribute{Type: uint16(ctaProtoNum), Data: []byte{pt.Protocol}}
 
 	switch pt.Protocol {
-	case unix.IPPROTO_ICMP:
+	case protoICMP:
 		nfa.Children[1] = netfilter.Attribute{Type: uint16(ctaProtoICMPType), Data: []byte{pt.ICMPType}}
 		nfa.Children[2] = netfilter.Attribute{Type: uint16(ctaProtoICMPCode), Data: []byte{pt.ICMPCode}}
 		nfa.Children = append(nfa.Children, netfilter.Attribute{Type: uint16(ctaProtoICMPID), Data: netfilter.Uint16Bytes(pt.ICMPID)})
-	case unix.IPPROTO_ICMPV6:
+	case protoICMPv6:
 		nfa.Children[1] = netfilter.Attribute{Type: uint16(ctaProtoICMPv6Type), Data: []byte{pt.ICMPType}}
 		nfa.Children[2] = netfilter.Attribute{Type: uint16(ctaProtoICMPv6Code), Data: []byte{pt.ICMPCode}}
 		nfa.Children = append(nfa.Children, netfilter.Attribute{Type: uint16(ctaProtoICMPv6ID), Data: netfilter.Uint16Bytes(pt.ICMPID)})
@@ -3,16 +3,16 @@ package conntrack
 import (
 	"fmt"
 	"net"
+	"net/netip"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
-	"golang.org/x/sys/unix"
 
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
-	"github.com/ti-mo/netfilter"
+	"github.com/ti-mo/conntrack/internal/netfilter"
 )
 
 var (
@@ -216,7 +216,7 @@ var protoTupleTests = []struct {
 			Children: []netfilter.Attribute{
 				{
 					Type: uint16(ctaProtoNum),
-					Data: []byte{unix.IPPROTO_ICMP},
+					Data: []byte{protoICMP},
 				},
 				{
 					Type: uint16(ctaProtoICMPType),
@@ -233,7 +233,7 @@ var protoTupleTests = []struct {
 			},
 		},
 		cta: ProtoTuple{
-			Protocol: unix.IPPROTO_ICMP,
+			Protocol: protoICMP,
 			ICMPv4:   true,
 			ICMPType: 1,
 			ICMPCode: 0xf,
@@ -248,7 +248,7 @@ var protoTupleTests = []struct {
 			Children: []netfilter.Attribute{
 				{
 					Type: uint16(ctaProtoNum),
-					Data: []byte{unix.IPPROTO_ICMPV6},
+					Data: []byte{protoICMPv6},
 				},
 				{
 					Type: uint16(ctaProtoICMPv6Type),
@@ -265,7 +265,7 @@ var protoTupleTests = []struct {
 			},
 		},
 		cta: ProtoTuple{
-			Protocol: unix.IPPROTO_ICMPV6,
+			Protocol: protoICMPv6,
 			ICMPv6:   true,
 			ICMPType: 2,
 			ICMPCode: 0xe,
@@ -501,3 +501,60 @@ func TestTupleTypeString(t *testing.T) {
 		t.Fatal("TupleType string representation empty - did you run `go generate`?")
 	}
 }
+
+func TestTupleAddrPort(t *testing.T) {
+
+	f := NewFlow(6, 0, net.IPv4(192, 0, 2, 1), net.ParseIP("2001:db8::1"), 1234, 80, 0, 0)
+
+	assert.Equal(t, netip.MustParseAddrPort("192.0.2.1:1234"), f.TupleOrig.Source())
+	assert.Equal(t, netip.MustParseAddrPort("[2001:db8::1]:80"), f.TupleOrig.Destination())
+	assert.Equal(t, f.TupleOrig.Destination(), f.TupleReply.Source())
+	assert.Equal(t, f.TupleOrig.Source(), f.TupleReply.Destination())
+
+	assert.Equal(t, netip.AddrPort{}, Tuple{}.Source())
+	assert.False(t, Tuple{}.Destination().IsValid())
+}
+
+func TestTupleNormalize(t *testing.T) {
+
+	f := NewFlow(17, 0, net.IPv4(192, 0, 2, 2), net.IPv4(192, 0, 2, 1), 1234, 53, 0, 0)
+
+	n := f.TupleOrig.Normalize()
+	assert.Equal(t, n, f.TupleReply.Normalize())
+	assert.Equal(t, netip.MustParseAddrPort("192.0.2.1:53"), n.Source())
+	assert.Equal(t, n, n.Normalize())
+
+	// Equal addresses are ordered by port.
+	f = NewFlow(6, 0, net.IPv6loopback, net.IPv6loopback, 40000, 8080, 0, 0)
+	assert.Equal(t, uint16(8080), f.TupleOrig.Normalize().Proto.SourcePort)
+	assert.Equal(t, uint16(8080), f.TupleReply.Normalize().Proto.SourcePort)
+
+	// An echo request and its reply.
+	req := Tuple{
+		IP:    IPTuple{SourceAddress: net.IPv4(192, 0, 2, 2), DestinationAddress: net.IPv4(192, 0, 2, 1)},
+		Proto: ProtoTuple{Protocol: protoICMP, ICMPv4: true, ICMPID: 42, ICMPType: 8},
+	}
+	rep := req.invert()
+	assert.Equal(t, uint8(0), rep.Proto.ICMPType)
+	assert.Equal(t, req.Normalize(), rep.Normalize())
+	assert.Equal(t, uint8(0), req.Normalize().Proto.ICMPType)
+}
+
+func TestIPTupleCanonical(t *testing.T) {
+
+	v6 := net.ParseIP("2001:db8::1")
+
+	ipt := IPTuple{SourceAddress: net.ParseIP("192.0.2.1"), DestinationAddress: v6}
+	c := ipt.Canonical()
+
+	assert.Equal(t, net.IP{192, 0, 2, 1}, c.SourceAddress)
+	assert.Equal(t, v6, c.DestinationAddress)
+	assert.Equal(t, c, IPTuple{SourceAddress: net.IPv4(192, 0, 2, 1).To4(), DestinationAddress: v6}.Canonical())
+
+	// Addresses are copied.
+	c.DestinationAddress[0] = 0
+	assert.Equal(t, byte(0x20), v6[0])
+
+	assert.Equal(t, IPTuple{}, IPTuple{}.Canonical())
+	assert.Equal(t, net.IP{1, 2, 3}, canonicalIP(net.IP{1, 2, 3}))
+}